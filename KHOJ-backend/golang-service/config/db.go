@@ -4,9 +4,11 @@ import(
 	"fmt"
 	"log"
 	"github.com/jmoiron/sqlx"
-	 _ "github.com/lib/pq"
+	 "github.com/lib/pq"
 	 "github.com/joho/godotenv"
 	 "os"
+	 "strings"
+	 "time"
 )
 
 
@@ -55,8 +57,8 @@ fmt.Println("✅ Environment variables loaded successfully!")
 		id TEXT PRIMARY KEY,
 		user_id INTEGER NOT NULL,
 		topic TEXT NOT NULL,
-		created_at TIMESTAMP NOT NULL,
-		updated_at TIMESTAMP NOT NULL
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
 	);`
 
 	createMessages := `
@@ -65,7 +67,7 @@ fmt.Println("✅ Environment variables loaded successfully!")
 		chat_id TEXT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
 		role TEXT NOT NULL,
 		content TEXT NOT NULL,
-		created_at TIMESTAMP NOT NULL
+		created_at TIMESTAMPTZ NOT NULL
 	);`
 
 	createUserAnswers := `
@@ -82,9 +84,9 @@ fmt.Println("✅ Environment variables loaded successfully!")
 		user_id INTEGER NOT NULL,
 		chat_id TEXT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
 		topic TEXT NOT NULL,
-		scheduled_time TIMESTAMP NOT NULL,
+		scheduled_time TIMESTAMPTZ NOT NULL,
 		active BOOLEAN DEFAULT true,
-		created_at TIMESTAMP DEFAULT NOW()
+		created_at TIMESTAMPTZ DEFAULT NOW()
 	);`
 
 	createQuizzes := `
@@ -96,8 +98,8 @@ fmt.Println("✅ Environment variables loaded successfully!")
 		status TEXT DEFAULT 'pending',
 		score INTEGER DEFAULT 0,
 		total_questions INTEGER NOT NULL,
-		created_at TIMESTAMP DEFAULT NOW(),
-		completed_at TIMESTAMP
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		completed_at TIMESTAMPTZ
 	);`
 
 	createQuizQuestions := `
@@ -152,5 +154,842 @@ fmt.Println("✅ Environment variables loaded successfully!")
 		fmt.Println("✅ Added options column to quiz_questions table")
 	}
 
+	// Question bank: questions are stored once per canonical topic and reused across quizzes
+	createBankQuestions := `
+	CREATE TABLE IF NOT EXISTS bank_questions (
+		id SERIAL PRIMARY KEY,
+		topic TEXT NOT NULL,
+		subtopic TEXT NOT NULL DEFAULT '',
+		question TEXT NOT NULL,
+		normalized_text TEXT NOT NULL,
+		options TEXT NOT NULL DEFAULT '[]',
+		answer TEXT NOT NULL,
+		embedding TEXT NOT NULL DEFAULT '',
+		source TEXT NOT NULL DEFAULT 'generated',
+		status TEXT NOT NULL DEFAULT 'unreviewed',
+		flag_count INTEGER NOT NULL DEFAULT 0,
+		times_used INTEGER NOT NULL DEFAULT 0,
+		created_by INTEGER,
+		reviewed_by INTEGER,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (topic, normalized_text)
+	);`
+
+	createBankQuestionFlags := `
+	CREATE TABLE IF NOT EXISTS bank_question_flags (
+		id SERIAL PRIMARY KEY,
+		question_id INTEGER NOT NULL REFERENCES bank_questions(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		resolved BOOLEAN NOT NULL DEFAULT false,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (question_id, user_id)
+	);`
+
+	if _, err := db.Exec(createBankQuestions); err != nil {
+		log.Fatal("Failed creating bank_questions table:", err)
+	}
+	if _, err := db.Exec(createBankQuestionFlags); err != nil {
+		log.Fatal("Failed creating bank_question_flags table:", err)
+	}
+
+	// Migration: link quiz questions to the bank entry they were drawn from, and give users a role for curation
+	_, err = db.Exec(`
+		ALTER TABLE quiz_questions ADD COLUMN IF NOT EXISTS bank_question_id INTEGER REFERENCES bank_questions(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS idx_quiz_questions_bank_question_id ON quiz_questions(bank_question_id);
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'learner';
+	`)
+	if err != nil {
+		log.Fatal("Failed migrating question bank columns:", err)
+	}
+
+	// Migration: activity timestamps become TIMESTAMPTZ so analytics can bucket them in any time zone
+	for _, col := range [][2]string{
+		{"chats", "created_at"}, {"chats", "updated_at"},
+		{"messages", "created_at"},
+		{"quizzes", "created_at"}, {"quizzes", "completed_at"},
+	} {
+		if err := migrateToTimestamptz(db, col[0], col[1]); err != nil {
+			log.Fatalf("Failed converting %s.%s to TIMESTAMPTZ: %v", col[0], col[1], err)
+		}
+	}
+
+	// Indexes backing the per-user analytics aggregates
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_quizzes_user_id_completed_at ON quizzes(user_id, completed_at);
+		CREATE INDEX IF NOT EXISTS idx_quiz_questions_quiz_id ON quiz_questions(quiz_id);
+	`)
+	if err != nil {
+		log.Fatal("Failed creating analytics indexes:", err)
+	}
+
+	// Gamification: XP ledger, per-user counters and streaks, data-driven achievements
+	createXPEvents := `
+	CREATE TABLE IF NOT EXISTS xp_events (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		event_type TEXT NOT NULL,
+		source_id TEXT NOT NULL,
+		xp INTEGER NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (user_id, event_type, source_id)
+	);`
+
+	createUserGamification := `
+	CREATE TABLE IF NOT EXISTS user_gamification (
+		user_id INTEGER PRIMARY KEY,
+		total_xp INTEGER NOT NULL DEFAULT 0,
+		current_streak INTEGER NOT NULL DEFAULT 0,
+		longest_streak INTEGER NOT NULL DEFAULT 0,
+		last_active_date DATE,
+		freeze_tokens INTEGER NOT NULL DEFAULT 0,
+		messages_sent INTEGER NOT NULL DEFAULT 0,
+		quizzes_completed INTEGER NOT NULL DEFAULT 0,
+		perfect_quizzes INTEGER NOT NULL DEFAULT 0,
+		reviews_completed INTEGER NOT NULL DEFAULT 0,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+
+	createAchievementDefinitions := `
+	CREATE TABLE IF NOT EXISTS achievement_definitions (
+		code TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		icon TEXT NOT NULL DEFAULT '',
+		rule TEXT NOT NULL,
+		xp_reward INTEGER NOT NULL DEFAULT 0,
+		sort_order INTEGER NOT NULL DEFAULT 0,
+		active BOOLEAN NOT NULL DEFAULT true
+	);`
+
+	createUserAchievements := `
+	CREATE TABLE IF NOT EXISTS user_achievements (
+		user_id INTEGER NOT NULL,
+		achievement_code TEXT NOT NULL REFERENCES achievement_definitions(code) ON DELETE CASCADE,
+		progress INTEGER NOT NULL DEFAULT 0,
+		target INTEGER NOT NULL DEFAULT 0,
+		earned_at TIMESTAMPTZ,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (user_id, achievement_code)
+	);`
+
+	if _, err := db.Exec(createXPEvents); err != nil {
+		log.Fatal("Failed creating xp_events table:", err)
+	}
+	if _, err := db.Exec(createUserGamification); err != nil {
+		log.Fatal("Failed creating user_gamification table:", err)
+	}
+	if _, err := db.Exec(createAchievementDefinitions); err != nil {
+		log.Fatal("Failed creating achievement_definitions table:", err)
+	}
+	if _, err := db.Exec(createUserAchievements); err != nil {
+		log.Fatal("Failed creating user_achievements table:", err)
+	}
+
+	// Migration: users get an IANA time zone so streak days follow their local calendar
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';`)
+	if err != nil {
+		log.Fatal("Failed adding users.timezone:", err)
+	}
+
+	// Leaderboards: classroom/study groups and a per-user privacy opt-out
+	createStudyGroups := `
+	CREATE TABLE IF NOT EXISTS study_groups (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		join_code TEXT UNIQUE NOT NULL,
+		owner_id INTEGER NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+
+	createStudyGroupMembers := `
+	CREATE TABLE IF NOT EXISTS study_group_members (
+		group_id INTEGER NOT NULL REFERENCES study_groups(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL,
+		role TEXT NOT NULL DEFAULT 'member',
+		joined_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (group_id, user_id)
+	);`
+
+	if _, err := db.Exec(createStudyGroups); err != nil {
+		log.Fatal("Failed creating study_groups table:", err)
+	}
+	if _, err := db.Exec(createStudyGroupMembers); err != nil {
+		log.Fatal("Failed creating study_group_members table:", err)
+	}
+
+	_, err = db.Exec(`
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS leaderboard_opt_out BOOLEAN NOT NULL DEFAULT false;
+		CREATE INDEX IF NOT EXISTS idx_study_group_members_user_id ON study_group_members(user_id);
+	`)
+	if err != nil {
+		log.Fatal("Failed migrating leaderboard columns:", err)
+	}
+
+	// Migration: recurrence columns the schedule handlers already write, plus the
+	// in-process scheduler's retry state
+	_, err = db.Exec(`
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS recurrence_type TEXT NOT NULL DEFAULT 'once';
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS reminder_time TEXT NOT NULL DEFAULT '';
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS reminder_time_end TEXT NOT NULL DEFAULT '';
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS days_of_week TEXT NOT NULL DEFAULT '';
+		UPDATE schedules SET recurrence_type='once' WHERE recurrence_type IS NULL OR recurrence_type='';
+		UPDATE schedules SET reminder_time='' WHERE reminder_time IS NULL;
+		UPDATE schedules SET reminder_time_end='' WHERE reminder_time_end IS NULL;
+		UPDATE schedules SET days_of_week='' WHERE days_of_week IS NULL;
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS last_fired_at TIMESTAMPTZ;
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS last_error TEXT NOT NULL DEFAULT '';
+	`)
+	if err != nil {
+		log.Fatal("Failed migrating schedule columns:", err)
+	}
+
+	// Migration: RFC 5545 recurrence rules with exclusions, anchored at dtstart
+	_, err = db.Exec(`
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS rrule TEXT NOT NULL DEFAULT '';
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS exdates TEXT NOT NULL DEFAULT '';
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS dtstart TIMESTAMPTZ;
+	`)
+	if err != nil {
+		log.Fatal("Failed adding schedule recurrence rule columns:", err)
+	}
+	for _, col := range []string{"scheduled_time", "created_at"} {
+		if err := migrateToTimestamptz(db, "schedules", col); err != nil {
+			log.Fatalf("Failed converting schedules.%s to TIMESTAMPTZ: %v", col, err)
+		}
+	}
+
+	createReminderDeliveries := `
+	CREATE TABLE IF NOT EXISTS reminder_deliveries (
+		id SERIAL PRIMARY KEY,
+		schedule_id INTEGER NOT NULL REFERENCES schedules(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL,
+		occurrence_time TIMESTAMPTZ NOT NULL,
+		attempt INTEGER NOT NULL DEFAULT 1,
+		mode TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		message_id TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+	if _, err := db.Exec(createReminderDeliveries); err != nil {
+		log.Fatal("Failed creating reminder_deliveries table:", err)
+	}
+
+	// At most one successful delivery per occurrence; the due index keeps the worker's poll cheap
+	_, err = db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_reminder_deliveries_sent_once
+			ON reminder_deliveries(schedule_id, occurrence_time) WHERE status='sent';
+		CREATE INDEX IF NOT EXISTS idx_schedules_due
+			ON schedules((COALESCE(next_attempt_at, scheduled_time))) WHERE active;
+	`)
+	if err != nil {
+		log.Fatal("Failed creating scheduler indexes:", err)
+	}
+
+	// Migration: reminder windows and quiet hours. occurrence_time is the rule's
+	// nominal occurrence; scheduled_time is when it actually fires after the
+	// window and quiet hours are applied.
+	_, err = db.Exec(`
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS window_strategy TEXT NOT NULL DEFAULT 'random';
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS occurrence_time TIMESTAMPTZ;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_hours_start TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_hours_end TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS dnd_days TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS preferred_hours_start TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS preferred_hours_end TEXT NOT NULL DEFAULT '';
+	`)
+	if err != nil {
+		log.Fatal("Failed migrating reminder window columns:", err)
+	}
+
+	// Notifications: per-user channel preferences, Web Push subscriptions and a
+	// delivery log shared by every channel
+	createNotificationPreferences := `
+	CREATE TABLE IF NOT EXISTS notification_preferences (
+		user_id INTEGER NOT NULL,
+		channel TEXT NOT NULL,
+		enabled BOOLEAN NOT NULL DEFAULT true,
+		target TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (user_id, channel)
+	);`
+
+	createPushSubscriptions := `
+	CREATE TABLE IF NOT EXISTS push_subscriptions (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		endpoint TEXT UNIQUE NOT NULL,
+		p256dh TEXT NOT NULL,
+		auth TEXT NOT NULL,
+		user_agent TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+
+	createNotificationDeliveries := `
+	CREATE TABLE IF NOT EXISTS notification_deliveries (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		channel TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		dedupe_key TEXT NOT NULL DEFAULT '',
+		target TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		sent_at TIMESTAMPTZ
+	);`
+
+	if _, err := db.Exec(createNotificationPreferences); err != nil {
+		log.Fatal("Failed creating notification_preferences table:", err)
+	}
+	if _, err := db.Exec(createPushSubscriptions); err != nil {
+		log.Fatal("Failed creating push_subscriptions table:", err)
+	}
+	if _, err := db.Exec(createNotificationDeliveries); err != nil {
+		log.Fatal("Failed creating notification_deliveries table:", err)
+	}
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_push_subscriptions_user_id ON push_subscriptions(user_id);
+		CREATE INDEX IF NOT EXISTS idx_notification_deliveries_user ON notification_deliveries(user_id, created_at DESC);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_notification_deliveries_sent_once
+			ON notification_deliveries(user_id, channel, dedupe_key) WHERE status='sent' AND dedupe_key <> '';
+	`)
+	if err != nil {
+		log.Fatal("Failed creating notification indexes:", err)
+	}
+
+	// Reminder instances: one row per delivered occurrence, tracking what the
+	// learner did with it
+	createReminderInstances := `
+	CREATE TABLE IF NOT EXISTS reminder_instances (
+		id SERIAL PRIMARY KEY,
+		schedule_id INTEGER NOT NULL REFERENCES schedules(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL,
+		chat_id TEXT NOT NULL,
+		topic TEXT NOT NULL,
+		occurrence_time TIMESTAMPTZ NOT NULL,
+		state TEXT NOT NULL DEFAULT 'sent',
+		message_id TEXT NOT NULL DEFAULT '',
+		snoozed_until TIMESTAMPTZ,
+		snooze_count INTEGER NOT NULL DEFAULT 0,
+		completed_quiz_id INTEGER,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (schedule_id, occurrence_time)
+	);`
+	if _, err := db.Exec(createReminderInstances); err != nil {
+		log.Fatal("Failed creating reminder_instances table:", err)
+	}
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_reminder_instances_user_state ON reminder_instances(user_id, state);
+		CREATE INDEX IF NOT EXISTS idx_reminder_instances_snoozed ON reminder_instances(snoozed_until) WHERE state='snoozed';
+	`)
+	if err != nil {
+		log.Fatal("Failed creating reminder_instances indexes:", err)
+	}
+
+	// Private calendar feeds: the token in the subscription URL is the only
+	// credential, so revoking a feed is how a leaked URL is shut off
+	createCalendarFeeds := `
+	CREATE TABLE IF NOT EXISTS calendar_feeds (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		token TEXT UNIQUE NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		last_accessed_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ
+	);`
+	if _, err := db.Exec(createCalendarFeeds); err != nil {
+		log.Fatal("Failed creating calendar_feeds table:", err)
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_calendar_feeds_user_id ON calendar_feeds(user_id) WHERE revoked_at IS NULL;`)
+	if err != nil {
+		log.Fatal("Failed creating calendar_feeds index:", err)
+	}
+
+	// Study plans: a multi-week plan built from the onboarding answers. Each
+	// item is a session, review or quiz checkpoint on one topic, reminded
+	// through its own one-time schedule.
+	createStudyPlans := `
+	CREATE TABLE IF NOT EXISTS study_plans (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'active',
+		topics TEXT NOT NULL DEFAULT '',
+		weeks INTEGER NOT NULL,
+		start_date DATE NOT NULL,
+		end_date DATE NOT NULL,
+		study_days TEXT NOT NULL DEFAULT '',
+		session_time TEXT NOT NULL DEFAULT '',
+		session_minutes INTEGER NOT NULL,
+		learning_styles TEXT NOT NULL DEFAULT '',
+		reminders_enabled BOOLEAN NOT NULL DEFAULT true,
+		facts_enabled BOOLEAN NOT NULL DEFAULT false,
+		replan_count INTEGER NOT NULL DEFAULT 0,
+		replanned_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+	if _, err := db.Exec(createStudyPlans); err != nil {
+		log.Fatal("Failed creating study_plans table:", err)
+	}
+	createStudyPlanItems := `
+	CREATE TABLE IF NOT EXISTS study_plan_items (
+		id SERIAL PRIMARY KEY,
+		plan_id INTEGER NOT NULL REFERENCES study_plans(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL,
+		chat_id TEXT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+		topic TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		activity TEXT NOT NULL DEFAULT '',
+		week INTEGER NOT NULL,
+		scheduled_for TIMESTAMPTZ NOT NULL,
+		duration_minutes INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		schedule_id INTEGER REFERENCES schedules(id) ON DELETE SET NULL,
+		replanned_from INTEGER REFERENCES study_plan_items(id) ON DELETE SET NULL,
+		quiz_id INTEGER,
+		completed_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+	if _, err := db.Exec(createStudyPlanItems); err != nil {
+		log.Fatal("Failed creating study_plan_items table:", err)
+	}
+	_, err = db.Exec(`
+		ALTER TABLE schedules ADD COLUMN IF NOT EXISTS plan_item_id INTEGER;
+		CREATE INDEX IF NOT EXISTS idx_study_plans_user_status ON study_plans(user_id, status);
+		CREATE INDEX IF NOT EXISTS idx_study_plan_items_plan ON study_plan_items(plan_id, scheduled_for);
+		CREATE INDEX IF NOT EXISTS idx_study_plan_items_pending ON study_plan_items(user_id, scheduled_for) WHERE status='pending';
+	`)
+	if err != nil {
+		log.Fatal("Failed migrating study plans:", err)
+	}
+
+	// Migration: onboarding answers become one row per question, upserted on
+	// resubmission. Earlier duplicates are dropped, keeping the latest row.
+	_, err = db.Exec(`
+		ALTER TABLE user_answers ADD COLUMN IF NOT EXISTS questionnaire_version INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE user_answers ADD COLUMN IF NOT EXISTS answered_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+		DELETE FROM user_answers a USING user_answers b
+			WHERE a.user_id = b.user_id AND a.question_number = b.question_number AND a.ctid < b.ctid;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_user_answers_question ON user_answers(user_id, question_number);
+	`)
+	if err != nil {
+		log.Fatal("Failed migrating user_answers:", err)
+	}
+
+	// Typed learner profile parsed from the onboarding answers
+	createLearnerProfiles := `
+	CREATE TABLE IF NOT EXISTS learner_profiles (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		questionnaire_version INTEGER NOT NULL,
+		learning_styles TEXT[] NOT NULL DEFAULT '{}',
+		preferred_windows TEXT[] NOT NULL DEFAULT '{}',
+		reminder_frequency TEXT NOT NULL DEFAULT '',
+		facts_notifications BOOLEAN NOT NULL DEFAULT false,
+		language TEXT NOT NULL DEFAULT 'en',
+		completed_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+	if _, err := db.Exec(createLearnerProfiles); err != nil {
+		log.Fatal("Failed creating learner_profiles table:", err)
+	}
+
+	// Tutor persona: the learner's level and chosen tutor style, and the
+	// versioned prompt templates replies are built from. One version per
+	// name is active.
+	_, err = db.Exec(`
+		ALTER TABLE learner_profiles ADD COLUMN IF NOT EXISTS level TEXT NOT NULL DEFAULT '';
+		ALTER TABLE learner_profiles ADD COLUMN IF NOT EXISTS tutor_style TEXT NOT NULL DEFAULT 'balanced';
+	`)
+	if err != nil {
+		log.Fatal("Failed migrating learner_profiles:", err)
+	}
+	createPromptTemplates := `
+	CREATE TABLE IF NOT EXISTS prompt_templates (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		body TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		active BOOLEAN NOT NULL DEFAULT false,
+		created_by INTEGER,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (name, version)
+	);`
+	if _, err := db.Exec(createPromptTemplates); err != nil {
+		log.Fatal("Failed creating prompt_templates table:", err)
+	}
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_prompt_templates_active ON prompt_templates(name) WHERE active;`)
+	if err != nil {
+		log.Fatal("Failed creating prompt_templates index:", err)
+	}
+
+	// Prompt registry: per-version model settings, A/B experiments between
+	// versions, and what happened each time a prompt was used
+	_, err = db.Exec(`
+		ALTER TABLE prompt_templates ADD COLUMN IF NOT EXISTS model TEXT NOT NULL DEFAULT '';
+		ALTER TABLE prompt_templates ADD COLUMN IF NOT EXISTS temperature DOUBLE PRECISION;
+		ALTER TABLE prompt_templates ADD COLUMN IF NOT EXISTS max_output_tokens INTEGER;
+	`)
+	if err != nil {
+		log.Fatal("Failed migrating prompt_templates:", err)
+	}
+	createPromptExperiments := `
+	CREATE TABLE IF NOT EXISTS prompt_experiments (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		prompt_name TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'running',
+		created_by INTEGER,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		stopped_at TIMESTAMPTZ
+	);`
+	if _, err := db.Exec(createPromptExperiments); err != nil {
+		log.Fatal("Failed creating prompt_experiments table:", err)
+	}
+	createPromptVariants := `
+	CREATE TABLE IF NOT EXISTS prompt_experiment_variants (
+		id SERIAL PRIMARY KEY,
+		experiment_id INTEGER NOT NULL REFERENCES prompt_experiments(id) ON DELETE CASCADE,
+		label TEXT NOT NULL,
+		template_id INTEGER NOT NULL REFERENCES prompt_templates(id),
+		weight INTEGER NOT NULL DEFAULT 1,
+		UNIQUE (experiment_id, label)
+	);`
+	if _, err := db.Exec(createPromptVariants); err != nil {
+		log.Fatal("Failed creating prompt_experiment_variants table:", err)
+	}
+	createPromptUsages := `
+	CREATE TABLE IF NOT EXISTS prompt_usages (
+		id BIGSERIAL PRIMARY KEY,
+		template_id INTEGER REFERENCES prompt_templates(id) ON DELETE SET NULL,
+		prompt_name TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		experiment_id INTEGER REFERENCES prompt_experiments(id) ON DELETE SET NULL,
+		variant_id INTEGER REFERENCES prompt_experiment_variants(id) ON DELETE SET NULL,
+		user_id INTEGER,
+		source_type TEXT NOT NULL,
+		source_id TEXT NOT NULL DEFAULT '',
+		model TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+	if _, err := db.Exec(createPromptUsages); err != nil {
+		log.Fatal("Failed creating prompt_usages table:", err)
+	}
+	createPromptOutcomes := `
+	CREATE TABLE IF NOT EXISTS prompt_outcomes (
+		id BIGSERIAL PRIMARY KEY,
+		usage_id BIGINT NOT NULL REFERENCES prompt_usages(id) ON DELETE CASCADE,
+		metric TEXT NOT NULL,
+		value DOUBLE PRECISION NOT NULL DEFAULT 1,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+	if _, err := db.Exec(createPromptOutcomes); err != nil {
+		log.Fatal("Failed creating prompt_outcomes table:", err)
+	}
+	_, err = db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_prompt_experiments_running ON prompt_experiments(prompt_name) WHERE status='running';
+		CREATE INDEX IF NOT EXISTS idx_prompt_usages_source ON prompt_usages(source_type, source_id);
+		CREATE INDEX IF NOT EXISTS idx_prompt_usages_template ON prompt_usages(prompt_name, template_id);
+		CREATE INDEX IF NOT EXISTS idx_prompt_outcomes_usage ON prompt_outcomes(usage_id);
+	`)
+	if err != nil {
+		log.Fatal("Failed creating prompt registry indexes:", err)
+	}
+
+	// Reply versions: a regenerated bot reply is another version with the
+	// same parent (the user message it answers)
+	_, err = db.Exec(`
+		ALTER TABLE messages ADD COLUMN IF NOT EXISTS parent_id TEXT REFERENCES messages(id) ON DELETE SET NULL;
+		ALTER TABLE messages ADD COLUMN IF NOT EXISTS model TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS idx_messages_parent_id ON messages(parent_id);
+	`)
+	if err != nil {
+		log.Fatal("Failed migrating messages:", err)
+	}
+	createMessageFeedback := `
+	CREATE TABLE IF NOT EXISTS message_feedback (
+		message_id TEXT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL,
+		rating SMALLINT NOT NULL CHECK (rating IN (-1, 1)),
+		reason TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (message_id, user_id)
+	);`
+	if _, err := db.Exec(createMessageFeedback); err != nil {
+		log.Fatal("Failed creating message_feedback table:", err)
+	}
+
+	// Conversation tree: every message's parent is the one before it on its
+	// branch, and a chat shows the path to its active leaf. Chats from before
+	// the tree are linked up once, in order, following the reply versions
+	// picked with is_selected.
+	_, err = db.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='chats' AND column_name='active_leaf_id') THEN
+				ALTER TABLE chats ADD COLUMN active_leaf_id TEXT;
+				ALTER TABLE messages ADD COLUMN IF NOT EXISTS is_selected BOOLEAN NOT NULL DEFAULT true;
+				UPDATE messages m SET parent_id = (
+					SELECT p.id FROM messages p
+					WHERE p.chat_id = m.chat_id AND p.is_selected AND (p.created_at, p.id) < (m.created_at, m.id)
+					ORDER BY p.created_at DESC, p.id DESC LIMIT 1
+				) WHERE m.parent_id IS NULL;
+				UPDATE chats c SET active_leaf_id = (
+					SELECT id FROM messages WHERE chat_id = c.id AND is_selected ORDER BY created_at DESC, id DESC LIMIT 1
+				);
+				ALTER TABLE messages DROP COLUMN is_selected;
+			END IF;
+		END $$;
+	`)
+	if err != nil {
+		log.Fatal("Failed migrating chats to a conversation tree:", err)
+	}
+
+	// Asides (daily facts) hang off the message they follow and show on its
+	// branch, but never become the leaf or the parent of a reply
+	_, err = db.Exec(`ALTER TABLE messages ADD COLUMN IF NOT EXISTS aside BOOLEAN NOT NULL DEFAULT false;`)
+	if err != nil {
+		log.Fatal("Failed migrating messages:", err)
+	}
+
+	// Search: full-text indexes over what learners wrote and were asked, and
+	// embeddings for semantic search, filled in by the search indexer
+	createSearchEmbeddings := `
+	CREATE TABLE IF NOT EXISTS search_embeddings (
+		source_type TEXT NOT NULL,
+		source_id TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		embedding TEXT NOT NULL,
+		model TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (source_type, source_id)
+	);`
+	if _, err := db.Exec(createSearchEmbeddings); err != nil {
+		log.Fatal("Failed creating search_embeddings table:", err)
+	}
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_messages_fts ON messages USING GIN (to_tsvector('english', content));
+		CREATE INDEX IF NOT EXISTS idx_quiz_questions_fts ON quiz_questions USING GIN (to_tsvector('english', question));
+		CREATE INDEX IF NOT EXISTS idx_search_embeddings_user ON search_embeddings(user_id, source_type);
+	`)
+	if err != nil {
+		log.Fatal("Failed creating search indexes:", err)
+	}
+
+	// Keyset pagination: lists are paged on (time, id), so each needs an
+	// index in that order. Chat history walks the message tree instead; the
+	// messages index serves its latest-message lookups.
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_messages_chat_keyset ON messages(chat_id, created_at, id);
+		CREATE INDEX IF NOT EXISTS idx_chats_user_keyset ON chats(user_id, updated_at, id);
+		CREATE INDEX IF NOT EXISTS idx_schedules_user_keyset ON schedules(user_id, scheduled_time, id) WHERE active;
+	`)
+	if err != nil {
+		log.Fatal("Failed creating pagination indexes:", err)
+	}
+
+	// "Download all my data" jobs; the zip is stored with the job until it
+	// expires
+	createDataExports := `
+	CREATE TABLE IF NOT EXISTS data_exports (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		status TEXT NOT NULL DEFAULT 'pending',
+		error TEXT NOT NULL DEFAULT '',
+		archive BYTEA,
+		size_bytes INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		started_at TIMESTAMPTZ,
+		finished_at TIMESTAMPTZ,
+		expires_at TIMESTAMPTZ
+	);`
+	if _, err := db.Exec(createDataExports); err != nil {
+		log.Fatal("Failed creating data_exports table:", err)
+	}
+	_, err = db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_data_exports_active ON data_exports(user_id) WHERE status IN ('pending', 'running');
+		CREATE INDEX IF NOT EXISTS idx_data_exports_user ON data_exports(user_id, created_at DESC);
+	`)
+	if err != nil {
+		log.Fatal("Failed creating data export indexes:", err)
+	}
+
+	// Chat titles and rolling summaries are generated after a few turns; a
+	// learner can have several chats on one topic, told apart by title
+	_, err = db.Exec(`
+		ALTER TABLE chats ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '';
+		ALTER TABLE chats ADD COLUMN IF NOT EXISTS title_by_user BOOLEAN NOT NULL DEFAULT false;
+		ALTER TABLE chats ADD COLUMN IF NOT EXISTS summary TEXT NOT NULL DEFAULT '';
+		ALTER TABLE chats ADD COLUMN IF NOT EXISTS summary_through_id TEXT;
+		CREATE INDEX IF NOT EXISTS idx_chats_user_topic ON chats(user_id, topic, updated_at DESC);
+	`)
+	if err != nil {
+		log.Fatal("Failed adding chat title columns:", err)
+	}
+	createChatNotes := `
+	CREATE TABLE IF NOT EXISTS chat_notes (
+		chat_id TEXT PRIMARY KEY REFERENCES chats(id) ON DELETE CASCADE,
+		leaf_id TEXT NOT NULL,
+		notes TEXT NOT NULL,
+		model TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+	if _, err := db.Exec(createChatNotes); err != nil {
+		log.Fatal("Failed creating chat_notes table:", err)
+	}
+
+	// Flashcards: one deck per learner and topic, cards scheduled with SM-2
+	createFlashcardDecks := `
+	CREATE TABLE IF NOT EXISTS flashcard_decks (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		topic TEXT NOT NULL,
+		topic_key TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (user_id, topic_key)
+	);`
+	createFlashcards := `
+	CREATE TABLE IF NOT EXISTS flashcards (
+		id SERIAL PRIMARY KEY,
+		deck_id INTEGER NOT NULL REFERENCES flashcard_decks(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL,
+		chat_id TEXT REFERENCES chats(id) ON DELETE SET NULL,
+		front TEXT NOT NULL,
+		back TEXT NOT NULL,
+		normalized_front TEXT NOT NULL,
+		source TEXT NOT NULL DEFAULT 'manual',
+		ease DOUBLE PRECISION NOT NULL DEFAULT 2.5,
+		interval_days INTEGER NOT NULL DEFAULT 0,
+		repetitions INTEGER NOT NULL DEFAULT 0,
+		lapses INTEGER NOT NULL DEFAULT 0,
+		due_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		last_reviewed_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (deck_id, normalized_front)
+	);`
+	createFlashcardReviews := `
+	CREATE TABLE IF NOT EXISTS flashcard_reviews (
+		id SERIAL PRIMARY KEY,
+		card_id INTEGER NOT NULL REFERENCES flashcards(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL,
+		grade INTEGER NOT NULL,
+		interval_days INTEGER NOT NULL,
+		ease DOUBLE PRECISION NOT NULL,
+		reviewed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`
+	if _, err := db.Exec(createFlashcardDecks); err != nil {
+		log.Fatal("Failed creating flashcard_decks table:", err)
+	}
+	if _, err := db.Exec(createFlashcards); err != nil {
+		log.Fatal("Failed creating flashcards table:", err)
+	}
+	if _, err := db.Exec(createFlashcardReviews); err != nil {
+		log.Fatal("Failed creating flashcard_reviews table:", err)
+	}
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_flashcards_user_due ON flashcards(user_id, due_at);
+		CREATE INDEX IF NOT EXISTS idx_flashcard_reviews_card ON flashcard_reviews(card_id, reviewed_at);
+	`)
+	if err != nil {
+		log.Fatal("Failed creating flashcard indexes:", err)
+	}
+
+	// Topic facts: generated and checked once per canonical topic, then sent
+	// to opted-in learners at most once a day each
+	createTopicFacts := `
+	CREATE TABLE IF NOT EXISTS topic_facts (
+		id SERIAL PRIMARY KEY,
+		topic_key TEXT NOT NULL,
+		fact TEXT NOT NULL,
+		normalized_fact TEXT NOT NULL,
+		model TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'active',
+		upvotes INTEGER NOT NULL DEFAULT 0,
+		downvotes INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (topic_key, normalized_fact)
+	);`
+	createFactDeliveries := `
+	CREATE TABLE IF NOT EXISTS fact_deliveries (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		fact_id INTEGER NOT NULL REFERENCES topic_facts(id) ON DELETE CASCADE,
+		topic TEXT NOT NULL,
+		topic_key TEXT NOT NULL,
+		chat_id TEXT REFERENCES chats(id) ON DELETE SET NULL,
+		local_date DATE NOT NULL,
+		scheduled_for TIMESTAMPTZ NOT NULL,
+		status TEXT NOT NULL DEFAULT 'scheduled',
+		rating INTEGER,
+		sent_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (user_id, fact_id),
+		UNIQUE (user_id, local_date)
+	);`
+	createFactMutes := `
+	CREATE TABLE IF NOT EXISTS fact_mutes (
+		user_id INTEGER NOT NULL,
+		topic_key TEXT NOT NULL,
+		topic TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (user_id, topic_key)
+	);`
+	if _, err := db.Exec(createTopicFacts); err != nil {
+		log.Fatal("Failed creating topic_facts table:", err)
+	}
+	if _, err := db.Exec(createFactDeliveries); err != nil {
+		log.Fatal("Failed creating fact_deliveries table:", err)
+	}
+	if _, err := db.Exec(createFactMutes); err != nil {
+		log.Fatal("Failed creating fact_mutes table:", err)
+	}
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_fact_deliveries_due ON fact_deliveries(scheduled_for) WHERE status='scheduled';
+		CREATE INDEX IF NOT EXISTS idx_topic_facts_topic ON topic_facts(topic_key, status);
+	`)
+	if err != nil {
+		log.Fatal("Failed creating fact indexes:", err)
+	}
+
       DB=db
+}
+
+// migrateToTimestamptz converts a naive TIMESTAMP column to TIMESTAMPTZ if it
+// hasn't been converted yet. The old values were written as the server's wall
+// clock, so they are read in DB_LEGACY_TIMEZONE (an IANA name such as
+// "Asia/Kolkata") when set, or in the database session's time zone otherwise.
+func migrateToTimestamptz(db *sqlx.DB, table string, column string) error {
+	var dataType string
+	err := db.Get(&dataType, `
+		SELECT data_type FROM information_schema.columns
+		WHERE table_name=$1 AND column_name=$2
+	`, table, column)
+	if err != nil || dataType != "timestamp without time zone" {
+		return nil
+	}
+
+	using := ""
+	if zone := strings.TrimSpace(os.Getenv("DB_LEGACY_TIMEZONE")); zone != "" {
+		if _, err := time.LoadLocation(zone); err != nil {
+			return fmt.Errorf("invalid DB_LEGACY_TIMEZONE %q: %w", zone, err)
+		}
+		using = fmt.Sprintf(" USING %s AT TIME ZONE %s", pq.QuoteIdentifier(column), pq.QuoteLiteral(zone))
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE TIMESTAMPTZ%s",
+		pq.QuoteIdentifier(table), pq.QuoteIdentifier(column), using))
+	if err == nil {
+		fmt.Printf("✅ Converted %s.%s to TIMESTAMPTZ\n", table, column)
+	}
+	return err
 }
\ No newline at end of file
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang-service/config"
+)
+
+// requireAdmin checks that userID belongs to an admin, writing a 403 response
+// and returning false otherwise
+func requireAdmin(c *gin.Context, userID int) bool {
+	var role string
+	err := config.DB.Get(&role, "SELECT role FROM users WHERE id=$1", userID)
+	if err != nil || role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return false
+	}
+	return true
+}
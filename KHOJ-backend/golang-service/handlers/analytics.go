@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAnalyticsDays bounds custom ranges so a single request can't scan years of history
+const maxAnalyticsDays = 400
+
+// GetActivityHeatmap returns per-bucket activity counts with a 0-4 intensity
+// level, ready for the ActivityGrid heatmap
+func GetActivityHeatmap(c *gin.Context) {
+	userID, r, ok := parseAnalyticsRequest(c)
+	if !ok {
+		return
+	}
+	buckets, err := services.ActivityHeatmap(userID, r)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load activity: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"range": analyticsRangeJSON(r), "buckets": buckets})
+}
+
+// GetAccuracyTrends returns quiz accuracy per canonical topic over time.
+// Pass topic to restrict the result to one topic.
+func GetAccuracyTrends(c *gin.Context) {
+	userID, r, ok := parseAnalyticsRequest(c)
+	if !ok {
+		return
+	}
+	topics, err := services.AccuracyTrends(userID, r, services.CanonicalTopic(c.Query("topic")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load accuracy: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"range": analyticsRangeJSON(r), "topics": topics})
+}
+
+// GetStreaks returns the current and longest daily study streaks. This is
+// the same streak the achievements summary shows: days are counted in the
+// user's zone and freeze tokens cover missed days.
+func GetStreaks(c *gin.Context) {
+	userID := parseInt(c.Param("user_id"))
+	if userID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+		return
+	}
+	streak, err := services.UserStreak(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load streaks: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"timezone": services.UserLocation(userID).String(), "streak": streak})
+}
+
+// GetTimeSpent returns estimated study time per bucket
+func GetTimeSpent(c *gin.Context) {
+	userID, r, ok := parseAnalyticsRequest(c)
+	if !ok {
+		return
+	}
+	spent, err := services.EstimateTimeSpent(userID, r)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load time spent: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"range": analyticsRangeJSON(r), "time_spent": spent})
+}
+
+// GetWeakSubtopics returns the subtopics with the lowest accuracy
+func GetWeakSubtopics(c *gin.Context) {
+	userID, r, ok := parseAnalyticsRequest(c)
+	if !ok {
+		return
+	}
+	minAnswered := 3
+	if v, err := strconv.Atoi(c.Query("min_answered")); err == nil && v > 0 {
+		minAnswered = v
+	}
+	limit := 5
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 && v <= 50 {
+		limit = v
+	}
+	subtopics, err := services.WeakestSubtopics(userID, r, minAnswered, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load subtopics: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"range": analyticsRangeJSON(r), "subtopics": subtopics})
+}
+
+// GetScoreHistory returns the scores of completed quizzes in the range
+func GetScoreHistory(c *gin.Context) {
+	userID, r, ok := parseAnalyticsRequest(c)
+	if !ok {
+		return
+	}
+	scores, err := services.ScoreHistory(userID, r)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load score history: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"range": analyticsRangeJSON(r), "scores": scores})
+}
+
+// parseAnalyticsRequest reads the user_id param and the range query:
+//   - range=week|month|year: the last 7 days, 30 days or 52 weeks up to today
+//   - or from/to as YYYY-MM-DD (to inclusive) or RFC 3339 instants
+//   - bucket=day|week|month (defaults to week for a year, day otherwise)
+//   - tz: IANA zone used for day boundaries (defaults to UTC)
+//
+// On failure it writes the error response and returns false.
+func parseAnalyticsRequest(c *gin.Context) (int, services.AnalyticsRange, bool) {
+	var r services.AnalyticsRange
+	userID := parseInt(c.Param("user_id"))
+	if userID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+		return 0, r, false
+	}
+	loc, ok := parseAnalyticsZone(c)
+	if !ok {
+		return 0, r, false
+	}
+	r.Location = loc
+
+	now := time.Now().In(loc)
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1)
+	defaultBucket := services.BucketDay
+
+	from, to := c.Query("from"), c.Query("to")
+	if from != "" || to != "" {
+		var err error
+		r.To = tomorrow
+		if to != "" {
+			if r.To, err = parseAnalyticsTime(to, loc, true); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to: " + err.Error()})
+				return 0, r, false
+			}
+		}
+		if from == "" {
+			r.From = r.To.AddDate(0, 0, -30)
+		} else if r.From, err = parseAnalyticsTime(from, loc, false); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from: " + err.Error()})
+			return 0, r, false
+		}
+		if !r.From.Before(r.To) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+			return 0, r, false
+		}
+		if r.To.Sub(r.From) > maxAnalyticsDays*24*time.Hour {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Range is limited to %d days", maxAnalyticsDays)})
+			return 0, r, false
+		}
+		if r.To.Sub(r.From) > 92*24*time.Hour {
+			defaultBucket = services.BucketWeek
+		}
+	} else {
+		r.To = tomorrow
+		switch c.DefaultQuery("range", "month") {
+		case "week":
+			r.From = tomorrow.AddDate(0, 0, -7)
+		case "month":
+			r.From = tomorrow.AddDate(0, 0, -30)
+		case "year":
+			r.From = tomorrow.AddDate(0, 0, -52*7)
+			defaultBucket = services.BucketWeek
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "range must be week, month or year"})
+			return 0, r, false
+		}
+	}
+
+	r.Bucket = strings.ToLower(c.DefaultQuery("bucket", defaultBucket))
+	if !services.ValidBucket(r.Bucket) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bucket must be day, week or month"})
+		return 0, r, false
+	}
+	return userID, r, true
+}
+
+// parseAnalyticsZone reads the tz query parameter, defaulting to the user's
+// own zone; on failure it writes the error response and returns false
+func parseAnalyticsZone(c *gin.Context) (*time.Location, bool) {
+	tz := strings.TrimSpace(c.Query("tz"))
+	if tz == "" {
+		userID := parseInt(c.Param("user_id"))
+		if userID == 0 {
+			userID = parseInt(c.Query("user_id"))
+		}
+		if userID == 0 {
+			return time.UTC, true
+		}
+		return services.UserLocation(userID), true
+	}
+	loc, err := services.ParseTimezone(tz)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tz, use an IANA name such as Asia/Kolkata"})
+		return nil, false
+	}
+	return loc, true
+}
+
+// parseAnalyticsTime accepts a date (local midnight; the day after when it
+// ends a range, so to is inclusive) or an RFC 3339 instant
+func parseAnalyticsTime(s string, loc *time.Location, end bool) (time.Time, error) {
+	if d, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
+		if end {
+			d = d.AddDate(0, 0, 1)
+		}
+		return d, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func analyticsRangeJSON(r services.AnalyticsRange) gin.H {
+	return gin.H{
+		"from":     r.From.In(r.Location),
+		"to":       r.To.In(r.Location),
+		"timezone": r.Location.String(),
+		"bucket":   r.Bucket,
+	}
+}
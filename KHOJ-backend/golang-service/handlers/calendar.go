@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// calendarName is the name calendar apps show for the feed
+const calendarName = "KHOJ study reminders"
+
+// calendarFeedURLs returns the https and webcal:// URLs of a feed. The base is
+// PUBLIC_API_URL when set, otherwise the host the request came in on.
+func calendarFeedURLs(c *gin.Context, token string) (string, string) {
+	base := strings.TrimRight(os.Getenv("PUBLIC_API_URL"), "/")
+	if base == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https") {
+			scheme = "https"
+		}
+		host := c.Request.Host
+		if fwd := c.GetHeader("X-Forwarded-Host"); fwd != "" {
+			host = fwd
+		}
+		base = scheme + "://" + host
+	}
+	feed := base + "/api/calendar/feed/" + token + ".ics"
+	webcal := "webcal://" + strings.TrimPrefix(strings.TrimPrefix(feed, "https://"), "http://")
+	return feed, webcal
+}
+
+func calendarFeedJSON(c *gin.Context, feed models.CalendarFeed) gin.H {
+	url, webcal := calendarFeedURLs(c, feed.Token)
+	return gin.H{
+		"feed_url":         url,
+		"webcal_url":       webcal,
+		"created_at":       feed.CreatedAt,
+		"last_accessed_at": feed.LastAccessedAt,
+	}
+}
+
+// CreateCalendarFeed issues a new private feed URL for the user's reminders.
+// Any previous URL is revoked, so this also rotates a leaked link.
+func CreateCalendarFeed(c *gin.Context) {
+	userID := parseInt(c.Param("user_id"))
+	var exists bool
+	if err := config.DB.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id=$1)", userID); err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	token, err := services.NewCalendarToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE calendar_feeds SET revoked_at=NOW() WHERE user_id=$1 AND revoked_at IS NULL", userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var feed models.CalendarFeed
+	if err := tx.Get(&feed, "INSERT INTO calendar_feeds (user_id, token) VALUES ($1, $2) RETURNING *", userID, token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar feed: " + err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, calendarFeedJSON(c, feed))
+}
+
+// GetCalendarFeed returns the user's current feed URL
+func GetCalendarFeed(c *gin.Context) {
+	var feed models.CalendarFeed
+	err := config.DB.Get(&feed, `
+		SELECT * FROM calendar_feeds WHERE user_id=$1 AND revoked_at IS NULL ORDER BY created_at DESC LIMIT 1
+	`, parseInt(c.Param("user_id")))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No calendar feed; create one first"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, calendarFeedJSON(c, feed))
+}
+
+// RevokeCalendarFeed turns off the user's feed URL; subscribed calendars stop
+// updating
+func RevokeCalendarFeed(c *gin.Context) {
+	res, err := config.DB.Exec("UPDATE calendar_feeds SET revoked_at=NOW() WHERE user_id=$1 AND revoked_at IS NULL", parseInt(c.Param("user_id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No calendar feed to revoke"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar feed revoked"})
+}
+
+// ServeCalendarFeed serves the ICS feed for a token. This is what Google
+// Calendar or Outlook poll, so the token is the only credential.
+func ServeCalendarFeed(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+	var feed models.CalendarFeed
+	err := config.DB.Get(&feed, "SELECT * FROM calendar_feeds WHERE token=$1 AND revoked_at IS NULL", token)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar feed not found"})
+		return
+	}
+
+	var schedules []models.Schedule
+	err = config.DB.Select(&schedules, "SELECT * FROM schedules WHERE user_id=$1 AND active=true ORDER BY id", feed.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := config.DB.Exec("UPDATE calendar_feeds SET last_accessed_at=NOW() WHERE id=$1", feed.ID); err != nil {
+		fmt.Printf("Warning: failed to update calendar feed %d access time: %v\n", feed.ID, err)
+	}
+
+	body := services.ScheduleCalendar(calendarName, schedules, services.UserLocation(feed.UserID), time.Now())
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Header("Content-Disposition", `inline; filename="khoj.ics"`)
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(body))
+}
+
+var icsFilenameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// DownloadScheduleICS downloads one schedule as an .ics file to import, mainly
+// for one-off reminders (?user_id= must own it)
+func DownloadScheduleICS(c *gin.Context) {
+	var s models.Schedule
+	err := config.DB.Get(&s, "SELECT * FROM schedules WHERE id=$1 AND user_id=$2", parseInt(c.Param("id")), parseInt(c.Query("user_id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found or unauthorized"})
+		return
+	}
+
+	body := services.ScheduleCalendar(calendarName, []models.Schedule{s}, services.UserLocation(s.UserID), time.Now())
+	name := strings.Trim(icsFilenameUnsafe.ReplaceAllString(strings.ToLower(s.Topic), "-"), "-")
+	if name == "" {
+		name = "reminder"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="khoj-%s.ics"`, name))
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(body))
+}
@@ -15,11 +15,15 @@ import (
     "golang-service/services"
 )
 
-// 🧩 Start a new chat
+// 🧩 Start a chat. A learner can have several chats on a topic: by default
+// the most recently active one is reopened, and "new": true starts another.
+// New chats are named after a few turns unless given a title.
 func StartChat(c *gin.Context) {
 	var body struct {
 		UserID int    `json:"user_id"`
 		Topic  string `json:"topic"`
+		Title  string `json:"title"`
+		New    bool   `json:"new"`
 	}
 
 	if err := c.BindJSON(&body); err != nil {
@@ -27,11 +31,14 @@ func StartChat(c *gin.Context) {
 		return
 	}
 
-	// Check if user already has chat on this topic
+	// Reopen the latest chat on this topic unless a new one is asked for
 	var existingChat models.Chat
-	err := config.DB.Get(&existingChat,
-		"SELECT * FROM chats WHERE user_id=$1 AND topic=$2",
-		body.UserID, body.Topic)
+	err := sql.ErrNoRows
+	if !body.New {
+		err = config.DB.Get(&existingChat,
+			"SELECT * FROM chats WHERE user_id=$1 AND topic=$2 ORDER BY updated_at DESC, id DESC LIMIT 1",
+			body.UserID, body.Topic)
+	}
 	
 	// If chat exists, return the existing chat_id instead of error
 	if err == nil {
@@ -43,7 +50,7 @@ func StartChat(c *gin.Context) {
 			// Log but don't fail - we still return the chat
 			fmt.Printf("Warning: failed to update chat timestamp: %v\n", updateErr)
 		}
-		c.JSON(http.StatusOK, gin.H{"chat_id": existingChat.ID, "existing": true})
+		c.JSON(http.StatusOK, gin.H{"chat_id": existingChat.ID, "existing": true, "title": existingChat.Title})
 		return
 	}
 	
@@ -56,18 +63,19 @@ func StartChat(c *gin.Context) {
 	// Create new chat
 	chatID := uuid.New().String()
 	now := time.Now()
+	title := services.CleanChatTitle(body.Title)
 
 	_, err = config.DB.Exec(`
-		INSERT INTO chats (id, user_id, topic, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
-	`, chatID, body.UserID, body.Topic, now, now)
+		INSERT INTO chats (id, user_id, topic, created_at, updated_at, title, title_by_user)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, chatID, body.UserID, body.Topic, now, now, title, title != "")
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"chat_id": chatID, "existing": false})
+	c.JSON(http.StatusOK, gin.H{"chat_id": chatID, "existing": false, "title": title})
 }
 
 // 🧩 Send a message and get a bot reply
@@ -91,16 +99,33 @@ func SendMessage(c *gin.Context) {
 		return
 	}
 
+    // Commands ("quiz here", "/remind 7pm daily", a quiz answer...) are
+    // carried out instead of going to the LLM
+    intent, isCommand := services.ParseIntent(body.Message, services.ChatContext{QuizActive: chatQuizActive(chat.ID, body.UserID)})
+    if isCommand && intent.Name == services.IntentAnswerQuiz {
+        // SubmitQuizAnswer stores the answer and the feedback itself
+        reply, action := runChatCommand(c, chat, intent)
+        c.JSON(http.StatusOK, gin.H{"reply": reply, "action": action})
+        return
+    }
+
     // Save user message
-    userMsgID := uuid.New().String()
-    _, err = config.DB.Exec(`
-        INSERT INTO messages (id, chat_id, role, content, created_at)
-        VALUES ($1, $2, 'user', $3, $4)
-    `, userMsgID, body.ChatID, body.Message, time.Now())
+    userMsg, err := services.AppendMessage(c.Request.Context(), config.DB, body.ChatID, "user", body.Message, "")
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
+    services.Publish(services.Event{Type: services.EventMessageSent, UserID: body.UserID, SourceID: userMsg.ID, Topic: chat.Topic})
+
+    if isCommand {
+        reply, action := runChatCommand(c, chat, intent)
+        if err := saveBotMessage(body.ChatID, reply); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"reply": reply, "action": action})
+        return
+    }
 
     // Enforce topic consistency: if message is off-topic, do NOT create a bot reply
     if !isMessageOnTopic(body.Message, chat.Topic) {
@@ -121,43 +146,89 @@ func SendMessage(c *gin.Context) {
     // Allow caller to specify a model; else service will fall back
     preferredModel := strings.TrimSpace(c.GetHeader("X-Gemini-Model"))
 
-    // Generate bot reply from Gemini
-    botReply, err := services.GenerateGeminiReply(context.Background(), apiKey, preferredModel, chat.Topic, body.Message)
+    // Generate bot reply from Gemini, in the learner's tutor persona
+    systemPrompt, err := services.TutorSystemPrompt(body.UserID, chat.Topic)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        return
+    }
+    botReply, model, err := services.GenerateGeminiReply(context.Background(), apiKey, preferredModel, systemPrompt, body.Message)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
 
-    botMsgID := uuid.New().String()
-    _, err = config.DB.Exec(`
-        INSERT INTO messages (id, chat_id, role, content, created_at)
-        VALUES ($1, $2, 'bot', $3, $4)
-    `, botMsgID, body.ChatID, botReply, time.Now())
+    botMsg, err := services.AppendMessage(c.Request.Context(), config.DB, body.ChatID, "bot", botReply, model)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
+    // Feedback on the reply is attributed to the prompt versions behind it
+    services.LogPromptUsage(systemPrompt, body.UserID, model, services.PromptSourceMessage, botMsg.ID)
+    services.RefreshChatDigestAsync(apiKey, preferredModel, chat.ID)
 
     c.JSON(http.StatusOK, gin.H{
-        "reply": botReply,
+        "reply":      botReply,
+        "message_id": botMsg.ID,
     })
 }
 
-// 🧩 Get full chat history
+// 🧩 Get chat history: the messages on the chat's active branch, oldest
+// first, with how many versions (edits or regenerated replies) each has.
+// A page holds the latest ?limit= (default 100) messages; next_cursor pages
+// further back. ?since= (a sync_cursor or a time) returns only newer
+// messages, for incremental sync. If active_leaf_id changes between syncs,
+// the learner switched branches and the history should be reloaded.
 func GetChatHistory(c *gin.Context) {
-	chatID := c.Param("id")
-	var messages []models.Message
-
-	err := config.DB.Select(&messages, "SELECT * FROM messages WHERE chat_id=$1 ORDER BY created_at ASC", chatID)
+	page, ok := parsePageQuery(c, 100, 500, true)
+	if !ok {
+		return
+	}
+	var chat models.Chat
+	err := config.DB.Get(&chat, "SELECT * FROM chats WHERE id=$1", c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	messages, more, err := services.ActivePathPage(chat.ID, page)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, messages)
+	// next_cursor continues away from the cursor: older messages when paging
+	// back, newer ones when syncing. sync_cursor marks the newest message seen.
+	var nextCursor, syncCursor *string
+	if len(messages) > 0 {
+		first, last := messages[0], messages[len(messages)-1]
+		if page.Since != nil {
+			nextCursor = pageCursor(more, last.CreatedAt, last.ID)
+		} else {
+			nextCursor = pageCursor(more, first.CreatedAt, first.ID)
+		}
+		if page.Cursor == nil {
+			syncCursor = pageCursor(true, last.CreatedAt, last.ID)
+		}
+	} else if page.Since != nil {
+		since := c.Query("since")
+		syncCursor = &since
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":          messages,
+		"next_cursor":    nextCursor,
+		"sync_cursor":    syncCursor,
+		"active_leaf_id": chat.ActiveLeafID,
+	})
 }
 
-// 🧩 Get all chats for a user
+// 🧩 Get a user's chats, most recently active first, in pages of ?limit=
+// (default 50). ?since= (a sync_cursor or a time) instead lists the chats
+// that changed after it, oldest change first. ?topic= keeps the chats on
+// one topic; a new title or summary counts as a change.
 func GetUserChats(c *gin.Context) {
 	userIDStr := c.Param("user_id")
 	var userID int
@@ -168,19 +239,58 @@ func GetUserChats(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
 		return
 	}
+	page, ok := parsePageQuery(c, 50, 200, true)
+	if !ok {
+		return
+	}
 	
-	var chats []models.Chat
-	err = config.DB.Select(&chats, "SELECT * FROM chats WHERE user_id=$1 ORDER BY updated_at DESC", userID)
+	topic := c.Query("topic")
+	
+	chats := []models.Chat{}
+	if page.Since != nil {
+		err = config.DB.Select(&chats, `
+			SELECT * FROM chats WHERE user_id=$1 AND ($5 = '' OR topic=$5) AND (updated_at, id) > ($2, $3)
+			ORDER BY updated_at, id
+			LIMIT $4
+		`, userID, page.Since.Time, page.Since.ID, page.Limit+1, topic)
+	} else if page.Cursor != nil {
+		err = config.DB.Select(&chats, `
+			SELECT * FROM chats WHERE user_id=$1 AND ($5 = '' OR topic=$5) AND (updated_at, id) < ($2, $3)
+			ORDER BY updated_at DESC, id DESC
+			LIMIT $4
+		`, userID, page.Cursor.Time, page.Cursor.ID, page.Limit+1, topic)
+	} else {
+		err = config.DB.Select(&chats, `
+			SELECT * FROM chats WHERE user_id=$1 AND ($3 = '' OR topic=$3)
+			ORDER BY updated_at DESC, id DESC
+			LIMIT $2
+		`, userID, page.Limit+1, topic)
+	}
 	if err != nil {
 		fmt.Printf("Error fetching chats for user %d: %v\n", userID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	more := len(chats) > page.Limit
+	if more {
+		chats = chats[:page.Limit]
+	}
 
-	// Log for debugging
-	fmt.Printf("Found %d chats for user %d\n", len(chats), userID)
-	
-	c.JSON(http.StatusOK, chats)
+	var nextCursor, syncCursor *string
+	if len(chats) > 0 {
+		last := chats[len(chats)-1]
+		nextCursor = pageCursor(more, last.UpdatedAt, last.ID)
+		switch {
+		case page.Since != nil:
+			syncCursor = pageCursor(true, last.UpdatedAt, last.ID)
+		case page.Cursor == nil:
+			syncCursor = pageCursor(true, chats[0].UpdatedAt, chats[0].ID)
+		}
+	} else if page.Since != nil {
+		since := c.Query("since")
+		syncCursor = &since
+	}
+	c.JSON(http.StatusOK, gin.H{"items": chats, "next_cursor": nextCursor, "sync_cursor": syncCursor})
 }
 
 // 🧩 Delete a chat
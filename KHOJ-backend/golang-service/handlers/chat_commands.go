@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Status of a chat command's action
+const (
+	actionDone   = "done"
+	actionFailed = "failed"
+)
+
+// chatAction is the structured result of a command, returned as "action"
+// alongside the reply so the frontend can react (open the quiz, show the new
+// schedule, navigate)
+type chatAction struct {
+	Type   string      `json:"type"`
+	Status string      `json:"status"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// chatQuizActive reports whether the chat has a quiz with unanswered questions
+func chatQuizActive(chatID string, userID int) bool {
+	var open int
+	err := config.DB.Get(&open, `
+		SELECT COUNT(*) FROM quiz_questions qq
+		JOIN quizzes q ON q.id = qq.quiz_id
+		WHERE q.chat_id=$1 AND q.user_id=$2 AND q.status != 'completed'
+		AND (qq.user_answer IS NULL OR qq.user_answer = '')
+	`, chatID, userID)
+	return err == nil && open > 0
+}
+
+// actionFrom turns a service result into an action; failed actions carry the
+// error's message
+func actionFrom(kind string, result interface{}, err error) chatAction {
+	if err != nil {
+		return chatAction{Type: kind, Status: actionFailed, Error: err.Error()}
+	}
+	return chatAction{Type: kind, Status: actionDone, Result: result}
+}
+
+// runChatCommand carries out a recognised command and returns the bot's reply
+// and the action result. Quiz answers are handled by services.AnswerQuiz,
+// which stores both messages itself; every other reply is stored by the
+// caller.
+func runChatCommand(c *gin.Context, chat models.Chat, intent services.Intent) (string, chatAction) {
+	switch intent.Name {
+	case services.IntentStartQuiz:
+		return startQuizCommand(c, chat, intent)
+
+	case services.IntentAnswerQuiz:
+		answer := strings.TrimSpace(intent.Args["answer"])
+		if answer == "" {
+			return "Tell me your answer, e.g. '/answer B'.", chatAction{Type: intent.Name, Status: actionFailed, Error: "answer is required"}
+		}
+		result, err := services.AnswerQuiz(c.Request.Context(), chat.UserID, chat.ID, answer)
+		if err != nil {
+			return "There's no quiz question waiting for an answer. Type 'quiz here' to start one.", actionFrom(intent.Name, nil, err)
+		}
+		return result.Response, actionFrom(intent.Name, quizAnswerResponse(result), nil)
+
+	case services.IntentSetReminder:
+		return reminderCommand(c, chat, intent)
+
+	case services.IntentSummarize:
+		return summarizeCommand(c, chat)
+
+	case services.IntentSnooze, services.IntentSkip:
+		return reminderActionCommand(c, chat, intent)
+
+	case services.IntentDashboard:
+		return "Opening your dashboard.", chatAction{Type: intent.Name, Status: actionDone, Result: gin.H{"navigate": "/Dashboard"}}
+	}
+
+	reply := services.ChatCommandHelp
+	if unknown := intent.Args["unknown"]; unknown != "" {
+		reply = fmt.Sprintf("I don't know the command /%s.\n\n%s", unknown, reply)
+	}
+	return reply, chatAction{Type: services.IntentHelp, Status: actionDone}
+}
+
+// startQuizCommand starts (or resumes) a quiz on the chat's topic and shows the
+// first open question. Starting a quiz answers the latest reminder.
+func startQuizCommand(c *gin.Context, chat models.Chat, intent services.Intent) (string, chatAction) {
+	duration := 10
+	if d, err := strconv.Atoi(intent.Args["duration"]); err == nil && d > 0 {
+		duration = d
+	}
+	start, err := services.StartQuiz(context.Background(), services.ResolveGeminiAPIKeyFromRequest(c), chat.UserID, chat.ID, chat.Topic, duration)
+	action := actionFrom(intent.Name, quizStartResponse(start), err)
+	if err != nil {
+		return fmt.Sprintf("Sorry, I couldn't start a quiz on '%s' right now.", chat.Topic), action
+	}
+
+	if inst, err := services.LatestOpenReminder(chat.UserID, chat.ID); err == nil && inst.State != services.ReminderAcknowledged {
+		if _, err := services.UpdateReminderState(inst.ID, chat.UserID, services.ReminderAcknowledged, 0); err != nil {
+			fmt.Printf("Warning: failed to acknowledge reminder %d: %v\n", inst.ID, err)
+		}
+	}
+
+	next, err := services.NextQuizQuestion(start.QuizID)
+	if err != nil {
+		return fmt.Sprintf("Your quiz on '%s' is ready.", chat.Topic), action
+	}
+
+	intro := fmt.Sprintf("🧠 Quiz on '%s': %d questions. Reply with the letter of your answer.", chat.Topic, start.TotalQuestions)
+	if start.Existing {
+		intro = fmt.Sprintf("🧠 Resuming your quiz on '%s'.", chat.Topic)
+	}
+	return intro + "\n\n" + services.FormatQuizQuestion(next, start.TotalQuestions), action
+}
+
+// reminderCommand creates a reminder schedule for the chat from the parsed
+// phrase. One-time reminders at a time that has already passed today move to
+// tomorrow.
+func reminderCommand(c *gin.Context, chat models.Chat, intent services.Intent) (string, chatAction) {
+	args := intent.Args
+	if args["reminder_time"] == "" && args["in_minutes"] == "" {
+		return "When should I remind you? Try '/remind 7pm daily', '/remind 18:30 on weekdays' or '/remind in 30 minutes'.",
+			chatAction{Type: intent.Name, Status: actionFailed, Error: "no time given"}
+	}
+
+	req := services.ScheduleRequest{
+		RecurrenceType: args["recurrence_type"],
+		ReminderTime:   args["reminder_time"],
+		DaysOfWeek:     args["days_of_week"],
+	}
+
+	if args["recurrence_type"] == "once" {
+		loc := services.UserLocation(chat.UserID)
+		now := time.Now().In(loc)
+		var at time.Time
+		if mins, err := strconv.Atoi(args["in_minutes"]); err == nil {
+			at = now.Add(time.Duration(mins) * time.Minute)
+		} else if clock, err := services.ParseClock(args["reminder_time"]); err == nil {
+			day := now
+			if args["day"] == "tomorrow" {
+				day = now.AddDate(0, 0, 1)
+			}
+			at = services.LocalTime(day.Year(), day.Month(), day.Day(), clock/60, clock%60, 0, loc)
+			if !at.After(now) {
+				day = day.AddDate(0, 0, 1)
+				at = services.LocalTime(day.Year(), day.Month(), day.Day(), clock/60, clock%60, 0, loc)
+			}
+		}
+		req.ScheduledTime = at.Format(time.RFC3339)
+		req.ReminderTime = ""
+	}
+
+	created, err := services.CreateSchedule(chat.UserID, chat.ID, req, time.Now())
+	if err != nil {
+		return "I couldn't set that reminder: " + err.Error(), actionFrom(intent.Name, nil, err)
+	}
+	action := actionFrom(intent.Name, scheduleCreatedResponse(created), nil)
+
+	when := created.FireAt.In(created.Location).Format("Mon Jan 2 at 15:04")
+	switch args["recurrence_type"] {
+	case "daily":
+		return fmt.Sprintf("⏰ Done! I'll remind you to quiz on '%s' every day at %s. First reminder: %s.", chat.Topic, args["reminder_time"], when), action
+	case "weekly":
+		return fmt.Sprintf("⏰ Done! I'll remind you to quiz on '%s' every %s at %s. First reminder: %s.", chat.Topic, weekdayNames(args["days_of_week"]), args["reminder_time"], when), action
+	}
+	return fmt.Sprintf("⏰ Done! I'll remind you to quiz on '%s' on %s.", chat.Topic, when), action
+}
+
+// reminderActionCommand snoozes or skips the latest open reminder in the chat
+func reminderActionCommand(c *gin.Context, chat models.Chat, intent services.Intent) (string, chatAction) {
+	inst, err := services.LatestOpenReminder(chat.UserID, chat.ID)
+	if err != nil {
+		return "There's no open reminder in this chat.", chatAction{Type: intent.Name, Status: actionFailed, Error: "no open reminder"}
+	}
+	if intent.Name == services.IntentSkip {
+		updated, err := services.UpdateReminderState(inst.ID, chat.UserID, services.ReminderSkipped, 0)
+		if err != nil {
+			return "I couldn't skip the reminder: " + err.Error(), actionFrom(intent.Name, nil, err)
+		}
+		return fmt.Sprintf("👍 Skipped today's reminder for '%s'.", chat.Topic), actionFrom(intent.Name, updated, nil)
+	}
+
+	minutes, _ := strconv.Atoi(intent.Args["minutes"])
+	if minutes == 0 {
+		minutes = 10
+	}
+	updated, err := services.UpdateReminderState(inst.ID, chat.UserID, services.ReminderSnoozed, minutes)
+	if err != nil {
+		return "I couldn't snooze the reminder: " + err.Error(), actionFrom(intent.Name, nil, err)
+	}
+	until := ""
+	if updated.SnoozedUntil != nil {
+		until = updated.SnoozedUntil.In(services.UserLocation(chat.UserID)).Format("15:04")
+	}
+	return fmt.Sprintf("😴 Snoozed. I'll remind you again at %s.", until), actionFrom(intent.Name, updated, nil)
+}
+
+// summaryMessageLimit caps how much of the chat goes into a summary prompt
+const summaryMessageLimit = 60
+
+// summarizeCommand asks Gemini for a summary of the recent conversation
+func summarizeCommand(c *gin.Context, chat models.Chat) (string, chatAction) {
+	messages, err := services.ActivePath(chat.ID, summaryMessageLimit)
+	if err != nil {
+		return "I couldn't load this chat to summarize it.", chatAction{Type: services.IntentSummarize, Status: actionFailed, Error: err.Error()}
+	}
+
+	transcript := services.ChatTranscript(chat.Topic, messages)
+	if transcript == "" {
+		return "There's nothing to summarize yet.", chatAction{Type: services.IntentSummarize, Status: actionDone}
+	}
+
+	apiKey := services.ResolveGeminiAPIKeyFromRequest(c)
+	if apiKey == "" {
+		return "I can't summarize right now.", chatAction{Type: services.IntentSummarize, Status: actionFailed, Error: "GEMINI_API_KEY not set"}
+	}
+	prompt, err := services.RenderPrompt(services.PromptChatSummary, chat.UserID, services.SummaryPromptData{Topic: chat.Topic, Transcript: transcript})
+	if err != nil {
+		return "I couldn't summarize this chat right now.", chatAction{Type: services.IntentSummarize, Status: actionFailed, Error: err.Error()}
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+	defer cancel()
+	summary, model, err := services.GenerateGeminiText(ctx, apiKey, strings.TrimSpace(c.GetHeader("X-Gemini-Model")), prompt)
+	if err != nil {
+		return "I couldn't summarize this chat right now.", chatAction{Type: services.IntentSummarize, Status: actionFailed, Error: err.Error()}
+	}
+	services.LogPromptUsage(prompt, chat.UserID, model, services.PromptSourceChatSummary, chat.ID)
+	return "📋 Summary so far:\n" + summary, chatAction{Type: services.IntentSummarize, Status: actionDone, Result: gin.H{"messages": len(messages)}}
+}
+
+// weekdayNames turns "1,3,5" into "Mon, Wed, Fri"
+func weekdayNames(days string) string {
+	var names []string
+	for _, d := range strings.Split(days, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(d)); err == nil && n >= 0 && n <= 6 {
+			names = append(names, time.Weekday(n).String()[:3])
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// saveBotMessage stores a bot reply in the chat
+func saveBotMessage(chatID string, content string) error {
+	_, err := services.AppendMessage(context.Background(), config.DB, chatID, "bot", content, "")
+	return err
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RenameChat sets a chat's title. A title set this way is kept; an empty
+// one goes back to the generated title.
+func RenameChat(c *gin.Context) {
+	var body struct {
+		UserID int    `json:"user_id"`
+		Title  string `json:"title"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	chat, ok := ownedChat(c, body.UserID)
+	if !ok {
+		return
+	}
+	title := services.CleanChatTitle(body.Title)
+	var renamed models.Chat
+	err := config.DB.Get(&renamed, `
+		UPDATE chats SET title=$2, title_by_user=$3, updated_at=NOW()
+		WHERE id=$1
+		RETURNING *
+	`, chat.ID, title, title != "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if title == "" {
+		if apiKey := services.ResolveGeminiAPIKeyFromRequest(c); apiKey != "" {
+			services.RefreshChatDigestAsync(apiKey, strings.TrimSpace(c.GetHeader("X-Gemini-Model")), chat.ID)
+		}
+	}
+	c.JSON(http.StatusOK, renamed)
+}
+
+// GetChatNotes condenses a chat's active branch into study notes: key points
+// and definitions. Notes are generated once per branch state; ?refresh=true
+// makes new ones.
+func GetChatNotes(c *gin.Context) {
+	chat, ok := ownedChat(c, parseInt(c.Query("user_id")))
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 90*time.Second)
+	defer cancel()
+	notes, stored, err := services.ChatStudyNotes(ctx, services.ResolveGeminiAPIKeyFromRequest(c),
+		strings.TrimSpace(c.GetHeader("X-Gemini-Model")), chat, c.Query("refresh") == "true")
+	if err == services.ErrNothingToNote {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"chat_id":      chat.ID,
+		"title":        services.ChatName(chat),
+		"topic":        chat.Topic,
+		"summary":      chat.Summary,
+		"key_points":   notes.KeyPoints,
+		"definitions":  notes.Definitions,
+		"leaf_id":      stored.LeafID,
+		"model":        stored.Model,
+		"generated_at": stored.CreatedAt,
+	})
+}
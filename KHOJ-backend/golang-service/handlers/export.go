@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportChat downloads a chat with its quizzes as ?format=markdown (the
+// default), pdf or json. Markdown and PDF show the active branch; JSON keeps
+// every version of every message. A PDF of text its fonts can't show (CJK,
+// emoji) is refused with 422 and the formats that can carry it.
+func ExportChat(c *gin.Context) {
+	chat, ok := ownedChat(c, parseInt(c.Query("user_id")))
+	if !ok {
+		return
+	}
+	archive, err := services.LoadChatArchive(chat)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	out, contentType, ext, err := services.ExportChat(c.DefaultQuery("format", services.ChatFormatMarkdown), archive)
+	var unsupported *services.UnsupportedCharsError
+	if errors.As(err, &unsupported) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":   err.Error(),
+			"formats": []string{services.ChatFormatMarkdown, services.ChatFormatJSON},
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", services.ChatExportName(chat)+"."+ext))
+	c.Data(http.StatusOK, contentType, out)
+}
+
+// RequestDataExport starts building a zip of everything stored about the
+// user. The job runs in the background; poll it and download the zip once
+// its status is done. Asking while a job is running returns that job.
+func RequestDataExport(c *gin.Context) {
+	job, created, err := services.RequestDataExport(parseInt(c.Param("user_id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	status := http.StatusAccepted
+	if !created {
+		status = http.StatusOK
+	}
+	c.JSON(status, job)
+}
+
+// GetDataExports lists the user's recent data exports
+func GetDataExports(c *gin.Context) {
+	jobs, err := services.DataExports(parseInt(c.Param("user_id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// GetDataExport shows the status of one data export
+func GetDataExport(c *gin.Context) {
+	job, err := services.DataExport(parseInt(c.Param("user_id")), parseInt(c.Param("id")), false)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// DownloadDataExport sends the zip of a finished data export
+func DownloadDataExport(c *gin.Context) {
+	job, err := services.DataExport(parseInt(c.Param("user_id")), parseInt(c.Param("id")), true)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	switch {
+	case job.Status == services.ExportFailed:
+		c.JSON(http.StatusConflict, gin.H{"error": "Export failed: " + job.Error, "status": job.Status})
+		return
+	case job.Status != services.ExportDone:
+		c.JSON(http.StatusConflict, gin.H{"error": "Export is not ready yet", "status": job.Status})
+		return
+	case job.ExpiresAt != nil && job.ExpiresAt.Before(time.Now()):
+		c.JSON(http.StatusGone, gin.H{"error": "Export has expired; request a new one"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "khoj-data-"+job.CreatedAt.Format("2006-01-02")+".zip"))
+	c.Data(http.StatusOK, "application/zip", job.Archive)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUserFacts returns whether the learner gets daily facts, the facts they
+// were sent with their ratings, the next planned delivery and muted topics
+func GetUserFacts(c *gin.Context) {
+	userID := parseInt(c.Param("user_id"))
+	enabled, err := services.FactsEnabled(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	facts, err := services.UserFacts(userID, 50)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	next, err := services.NextUserFact(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	mutes, err := services.FactMutes(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": enabled, "facts": facts, "next": next, "muted_topics": mutes})
+}
+
+// RateFact records a thumbs up (1) or down (-1) on a fact the learner was
+// sent; 0 clears it
+func RateFact(c *gin.Context) {
+	var body struct {
+		UserID int  `json:"user_id" binding:"required"`
+		Rating *int `json:"rating" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	fact, err := services.RateFact(body.UserID, parseInt(c.Param("id")), *body.Rating)
+	switch err {
+	case nil:
+	case services.ErrFactNotReceived:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case services.ErrInvalidFactRating:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, fact)
+}
+
+// MuteFact stops facts on the topic of a fact the learner was sent
+func MuteFact(c *gin.Context) {
+	var body struct {
+		UserID int `json:"user_id" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	mute, err := services.MuteFact(body.UserID, parseInt(c.Param("id")))
+	switch err {
+	case nil:
+	case services.ErrFactNotReceived:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, mute)
+}
+
+// MuteFactTopic stops facts on a topic for the learner
+func MuteFactTopic(c *gin.Context) {
+	var body struct {
+		Topic string `json:"topic" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	mute, err := services.MuteFactTopic(parseInt(c.Param("user_id")), body.Topic)
+	switch err {
+	case nil:
+	case services.ErrNoFactTopic:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, mute)
+}
+
+// UnmuteFactTopic lets facts on ?topic= through again
+func UnmuteFactTopic(c *gin.Context) {
+	found, err := services.UnmuteFactTopic(parseInt(c.Param("user_id")), c.Query("topic"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Topic is not muted"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Topic unmuted"})
+}
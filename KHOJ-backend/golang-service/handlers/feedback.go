@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateMessage records a thumbs up or down on a bot reply, with an optional
+// reason. Rating again replaces the earlier rating.
+func RateMessage(c *gin.Context) {
+	var body struct {
+		UserID int    `json:"user_id" binding:"required"`
+		Rating string `json:"rating" binding:"required"` // "up" or "down"
+		Reason string `json:"reason"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	var rating int
+	switch strings.ToLower(strings.TrimSpace(body.Rating)) {
+	case "up":
+		rating = services.RatingUp
+	case "down":
+		rating = services.RatingDown
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rating must be \"up\" or \"down\""})
+		return
+	}
+	msg, _, ok := ownedMessage(c, body.UserID)
+	if !ok {
+		return
+	}
+	fb, err := services.RateMessage(msg, body.UserID, rating, strings.TrimSpace(body.Reason))
+	if errors.Is(err, services.ErrNotABotReply) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, fb)
+}
+
+// ClearMessageRating removes the learner's rating of a reply
+func ClearMessageRating(c *gin.Context) {
+	userID := parseInt(c.Query("user_id"))
+	msg, _, ok := ownedMessage(c, userID)
+	if !ok {
+		return
+	}
+	if err := services.ClearMessageRating(msg.ID, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Rating removed"})
+}
+
+// GetFeedbackReport shows admins how replies were rated by topic and model
+// over the last ?days= (default 30). Groups with fewer than ?min_ratings=
+// (default 5) ratings are left out.
+func GetFeedbackReport(c *gin.Context) {
+	if !requireAdmin(c, parseInt(c.Query("user_id"))) {
+		return
+	}
+	minRatings := 5
+	if v, err := strconv.Atoi(c.Query("min_ratings")); err == nil && v > 0 {
+		minRatings = v
+	}
+	since := feedbackSince(c)
+	report, err := services.FeedbackReport(since, minRatings)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"since": since, "groups": report})
+}
+
+// GetLowRatedReplies lists recent thumbs-down replies with the question they
+// answered, optionally for one ?topic= or ?model=
+func GetLowRatedReplies(c *gin.Context) {
+	if !requireAdmin(c, parseInt(c.Query("user_id"))) {
+		return
+	}
+	limit := 50
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 && v <= 200 {
+		limit = v
+	}
+	replies, err := services.LowRatedReplies(strings.TrimSpace(c.Query("topic")), strings.TrimSpace(c.Query("model")), feedbackSince(c), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, replies)
+}
+
+// feedbackSince is the start of the ?days= (default 30) feedback reports cover
+func feedbackSince(c *gin.Context) time.Time {
+	days := 30
+	if v, err := strconv.Atoi(c.Query("days")); err == nil && v > 0 && v <= 365 {
+		days = v
+	}
+	return time.Now().AddDate(0, 0, -days).Truncate(time.Hour)
+}
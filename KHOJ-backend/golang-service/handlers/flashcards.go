@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GenerateFlashcards has the tutor write cards into the user's deck on a
+// topic: from a chat's conversation when chat_id is given (the deck is the
+// chat's topic), otherwise from the topic alone. count defaults to 10.
+func GenerateFlashcards(c *gin.Context) {
+	var body struct {
+		UserID int    `json:"user_id" binding:"required"`
+		ChatID string `json:"chat_id"`
+		Topic  string `json:"topic"`
+		Count  int    `json:"count"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if body.Count == 0 {
+		body.Count = services.DefaultFlashcardCount
+	}
+	if body.Count < 1 || body.Count > services.MaxFlashcardCount {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "count must be between 1 and " + strconv.Itoa(services.MaxFlashcardCount)})
+		return
+	}
+
+	var chat *models.Chat
+	if body.ChatID != "" {
+		var owned models.Chat
+		err := config.DB.Get(&owned, "SELECT * FROM chats WHERE id=$1 AND user_id=$2", body.ChatID, body.UserID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found or unauthorized"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		chat, body.Topic = &owned, owned.Topic
+	}
+
+	apiKey := services.ResolveGeminiAPIKeyFromRequest(c)
+	if apiKey == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "GEMINI_API_KEY not set"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 90*time.Second)
+	defer cancel()
+	deck, cards, skipped, err := services.GenerateFlashcards(ctx, apiKey, strings.TrimSpace(c.GetHeader("X-Gemini-Model")),
+		body.UserID, body.Topic, chat, body.Count)
+	switch err {
+	case nil:
+	case services.ErrNoDeckTopic, services.ErrNothingToNote:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate flashcards: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"deck": deck, "cards": cards, "skipped": skipped})
+}
+
+// GetFlashcardDecks lists the user's decks with their card and due counts
+func GetFlashcardDecks(c *gin.Context) {
+	decks, err := services.FlashcardDecks(parseInt(c.Query("user_id")), 0, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, decks)
+}
+
+// GetFlashcardDeck returns a deck (?user_id= must own it) with its cards
+func GetFlashcardDeck(c *gin.Context) {
+	deck, ok := ownedFlashcardDeck(c, parseInt(c.Query("user_id")))
+	if !ok {
+		return
+	}
+	cards := []models.Flashcard{}
+	if err := config.DB.Select(&cards, "SELECT * FROM flashcards WHERE deck_id=$1 ORDER BY created_at, id", deck.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deck": deck, "cards": cards})
+}
+
+// DeleteFlashcardDeck deletes a deck with its cards and their reviews
+func DeleteFlashcardDeck(c *gin.Context) {
+	deck, ok := ownedFlashcardDeck(c, parseInt(c.Query("user_id")))
+	if !ok {
+		return
+	}
+	if _, err := config.DB.Exec("DELETE FROM flashcard_decks WHERE id=$1", deck.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Deck deleted"})
+}
+
+// GetDueFlashcards returns the cards to review now, longest overdue first,
+// from every deck or just ?deck_id=, up to ?limit= (default 20)
+func GetDueFlashcards(c *gin.Context) {
+	limit := 20
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		limit = n
+	}
+	cards, err := services.DueFlashcards(parseInt(c.Query("user_id")), parseInt(c.Query("deck_id")), time.Now(), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cards)
+}
+
+// CreateFlashcard adds a card by hand, to deck_id or to the deck on topic
+// (created if needed)
+func CreateFlashcard(c *gin.Context) {
+	var body struct {
+		UserID int    `json:"user_id" binding:"required"`
+		DeckID int    `json:"deck_id"`
+		Topic  string `json:"topic"`
+		Front  string `json:"front"`
+		Back   string `json:"back"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	var deck models.FlashcardDeck
+	var err error
+	if body.DeckID != 0 {
+		err = config.DB.Get(&deck, "SELECT * FROM flashcard_decks WHERE id=$1 AND user_id=$2", body.DeckID, body.UserID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found or unauthorized"})
+			return
+		}
+	} else {
+		deck, err = services.FlashcardDeckFor(body.UserID, body.Topic)
+		if err == services.ErrNoDeckTopic {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "deck_id or topic is required"})
+			return
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	card, err := services.AddFlashcard(deck, nil, body.Front, body.Back, services.FlashcardManual)
+	if !respondFlashcardError(c, err) {
+		return
+	}
+	c.JSON(http.StatusCreated, card)
+}
+
+// UpdateFlashcard edits a card's front and/or back
+func UpdateFlashcard(c *gin.Context) {
+	var body struct {
+		UserID int     `json:"user_id" binding:"required"`
+		Front  *string `json:"front"`
+		Back   *string `json:"back"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	card, ok := ownedFlashcard(c, body.UserID)
+	if !ok {
+		return
+	}
+	front, back := card.Front, card.Back
+	if body.Front != nil {
+		front = *body.Front
+	}
+	if body.Back != nil {
+		back = *body.Back
+	}
+	card, err := services.UpdateFlashcard(card, front, back)
+	if !respondFlashcardError(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, card)
+}
+
+// DeleteFlashcard deletes a card (?user_id= must own it)
+func DeleteFlashcard(c *gin.Context) {
+	card, ok := ownedFlashcard(c, parseInt(c.Query("user_id")))
+	if !ok {
+		return
+	}
+	if _, err := config.DB.Exec("DELETE FROM flashcards WHERE id=$1", card.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Card deleted"})
+}
+
+// ReviewFlashcard records how well the learner recalled a card, from 0
+// (forgot) to 5 (perfect), and returns the card with its next due date
+func ReviewFlashcard(c *gin.Context) {
+	var body struct {
+		UserID int  `json:"user_id" binding:"required"`
+		Grade  *int `json:"grade" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	card, err := services.ReviewFlashcard(parseInt(c.Param("id")), body.UserID, *body.Grade, time.Now())
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found or unauthorized"})
+		return
+	case services.ErrInvalidGrade:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, card)
+}
+
+// ownedFlashcardDeck loads the deck in the :id param with its counts,
+// checking it belongs to the user
+func ownedFlashcardDeck(c *gin.Context, userID int) (models.FlashcardDeck, bool) {
+	decks, err := services.FlashcardDecks(userID, parseInt(c.Param("id")), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return models.FlashcardDeck{}, false
+	}
+	if len(decks) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found or unauthorized"})
+		return models.FlashcardDeck{}, false
+	}
+	return decks[0], true
+}
+
+// ownedFlashcard loads the card in the :id param, checking it belongs to the
+// user
+func ownedFlashcard(c *gin.Context, userID int) (models.Flashcard, bool) {
+	var card models.Flashcard
+	err := config.DB.Get(&card, "SELECT * FROM flashcards WHERE id=$1 AND user_id=$2", parseInt(c.Param("id")), userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found or unauthorized"})
+		return card, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return card, false
+	}
+	return card, true
+}
+
+// respondFlashcardError sends the response for a failed card save. It
+// returns true when err is nil.
+func respondFlashcardError(c *gin.Context, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case services.ErrInvalidFlashcard:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case services.ErrDuplicateFlashcard:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAchievements returns the learner's XP, level, streak, earned achievements
+// and progress towards the ones not yet earned
+func GetAchievements(c *gin.Context) {
+	userID := parseInt(c.Param("user_id"))
+	if userID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+		return
+	}
+	summary, err := services.GetGamificationSummary(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load achievements: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// ReviewQuiz returns a completed quiz with the correct answers so the learner
+// can go over their mistakes. The first review of each quiz earns XP.
+func ReviewQuiz(c *gin.Context) {
+	var body struct {
+		UserID int `json:"user_id" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	var quiz models.Quiz
+	err := config.DB.Get(&quiz, "SELECT * FROM quizzes WHERE id=$1 AND user_id=$2", parseInt(c.Param("id")), body.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quiz not found or unauthorized"})
+		return
+	}
+	if quiz.Status != "completed" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only completed quizzes can be reviewed"})
+		return
+	}
+
+	var questions []models.QuizQuestion
+	err = config.DB.Select(&questions, `
+		SELECT
+			id,
+			quiz_id,
+			question,
+			answer,
+			COALESCE(options, '[]') as options,
+			COALESCE(user_answer, '') as user_answer,
+			COALESCE(is_correct, false) as is_correct,
+			order_num
+		FROM quiz_questions
+		WHERE quiz_id=$1
+		ORDER BY order_num ASC
+	`, quiz.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch questions"})
+		return
+	}
+
+	results := make([]gin.H, len(questions))
+	for i, q := range questions {
+		var options []string
+		json.Unmarshal([]byte(q.Options), &options)
+		results[i] = gin.H{
+			"question_id":    q.ID,
+			"question":       q.Question,
+			"options":        options,
+			"correct_answer": q.Answer,
+			"user_answer":    q.UserAnswer,
+			"is_correct":     q.IsCorrect,
+		}
+	}
+
+	services.Publish(services.Event{
+		Type:     services.EventReviewCompleted,
+		UserID:   body.UserID,
+		SourceID: fmt.Sprintf("quiz:%d", quiz.ID),
+		Topic:    quiz.Topic,
+		Score:    quiz.Score,
+		Total:    quiz.TotalQues,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"quiz_id":         quiz.ID,
+		"topic":           quiz.Topic,
+		"score":           quiz.Score,
+		"total_questions": quiz.TotalQues,
+		"results":         results,
+	})
+}
+
+// UpdateUserTimezone sets the IANA time zone used for the learner's streak days
+// and reminders. Recurring reminders keep their local time of day in the new zone.
+func UpdateUserTimezone(c *gin.Context) {
+	var body struct {
+		Timezone string `json:"timezone" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	loc, err := services.ParseTimezone(body.Timezone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tz := loc.String()
+	userID := parseInt(c.Param("user_id"))
+	previous := services.UserLocation(userID)
+
+	res, err := config.DB.Exec("UPDATE users SET timezone=$1, updated_at=NOW() WHERE id=$2", tz, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := services.RezoneSchedules(userID, previous, loc); err != nil {
+		fmt.Printf("Warning: failed to move reminders of user %d to %s: %v\n", userID, tz, err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Timezone updated", "timezone": tz})
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetLeaderboard ranks learners over a window (weekly, monthly, all_time) and
+// scope (global, topic, group). The requesting user's own entry is returned
+// separately so it is visible even when outside the top entries.
+func GetLeaderboard(c *gin.Context) {
+	userID := parseInt(c.Query("user_id"))
+	window := c.DefaultQuery("window", services.LeaderboardWeekly)
+	if !services.ValidLeaderboardWindow(window) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window must be weekly, monthly or all_time"})
+		return
+	}
+	loc, ok := parseAnalyticsZone(c)
+	if !ok {
+		return
+	}
+	q := services.LeaderboardQuery{Window: window, Scope: c.DefaultQuery("scope", services.ScopeGlobal), Location: loc}
+
+	switch q.Scope {
+	case services.ScopeGlobal:
+	case services.ScopeTopic:
+		q.Topic = services.CanonicalTopic(c.Query("topic"))
+		if q.Topic == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "topic is required for the topic scope"})
+			return
+		}
+	case services.ScopeGroup:
+		q.GroupID = parseInt(c.Query("group_id"))
+		// Group boards are only visible to members
+		var isMember bool
+		err := config.DB.Get(&isMember, "SELECT EXISTS(SELECT 1 FROM study_group_members WHERE group_id=$1 AND user_id=$2)", q.GroupID, userID)
+		if err != nil || !isMember {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Group not found or you are not a member"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be global, topic or group"})
+		return
+	}
+
+	limit := 50
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 && v <= 500 {
+		limit = v
+	}
+
+	entries, since, err := services.BuildLeaderboard(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build leaderboard: " + err.Error()})
+		return
+	}
+
+	var me *services.LeaderboardEntry
+	for i := range entries {
+		if entries[i].UserID == userID {
+			me = &entries[i]
+			break
+		}
+	}
+	total := len(entries)
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	var optedOut bool
+	if userID != 0 {
+		config.DB.Get(&optedOut, "SELECT leaderboard_opt_out FROM users WHERE id=$1", userID)
+	}
+
+	resp := gin.H{
+		"window":    window,
+		"scope":     q.Scope,
+		"timezone":  loc.String(),
+		"total":     total,
+		"entries":   entries,
+		"me":        me,
+		"opted_out": optedOut,
+	}
+	if !since.IsZero() {
+		resp["since"] = since
+	}
+	if q.Topic != "" {
+		resp["topic"] = q.Topic
+	}
+	if q.GroupID != 0 {
+		resp["group_id"] = q.GroupID
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// UpdateLeaderboardPrivacy lets a learner hide from (or rejoin) every leaderboard
+func UpdateLeaderboardPrivacy(c *gin.Context) {
+	var body struct {
+		OptOut *bool `json:"opt_out" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	res, err := config.DB.Exec("UPDATE users SET leaderboard_opt_out=$1, updated_at=NOW() WHERE id=$2", *body.OptOut, parseInt(c.Param("user_id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	services.InvalidateLeaderboards()
+	c.JSON(http.StatusOK, gin.H{"message": "Leaderboard privacy updated", "opt_out": *body.OptOut})
+}
+
+// CreateGroup creates a classroom or study group owned by the user
+func CreateGroup(c *gin.Context) {
+	var body struct {
+		UserID int    `json:"user_id" binding:"required"`
+		Name   string `json:"name" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var group models.StudyGroup
+	for attempt := 0; ; attempt++ {
+		err = tx.Get(&group, `
+			INSERT INTO study_groups (name, join_code, owner_id)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (join_code) DO NOTHING
+			RETURNING *
+		`, name, services.NewJoinCode(), body.UserID)
+		if err == nil || attempt == 3 {
+			break
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group: " + err.Error()})
+		return
+	}
+	_, err = tx.Exec(`INSERT INTO study_group_members (group_id, user_id, role) VALUES ($1, $2, 'owner')`, group.ID, body.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group: " + err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, group)
+}
+
+// JoinGroup adds the user to the group with the given join code
+func JoinGroup(c *gin.Context) {
+	var body struct {
+		UserID   int    `json:"user_id" binding:"required"`
+		JoinCode string `json:"join_code" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	var group models.StudyGroup
+	err := config.DB.Get(&group, "SELECT * FROM study_groups WHERE join_code=$1", strings.ToUpper(strings.TrimSpace(body.JoinCode)))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No group with that join code"})
+		return
+	}
+	_, err = config.DB.Exec(`
+		INSERT INTO study_group_members (group_id, user_id) VALUES ($1, $2)
+		ON CONFLICT (group_id, user_id) DO NOTHING
+	`, group.ID, body.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Joined group", "group": group})
+}
+
+// GetUserGroups lists the groups a user belongs to with their member counts
+func GetUserGroups(c *gin.Context) {
+	var groups []struct {
+		models.StudyGroup
+		Role    string `db:"role" json:"role"`
+		Members int    `db:"members" json:"members"`
+	}
+	err := config.DB.Select(&groups, `
+		SELECT g.*, m.role,
+			(SELECT COUNT(*) FROM study_group_members x WHERE x.group_id = g.id) AS members
+		FROM study_groups g
+		JOIN study_group_members m ON m.group_id = g.id
+		WHERE m.user_id=$1
+		ORDER BY g.name ASC
+	`, parseInt(c.Param("user_id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range groups {
+		// Only owners get to share the join code
+		if groups[i].Role != "owner" {
+			groups[i].JoinCode = ""
+		}
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+// LeaveGroup removes a member. Members can remove themselves and the owner can
+// remove anyone; when the owner leaves, the group is deleted.
+func LeaveGroup(c *gin.Context) {
+	groupID := parseInt(c.Param("id"))
+	memberID := parseInt(c.Param("user_id"))
+	actorID := parseInt(c.Query("actor_id"))
+	if actorID == 0 {
+		actorID = memberID
+	}
+
+	var group models.StudyGroup
+	if err := config.DB.Get(&group, "SELECT * FROM study_groups WHERE id=$1", groupID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+	if actorID != memberID && actorID != group.OwnerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the group owner can remove other members"})
+		return
+	}
+
+	if memberID == group.OwnerID {
+		if _, err := config.DB.Exec("DELETE FROM study_groups WHERE id=$1", groupID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Group deleted"})
+		return
+	}
+
+	res, err := config.DB.Exec("DELETE FROM study_group_members WHERE group_id=$1 AND user_id=$2", groupID, memberID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not a member of this group"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Left group"})
+}
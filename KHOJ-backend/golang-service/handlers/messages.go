@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegenerateMessage asks the tutor for another version of a reply. It is
+// added next to the old one as a new branch, which the chat then shows;
+// the old reply and anything after it stay on their own branch.
+func RegenerateMessage(c *gin.Context) {
+	var body struct {
+		UserID int `json:"user_id" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	msg, chat, ok := ownedMessage(c, body.UserID)
+	if !ok {
+		return
+	}
+	parent, err := services.ReplyParent(msg)
+	if errors.Is(err, services.ErrNotATutorReply) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	reply, ok := tutorReply(c, chat, parent)
+	if !ok {
+		return
+	}
+	respondMessageVersions(c, http.StatusCreated, reply)
+}
+
+// EditMessage changes a learner message by forking the chat: the new text
+// becomes a sibling of the old message on a new branch, and the tutor
+// answers it there. The original message and its replies stay on their
+// branch.
+func EditMessage(c *gin.Context) {
+	var body struct {
+		UserID  int    `json:"user_id" binding:"required"`
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	msg, chat, ok := ownedMessage(c, body.UserID)
+	if !ok {
+		return
+	}
+	content := strings.TrimSpace(body.Content)
+	if msg.Role != "user" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only your own messages can be edited"})
+		return
+	}
+	// Commands have side effects (quizzes, reminders) that an edit can't undo
+	for _, text := range []string{msg.Content, content} {
+		if _, isCommand := services.ParseIntent(text, services.ChatContext{}); isCommand {
+			c.JSON(http.StatusConflict, gin.H{"error": "Commands can't be edited; send a new message instead"})
+			return
+		}
+	}
+	if !isMessageOnTopic(content, chat.Topic) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":          fmt.Sprintf("Message is off-topic. This chat is for '%s'. Start a new chat for a different topic.", chat.Topic),
+			"required_topic": chat.Topic,
+		})
+		return
+	}
+
+	edited, err := services.BranchMessage(c.Request.Context(), config.DB, chat.ID, msg.ParentID, "user", content, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save message: " + err.Error()})
+		return
+	}
+	reply, ok := tutorReply(c, chat, edited)
+	if !ok {
+		return
+	}
+	versions, err := services.MessageVersions(edited)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": edited, "reply": reply, "versions": versions})
+}
+
+// GetMessageVersions lists a message's versions: the edits of a learner
+// message or the regenerated replies of a bot one (?user_id= must own the
+// chat)
+func GetMessageVersions(c *gin.Context) {
+	msg, _, ok := ownedMessage(c, parseInt(c.Query("user_id")))
+	if !ok {
+		return
+	}
+	respondMessageVersions(c, http.StatusOK, msg)
+}
+
+// SelectMessageVersion switches the chat to the branch through a version of
+// a message
+func SelectMessageVersion(c *gin.Context) {
+	var body struct {
+		UserID int `json:"user_id" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	msg, chat, ok := ownedMessage(c, body.UserID)
+	if !ok {
+		return
+	}
+	if _, err := services.SwitchBranch(chat, msg.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	respondMessageVersions(c, http.StatusOK, msg)
+}
+
+// GetChatBranches lists the branches of a chat (?user_id= must own it)
+func GetChatBranches(c *gin.Context) {
+	chat, ok := ownedChat(c, parseInt(c.Query("user_id")))
+	if !ok {
+		return
+	}
+	branches, err := services.ChatBranches(chat)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, branches)
+}
+
+// SwitchChatBranch makes the chat show the branch through message_id,
+// continuing to its most recent message, and returns the new history
+func SwitchChatBranch(c *gin.Context) {
+	var body struct {
+		UserID    int    `json:"user_id" binding:"required"`
+		MessageID string `json:"message_id" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	chat, ok := ownedChat(c, body.UserID)
+	if !ok {
+		return
+	}
+	leaf, err := services.SwitchBranch(chat, body.MessageID)
+	if err == services.ErrNotInChat {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	messages, err := services.ActivePath(chat.ID, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"active_leaf_id": leaf, "messages": messages})
+}
+
+// tutorReply has the tutor answer a learner message on its branch. On
+// failure the response has been sent.
+func tutorReply(c *gin.Context, chat models.Chat, question models.Message) (models.Message, bool) {
+	apiKey := services.ResolveGeminiAPIKeyFromRequest(c)
+	if apiKey == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "GEMINI_API_KEY not set"})
+		return models.Message{}, false
+	}
+	systemPrompt, err := services.TutorSystemPrompt(chat.UserID, chat.Topic)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return models.Message{}, false
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+	defer cancel()
+	preferredModel := strings.TrimSpace(c.GetHeader("X-Gemini-Model"))
+	text, model, err := services.GenerateGeminiReply(ctx, apiKey, preferredModel, systemPrompt, question.Content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return models.Message{}, false
+	}
+	reply, err := services.BranchMessage(c.Request.Context(), config.DB, chat.ID, &question.ID, "bot", text, model)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save reply: " + err.Error()})
+		return models.Message{}, false
+	}
+	services.LogPromptUsage(systemPrompt, chat.UserID, model, services.PromptSourceMessage, reply.ID)
+	services.RefreshChatDigestAsync(apiKey, preferredModel, chat.ID)
+	return reply, true
+}
+
+// ownedMessage loads the message in the :id param, checking its chat belongs
+// to the user
+func ownedMessage(c *gin.Context, userID int) (models.Message, models.Chat, bool) {
+	var msg models.Message
+	var chat models.Chat
+	err := config.DB.Get(&msg, "SELECT * FROM messages WHERE id=$1", c.Param("id"))
+	if err == nil {
+		err = config.DB.Get(&chat, "SELECT * FROM chats WHERE id=$1 AND user_id=$2", msg.ChatID, userID)
+	}
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found or unauthorized"})
+		return msg, chat, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return msg, chat, false
+	}
+	return msg, chat, true
+}
+
+// ownedChat loads the chat in the :id param, checking it belongs to the user
+func ownedChat(c *gin.Context, userID int) (models.Chat, bool) {
+	var chat models.Chat
+	err := config.DB.Get(&chat, "SELECT * FROM chats WHERE id=$1 AND user_id=$2", c.Param("id"), userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found or unauthorized"})
+		return chat, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return chat, false
+	}
+	return chat, true
+}
+
+// respondMessageVersions sends a message with all its versions
+func respondMessageVersions(c *gin.Context, status int, msg models.Message) {
+	versions, err := services.MessageVersions(msg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(status, gin.H{"message": msg, "versions": versions})
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetNotificationPreferences returns the user's setting for every channel and
+// whether the server can currently deliver on it
+func GetNotificationPreferences(c *gin.Context) {
+	prefs, err := services.UserNotificationPreferences(parseInt(c.Param("user_id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	channels := make([]gin.H, 0, len(prefs))
+	for _, p := range prefs {
+		channels = append(channels, gin.H{
+			"channel":   p.Channel,
+			"enabled":   p.Enabled,
+			"target":    p.Target,
+			"available": services.ChannelAvailable(p.Channel),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"channels": channels})
+}
+
+// UpdateNotificationPreferences enables or disables channels. The email
+// target overrides the account address; the webhook target is its URL.
+func UpdateNotificationPreferences(c *gin.Context) {
+	var body struct {
+		Channels []struct {
+			Channel string `json:"channel" binding:"required"`
+			Enabled bool   `json:"enabled"`
+			Target  string `json:"target"`
+		} `json:"channels" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	userID := parseInt(c.Param("user_id"))
+
+	for _, ch := range body.Channels {
+		ch.Target = strings.TrimSpace(ch.Target)
+		switch {
+		case !services.ValidChannel(ch.Channel):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown channel " + ch.Channel})
+			return
+		case ch.Channel == services.ChannelEmail && ch.Target != "":
+			if _, err := mail.ParseAddress(ch.Target); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+				return
+			}
+		case ch.Channel == services.ChannelWebhook && (ch.Enabled || ch.Target != ""):
+			if err := services.CheckWebhookURL(c.Request.Context(), ch.Target); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "The webhook channel needs a public http(s) URL as its target: " + err.Error()})
+				return
+			}
+		}
+	}
+
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	for _, ch := range body.Channels {
+		_, err := tx.Exec(`
+			INSERT INTO notification_preferences (user_id, channel, enabled, target, updated_at)
+			VALUES ($1, $2, $3, $4, NOW())
+			ON CONFLICT (user_id, channel) DO UPDATE SET enabled=EXCLUDED.enabled, target=EXCLUDED.target, updated_at=NOW()
+		`, userID, ch.Channel, ch.Enabled, strings.TrimSpace(ch.Target))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save preferences: " + err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	GetNotificationPreferences(c)
+}
+
+// GetVAPIDPublicKey returns the key the browser needs to subscribe to push
+func GetVAPIDPublicKey(c *gin.Context) {
+	key := services.VAPIDPublicKey()
+	if key == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Web Push is not configured"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"public_key": key})
+}
+
+// SavePushSubscription stores a browser push subscription. The body is the
+// browser's PushSubscription.toJSON() plus user_id.
+func SavePushSubscription(c *gin.Context) {
+	var body struct {
+		UserID   int    `json:"user_id" binding:"required"`
+		Endpoint string `json:"endpoint" binding:"required"`
+		Keys     struct {
+			P256dh string `json:"p256dh" binding:"required"`
+			Auth   string `json:"auth" binding:"required"`
+		} `json:"keys" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if !services.ValidPushEndpoint(body.Endpoint) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "endpoint must be an https URL on a browser push service"})
+		return
+	}
+	// Reject keys we couldn't encrypt for now rather than at delivery time
+	if _, err := services.EncryptPushPayload(body.Keys.P256dh, body.Keys.Auth, []byte("{}")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var sub models.PushSubscription
+	err := config.DB.Get(&sub, `
+		INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth, user_agent)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (endpoint) DO UPDATE SET user_id=EXCLUDED.user_id, p256dh=EXCLUDED.p256dh, auth=EXCLUDED.auth, user_agent=EXCLUDED.user_agent
+		RETURNING *
+	`, body.UserID, body.Endpoint, body.Keys.P256dh, body.Keys.Auth, c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save subscription: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, sub)
+}
+
+// DeletePushSubscription removes a subscription, e.g. when the user turns
+// notifications off in the browser
+func DeletePushSubscription(c *gin.Context) {
+	res, err := config.DB.Exec("DELETE FROM push_subscriptions WHERE endpoint=$1 AND user_id=$2", c.Query("endpoint"), parseInt(c.Query("user_id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Subscription removed"})
+}
+
+// GetNotificationDeliveries returns the user's most recent notification
+// deliveries across all channels
+func GetNotificationDeliveries(c *gin.Context) {
+	var deliveries []models.NotificationDelivery
+	err := config.DB.Select(&deliveries, `
+		SELECT * FROM notification_deliveries WHERE user_id=$1 ORDER BY created_at DESC LIMIT 100
+	`, parseInt(c.Param("user_id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// SendTestNotification sends the "test" template on every enabled channel and
+// returns the per-channel results
+func SendTestNotification(c *gin.Context) {
+	var body struct {
+		UserID int    `json:"user_id" binding:"required"`
+		ChatID string `json:"chat_id"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+	deliveries := services.Notify(ctx, services.Notification{UserID: body.UserID, ChatID: body.ChatID, Kind: services.NotifyTest})
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// DevPushSink is a fake push service for local testing: point a subscription's
+// endpoint at /api/dev/push-sink/<anything> and the requests are recorded.
+// Only available with NOTIFY_DEV_SINKS=true.
+func DevPushSink(c *gin.Context) {
+	if !services.DevSinksEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	payload, _ := io.ReadAll(io.LimitReader(c.Request.Body, 1<<16))
+	services.RecordSinkPush(services.SinkPush{
+		Subscription:    c.Param("id"),
+		Authorization:   c.GetHeader("Authorization"),
+		ContentEncoding: c.GetHeader("Content-Encoding"),
+		TTL:             c.GetHeader("TTL"),
+		Bytes:           len(payload),
+		At:              time.Now(),
+	})
+	c.Status(http.StatusCreated)
+}
+
+// GetDevSinks lists what the mail and push sinks captured
+func GetDevSinks(c *gin.Context) {
+	if !services.DevSinksEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	mails, pushes := services.DevSinkContents()
+	c.JSON(http.StatusOK, gin.H{"mail": mails, "push": pushes})
+}
+
+// ClearDevSinks empties the mail and push sinks
+func ClearDevSinks(c *gin.Context) {
+	if !services.DevSinksEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	services.ClearDevSinks()
+	c.JSON(http.StatusOK, gin.H{"message": "Sinks cleared"})
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Paginated lists answer with {"items": [...], "next_cursor": ...}. A
+// next_cursor of null means the list is complete; otherwise it is passed
+// back as ?cursor= for the next page (or as ?since= while syncing).
+
+// parsePageQuery reads ?limit= (default def, at most max), ?cursor= and, for
+// lists that can sync, ?since=. On failure the response has been sent.
+func parsePageQuery(c *gin.Context, def int, max int, sync bool) (services.PageQuery, bool) {
+	p := services.PageQuery{Limit: def}
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 && v <= max {
+		p.Limit = v
+	}
+	if s := strings.TrimSpace(c.Query("cursor")); s != "" {
+		cur, err := services.DecodeCursor(s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return p, false
+		}
+		p.Cursor = &cur
+	}
+	if s := strings.TrimSpace(c.Query("since")); s != "" && sync {
+		if p.Cursor != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Use either cursor or since, not both"})
+			return p, false
+		}
+		since, err := services.ParseSince(s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since, use a sync cursor or an RFC 3339 time"})
+			return p, false
+		}
+		p.Since = &since
+	}
+	return p, true
+}
+
+// pageCursor is the cursor of an item when the list goes on past it
+func pageCursor(more bool, t time.Time, id string) *string {
+	if !more {
+		return nil
+	}
+	cur := services.EncodeCursor(t, id)
+	return &cur
+}
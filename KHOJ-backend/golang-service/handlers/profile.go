@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetOnboardingQuestionnaire serves the onboarding questions, the current
+// version unless ?version= asks for an older one
+func GetOnboardingQuestionnaire(c *gin.Context) {
+	version := services.CurrentQuestionnaireVersion
+	if v := c.Query("version"); v != "" {
+		version = parseInt(v)
+	}
+	q, err := services.OnboardingQuestionnaire(version)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, q)
+}
+
+// GetLearnerProfile returns the typed preferences parsed from the user's
+// onboarding answers
+func GetLearnerProfile(c *gin.Context) {
+	profile, err := services.LoadLearnerProfile(parseInt(c.Param("user_id")))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No learner profile; complete onboarding first"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateLearnerProfile changes the fields present in the body. Preferred
+// windows are "HH:MM-HH:MM"; the first is also the default reminder window.
+func UpdateLearnerProfile(c *gin.Context) {
+	var patch services.LearnerProfilePatch
+	if err := c.BindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	profile, err := services.UpdateLearnerProfile(parseInt(c.Param("user_id")), patch)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, services.ErrInvalidProfile):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, profile)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListPromptExperiments lists experiments, optionally on one template
+// (?prompt_name=)
+func ListPromptExperiments(c *gin.Context) {
+	if !requireAdmin(c, parseInt(c.Query("user_id"))) {
+		return
+	}
+	exps, err := services.ListPromptExperiments(strings.TrimSpace(c.Query("prompt_name")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, exps)
+}
+
+// CreatePromptExperiment starts splitting learners between stored versions
+// of a template, e.g. {"name": "mcq-strict-json", "prompt_name":
+// "quiz.mcq_generation", "variants": [{"label": "control", "template_id": 3},
+// {"label": "strict", "template_id": 7}]}
+func CreatePromptExperiment(c *gin.Context) {
+	var body struct {
+		UserID     int                               `json:"user_id" binding:"required"`
+		Name       string                            `json:"name" binding:"required"`
+		PromptName string                            `json:"prompt_name" binding:"required"`
+		Variants   []services.ExperimentVariantInput `json:"variants" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if !requireAdmin(c, body.UserID) {
+		return
+	}
+	exp, err := services.CreatePromptExperiment(body.Name, body.PromptName, body.Variants, body.UserID)
+	if errors.Is(err, services.ErrInvalidExperiment) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, exp)
+}
+
+// StopPromptExperiment ends an experiment; its results stay available
+func StopPromptExperiment(c *gin.Context) {
+	var body struct {
+		UserID int `json:"user_id" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if !requireAdmin(c, body.UserID) {
+		return
+	}
+	exp, err := services.StopPromptExperiment(parseInt(c.Param("id")))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Experiment not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, exp)
+}
+
+// GetPromptExperimentResults compares an experiment's variants: how often
+// each was used and the rate of every recorded outcome
+func GetPromptExperimentResults(c *gin.Context) {
+	if !requireAdmin(c, parseInt(c.Query("user_id"))) {
+		return
+	}
+	var exp models.PromptExperiment
+	err := config.DB.Get(&exp, "SELECT * FROM prompt_experiments WHERE id=$1", parseInt(c.Param("id")))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Experiment not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	results, err := services.PromptExperimentResults(exp.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"experiment": exp, "variants": results})
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListPromptTemplates lets admins see every version of the prompt templates,
+// optionally for one ?name=
+func ListPromptTemplates(c *gin.Context) {
+	if !requireAdmin(c, parseInt(c.Query("user_id"))) {
+		return
+	}
+	query := "SELECT * FROM prompt_templates"
+	args := []interface{}{}
+	if name := strings.TrimSpace(c.Query("name")); name != "" {
+		query += " WHERE name=$1"
+		args = append(args, name)
+	}
+	query += " ORDER BY name, version DESC"
+
+	var templates []models.PromptTemplate
+	if err := config.DB.Select(&templates, query, args...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, templates)
+}
+
+// CreatePromptTemplate adds a new version of a template, with optional model
+// settings. A name of the form tutor.style.<style> adds a tutor style
+// learners can pick.
+func CreatePromptTemplate(c *gin.Context) {
+	var body struct {
+		UserID      int    `json:"user_id" binding:"required"`
+		Name        string `json:"name" binding:"required"`
+		Body        string `json:"body" binding:"required"`
+		Description string `json:"description"`
+		Activate    bool   `json:"activate"`
+		services.GenerationSettings
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if !requireAdmin(c, body.UserID) {
+		return
+	}
+	tmpl, err := services.CreatePromptVersion(body.Name, body.Body, body.Description, body.GenerationSettings, body.Activate, body.UserID)
+	if errors.Is(err, services.ErrUnknownPrompt) || errors.Is(err, services.ErrInvalidPromptSettings) ||
+		(err != nil && strings.HasPrefix(err.Error(), "invalid template")) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, tmpl)
+}
+
+// ActivatePromptTemplate switches a template to a stored version, e.g. to
+// roll back
+func ActivatePromptTemplate(c *gin.Context) {
+	var body struct {
+		UserID int `json:"user_id" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if !requireAdmin(c, body.UserID) {
+		return
+	}
+	tmpl, err := services.ActivatePromptVersion(parseInt(c.Param("id")))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prompt template not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tmpl)
+}
+
+// UpdatePromptTemplateSettings changes the model, temperature and output
+// token limit of a stored version; omitted settings are cleared
+func UpdatePromptTemplateSettings(c *gin.Context) {
+	var body struct {
+		UserID int `json:"user_id" binding:"required"`
+		services.GenerationSettings
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if !requireAdmin(c, body.UserID) {
+		return
+	}
+	tmpl, err := services.UpdatePromptSettings(parseInt(c.Param("id")), body.GenerationSettings)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prompt template not found"})
+	case errors.Is(err, services.ErrInvalidPromptSettings):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, tmpl)
+	}
+}
+
+// GetPromptMetrics compares the versions of the template ?name= by their
+// uses and recorded outcomes
+func GetPromptMetrics(c *gin.Context) {
+	if !requireAdmin(c, parseInt(c.Query("user_id"))) {
+		return
+	}
+	name := strings.TrimSpace(c.Query("name"))
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	metrics, err := services.PromptVersionMetrics(name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": name, "versions": metrics})
+}
+
+// GetTutorStyles lists the tutor styles learners can choose in their profile
+func GetTutorStyles(c *gin.Context) {
+	styles, err := services.TutorStyles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	list := []gin.H{}
+	for _, name := range services.SortedTutorStyles(styles) {
+		list = append(list, gin.H{"style": name, "description": styles[name], "default": name == services.DefaultTutorStyle})
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GetTutorPrompt shows the system prompt the learner's chats on ?topic= use,
+// with the persona it was built from
+func GetTutorPrompt(c *gin.Context) {
+	userID := parseInt(c.Param("user_id"))
+	topic := strings.TrimSpace(c.Query("topic"))
+	if topic == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "topic is required"})
+		return
+	}
+	prompt, err := services.TutorSystemPrompt(userID, topic)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"persona": services.TutorPersona(userID, topic), "prompt": prompt})
+}
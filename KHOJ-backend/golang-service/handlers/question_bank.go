@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+)
+
+// FlagQuizQuestion lets a learner report a question they were asked as wrong.
+// The flag lands on the bank question the quiz question was drawn from.
+func FlagQuizQuestion(c *gin.Context) {
+	questionID := parseInt(c.Param("id"))
+	var body struct {
+		UserID int    `json:"user_id" binding:"required"`
+		Reason string `json:"reason"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	// The learner must own the quiz the question belongs to
+	var bankID sql.NullInt64
+	err := config.DB.Get(&bankID, `
+		SELECT qq.bank_question_id FROM quiz_questions qq
+		JOIN quizzes q ON q.id = qq.quiz_id
+		WHERE qq.id=$1 AND q.user_id=$2
+	`, questionID, body.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+		return
+	}
+	if !bankID.Valid {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "This question is not part of the question bank"})
+		return
+	}
+
+	q, err := services.FlagBankQuestion(int(bankID.Int64), body.UserID, strings.TrimSpace(body.Reason))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to flag question: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Thanks, the question has been flagged for review",
+		"flag_count": q.FlagCount,
+		"withheld":   !services.IsBankQuestionUsable(q),
+	})
+}
+
+// ListBankQuestions lets admins browse the bank, optionally filtered by topic,
+// status, or only questions with unresolved flags
+func ListBankQuestions(c *gin.Context) {
+	if !requireAdmin(c, parseInt(c.Query("user_id"))) {
+		return
+	}
+
+	limit := parseInt(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	offset := parseInt(c.DefaultQuery("offset", "0"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	var questions []models.BankQuestion
+	err := config.DB.Select(&questions, `
+		SELECT * FROM bank_questions
+		WHERE ($1 = '' OR topic = $1)
+		AND ($2 = '' OR status = $2)
+		AND ($3 = false OR flag_count > 0)
+		ORDER BY flag_count DESC, updated_at DESC, id DESC
+		LIMIT $4 OFFSET $5
+	`, services.CanonicalTopic(c.Query("topic")), c.Query("status"), c.Query("flagged") == "true", limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if questions == nil {
+		questions = []models.BankQuestion{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"questions": questions,
+		"limit":     limit,
+		"offset":    offset,
+	})
+}
+
+// GetBankQuestionFlags returns the learner reports filed against a question
+func GetBankQuestionFlags(c *gin.Context) {
+	if !requireAdmin(c, parseInt(c.Query("user_id"))) {
+		return
+	}
+
+	var flags []models.BankQuestionFlag
+	err := config.DB.Select(&flags, `
+		SELECT * FROM bank_question_flags WHERE question_id=$1 ORDER BY resolved ASC, created_at DESC
+	`, parseInt(c.Param("id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if flags == nil {
+		flags = []models.BankQuestionFlag{}
+	}
+	c.JSON(http.StatusOK, flags)
+}
+
+// CreateBankQuestion adds a hand-written question to the bank. Admin-authored
+// questions are approved straight away.
+func CreateBankQuestion(c *gin.Context) {
+	var body struct {
+		UserID   int      `json:"user_id" binding:"required"`
+		Topic    string   `json:"topic" binding:"required"`
+		Subtopic string   `json:"subtopic"`
+		Question string   `json:"question" binding:"required"`
+		Options  []string `json:"options"`
+		Answer   string   `json:"answer" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if !requireAdmin(c, body.UserID) {
+		return
+	}
+	if msg := validateBankAnswer(body.Options, body.Answer); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	options := body.Options
+	if options == nil {
+		options = []string{}
+	}
+	optionsJSON, _ := json.Marshal(options)
+	createdBy := body.UserID
+	q, created, err := services.SaveToBank(context.Background(), services.ResolveGeminiAPIKeyFromRequest(c), models.BankQuestion{
+		Topic:     body.Topic,
+		Subtopic:  strings.TrimSpace(body.Subtopic),
+		Question:  strings.TrimSpace(body.Question),
+		Options:   string(optionsJSON),
+		Answer:    strings.TrimSpace(body.Answer),
+		Source:    "manual",
+		Status:    services.BankStatusApproved,
+		CreatedBy: &createdBy,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save question: " + err.Error()})
+		return
+	}
+	if !created {
+		c.JSON(http.StatusConflict, gin.H{"error": "An equivalent question already exists", "question": q})
+		return
+	}
+
+	c.JSON(http.StatusCreated, q)
+}
+
+// UpdateBankQuestion lets an admin correct a question in place. Any field left
+// out of the request keeps its current value.
+func UpdateBankQuestion(c *gin.Context) {
+	var body struct {
+		UserID   int       `json:"user_id" binding:"required"`
+		Subtopic *string   `json:"subtopic"`
+		Question *string   `json:"question"`
+		Options  *[]string `json:"options"`
+		Answer   *string   `json:"answer"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if !requireAdmin(c, body.UserID) {
+		return
+	}
+
+	var q models.BankQuestion
+	if err := config.DB.Get(&q, "SELECT * FROM bank_questions WHERE id=$1", parseInt(c.Param("id"))); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+		return
+	}
+
+	var options []string
+	json.Unmarshal([]byte(q.Options), &options)
+	if body.Subtopic != nil {
+		q.Subtopic = strings.TrimSpace(*body.Subtopic)
+	}
+	if body.Question != nil {
+		q.Question = strings.TrimSpace(*body.Question)
+	}
+	if body.Options != nil {
+		options = *body.Options
+	}
+	if body.Answer != nil {
+		q.Answer = strings.TrimSpace(*body.Answer)
+	}
+	if q.Question == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "question cannot be empty"})
+		return
+	}
+	if msg := validateBankAnswer(options, q.Answer); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if options == nil {
+		options = []string{}
+	}
+	optionsJSON, _ := json.Marshal(options)
+
+	// The stored embedding describes the old wording, so drop it when the text changes
+	normalized := services.NormalizeQuestionText(q.Question)
+	embedding := q.Embedding
+	if normalized != q.NormalizedText {
+		embedding = ""
+	}
+
+	_, err := config.DB.Exec(`
+		UPDATE bank_questions
+		SET subtopic=$1, question=$2, normalized_text=$3, options=$4, answer=$5, embedding=$6, reviewed_by=$7, updated_at=NOW()
+		WHERE id=$8
+	`, q.Subtopic, q.Question, normalized, string(optionsJSON), q.Answer, embedding, body.UserID, q.ID)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			c.JSON(http.StatusConflict, gin.H{"error": "An equivalent question already exists for this topic"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	config.DB.Get(&q, "SELECT * FROM bank_questions WHERE id=$1", q.ID)
+	c.JSON(http.StatusOK, q)
+}
+
+// ReviewBankQuestion records an admin verdict ("approved", "rejected" or back
+// to "unreviewed") and resolves open flags on the question
+func ReviewBankQuestion(c *gin.Context) {
+	var body struct {
+		UserID int    `json:"user_id" binding:"required"`
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if !requireAdmin(c, body.UserID) {
+		return
+	}
+
+	questionID := parseInt(c.Param("id"))
+	err := services.ReviewBankQuestion(questionID, body.UserID, body.Status)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     fmt.Sprintf("Question marked %s", body.Status),
+		"question_id": questionID,
+		"status":      body.Status,
+	})
+}
+
+// validateBankAnswer checks that an MCQ answer names one of its options. It
+// returns an error message, or "" when the answer is acceptable.
+func validateBankAnswer(options []string, answer string) string {
+	if strings.TrimSpace(answer) == "" {
+		return "answer is required"
+	}
+	if len(options) == 0 {
+		return ""
+	}
+	if len(options) < 2 {
+		return "multiple choice questions need at least two options"
+	}
+	letter := strings.ToUpper(strings.TrimSpace(answer))
+	if len(letter) != 1 || letter[0] < 'A' || int(letter[0]-'A') >= len(options) {
+		return fmt.Sprintf("answer must be an option letter between A and %c", 'A'+len(options)-1)
+	}
+	return ""
+}
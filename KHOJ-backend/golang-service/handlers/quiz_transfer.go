@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+)
+
+// maxImportBytes bounds the size of an uploaded quiz file
+const maxImportBytes = 10 << 20
+
+// ExportQuiz downloads a quiz, including correct answers, as GIFT, QTI 2.1,
+// CSV or JSON. Only the quiz owner may export it.
+func ExportQuiz(c *gin.Context) {
+	userID := parseInt(c.Query("user_id"))
+	format := strings.ToLower(c.DefaultQuery("format", services.QuizFormatJSON))
+
+	var quiz models.Quiz
+	err := config.DB.Get(&quiz, "SELECT * FROM quizzes WHERE id=$1 AND user_id=$2", parseInt(c.Param("id")), userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quiz not found or unauthorized"})
+		return
+	}
+
+	var questions []models.QuizQuestion
+	err = config.DB.Select(&questions, `
+		SELECT
+			id,
+			quiz_id,
+			question,
+			answer,
+			COALESCE(options, '[]') as options,
+			COALESCE(user_answer, '') as user_answer,
+			COALESCE(is_correct, false) as is_correct,
+			order_num
+		FROM quiz_questions
+		WHERE quiz_id=$1
+		ORDER BY order_num ASC
+	`, quiz.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch questions: " + err.Error()})
+		return
+	}
+
+	sendQuizExport(c, format, fmt.Sprintf("quiz-%d", quiz.ID), services.QuizDocument{Quiz: quiz, Questions: questions})
+}
+
+// ImportQuiz creates a quiz in one of the user's chats from an uploaded file.
+// Invalid questions are skipped and listed in the validation report; pass
+// dry_run=true to get the report without creating anything.
+func ImportQuiz(c *gin.Context) {
+	userID := parseInt(c.Query("user_id"))
+	chatID := c.Query("chat_id")
+	dryRun := c.Query("dry_run") == "true"
+
+	var chat models.Chat
+	err := config.DB.Get(&chat, "SELECT * FROM chats WHERE id=$1 AND user_id=$2", chatID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found or unauthorized"})
+		return
+	}
+
+	doc, report, ok := readQuizImport(c)
+	if !ok {
+		return
+	}
+	if dryRun || len(doc.Questions) == 0 {
+		status := http.StatusOK
+		if len(doc.Questions) == 0 {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"report": report, "dry_run": dryRun})
+		return
+	}
+
+	topic := strings.TrimSpace(doc.Quiz.Topic)
+	if topic == "" {
+		topic = chat.Topic
+	}
+
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var quizID int
+	err = tx.QueryRow(`
+		INSERT INTO quizzes (user_id, chat_id, topic, status, total_questions, created_at)
+		VALUES ($1, $2, $3, 'pending', $4, $5)
+		RETURNING id
+	`, userID, chat.ID, topic, len(doc.Questions), time.Now()).Scan(&quizID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create quiz: " + err.Error()})
+		return
+	}
+	for _, q := range doc.Questions {
+		_, err = tx.Exec(`
+			INSERT INTO quiz_questions (quiz_id, question, answer, options, order_num)
+			VALUES ($1, $2, $3, $4, $5)
+		`, quizID, q.Question, q.Answer, q.Options, q.OrderNum)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create questions: " + err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":         "Quiz imported successfully",
+		"quiz_id":         quizID,
+		"topic":           topic,
+		"total_questions": len(doc.Questions),
+		"report":          report,
+	})
+}
+
+// ExportBank downloads the usable bank questions of a topic (admin only)
+func ExportBank(c *gin.Context) {
+	if !requireAdmin(c, parseInt(c.Query("user_id"))) {
+		return
+	}
+	topic := services.CanonicalTopic(c.Query("topic"))
+	if topic == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "topic is required"})
+		return
+	}
+	format := strings.ToLower(c.DefaultQuery("format", services.QuizFormatJSON))
+
+	var bank []models.BankQuestion
+	err := config.DB.Select(&bank, `
+		SELECT * FROM bank_questions WHERE topic=$1 AND status <> $2 ORDER BY id ASC
+	`, topic, services.BankStatusRejected)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	doc := services.QuizDocument{Quiz: models.Quiz{Topic: topic, TotalQues: len(bank)}}
+	for i, bq := range bank {
+		doc.Questions = append(doc.Questions, models.QuizQuestion{
+			ID:       bq.ID,
+			Question: bq.Question,
+			Answer:   bq.Answer,
+			Options:  bq.Options,
+			OrderNum: i + 1,
+		})
+	}
+	sendQuizExport(c, format, "bank-"+strings.ReplaceAll(topic, " ", "-"), doc)
+}
+
+// ImportBank adds questions from an uploaded file to the bank under a topic
+// (admin only). Imported questions go through the same deduplication as
+// generated ones and count as approved.
+func ImportBank(c *gin.Context) {
+	adminID := parseInt(c.Query("user_id"))
+	if !requireAdmin(c, adminID) {
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+
+	doc, report, ok := readQuizImport(c)
+	if !ok {
+		return
+	}
+	topic := c.Query("topic")
+	if strings.TrimSpace(topic) == "" {
+		topic = doc.Quiz.Topic
+	}
+	if services.CanonicalTopic(topic) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "topic is required (query parameter or in the file)", "report": report})
+		return
+	}
+	if dryRun || len(doc.Questions) == 0 {
+		status := http.StatusOK
+		if len(doc.Questions) == 0 {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"report": report, "dry_run": dryRun})
+		return
+	}
+
+	ctx := context.Background()
+	apiKey := services.ResolveGeminiAPIKeyFromRequest(c)
+	created, duplicates := 0, 0
+	for _, q := range doc.Questions {
+		_, isNew, err := services.SaveToBank(ctx, apiKey, models.BankQuestion{
+			Topic:     topic,
+			Question:  q.Question,
+			Options:   q.Options,
+			Answer:    q.Answer,
+			Source:    "import",
+			Status:    services.BankStatusApproved,
+			CreatedBy: &adminID,
+		})
+		switch {
+		case err != nil:
+			report.Issues = append(report.Issues, services.ImportIssue{Item: q.OrderNum, Severity: "error", Message: "failed to save: " + err.Error()})
+		case isNew:
+			created++
+		default:
+			duplicates++
+			report.Issues = append(report.Issues, services.ImportIssue{Item: q.OrderNum, Severity: "warning", Message: "already in the bank, skipped as duplicate"})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Bank import finished",
+		"topic":      services.CanonicalTopic(topic),
+		"created":    created,
+		"duplicates": duplicates,
+		"report":     report,
+	})
+}
+
+// readQuizImport reads the uploaded file (multipart "file" field or the raw
+// request body) and parses it in the requested or inferred format. On failure
+// it writes the error response and returns false.
+func readQuizImport(c *gin.Context) (services.QuizDocument, services.ImportReport, bool) {
+	format := strings.ToLower(c.Query("format"))
+	var data []byte
+
+	if file, err := c.FormFile("file"); err == nil {
+		if file.Size > maxImportBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large"})
+			return services.QuizDocument{}, services.ImportReport{}, false
+		}
+		if format == "" {
+			format = services.QuizFormatFromFilename(file.Filename)
+		}
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read upload: " + err.Error()})
+			return services.QuizDocument{}, services.ImportReport{}, false
+		}
+		defer f.Close()
+		data, err = io.ReadAll(f)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read upload: " + err.Error()})
+			return services.QuizDocument{}, services.ImportReport{}, false
+		}
+	} else {
+		data, err = io.ReadAll(io.LimitReader(c.Request.Body, maxImportBytes+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read request body: " + err.Error()})
+			return services.QuizDocument{}, services.ImportReport{}, false
+		}
+		if len(data) > maxImportBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large"})
+			return services.QuizDocument{}, services.ImportReport{}, false
+		}
+	}
+
+	if format == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format is required (gift, qti, csv or json)"})
+		return services.QuizDocument{}, services.ImportReport{}, false
+	}
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return services.QuizDocument{}, services.ImportReport{}, false
+	}
+
+	doc, report, err := services.ImportQuiz(format, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "report": report})
+		return doc, report, false
+	}
+	return doc, report, true
+}
+
+// sendQuizExport renders doc and sends it as a file download
+func sendQuizExport(c *gin.Context, format string, baseName string, doc services.QuizDocument) {
+	out, contentType, ext, err := services.ExportQuiz(format, doc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", baseName+"."+ext))
+	c.Data(http.StatusOK, contentType, out)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUserReminders lists a user's delivered reminders, newest first,
+// optionally filtered by ?state=sent,snoozed
+func GetUserReminders(c *gin.Context) {
+	query := "SELECT * FROM reminder_instances WHERE user_id=$1"
+	args := []interface{}{parseInt(c.Param("user_id"))}
+	if states := strings.TrimSpace(c.Query("state")); states != "" {
+		query += " AND state = ANY(string_to_array($2, ','))"
+		args = append(args, states)
+	}
+	query += " ORDER BY occurrence_time DESC LIMIT 100"
+
+	var reminders []models.ReminderInstance
+	if err := config.DB.Select(&reminders, query, args...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reminders)
+}
+
+// AcknowledgeReminder marks a reminder as seen
+func AcknowledgeReminder(c *gin.Context) {
+	updateReminder(c, services.ReminderAcknowledged)
+}
+
+// SnoozeReminder sends the reminder again after the given number of minutes
+// (default 10)
+func SnoozeReminder(c *gin.Context) {
+	updateReminder(c, services.ReminderSnoozed)
+}
+
+// SkipReminder dismisses a delivered reminder for this occurrence
+func SkipReminder(c *gin.Context) {
+	updateReminder(c, services.ReminderSkipped)
+}
+
+func updateReminder(c *gin.Context, state string) {
+	var body struct {
+		UserID  int `json:"user_id" binding:"required"`
+		Minutes int `json:"minutes"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if state == services.ReminderSnoozed && body.Minutes == 0 {
+		body.Minutes = 10
+	}
+
+	inst, err := services.UpdateReminderState(parseInt(c.Param("id")), body.UserID, state, body.Minutes)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reminder not found or unauthorized"})
+	case err == services.ErrReminderClosed:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "state": inst.State})
+	case err == services.ErrInvalidSnooze:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, inst)
+	}
+}
+
+// SkipScheduleOccurrence skips a schedule's next occurrence before it is
+// sent; recurring schedules continue with the one after
+func SkipScheduleOccurrence(c *gin.Context) {
+	var body struct {
+		UserID int `json:"user_id" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	schedule, skipped, err := services.SkipNextOccurrence(parseInt(c.Param("id")), body.UserID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Active schedule not found or unauthorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to skip occurrence: " + err.Error()})
+		return
+	}
+
+	loc := services.UserLocation(schedule.UserID)
+	scheduleInZone(&schedule, loc)
+	resp := gin.H{"message": "Occurrence skipped", "skipped": skipped.In(loc), "schedule": schedule}
+	if schedule.Active {
+		resp["next_reminder"] = schedule.ScheduledTime
+	}
+	c.JSON(http.StatusOK, resp)
+}
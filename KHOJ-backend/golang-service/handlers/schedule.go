@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"golang-service/config"
 	"golang-service/models"
+	"golang-service/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,13 +19,9 @@ import (
 // CreateSchedule creates a quiz reminder schedule from the current chat
 func CreateSchedule(c *gin.Context) {
 	var body struct {
-		UserID          int    `json:"user_id" binding:"required"`
-		ChatID          string `json:"chat_id" binding:"required"`
-		ScheduledTime   string `json:"scheduled_time,omitempty"`           // ISO 8601 format for one-time reminders
-		RecurrenceType  string `json:"recurrence_type" binding:"required"` // "daily", "weekly", "once"
-		ReminderTime    string `json:"reminder_time" binding:"required"`   // Time of day "HH:MM" or "HH:MM-HH:MM" for range
-		ReminderTimeEnd string `json:"reminder_time_end,omitempty"`        // Optional end time for ranges
-		DaysOfWeek      string `json:"days_of_week,omitempty"`             // Comma-separated: "1,3,5" for Mon,Wed,Fri
+		UserID int    `json:"user_id" binding:"required"`
+		ChatID string `json:"chat_id" binding:"required"`
+		services.ScheduleRequest
 	}
 
 	if err := c.BindJSON(&body); err != nil {
@@ -29,169 +29,324 @@ func CreateSchedule(c *gin.Context) {
 		return
 	}
 
-	// Verify chat exists and belongs to user, get topic
-	var chat models.Chat
-	err := config.DB.Get(&chat, "SELECT * FROM chats WHERE id=$1 AND user_id=$2", body.ChatID, body.UserID)
-	if err != nil {
+	created, err := services.CreateSchedule(body.UserID, body.ChatID, body.ScheduleRequest, time.Now())
+	var invalid services.InvalidScheduleError
+	switch {
+	case err == sql.ErrNoRows:
 		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found or unauthorized"})
 		return
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+	c.JSON(http.StatusCreated, scheduleCreatedResponse(created))
+}
 
-	var scheduledTime time.Time
-	var nextScheduledTime time.Time
-	now := time.Now()
-
-	// Calculate next scheduled time based on recurrence type
-	if body.RecurrenceType == "once" {
-		// One-time reminder - use provided scheduled_time
-		var err error
-		scheduledTime, err = time.Parse(time.RFC3339, body.ScheduledTime)
-		if err != nil {
-			// Try simpler format
-			scheduledTime, err = time.Parse("2006-01-02T15:04:05", body.ScheduledTime)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time format. Use ISO 8601 (e.g., 2025-10-31T14:30:00Z)"})
-				return
-			}
-		}
-		nextScheduledTime = scheduledTime
-	} else {
-		// Recurring reminder - calculate next occurrence
-		// Parse reminder time (HH:MM)
-		t, err := time.Parse("15:04", body.ReminderTime)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reminder time format. Use HH:MM (e.g., 14:30)"})
-			return
-		}
+// scheduleCreatedResponse is the body returned for a new schedule, by the API
+// and as the result of the /remind chat command
+func scheduleCreatedResponse(created services.CreatedSchedule) gin.H {
+	s, loc := created.Schedule, created.Location
+	return gin.H{
+		"message":         "Reminder created successfully",
+		"schedule_id":     s.ID,
+		"topic":           s.Topic,
+		"recurrence_type": created.Resolved.RecurrenceType,
+		"rrule":           created.Resolved.RRule,
+		"reminder_time":   s.ReminderTime,
+		"reminder_window": s.ReminderTimeEnd,
+		"window_strategy": created.Resolved.WindowStrategy,
+		"timezone":        loc.String(),
+		"next_occurrence": created.Resolved.Next.In(loc).Format(time.RFC3339),
+		"next_reminder":   created.FireAt.In(loc).Format(time.RFC3339),
+	}
+}
 
-		// Get hour and minute
-		reminderHour := t.Hour()
-		reminderMinute := t.Minute()
+// UpdateSchedule edits a schedule. Only the fields present in the body
+// change; the rest keep their current values. Changing the time of day of a
+// recurring reminder keeps its dtstart date and moves only the time, and
+// "active" re-enables a cancelled schedule. The pending occurrence is
+// recomputed.
+func UpdateSchedule(c *gin.Context) {
+	var body struct {
+		UserID          int       `json:"user_id" binding:"required"`
+		ScheduledTime   *string   `json:"scheduled_time"`
+		RecurrenceType  *string   `json:"recurrence_type"`
+		ReminderTime    *string   `json:"reminder_time"`
+		ReminderTimeEnd *string   `json:"reminder_time_end"`
+		DaysOfWeek      *string   `json:"days_of_week"`
+		RRule           *string   `json:"rrule"`
+		DTStart         *string   `json:"dtstart"`
+		ExDates         *[]string `json:"exdates"`
+		WindowStrategy  *string   `json:"window_strategy"`
+		Active          *bool     `json:"active"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
 
-		today := time.Date(now.Year(), now.Month(), now.Day(), reminderHour, reminderMinute, 0, 0, now.Location())
+	var schedule models.Schedule
+	err := config.DB.Get(&schedule, "SELECT * FROM schedules WHERE id=$1 AND user_id=$2", c.Param("id"), body.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found or unauthorized"})
+		return
+	}
+	loc := services.UserLocation(schedule.UserID)
+
+	// Start from the stored schedule, then apply the patch
+	req := services.ScheduleRequest{
+		RecurrenceType:  schedule.RecurrenceType,
+		ReminderTime:    schedule.ReminderTime,
+		ReminderTimeEnd: schedule.ReminderTimeEnd,
+		DaysOfWeek:      schedule.DaysOfWeek,
+		RRule:           schedule.RRule,
+		WindowStrategy:  schedule.WindowStrategy,
+	}
+	if schedule.DTStart != nil {
+		req.DTStart = schedule.DTStart.Format(time.RFC3339)
+	}
+	if schedule.RecurrenceType == "once" {
+		req.ScheduledTime = services.ScheduleOccurrence(schedule).Format(time.RFC3339)
+	}
+	if schedule.ExDates != "" {
+		req.ExDates = strings.Split(schedule.ExDates, ",")
+	}
 
-		if body.RecurrenceType == "daily" {
-			// Daily - if time today has passed, schedule for tomorrow
-			if today.After(now) || today.Equal(now) {
-				nextScheduledTime = today
+	if body.RRule != nil {
+		req.RRule = *body.RRule
+		if *body.RRule != "" && body.RecurrenceType == nil {
+			req.RecurrenceType = "rrule"
+		}
+	}
+	if body.RecurrenceType != nil {
+		req.RecurrenceType = *body.RecurrenceType
+		if body.RRule == nil {
+			req.RRule = ""
+		}
+	}
+	if body.ScheduledTime != nil {
+		req.ScheduledTime = *body.ScheduledTime
+		if body.RecurrenceType == nil && body.RRule == nil {
+			req.RecurrenceType, req.RRule = "once", ""
+		}
+	}
+	if body.ReminderTime != nil {
+		req.ReminderTime = *body.ReminderTime
+		// Keep the anchor's date, and with it COUNT and UNTIL progress; only
+		// its time of day moves
+		if body.DTStart == nil && schedule.DTStart != nil {
+			if t, err := time.Parse("15:04", req.ReminderTime); err == nil {
+				d := schedule.DTStart.In(loc)
+				req.DTStart = services.LocalTime(d.Year(), d.Month(), d.Day(), t.Hour(), t.Minute(), 0, loc).Format(time.RFC3339)
 			} else {
-				nextScheduledTime = today.AddDate(0, 0, 1)
-			}
-		} else if body.RecurrenceType == "weekly" {
-			// Weekly - find next matching day of week
-			if body.DaysOfWeek == "" {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "days_of_week required for weekly reminders"})
-				return
+				req.DTStart = "" // ResolveScheduleRequest reports the bad time
 			}
-			nextScheduledTime = calculateNextWeeklyReminder(now, body.DaysOfWeek, reminderHour, reminderMinute)
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recurrence_type. Use 'daily', 'weekly', or 'once'"})
-			return
 		}
-		scheduledTime = nextScheduledTime // For compatibility
 	}
-
-	// Check if time is in the future
-	if nextScheduledTime.Before(now) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Scheduled time must be in the future"})
+	if body.ReminderTimeEnd != nil {
+		req.ReminderTimeEnd = *body.ReminderTimeEnd
+	}
+	if body.DaysOfWeek != nil {
+		req.DaysOfWeek = *body.DaysOfWeek
+	}
+	if body.DTStart != nil {
+		req.DTStart = *body.DTStart
+	}
+	if body.ExDates != nil {
+		req.ExDates = *body.ExDates
+	}
+	if body.WindowStrategy != nil {
+		req.WindowStrategy = *body.WindowStrategy
+	}
+	if req.RecurrenceType == "rrule" && req.RRule == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rrule is required when recurrence_type is rrule"})
 		return
 	}
 
-	// Insert schedule - try with new fields, fallback to old schema if needed
-	var scheduleID int
-	err = config.DB.QueryRow(`
-		INSERT INTO schedules (user_id, chat_id, topic, scheduled_time, active, created_at, recurrence_type, reminder_time, reminder_time_end, days_of_week)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-		RETURNING id
-	`, body.UserID, body.ChatID, chat.Topic, nextScheduledTime, true, time.Now(), body.RecurrenceType, body.ReminderTime, body.ReminderTimeEnd, body.DaysOfWeek).Scan(&scheduleID)
+	resolved, err := services.ResolveScheduleRequest(req, time.Now(), loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	reminderTime := req.ReminderTime
+	if resolved.RecurrenceType == "once" {
+		reminderTime = ""
+	} else if resolved.DTStart != nil && (reminderTime == "" || req.RRule != "") {
+		reminderTime = resolved.DTStart.Format("15:04")
+	}
+	active := schedule.Active
+	if body.Active != nil {
+		active = *body.Active
+	}
 
+	schedule.ReminderTime = reminderTime
+	schedule.ReminderTimeEnd = req.ReminderTimeEnd
+	schedule.WindowStrategy = resolved.WindowStrategy
+	fireAt := services.PlanFireTime(schedule, resolved.Next, services.UserQuietHours(schedule.UserID))
+
+	err = config.DB.Get(&schedule, `
+		UPDATE schedules SET scheduled_time=$2, occurrence_time=$3, recurrence_type=$4, reminder_time=$5, reminder_time_end=$6,
+			days_of_week=$7, rrule=$8, exdates=$9, dtstart=$10, window_strategy=$11, active=$12,
+			attempts=0, next_attempt_at=NULL, last_error=''
+		WHERE id=$1
+		RETURNING *
+	`, schedule.ID, fireAt, resolved.Next, resolved.RecurrenceType, reminderTime, req.ReminderTimeEnd,
+		req.DaysOfWeek, resolved.RRule, services.FormatExDates(resolved.ExDates), resolved.DTStart, resolved.WindowStrategy, active)
 	if err != nil {
-		// Fallback to old schema if new columns don't exist
-		err = config.DB.QueryRow(`
-			INSERT INTO schedules (user_id, chat_id, topic, scheduled_time, active, created_at)
-			VALUES ($1, $2, $3, $4, $5, $6)
-			RETURNING id
-		`, body.UserID, body.ChatID, chat.Topic, nextScheduledTime, true, time.Now()).Scan(&scheduleID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule: " + err.Error()})
-			return
-		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule: " + err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message":         "Reminder created successfully",
-		"schedule_id":     scheduleID,
-		"topic":           chat.Topic,
-		"recurrence_type": body.RecurrenceType,
-		"reminder_time":   body.ReminderTime,
-		"next_reminder":   nextScheduledTime.Format(time.RFC3339),
+	scheduleInZone(&schedule, loc)
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Schedule updated",
+		"schedule":      schedule,
+		"timezone":      loc.String(),
+		"next_reminder": fireAt.In(loc).Format(time.RFC3339),
 	})
 }
 
-// calculateNextWeeklyReminder finds the next occurrence for weekly reminders
-func calculateNextWeeklyReminder(now time.Time, daysOfWeek string, hour, minute int) time.Time {
-	// Parse days of week (0=Sunday, 1=Monday, etc.)
-	var days []int
-	// Simple parsing - assumes comma-separated integers
-	for _, dayStr := range strings.Split(daysOfWeek, ",") {
-		var day int
-		fmt.Sscanf(strings.TrimSpace(dayStr), "%d", &day)
-		if day >= 0 && day <= 6 {
-			days = append(days, day)
-		}
+// PreviewSchedule returns the next firing times of a schedule request without
+// saving it, so the learner can check a rule before creating the reminder.
+// Times are computed in the given timezone, else the user's, else UTC; fire
+// times also apply the user's quiet hours when user_id is given.
+func PreviewSchedule(c *gin.Context) {
+	var body struct {
+		services.ScheduleRequest
+		UserID   int    `json:"user_id"`
+		Timezone string `json:"timezone"`
+		Count    int    `json:"count"`
 	}
-
-	if len(days) == 0 {
-		return now.AddDate(0, 0, 1) // Default to tomorrow if invalid
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if body.Count <= 0 {
+		body.Count = 5
+	}
+	if body.Count > 50 {
+		body.Count = 50
 	}
 
-	currentWeekday := int(now.Weekday()) // 0=Sunday, 1=Monday, etc.
-
-	// Find next matching day this week
-	for _, day := range days {
-		daysUntil := (day - currentWeekday + 7) % 7
-		if daysUntil == 0 {
-			// Today - check if time has passed
-			today := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
-			if today.After(now) || today.Equal(now) {
-				return today
-			}
-			// Time passed, check next week
-			daysUntil = 7
-		}
-		if daysUntil > 0 {
-			nextDate := now.AddDate(0, 0, daysUntil)
-			return time.Date(nextDate.Year(), nextDate.Month(), nextDate.Day(), hour, minute, 0, 0, now.Location())
+	loc := time.UTC
+	if body.Timezone != "" {
+		var err error
+		if loc, err = services.ParseTimezone(body.Timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
+	} else if body.UserID != 0 {
+		loc = services.UserLocation(body.UserID)
+	}
+	quiet := services.QuietHours{Location: loc}
+	if body.UserID != 0 {
+		quiet = services.UserQuietHours(body.UserID)
+		quiet.Location = loc
 	}
 
-	// If no match found this week, use first day of next week
-	firstDay := days[0]
-	daysUntil := (firstDay - currentWeekday + 7) % 7
-	if daysUntil == 0 {
-		daysUntil = 7
+	now := time.Now()
+	resolved, err := services.ResolveScheduleRequest(body.ScheduleRequest, now, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	nominal := []time.Time{resolved.Next}
+	if resolved.RRule != "" {
+		nominal = resolved.Recurrence.NextN(now.Add(-time.Nanosecond), body.Count)
 	}
-	nextDate := now.AddDate(0, 0, daysUntil)
-	return time.Date(nextDate.Year(), nextDate.Month(), nextDate.Day(), hour, minute, 0, 0, now.Location())
+	window := models.Schedule{
+		UserID:          body.UserID,
+		ReminderTime:    body.ReminderTime,
+		ReminderTimeEnd: body.ReminderTimeEnd,
+		WindowStrategy:  resolved.WindowStrategy,
+	}
+	if window.ReminderTime == "" && resolved.DTStart != nil {
+		window.ReminderTime = resolved.DTStart.Format("15:04")
+	}
+	occurrences := []string{}
+	fireTimes := []string{}
+	for _, t := range nominal {
+		occurrences = append(occurrences, t.Format(time.RFC3339))
+		fireTimes = append(fireTimes, services.PlanFireTime(window, t, quiet).Format(time.RFC3339))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recurrence_type": resolved.RecurrenceType,
+		"rrule":           resolved.RRule,
+		"dtstart":         resolved.DTStart,
+		"timezone":        loc.String(),
+		"occurrences":     occurrences,
+		"fire_times":      fireTimes,
+	})
 }
 
-// GetUserSchedules returns all active schedules for a user
+// GetUserSchedules returns a user's active schedules, soonest first, in pages
+// of ?limit= (default 50)
 func GetUserSchedules(c *gin.Context) {
 	userID := c.Param("user_id")
-	var schedules []models.Schedule
+	page, ok := parsePageQuery(c, 50, 200, false)
+	if !ok {
+		return
+	}
+	schedules := []models.Schedule{}
 
-	err := config.DB.Select(&schedules, `
-		SELECT * FROM schedules 
-		WHERE user_id=$1 AND active=true 
-		ORDER BY scheduled_time ASC
-	`, userID)
+	var err error
+	if page.Cursor != nil {
+		afterID, convErr := strconv.Atoi(page.Cursor.ID)
+		if convErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		err = config.DB.Select(&schedules, `
+			SELECT * FROM schedules
+			WHERE user_id=$1 AND active=true AND (scheduled_time, id) > ($2, $3)
+			ORDER BY scheduled_time ASC, id ASC
+			LIMIT $4
+		`, userID, page.Cursor.Time, afterID, page.Limit+1)
+	} else {
+		err = config.DB.Select(&schedules, `
+			SELECT * FROM schedules 
+			WHERE user_id=$1 AND active=true 
+			ORDER BY scheduled_time ASC, id ASC
+			LIMIT $2
+		`, userID, page.Limit+1)
+	}
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	more := len(schedules) > page.Limit
+	if more {
+		schedules = schedules[:page.Limit]
+	}
 
-	c.JSON(http.StatusOK, schedules)
+	var nextCursor *string
+	if len(schedules) > 0 {
+		last := schedules[len(schedules)-1]
+		nextCursor = pageCursor(more, last.ScheduledTime, strconv.Itoa(last.ID))
+	}
+	loc := services.UserLocation(parseInt(userID))
+	for i := range schedules {
+		scheduleInZone(&schedules[i], loc)
+	}
+	c.JSON(http.StatusOK, gin.H{"items": schedules, "next_cursor": nextCursor})
+}
+
+// scheduleInZone converts a schedule's instants to the user's zone so the
+// JSON shows local times with their offset
+func scheduleInZone(s *models.Schedule, loc *time.Location) {
+	s.ScheduledTime = s.ScheduledTime.In(loc)
+	s.CreatedAt = s.CreatedAt.In(loc)
+	for _, t := range []*time.Time{s.DTStart, s.OccurrenceTime, s.NextAttemptAt, s.LastFiredAt} {
+		if t != nil {
+			*t = t.In(loc)
+		}
+	}
 }
 
 // CancelSchedule deactivates a schedule
@@ -225,15 +380,15 @@ func CancelSchedule(c *gin.Context) {
 }
 
 // GetDueSchedules returns schedules that are due (scheduled_time <= now, active, not sent)
-// Useful for n8n/cron jobs to check what needs reminders
+// Useful for n8n/cron jobs to check what needs reminders when REMINDER_MODE=external.
+// Occurrences stay due until delivered, so a missed poll no longer drops them.
 func GetDueSchedules(c *gin.Context) {
 	var schedules []models.Schedule
 
 	err := config.DB.Select(&schedules, `
 		SELECT * FROM schedules 
 		WHERE active=true 
-		AND scheduled_time <= NOW()
-		AND scheduled_time >= NOW() - INTERVAL '1 hour'
+		AND COALESCE(next_attempt_at, scheduled_time) <= NOW()
 		ORDER BY scheduled_time ASC
 	`)
 	if err != nil {
@@ -244,6 +399,90 @@ func GetDueSchedules(c *gin.Context) {
 	c.JSON(http.StatusOK, schedules)
 }
 
+// GetScheduleDeliveries returns the delivery log of a schedule, newest first
+func GetScheduleDeliveries(c *gin.Context) {
+	var schedule models.Schedule
+	err := config.DB.Get(&schedule, "SELECT * FROM schedules WHERE id=$1 AND user_id=$2", c.Param("id"), parseInt(c.Query("user_id")))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found or unauthorized"})
+		return
+	}
+
+	var deliveries []models.ReminderDelivery
+	err = config.DB.Select(&deliveries, `
+		SELECT * FROM reminder_deliveries WHERE schedule_id=$1 ORDER BY created_at DESC LIMIT 100
+	`, schedule.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	loc := services.UserLocation(schedule.UserID)
+	scheduleInZone(&schedule, loc)
+	for i := range deliveries {
+		deliveries[i].OccurrenceTime = deliveries[i].OccurrenceTime.In(loc)
+		deliveries[i].CreatedAt = deliveries[i].CreatedAt.In(loc)
+	}
+	c.JSON(http.StatusOK, gin.H{"schedule": schedule, "deliveries": deliveries, "timezone": loc.String()})
+}
+
+// GetReminderPreferences returns the user's quiet hours, do-not-disturb days
+// and preferred reminder hours
+func GetReminderPreferences(c *gin.Context) {
+	userID := parseInt(c.Param("user_id"))
+	var prefs models.ReminderPreferences
+	err := config.DB.Get(&prefs, "SELECT quiet_hours_start, quiet_hours_end, dnd_days, preferred_hours_start, preferred_hours_end FROM users WHERE id=$1", userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"preferences": prefs, "timezone": services.UserLocation(userID).String()})
+}
+
+// UpdateReminderPreferences replaces the user's quiet hours, do-not-disturb
+// days and preferred hours, then re-plans pending reminders so none fire
+// inside the new quiet hours
+func UpdateReminderPreferences(c *gin.Context) {
+	var body models.ReminderPreferences
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	userID := parseInt(c.Param("user_id"))
+	loc := services.UserLocation(userID)
+	if _, err := services.NewQuietHours(body, loc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (body.PreferredHoursStart == "") != (body.PreferredHoursEnd == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "preferred_hours_start and preferred_hours_end must be set together"})
+		return
+	}
+	for _, t := range []string{body.PreferredHoursStart, body.PreferredHoursEnd} {
+		if _, err := services.ParseClock(t); t != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	res, err := config.DB.Exec(`
+		UPDATE users SET quiet_hours_start=$2, quiet_hours_end=$3, dnd_days=$4, preferred_hours_start=$5, preferred_hours_end=$6, updated_at=NOW()
+		WHERE id=$1
+	`, userID, body.QuietHoursStart, body.QuietHoursEnd, body.DNDDays, body.PreferredHoursStart, body.PreferredHoursEnd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := services.ReplanSchedules(userID); err != nil {
+		fmt.Printf("Warning: failed to re-plan reminders of user %d: %v\n", userID, err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Reminder preferences updated", "preferences": body, "timezone": loc.String()})
+}
+
 func parseInt(s string) int {
 	var n int
 	fmt.Sscanf(s, "%d", &n)
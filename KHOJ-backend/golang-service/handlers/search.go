@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SearchUserContent searches a learner's messages and quiz questions for ?q=.
+// ?mode=semantic matches by meaning instead of words and falls back to text
+// search without an API key. Results can be narrowed with ?type= (message,
+// quiz_question or both comma-separated), ?topic=, ?from= and ?to= (dates in
+// the learner's zone, to inclusive, or RFC 3339), and paged with ?limit=
+// (default 20) and ?offset=. A semantic search compares only the most recent
+// matching items; "truncated" says older ones were left out, and a narrower
+// range reaches them.
+func SearchUserContent(c *gin.Context) {
+	userID := parseInt(c.Param("user_id"))
+	q := services.SearchQuery{
+		UserID: userID,
+		Text:   strings.TrimSpace(c.Query("q")),
+		Mode:   strings.ToLower(strings.TrimSpace(c.DefaultQuery("mode", services.SearchModeText))),
+		Topic:  strings.TrimSpace(c.Query("topic")),
+		Limit:  20,
+	}
+	if q.Text == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	if q.Mode != services.SearchModeText && q.Mode != services.SearchModeSemantic {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be \"text\" or \"semantic\""})
+		return
+	}
+	if t := strings.TrimSpace(c.Query("type")); t != "" {
+		for _, part := range strings.Split(t, ",") {
+			part = strings.TrimSpace(part)
+			if part != services.SearchMessage && part != services.SearchQuizQuestion {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "type must be message, quiz_question or both"})
+				return
+			}
+			q.Types = append(q.Types, part)
+		}
+	}
+	loc := services.UserLocation(userID)
+	for _, p := range []struct {
+		name string
+		end  bool
+		dst  **time.Time
+	}{{"from", false, &q.From}, {"to", true, &q.To}} {
+		s := strings.TrimSpace(c.Query(p.name))
+		if s == "" {
+			continue
+		}
+		t, err := parseAnalyticsTime(s, loc, p.end)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + p.name + ", use YYYY-MM-DD or RFC 3339"})
+			return
+		}
+		*p.dst = &t
+	}
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 && v <= services.MaxSearchResults {
+		q.Limit = v
+	}
+	if v, err := strconv.Atoi(c.Query("offset")); err == nil && v > 0 {
+		q.Offset = v
+	}
+
+	var page services.SearchPage
+	var err error
+	apiKey := services.ResolveGeminiAPIKeyFromRequest(c)
+	if q.Mode == services.SearchModeSemantic && apiKey != "" {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+		defer cancel()
+		page, err = services.SearchSemantic(ctx, apiKey, q)
+	} else {
+		page, err = services.SearchText(q)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var nextOffset *int
+	if next := q.Offset + len(page.Results); len(page.Results) > 0 && next < page.Total {
+		nextOffset = &next
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"mode":        page.Mode,
+		"total":       page.Total,
+		"results":     page.Results,
+		"next_offset": nextOffset,
+		"truncated":   page.Truncated,
+	})
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+	"golang-service/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateStudyPlan generates a plan from the user's onboarding answers. Topics
+// default to the user's most recent chat topics; start_date (YYYY-MM-DD, the
+// user's zone) defaults to today. An existing active plan is archived.
+func CreateStudyPlan(c *gin.Context) {
+	var body struct {
+		UserID    int      `json:"user_id" binding:"required"`
+		Topics    []string `json:"topics"`
+		Weeks     int      `json:"weeks"`
+		StartDate string   `json:"start_date"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	var exists bool
+	if err := config.DB.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id=$1)", body.UserID); err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if len(body.Topics) == 0 {
+		err := config.DB.Select(&body.Topics, `
+			SELECT topic FROM chats WHERE user_id=$1 GROUP BY topic ORDER BY MAX(updated_at) DESC LIMIT $2
+		`, body.UserID, services.MaxStudyPlanTopics)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	loc := services.UserLocation(body.UserID)
+	start := time.Now().In(loc)
+	if body.StartDate != "" {
+		var err error
+		if start, err = time.ParseInLocation("2006-01-02", body.StartDate, loc); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start_date must be YYYY-MM-DD"})
+			return
+		}
+	}
+
+	plan, err := services.CreateStudyPlan(body.UserID, body.Topics, body.Weeks, start)
+	if err != nil {
+		if err == services.ErrNoPlanTopics || strings.HasPrefix(err.Error(), "a plan can") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create study plan: " + err.Error()})
+		return
+	}
+	respondStudyPlan(c, http.StatusCreated, plan, nil)
+}
+
+// GetUserStudyPlan returns the user's active plan, or the latest one if none
+// is active
+func GetUserStudyPlan(c *gin.Context) {
+	var plan models.StudyPlan
+	err := config.DB.Get(&plan, `
+		SELECT * FROM study_plans WHERE user_id=$1
+		ORDER BY (status='active') DESC, created_at DESC LIMIT 1
+	`, parseInt(c.Param("id")))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No study plan; create one first"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	respondStudyPlan(c, http.StatusOK, plan, nil)
+}
+
+// GetStudyPlan returns a plan by id (?user_id= must own it)
+func GetStudyPlan(c *gin.Context) {
+	plan, ok := ownedStudyPlan(c, c.Query("user_id"))
+	if !ok {
+		return
+	}
+	respondStudyPlan(c, http.StatusOK, plan, nil)
+}
+
+// ReplanStudyPlan re-plans missed items now instead of waiting for the
+// background sweep
+func ReplanStudyPlan(c *gin.Context) {
+	var body struct {
+		UserID int `json:"user_id" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	plan, ok := ownedStudyPlan(c, body.UserID)
+	if !ok {
+		return
+	}
+	if plan.Status != services.StudyPlanActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only an active plan can be re-planned", "status": plan.Status})
+		return
+	}
+	carried, err := services.ReplanStudyPlan(plan.ID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to re-plan: " + err.Error()})
+		return
+	}
+	if err := config.DB.Get(&plan, "SELECT * FROM study_plans WHERE id=$1", plan.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	respondStudyPlan(c, http.StatusOK, plan, gin.H{"carried_over": carried})
+}
+
+// ArchiveStudyPlan archives a plan and cancels its pending reminders
+func ArchiveStudyPlan(c *gin.Context) {
+	err := services.ArchiveStudyPlan(parseInt(c.Param("id")), parseInt(c.Query("user_id")))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Study plan not found or unauthorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Study plan archived"})
+}
+
+// CompleteStudyPlanItem marks a plan item done by hand, e.g. a video session
+// that left no trace in the chat
+func CompleteStudyPlanItem(c *gin.Context) {
+	var body struct {
+		UserID int `json:"user_id" binding:"required"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	item, err := services.CompletePlanItem(parseInt(c.Param("id")), body.UserID, nil)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Study plan item not found or unauthorized"})
+	case err == services.ErrPlanItemClosed:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": item.Status})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, item)
+	}
+}
+
+func ownedStudyPlan(c *gin.Context, userID interface{}) (models.StudyPlan, bool) {
+	var plan models.StudyPlan
+	err := config.DB.Get(&plan, "SELECT * FROM study_plans WHERE id=$1 AND user_id=$2", parseInt(c.Param("id")), userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Study plan not found or unauthorized"})
+		return plan, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return plan, false
+	}
+	return plan, true
+}
+
+// respondStudyPlan sends a plan with its items, grouped by week with times in
+// the user's zone, and progress so far. extra is merged into the response.
+func respondStudyPlan(c *gin.Context, status int, plan models.StudyPlan, extra gin.H) {
+	var items []models.StudyPlanItem
+	err := config.DB.Select(&items, "SELECT * FROM study_plan_items WHERE plan_id=$1 ORDER BY scheduled_for, id", plan.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	loc := services.UserLocation(plan.UserID)
+	carried := map[int]bool{}
+	for _, item := range items {
+		if item.ReplannedFrom != nil {
+			carried[*item.ReplannedFrom] = true
+		}
+	}
+	counts := map[string]int{}
+	weeks := []gin.H{}
+	var current gin.H
+	for _, item := range items {
+		// A carried-over miss is counted through its replacement
+		if !(item.Status == services.PlanItemMissed && carried[item.ID]) {
+			counts[item.Status]++
+		}
+		item.ScheduledFor = item.ScheduledFor.In(loc)
+		if current == nil || current["week"] != item.Week {
+			current = gin.H{"week": item.Week, "items": []models.StudyPlanItem{}}
+			weeks = append(weeks, current)
+		}
+		current["items"] = append(current["items"].([]models.StudyPlanItem), item)
+	}
+	// Skipped items were cancelled with an archived plan and don't count
+	open := counts[services.PlanItemPending] + counts[services.PlanItemMissed]
+	percent := 0
+	if total := counts[services.PlanItemDone] + open; total > 0 {
+		percent = counts[services.PlanItemDone] * 100 / total
+	}
+
+	resp := gin.H{
+		"plan":  plan,
+		"weeks": weeks,
+		"progress": gin.H{
+			"done":    counts[services.PlanItemDone],
+			"pending": counts[services.PlanItemPending],
+			"missed":  counts[services.PlanItemMissed],
+			"percent": percent,
+		},
+		"timezone": loc.String(),
+	}
+	for k, v := range extra {
+		resp[k] = v
+	}
+	c.JSON(status, resp)
+}
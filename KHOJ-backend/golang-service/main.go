@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"golang-service/config"
 	"golang-service/handlers"
@@ -11,6 +12,7 @@ import (
 	//"golang-service/middleware"
 
 	"golang-service/routes"
+	"golang-service/services"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -18,6 +20,17 @@ import (
 
 func main() {
 	config.ConnectDatabase()
+	if err := services.SeedPromptTemplates(); err != nil {
+		fmt.Printf("Warning: %v; using built-in prompts\n", err)
+	}
+	services.StartGamification()
+	services.StartLeaderboards()
+	services.StartNotifications()
+	services.StartReminderScheduler(context.Background())
+	services.StartStudyPlans(context.Background())
+	services.StartSearchIndexer(context.Background())
+	services.StartDataExports(context.Background())
+	services.StartFacts(context.Background())
 	r := gin.Default()
 
 	// Enable CORS for local frontend
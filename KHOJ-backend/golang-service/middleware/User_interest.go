@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"errors"
+	"fmt"
 	"golang-service/models"
+	"golang-service/services"
 
 	"net/http"
 
@@ -18,18 +21,35 @@ func SaveUserAnswers(c *gin.Context) {
 		return
 	}
 
-	for _, ans := range payload.Answers {
-		_, err := config.DB.Exec(`
-			INSERT INTO user_answers (user_id, question_number, question, answer)
-			VALUES ($1, $2, $3, $4)
-		`, payload.ID, ans.QuestionNumber, ans.Question, ans.Answer)
-
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save answers: " + err.Error()})
+	// Answers are upserted per question, so resubmitting onboarding replaces
+	// the earlier answers; the learner profile and default reminder window
+	// are derived from them
+	version := payload.Version
+	if version == 0 {
+		version = 1
+	}
+	profile, err := services.SaveOnboardingAnswers(payload.ID, version, payload.Answers)
+	if err != nil {
+		if err == services.ErrUnknownQuestionnaire || errors.Is(err, services.ErrInvalidAnswer) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save answers: " + err.Error()})
+		return
+	}
+
+	// The browser's zone is optional; an unknown name shouldn't fail onboarding
+	if payload.Timezone != "" {
+		if loc, err := services.ParseTimezone(payload.Timezone); err == nil {
+			if _, err := config.DB.Exec("UPDATE users SET timezone=$1, updated_at=NOW() WHERE id=$2", loc.String(), payload.ID); err != nil {
+				fmt.Printf("Warning: failed to save timezone for user %d: %v\n", payload.ID, err)
+			}
+		} else {
+			fmt.Printf("Warning: ignoring invalid timezone %q for user %d\n", payload.Timezone, payload.ID)
+		}
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Answers saved successfully!"})
+	profile.Timezone = services.UserLocation(payload.ID).String()
+	c.JSON(http.StatusOK, gin.H{"message": "Answers saved successfully!", "profile": profile})
 }
 
 // CheckUserOnboardingStatus checks if a user has completed onboarding
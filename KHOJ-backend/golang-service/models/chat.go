@@ -8,6 +8,22 @@ type Message struct {
 	Role      string    `db:"role" json:"role"`
 	Content   string    `db:"content" json:"content"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	ParentID  *string   `db:"parent_id" json:"parent_id,omitempty"` // Message before this one on its branch; nil for the first
+	Model     string    `db:"model" json:"model,omitempty"`         // Gemini model that wrote a bot reply
+	Aside     bool      `db:"aside" json:"aside,omitempty"`         // Posted beside the conversation (a daily fact); never a parent or the leaf
+	// Filled by the active path query
+	Versions int  `db:"versions" json:"versions,omitempty"` // Messages with the same parent and role: edits or regenerated replies
+	Rating   *int `db:"rating" json:"rating,omitempty"`
+}
+
+// MessageFeedback is a learner's thumbs up (1) or down (-1) on a bot reply
+type MessageFeedback struct {
+	MessageID string    `db:"message_id" json:"message_id"`
+	UserID    int       `db:"user_id" json:"user_id"`
+	Rating    int       `db:"rating" json:"rating"`
+	Reason    string    `db:"reason" json:"reason"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
 }
 
 type Chat struct {
@@ -16,4 +32,23 @@ type Chat struct {
 	Topic     string    `db:"topic" json:"topic"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+	// Last message of the branch the chat shows
+	ActiveLeafID *string `db:"active_leaf_id" json:"active_leaf_id,omitempty"`
+	// Generated after a few turns unless the learner named the chat; empty
+	// until then
+	Title       string `db:"title" json:"title"`
+	TitleByUser bool   `db:"title_by_user" json:"title_by_user"`
+	// Rolling summary of the active branch, up to SummaryThroughID
+	Summary          string  `db:"summary" json:"summary"`
+	SummaryThroughID *string `db:"summary_through_id" json:"-"`
+}
+
+// ChatNotes are study notes condensed from a chat's active branch ending at
+// LeafID. Notes is the JSON of services.StudyNotes.
+type ChatNotes struct {
+	ChatID    string    `db:"chat_id" json:"chat_id"`
+	LeafID    string    `db:"leaf_id" json:"leaf_id"`
+	Notes     string    `db:"notes" json:"-"`
+	Model     string    `db:"model" json:"model,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
 }
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// DataExport is a "download all my data" job. The finished zip is kept in
+// the row until it expires.
+type DataExport struct {
+	ID         int        `db:"id" json:"id"`
+	UserID     int        `db:"user_id" json:"user_id"`
+	Status     string     `db:"status" json:"status"` // "pending", "running", "done" or "failed"
+	Error      string     `db:"error" json:"error,omitempty"`
+	Archive    []byte     `db:"archive" json:"-"`
+	SizeBytes  int        `db:"size_bytes" json:"size_bytes"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	StartedAt  *time.Time `db:"started_at" json:"started_at,omitempty"`
+	FinishedAt *time.Time `db:"finished_at" json:"finished_at,omitempty"`
+	ExpiresAt  *time.Time `db:"expires_at" json:"expires_at,omitempty"` // When the zip is deleted
+}
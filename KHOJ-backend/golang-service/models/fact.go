@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// TopicFact is a short fact about a canonical topic. Facts are generated and
+// checked once, then shared by every learner of the topic.
+type TopicFact struct {
+	ID             int       `db:"id" json:"id"`
+	TopicKey       string    `db:"topic_key" json:"topic_key"`
+	Fact           string    `db:"fact" json:"fact"`
+	NormalizedFact string    `db:"normalized_fact" json:"-"`
+	Model          string    `db:"model" json:"model,omitempty"`
+	Status         string    `db:"status" json:"status"` // "active", or "retired" after too many thumbs down
+	Upvotes        int       `db:"upvotes" json:"upvotes"`
+	Downvotes      int       `db:"downvotes" json:"downvotes"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+// FactDelivery is a fact planned for or sent to a learner; at most one per
+// day in the learner's zone
+type FactDelivery struct {
+	ID           int        `db:"id" json:"id"`
+	UserID       int        `db:"user_id" json:"user_id"`
+	FactID       int        `db:"fact_id" json:"fact_id"`
+	Topic        string     `db:"topic" json:"topic"` // As in the learner's chat
+	TopicKey     string     `db:"topic_key" json:"topic_key"`
+	ChatID       *string    `db:"chat_id" json:"chat_id,omitempty"`
+	LocalDate    time.Time  `db:"local_date" json:"local_date"`
+	ScheduledFor time.Time  `db:"scheduled_for" json:"scheduled_for"`
+	Status       string     `db:"status" json:"status"`           // "scheduled", "sent", "cancelled" or "failed"
+	Rating       *int       `db:"rating" json:"rating,omitempty"` // 1 or -1
+	SentAt       *time.Time `db:"sent_at" json:"sent_at,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+}
+
+// FactMute stops facts on one topic for a learner
+type FactMute struct {
+	UserID    int       `db:"user_id" json:"user_id"`
+	TopicKey  string    `db:"topic_key" json:"topic_key"`
+	Topic     string    `db:"topic" json:"topic"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// FlashcardDeck holds a learner's cards on one topic
+type FlashcardDeck struct {
+	ID        int       `db:"id" json:"id"`
+	UserID    int       `db:"user_id" json:"user_id"`
+	Topic     string    `db:"topic" json:"topic"`         // As the learner first typed it
+	TopicKey  string    `db:"topic_key" json:"topic_key"` // CanonicalTopic, one deck per key
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+	// Filled by the deck list query
+	CardCount int `db:"card_count" json:"card_count"`
+	DueCount  int `db:"due_count" json:"due_count"`
+}
+
+// Flashcard is a front/back card, scheduled for review with SM-2
+type Flashcard struct {
+	ID              int        `db:"id" json:"id"`
+	DeckID          int        `db:"deck_id" json:"deck_id"`
+	UserID          int        `db:"user_id" json:"user_id"`
+	ChatID          *string    `db:"chat_id" json:"chat_id,omitempty"` // Chat it was generated from
+	Front           string     `db:"front" json:"front"`
+	Back            string     `db:"back" json:"back"`
+	NormalizedFront string     `db:"normalized_front" json:"-"`
+	Source          string     `db:"source" json:"source"` // "generated" or "manual"
+	Ease            float64    `db:"ease" json:"ease"`
+	IntervalDays    int        `db:"interval_days" json:"interval_days"`
+	Repetitions     int        `db:"repetitions" json:"repetitions"` // Successful reviews in a row
+	Lapses          int        `db:"lapses" json:"lapses"`
+	DueAt           time.Time  `db:"due_at" json:"due_at"`
+	LastReviewedAt  *time.Time `db:"last_reviewed_at" json:"last_reviewed_at,omitempty"`
+	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt       time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// FlashcardReview is one recall grade given to a card
+type FlashcardReview struct {
+	ID           int       `db:"id" json:"id"`
+	CardID       int       `db:"card_id" json:"card_id"`
+	UserID       int       `db:"user_id" json:"user_id"`
+	Grade        int       `db:"grade" json:"grade"` // 0 (forgot) to 5 (perfect recall)
+	IntervalDays int       `db:"interval_days" json:"interval_days"`
+	Ease         float64   `db:"ease" json:"ease"`
+	ReviewedAt   time.Time `db:"reviewed_at" json:"reviewed_at"`
+}
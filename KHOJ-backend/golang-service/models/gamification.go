@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// XPEvent is one XP award. (UserID, EventType, SourceID) is unique so the
+// same message or quiz never pays out twice.
+type XPEvent struct {
+	ID        int       `db:"id" json:"id"`
+	UserID    int       `db:"user_id" json:"user_id"`
+	EventType string    `db:"event_type" json:"event_type"`
+	SourceID  string    `db:"source_id" json:"source_id"`
+	XP        int       `db:"xp" json:"xp"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// UserGamification holds a learner's running XP, streak and activity counters
+type UserGamification struct {
+	UserID           int        `db:"user_id" json:"user_id"`
+	TotalXP          int        `db:"total_xp" json:"total_xp"`
+	CurrentStreak    int        `db:"current_streak" json:"current_streak"`
+	LongestStreak    int        `db:"longest_streak" json:"longest_streak"`
+	LastActiveDate   *time.Time `db:"last_active_date" json:"last_active_date,omitempty"` // Calendar date in the user's time zone
+	FreezeTokens     int        `db:"freeze_tokens" json:"freeze_tokens"`
+	MessagesSent     int        `db:"messages_sent" json:"messages_sent"`
+	QuizzesCompleted int        `db:"quizzes_completed" json:"quizzes_completed"`
+	PerfectQuizzes   int        `db:"perfect_quizzes" json:"perfect_quizzes"`
+	ReviewsCompleted int        `db:"reviews_completed" json:"reviews_completed"`
+	UpdatedAt        time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// AchievementDefinition is a badge. Rule is a JSON object evaluated against
+// the learner's counters, e.g. {"metric": "quizzes_completed", "threshold": 10}.
+type AchievementDefinition struct {
+	Code        string `db:"code" json:"code"`
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+	Icon        string `db:"icon" json:"icon"`
+	Rule        string `db:"rule" json:"rule"`
+	XPReward    int    `db:"xp_reward" json:"xp_reward"`
+	SortOrder   int    `db:"sort_order" json:"sort_order"`
+	Active      bool   `db:"active" json:"active"`
+}
+
+// UserAchievement tracks a learner's progress towards one achievement
+type UserAchievement struct {
+	UserID          int        `db:"user_id" json:"user_id"`
+	AchievementCode string     `db:"achievement_code" json:"achievement_code"`
+	Progress        int        `db:"progress" json:"progress"`
+	Target          int        `db:"target" json:"target"`
+	EarnedAt        *time.Time `db:"earned_at" json:"earned_at,omitempty"`
+	UpdatedAt       time.Time  `db:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// StudyGroup is a classroom or study group with its own leaderboard.
+// Learners join with the group's join code.
+type StudyGroup struct {
+	ID        int       `db:"id" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	JoinCode  string    `db:"join_code" json:"join_code"`
+	OwnerID   int       `db:"owner_id" json:"owner_id"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// StudyGroupMember links a user to a group
+type StudyGroupMember struct {
+	GroupID  int       `db:"group_id" json:"group_id"`
+	UserID   int       `db:"user_id" json:"user_id"`
+	Role     string    `db:"role" json:"role"` // "owner" or "member"
+	JoinedAt time.Time `db:"joined_at" json:"joined_at"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LearnerProfile is the typed form of a user's onboarding answers, editable
+// afterwards. Timezone lives on users and is joined in when read.
+type LearnerProfile struct {
+	UserID               int            `db:"user_id" json:"user_id"`
+	QuestionnaireVersion int            `db:"questionnaire_version" json:"questionnaire_version"`
+	LearningStyles       pq.StringArray `db:"learning_styles" json:"learning_styles"`       // Ranked, most preferred first: "videos", "documents", "conversations"
+	PreferredWindows     pq.StringArray `db:"preferred_windows" json:"preferred_windows"`   // "HH:MM-HH:MM" in the user's zone; may wrap past midnight
+	ReminderFrequency    string         `db:"reminder_frequency" json:"reminder_frequency"` // "daily", "3x_week", "weekly" or "never"
+	FactsNotifications   bool           `db:"facts_notifications" json:"facts_notifications"`
+	Language             string         `db:"language" json:"language"`       // BCP 47 tag, e.g. "en" or "hi"
+	Level                string         `db:"level" json:"level"`             // "beginner", "intermediate", "advanced"; empty to infer from quiz scores
+	TutorStyle           string         `db:"tutor_style" json:"tutor_style"` // e.g. "balanced", "socratic", "concise", "example_heavy"
+	Timezone             string         `db:"timezone" json:"timezone"`
+	CompletedAt          *time.Time     `db:"completed_at" json:"completed_at,omitempty"` // When every required question was answered
+	CreatedAt            time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt            time.Time      `db:"updated_at" json:"updated_at"`
+}
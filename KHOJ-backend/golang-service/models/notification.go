@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// NotificationPreference enables a delivery channel for a user. Target is the
+// channel's address when it needs one: an email override or a webhook URL.
+type NotificationPreference struct {
+	UserID    int       `db:"user_id" json:"user_id"`
+	Channel   string    `db:"channel" json:"channel"` // "in_app", "email", "push" or "webhook"
+	Enabled   bool      `db:"enabled" json:"enabled"`
+	Target    string    `db:"target" json:"target,omitempty"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// PushSubscription is a browser's Web Push subscription (PushSubscription.toJSON())
+type PushSubscription struct {
+	ID        int       `db:"id" json:"id"`
+	UserID    int       `db:"user_id" json:"user_id"`
+	Endpoint  string    `db:"endpoint" json:"endpoint"`
+	P256dh    string    `db:"p256dh" json:"p256dh"` // Base64url browser public key
+	Auth      string    `db:"auth" json:"auth"`     // Base64url auth secret
+	UserAgent string    `db:"user_agent" json:"user_agent,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// NotificationDelivery records one notification sent (or attempted) on one channel
+type NotificationDelivery struct {
+	ID        int        `db:"id" json:"id"`
+	UserID    int        `db:"user_id" json:"user_id"`
+	Channel   string     `db:"channel" json:"channel"`
+	Kind      string     `db:"kind" json:"kind"` // Template name, e.g. "quiz_reminder"
+	DedupeKey string     `db:"dedupe_key" json:"dedupe_key,omitempty"`
+	Target    string     `db:"target" json:"target,omitempty"`
+	Status    string     `db:"status" json:"status"` // "pending", "sent", "failed" or "skipped"
+	Error     string     `db:"error" json:"error,omitempty"`
+	Attempts  int        `db:"attempts" json:"attempts"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	SentAt    *time.Time `db:"sent_at" json:"sent_at,omitempty"`
+}
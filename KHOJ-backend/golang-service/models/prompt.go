@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// PromptTemplate is one version of a named Go text/template used to build
+// LLM prompts
+type PromptTemplate struct {
+	ID          int       `db:"id" json:"id"`
+	Name        string    `db:"name" json:"name"` // e.g. "tutor.system", "tutor.style.socratic", "quiz.mcq_generation"
+	Version     int       `db:"version" json:"version"`
+	Body        string    `db:"body" json:"body"`
+	Description string    `db:"description" json:"description"`
+	Active      bool      `db:"active" json:"active"`
+	CreatedBy   *int      `db:"created_by" json:"created_by,omitempty"` // Admin who added it; nil for built-in defaults
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	// Model settings; empty/nil use the caller's model and Gemini's defaults
+	Model           string   `db:"model" json:"model"`
+	Temperature     *float64 `db:"temperature" json:"temperature,omitempty"`
+	MaxOutputTokens *int     `db:"max_output_tokens" json:"max_output_tokens,omitempty"`
+}
+
+// PromptExperiment splits learners between versions of one prompt template
+type PromptExperiment struct {
+	ID         int                `db:"id" json:"id"`
+	Name       string             `db:"name" json:"name"`
+	PromptName string             `db:"prompt_name" json:"prompt_name"`
+	Status     string             `db:"status" json:"status"` // "running" or "stopped"
+	CreatedBy  *int               `db:"created_by" json:"created_by,omitempty"`
+	CreatedAt  time.Time          `db:"created_at" json:"created_at"`
+	StoppedAt  *time.Time         `db:"stopped_at" json:"stopped_at,omitempty"`
+	Variants   []PromptExpVariant `db:"-" json:"variants"`
+}
+
+// PromptExpVariant is one arm of an experiment. Learners are assigned in
+// proportion to weight.
+type PromptExpVariant struct {
+	ID           int    `db:"id" json:"id"`
+	ExperimentID int    `db:"experiment_id" json:"experiment_id"`
+	Label        string `db:"label" json:"label"`
+	TemplateID   int    `db:"template_id" json:"template_id"`
+	Weight       int    `db:"weight" json:"weight"`
+}
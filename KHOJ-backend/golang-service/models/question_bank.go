@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// BankQuestion is a reusable question stored once per canonical topic and
+// shared across quizzes and users.
+type BankQuestion struct {
+	ID             int       `db:"id" json:"id"`
+	Topic          string    `db:"topic" json:"topic"` // Canonical topic key (see services.CanonicalTopic)
+	Subtopic       string    `db:"subtopic" json:"subtopic,omitempty"`
+	Question       string    `db:"question" json:"question"`
+	NormalizedText string    `db:"normalized_text" json:"-"`
+	Options        string    `db:"options" json:"options"` // JSON array of options, "[]" for free-text questions
+	Answer         string    `db:"answer" json:"answer"`   // Correct option letter ("A".."D") or free-text answer
+	Embedding      string    `db:"embedding" json:"-"`     // JSON array of floats, empty when embeddings are unavailable
+	Source         string    `db:"source" json:"source"`   // "generated", "manual", "import"
+	Status         string    `db:"status" json:"status"`   // "unreviewed", "approved", "rejected"
+	FlagCount      int       `db:"flag_count" json:"flag_count"`
+	TimesUsed      int       `db:"times_used" json:"times_used"`
+	CreatedBy      *int      `db:"created_by" json:"created_by,omitempty"`
+	ReviewedBy     *int      `db:"reviewed_by" json:"reviewed_by,omitempty"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// BankQuestionFlag records a learner reporting a bank question as wrong.
+type BankQuestionFlag struct {
+	ID         int       `db:"id" json:"id"`
+	QuestionID int       `db:"question_id" json:"question_id"`
+	UserID     int       `db:"user_id" json:"user_id"`
+	Reason     string    `db:"reason" json:"reason"`
+	Resolved   bool      `db:"resolved" json:"resolved"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+}
@@ -15,6 +15,60 @@ type Schedule struct {
 	ReminderTime   string `db:"reminder_time" json:"reminder_time"`     // Time of day "HH:MM"
 	ReminderTimeEnd string `db:"reminder_time_end" json:"reminder_time_end,omitempty"` // Optional end time for ranges
 	DaysOfWeek     string `db:"days_of_week" json:"days_of_week,omitempty"` // Comma-separated: "1,3,5" for Mon,Wed,Fri (0=Sun, 1=Mon, etc.)
+	// RFC 5545 recurrence; legacy daily/weekly schedules get an equivalent rule
+	RRule   string     `db:"rrule" json:"rrule,omitempty"`     // e.g. "FREQ=MONTHLY;BYDAY=2TU"
+	ExDates string     `db:"exdates" json:"exdates,omitempty"` // Comma-separated excluded instants (RFC 3339) or dates (YYYY-MM-DD)
+	DTStart *time.Time `db:"dtstart" json:"dtstart,omitempty"` // Anchor of the rule: first possible occurrence and time of day
+	// Windowed delivery: the occurrence fires somewhere in reminder_time..reminder_time_end
+	WindowStrategy string     `db:"window_strategy" json:"window_strategy"`           // "random", "activity" or "start"
+	OccurrenceTime *time.Time `db:"occurrence_time" json:"occurrence_time,omitempty"` // Nominal occurrence; scheduled_time is when it actually fires
+	// Scheduler state: retries of the current occurrence and the last successful run
+	Attempts      int        `db:"attempts" json:"attempts"`
+	NextAttemptAt *time.Time `db:"next_attempt_at" json:"next_attempt_at,omitempty"` // Set while a failed occurrence waits for a retry
+	LastFiredAt   *time.Time `db:"last_fired_at" json:"last_fired_at,omitempty"`
+	LastError     string     `db:"last_error" json:"last_error,omitempty"`
+	// Set when the schedule reminds of a study plan item
+	PlanItemID *int `db:"plan_item_id" json:"plan_item_id,omitempty"`
 }
 
+// ReminderDelivery logs one attempt at delivering a schedule occurrence
+type ReminderDelivery struct {
+	ID             int       `db:"id" json:"id"`
+	ScheduleID     int       `db:"schedule_id" json:"schedule_id"`
+	UserID         int       `db:"user_id" json:"user_id"`
+	OccurrenceTime time.Time `db:"occurrence_time" json:"occurrence_time"` // The nominal occurrence being delivered
+	Attempt        int       `db:"attempt" json:"attempt"`
+	Mode           string    `db:"mode" json:"mode"`     // "internal", "webhook" or "external"
+	Status         string    `db:"status" json:"status"` // "sent" or "failed"
+	Error          string    `db:"error" json:"error,omitempty"`
+	MessageID      string    `db:"message_id" json:"message_id,omitempty"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+// ReminderInstance is one delivered occurrence of a schedule and what the
+// learner did with it
+type ReminderInstance struct {
+	ID              int        `db:"id" json:"id"`
+	ScheduleID      int        `db:"schedule_id" json:"schedule_id"`
+	UserID          int        `db:"user_id" json:"user_id"`
+	ChatID          string     `db:"chat_id" json:"chat_id"`
+	Topic           string     `db:"topic" json:"topic"`
+	OccurrenceTime  time.Time  `db:"occurrence_time" json:"occurrence_time"`
+	State           string     `db:"state" json:"state"` // "sent", "acknowledged", "snoozed", "skipped" or "completed"
+	MessageID       string     `db:"message_id" json:"message_id,omitempty"`
+	SnoozedUntil    *time.Time `db:"snoozed_until" json:"snoozed_until,omitempty"`
+	SnoozeCount     int        `db:"snooze_count" json:"snooze_count"`
+	CompletedQuizID *int       `db:"completed_quiz_id" json:"completed_quiz_id,omitempty"`
+	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt       time.Time  `db:"updated_at" json:"updated_at"`
+}
 
+// CalendarFeed is a private iCalendar subscription URL for a user's reminders
+type CalendarFeed struct {
+	ID             int        `db:"id" json:"id"`
+	UserID         int        `db:"user_id" json:"user_id"`
+	Token          string     `db:"token" json:"token"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+	LastAccessedAt *time.Time `db:"last_accessed_at" json:"last_accessed_at,omitempty"`
+	RevokedAt      *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+}
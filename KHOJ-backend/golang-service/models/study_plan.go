@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// StudyPlan is a multi-week plan generated from the onboarding answers
+type StudyPlan struct {
+	ID               int        `db:"id" json:"id"`
+	UserID           int        `db:"user_id" json:"user_id"`
+	Status           string     `db:"status" json:"status"` // "active", "completed" or "archived"
+	Topics           string     `db:"topics" json:"topics"` // Comma-separated, in the order given
+	Weeks            int        `db:"weeks" json:"weeks"`
+	StartDate        time.Time  `db:"start_date" json:"start_date"`
+	EndDate          time.Time  `db:"end_date" json:"end_date"`
+	StudyDays        string     `db:"study_days" json:"study_days"`     // Comma-separated weekdays, 0=Sun
+	SessionTime      string     `db:"session_time" json:"session_time"` // "HH:MM" in the user's zone
+	SessionMinutes   int        `db:"session_minutes" json:"session_minutes"`
+	LearningStyles   string     `db:"learning_styles" json:"learning_styles"` // Onboarding priority, e.g. "Videos, Documents, Conversations with the AI"
+	RemindersEnabled bool       `db:"reminders_enabled" json:"reminders_enabled"`
+	FactsEnabled     bool       `db:"facts_enabled" json:"facts_enabled"`
+	ReplanCount      int        `db:"replan_count" json:"replan_count"`
+	ReplannedAt      *time.Time `db:"replanned_at" json:"replanned_at,omitempty"`
+	CreatedAt        time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt        time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// StudyPlanItem is one slot of a plan
+type StudyPlanItem struct {
+	ID              int        `db:"id" json:"id"`
+	PlanID          int        `db:"plan_id" json:"plan_id"`
+	UserID          int        `db:"user_id" json:"user_id"`
+	ChatID          string     `db:"chat_id" json:"chat_id"`
+	Topic           string     `db:"topic" json:"topic"`
+	Kind            string     `db:"kind" json:"kind"`         // "session", "review" or "quiz_checkpoint"
+	Activity        string     `db:"activity" json:"activity"` // What to do, following the learning style
+	Week            int        `db:"week" json:"week"`         // 1-based
+	ScheduledFor    time.Time  `db:"scheduled_for" json:"scheduled_for"`
+	DurationMinutes int        `db:"duration_minutes" json:"duration_minutes"`
+	Status          string     `db:"status" json:"status"` // "pending", "done", "missed" or "skipped"
+	ScheduleID      *int       `db:"schedule_id" json:"schedule_id,omitempty"`
+	ReplannedFrom   *int       `db:"replanned_from" json:"replanned_from,omitempty"`
+	QuizID          *int       `db:"quiz_id" json:"quiz_id,omitempty"`
+	CompletedAt     *time.Time `db:"completed_at" json:"completed_at,omitempty"`
+	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
+}
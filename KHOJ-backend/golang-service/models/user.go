@@ -6,6 +6,10 @@ type User struct{
   Email      string `db:"email"  json:"email" binding:"required"`
  Password     string `db:"password"  json:"password" binding:"required"`
  RefreshToken   string `db:"refresh_token"  json:"refresh_token"`
+ Role       string `db:"role" json:"role,omitempty"` // "learner" or "admin"
+ Timezone   string `db:"timezone" json:"timezone,omitempty"` // IANA name, e.g. "Asia/Kolkata"
+ LeaderboardOptOut bool `db:"leaderboard_opt_out" json:"leaderboard_opt_out"`
+ ReminderPreferences
  CreatedAt time.Time `json:"created_at,omitempty" db:"created_at"`
     UpdatedAt time.Time `json:"updated_at,omitempty" db:"updated_at"`
   }
@@ -20,4 +24,16 @@ type User struct{
 type AnswerPayload struct {
 	ID  int          `db:"user_id" json:"id"`
 	Answers []UserAnswer `db:"answers" json:"answers"`
-}
\ No newline at end of file
+	Timezone string `json:"timezone,omitempty"` // IANA zone detected by the browser during onboarding
+	Version int `json:"version,omitempty"` // Questionnaire version answered; 1 when omitted
+}
+
+// ReminderPreferences controls when reminders may reach the user. Times are
+// "HH:MM" in the user's time zone; empty means unset.
+type ReminderPreferences struct {
+	QuietHoursStart     string `db:"quiet_hours_start" json:"quiet_hours_start"`         // e.g. "22:00"
+	QuietHoursEnd       string `db:"quiet_hours_end" json:"quiet_hours_end"`             // e.g. "07:00"; may wrap past midnight
+	DNDDays             string `db:"dnd_days" json:"dnd_days"`                           // Comma-separated weekdays with no reminders: "0,6" (0=Sun)
+	PreferredHoursStart string `db:"preferred_hours_start" json:"preferred_hours_start"` // From onboarding; default reminder window
+	PreferredHoursEnd   string `db:"preferred_hours_end" json:"preferred_hours_end"`
+}
@@ -15,21 +15,178 @@ func RegisterRoutes(r *gin.Engine) {
 			chat.POST("/send", handlers.SendMessage)
 			// More specific route must come before the general one
 			chat.GET("/user/:user_id", handlers.GetUserChats)
+			chat.POST("/messages/:id/feedback", handlers.RateMessage)
+			chat.DELETE("/messages/:id/feedback", handlers.ClearMessageRating)
+			chat.POST("/messages/:id/regenerate", handlers.RegenerateMessage)
+			chat.GET("/messages/:id/versions", handlers.GetMessageVersions)
+			chat.POST("/messages/:id/select", handlers.SelectMessageVersion)
+			chat.POST("/messages/:id/edit", handlers.EditMessage)
+			chat.GET("/:id/branches", handlers.GetChatBranches)
+			chat.POST("/:id/branches/switch", handlers.SwitchChatBranch)
+			chat.GET("/:id/export", handlers.ExportChat)
+			chat.GET("/:id/notes", handlers.GetChatNotes)
+			chat.PATCH("/:id", handlers.RenameChat)
 			chat.DELETE("/:id", handlers.DeleteChat)
 			chat.GET("/:id", handlers.GetChatHistory)
 		}
 
 		// Schedule endpoints
 		api.POST("/schedule", handlers.CreateSchedule)
+		api.POST("/schedule/preview", handlers.PreviewSchedule)
 		api.GET("/schedule/:user_id", handlers.GetUserSchedules)
 		api.GET("/schedule/due", handlers.GetDueSchedules) // For n8n/cron
+		api.GET("/schedule/deliveries/:id", handlers.GetScheduleDeliveries)
+		api.PATCH("/schedule/:id", handlers.UpdateSchedule)
+		api.POST("/schedule/:id/skip", handlers.SkipScheduleOccurrence)
 		api.DELETE("/schedule/:id", handlers.CancelSchedule)
 
+		// Delivered reminders and the learner's actions on them
+		api.GET("/reminders/user/:user_id", handlers.GetUserReminders)
+		api.POST("/reminders/:id/ack", handlers.AcknowledgeReminder)
+		api.POST("/reminders/:id/snooze", handlers.SnoozeReminder)
+		api.POST("/reminders/:id/skip", handlers.SkipReminder)
+
 		// Quiz endpoints (specific routes first)
 		api.POST("/quiz/reminder", handlers.TriggerQuizReminder) // Webhook for n8n
 		api.POST("/quiz/start", handlers.StartQuiz)
 		api.POST("/quiz/answer", handlers.SubmitQuizAnswer)
 		api.POST("/quiz/submit", handlers.SubmitCompleteQuiz)
+		api.POST("/quiz/question/:id/flag", handlers.FlagQuizQuestion)
+		api.POST("/quiz/import", handlers.ImportQuiz)
+		api.POST("/quiz/:id/review", handlers.ReviewQuiz)
+		api.GET("/quiz/:id/export", handlers.ExportQuiz)
 		api.GET("/quiz/:id", handlers.GetQuiz) // Must come after specific routes
+
+		// Question bank curation (admin only)
+		bank := api.Group("/bank")
+		{
+			bank.GET("/questions", handlers.ListBankQuestions)
+			bank.POST("/questions", handlers.CreateBankQuestion)
+			bank.PATCH("/questions/:id", handlers.UpdateBankQuestion)
+			bank.POST("/questions/:id/review", handlers.ReviewBankQuestion)
+			bank.GET("/questions/:id/flags", handlers.GetBankQuestionFlags)
+			bank.GET("/export", handlers.ExportBank)
+			bank.POST("/import", handlers.ImportBank)
+		}
+
+		// Learner progress analytics
+		analytics := api.Group("/analytics/:user_id")
+		{
+			analytics.GET("/activity", handlers.GetActivityHeatmap)
+			analytics.GET("/accuracy", handlers.GetAccuracyTrends)
+			analytics.GET("/streaks", handlers.GetStreaks)
+			analytics.GET("/time-spent", handlers.GetTimeSpent)
+			analytics.GET("/weak-subtopics", handlers.GetWeakSubtopics)
+			analytics.GET("/scores", handlers.GetScoreHistory)
+		}
+
+		// Gamification
+		api.GET("/achievements/:user_id", handlers.GetAchievements)
+		api.PUT("/user/:user_id/timezone", handlers.UpdateUserTimezone)
+
+		// Onboarding questionnaire and the learner profile built from it
+		api.GET("/onboarding/questionnaire", handlers.GetOnboardingQuestionnaire)
+		api.GET("/user/:user_id/profile", handlers.GetLearnerProfile)
+		api.PATCH("/user/:user_id/profile", handlers.UpdateLearnerProfile)
+
+		// Full-text and semantic search over a learner's chats and quizzes
+		api.GET("/user/:user_id/search", handlers.SearchUserContent)
+
+		// "Download all my data": zips built in the background
+		api.POST("/user/:user_id/export", handlers.RequestDataExport)
+		api.GET("/user/:user_id/exports", handlers.GetDataExports)
+		api.GET("/user/:user_id/exports/:id", handlers.GetDataExport)
+		api.GET("/user/:user_id/exports/:id/download", handlers.DownloadDataExport)
+
+		// Tutor persona and the versioned prompt templates behind it
+		api.GET("/tutor-styles", handlers.GetTutorStyles)
+		api.GET("/user/:user_id/tutor-prompt", handlers.GetTutorPrompt)
+		api.GET("/admin/prompts", handlers.ListPromptTemplates)
+		api.POST("/admin/prompts", handlers.CreatePromptTemplate)
+		api.POST("/admin/prompts/:id/activate", handlers.ActivatePromptTemplate)
+		api.PATCH("/admin/prompts/:id/settings", handlers.UpdatePromptTemplateSettings)
+		api.GET("/admin/prompt-metrics", handlers.GetPromptMetrics)
+		api.GET("/admin/experiments", handlers.ListPromptExperiments)
+		api.POST("/admin/experiments", handlers.CreatePromptExperiment)
+		api.POST("/admin/experiments/:id/stop", handlers.StopPromptExperiment)
+		api.GET("/admin/experiments/:id/results", handlers.GetPromptExperimentResults)
+
+		// Ratings of tutor replies
+		api.GET("/admin/feedback/report", handlers.GetFeedbackReport)
+		api.GET("/admin/feedback/low-rated", handlers.GetLowRatedReplies)
+
+		// Reminder quiet hours and preferred hours
+		api.GET("/user/:user_id/reminder-preferences", handlers.GetReminderPreferences)
+		api.PUT("/user/:user_id/reminder-preferences", handlers.UpdateReminderPreferences)
+
+		// Leaderboards and study groups
+		api.GET("/leaderboard", handlers.GetLeaderboard)
+		api.PUT("/user/:user_id/leaderboard-privacy", handlers.UpdateLeaderboardPrivacy)
+		api.POST("/groups", handlers.CreateGroup)
+		api.POST("/groups/join", handlers.JoinGroup)
+		api.GET("/groups/user/:user_id", handlers.GetUserGroups)
+		api.DELETE("/groups/:id/members/:user_id", handlers.LeaveGroup)
+
+		// Notification channels (in-app, email, Web Push, webhooks)
+		notifications := api.Group("/notifications")
+		{
+			notifications.GET("/vapid-public-key", handlers.GetVAPIDPublicKey)
+			notifications.POST("/push-subscriptions", handlers.SavePushSubscription)
+			notifications.DELETE("/push-subscriptions", handlers.DeletePushSubscription)
+			notifications.POST("/test", handlers.SendTestNotification)
+			notifications.GET("/:user_id/preferences", handlers.GetNotificationPreferences)
+			notifications.PUT("/:user_id/preferences", handlers.UpdateNotificationPreferences)
+			notifications.GET("/:user_id/deliveries", handlers.GetNotificationDeliveries)
+		}
+
+		// Calendar export: private ICS feed per user and single-schedule downloads
+		calendar := api.Group("/calendar")
+		{
+			calendar.GET("/feed/:token", handlers.ServeCalendarFeed) // token.ics, polled by calendar apps
+			calendar.GET("/schedule/:id", handlers.DownloadScheduleICS)
+			calendar.GET("/:user_id/feed", handlers.GetCalendarFeed)
+			calendar.POST("/:user_id/feed", handlers.CreateCalendarFeed)
+			calendar.DELETE("/:user_id/feed", handlers.RevokeCalendarFeed)
+		}
+
+		// Study plans generated from the onboarding answers
+		plans := api.Group("/study-plans")
+		{
+			plans.POST("", handlers.CreateStudyPlan)
+			plans.GET("/user/:id", handlers.GetUserStudyPlan)
+			plans.POST("/items/:id/complete", handlers.CompleteStudyPlanItem)
+			plans.GET("/:id", handlers.GetStudyPlan)
+			plans.POST("/:id/replan", handlers.ReplanStudyPlan)
+			plans.DELETE("/:id", handlers.ArchiveStudyPlan)
+		}
+
+		// Flashcard decks per topic, reviewed with spaced repetition
+		flashcards := api.Group("/flashcards")
+		{
+			flashcards.POST("/generate", handlers.GenerateFlashcards)
+			flashcards.GET("/decks", handlers.GetFlashcardDecks)
+			flashcards.GET("/decks/:id", handlers.GetFlashcardDeck)
+			flashcards.DELETE("/decks/:id", handlers.DeleteFlashcardDeck)
+			flashcards.GET("/due", handlers.GetDueFlashcards)
+			flashcards.POST("/cards", handlers.CreateFlashcard)
+			flashcards.PATCH("/cards/:id", handlers.UpdateFlashcard)
+			flashcards.DELETE("/cards/:id", handlers.DeleteFlashcard)
+			flashcards.POST("/cards/:id/review", handlers.ReviewFlashcard)
+		}
+
+		// Daily topic facts for learners who opted in during onboarding
+		facts := api.Group("/facts")
+		{
+			facts.GET("/user/:user_id", handlers.GetUserFacts)
+			facts.POST("/user/:user_id/mutes", handlers.MuteFactTopic)
+			facts.DELETE("/user/:user_id/mutes", handlers.UnmuteFactTopic)
+			facts.POST("/:id/rate", handlers.RateFact)
+			facts.POST("/:id/mute", handlers.MuteFact)
+		}
+
+		// Local notification sinks, only active with NOTIFY_DEV_SINKS=true
+		api.POST("/dev/push-sink/:id", handlers.DevPushSink)
+		api.GET("/dev/sinks", handlers.GetDevSinks)
+		api.DELETE("/dev/sinks", handlers.ClearDevSinks)
 	}
 }
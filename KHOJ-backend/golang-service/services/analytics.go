@@ -0,0 +1,449 @@
+package services
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+)
+
+// Buckets supported by the analytics queries; they map straight onto
+// Postgres date_trunc fields
+const (
+	BucketDay   = "day"
+	BucketWeek  = "week"
+	BucketMonth = "month"
+)
+
+// sessionGap is the idle time after which activity counts as a new study session
+const sessionGap = 30 * time.Minute
+
+// minSessionLength is credited for a session with a single event (one message)
+const minSessionLength = time.Minute
+
+// AnalyticsRange is the window an analytics query covers. From is inclusive,
+// To exclusive, and buckets and calendar days are computed in Location.
+type AnalyticsRange struct {
+	From     time.Time
+	To       time.Time
+	Location *time.Location
+	Bucket   string
+}
+
+// ValidBucket reports whether b can be used as an AnalyticsRange bucket
+func ValidBucket(b string) bool {
+	return b == BucketDay || b == BucketWeek || b == BucketMonth
+}
+
+// bucketStart truncates a local date to the start of its bucket, matching
+// Postgres date_trunc (weeks start on Monday)
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch bucket {
+	case BucketWeek:
+		offset := (int(t.Weekday()) + 6) % 7
+		return t.AddDate(0, 0, -offset)
+	case BucketMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return t
+}
+
+func nextBucket(t time.Time, bucket string) time.Time {
+	switch bucket {
+	case BucketWeek:
+		return t.AddDate(0, 0, 7)
+	case BucketMonth:
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// ActivityBucket is one cell of the activity heatmap
+type ActivityBucket struct {
+	Date        string `json:"date"` // First day of the bucket, YYYY-MM-DD in the requested zone
+	Messages    int    `json:"messages"`
+	QuizAnswers int    `json:"quiz_answers"`
+	Count       int    `json:"count"`
+	Level       int    `json:"level"` // 0-4 intensity relative to the busiest bucket
+}
+
+// ActivityHeatmap counts messages sent and quiz questions answered per bucket.
+// Every bucket in the range is present, including empty ones.
+func ActivityHeatmap(userID int, r AnalyticsRange) ([]ActivityBucket, error) {
+	var rows []struct {
+		Bucket time.Time `db:"bucket"`
+		Kind   string    `db:"kind"`
+		N      int       `db:"n"`
+	}
+	err := config.DB.Select(&rows, `
+		WITH events AS (
+			SELECT m.created_at AS at, 'message' AS kind, 1 AS n
+			FROM messages m JOIN chats c ON c.id = m.chat_id
+			WHERE c.user_id=$1 AND m.role='user' AND m.created_at >= $2 AND m.created_at < $3
+			UNION ALL
+			SELECT q.completed_at, 'quiz', COUNT(qq.id)::int
+			FROM quizzes q JOIN quiz_questions qq ON qq.quiz_id = q.id
+			WHERE q.user_id=$1 AND q.status='completed' AND q.completed_at >= $2 AND q.completed_at < $3
+			AND COALESCE(qq.user_answer, '') <> ''
+			GROUP BY q.id, q.completed_at
+		)
+		SELECT date_trunc($4, at AT TIME ZONE $5)::date AS bucket, kind, SUM(n)::int AS n
+		FROM events
+		GROUP BY 1, 2
+		ORDER BY 1
+	`, userID, r.From, r.To, r.Bucket, r.Location.String())
+	if err != nil {
+		return nil, err
+	}
+
+	byDate := map[string]*ActivityBucket{}
+	var buckets []ActivityBucket
+	end := r.To.In(r.Location).Add(-time.Nanosecond)
+	for d := bucketStart(r.From.In(r.Location), r.Bucket); !d.After(end); d = nextBucket(d, r.Bucket) {
+		buckets = append(buckets, ActivityBucket{Date: d.Format("2006-01-02")})
+	}
+	for i := range buckets {
+		byDate[buckets[i].Date] = &buckets[i]
+	}
+	for _, row := range rows {
+		b, ok := byDate[row.Bucket.Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		if row.Kind == "message" {
+			b.Messages += row.N
+		} else {
+			b.QuizAnswers += row.N
+		}
+		b.Count += row.N
+	}
+
+	max := 0
+	for _, b := range buckets {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	for i := range buckets {
+		if buckets[i].Count > 0 {
+			// 1..4 in quarters of the busiest bucket
+			buckets[i].Level = 1 + (buckets[i].Count*4-1)/max
+			if buckets[i].Level > 4 {
+				buckets[i].Level = 4
+			}
+		}
+	}
+	return buckets, nil
+}
+
+// AccuracyPoint is the quiz accuracy for one topic in one bucket
+type AccuracyPoint struct {
+	Date     string  `json:"date"`
+	Correct  int     `json:"correct"`
+	Answered int     `json:"answered"`
+	Quizzes  int     `json:"quizzes"`
+	Accuracy float64 `json:"accuracy"` // Percentage 0-100
+}
+
+// TopicAccuracy is the accuracy trend of one canonical topic
+type TopicAccuracy struct {
+	Topic    string          `json:"topic"`
+	Correct  int             `json:"correct"`
+	Answered int             `json:"answered"`
+	Accuracy float64         `json:"accuracy"`
+	Trend    []AccuracyPoint `json:"trend"`
+}
+
+// AccuracyTrends returns per-topic accuracy of completed quizzes per bucket.
+// When topic is non-empty only that canonical topic is returned.
+func AccuracyTrends(userID int, r AnalyticsRange, topic string) ([]TopicAccuracy, error) {
+	var rows []struct {
+		Topic    string    `db:"topic"`
+		Bucket   time.Time `db:"bucket"`
+		Correct  int       `db:"correct"`
+		Answered int       `db:"answered"`
+		Quizzes  int       `db:"quizzes"`
+	}
+	err := config.DB.Select(&rows, `
+		SELECT q.topic,
+			date_trunc($4, q.completed_at AT TIME ZONE $5)::date AS bucket,
+			COUNT(*) FILTER (WHERE qq.is_correct)::int AS correct,
+			COUNT(*)::int AS answered,
+			COUNT(DISTINCT q.id)::int AS quizzes
+		FROM quizzes q JOIN quiz_questions qq ON qq.quiz_id = q.id
+		WHERE q.user_id=$1 AND q.status='completed' AND q.completed_at >= $2 AND q.completed_at < $3
+		AND COALESCE(qq.user_answer, '') <> ''
+		GROUP BY 1, 2
+		ORDER BY 2
+	`, userID, r.From, r.To, r.Bucket, r.Location.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// Different spellings of a topic are merged under their canonical key
+	byTopic := map[string]*TopicAccuracy{}
+	points := map[string]map[string]*AccuracyPoint{}
+	var order []string
+	for _, row := range rows {
+		key := CanonicalTopic(row.Topic)
+		if topic != "" && key != topic {
+			continue
+		}
+		t, ok := byTopic[key]
+		if !ok {
+			t = &TopicAccuracy{Topic: key}
+			byTopic[key] = t
+			points[key] = map[string]*AccuracyPoint{}
+			order = append(order, key)
+		}
+		date := row.Bucket.Format("2006-01-02")
+		p, ok := points[key][date]
+		if !ok {
+			t.Trend = append(t.Trend, AccuracyPoint{Date: date})
+			p = &t.Trend[len(t.Trend)-1]
+			points[key][date] = p
+		}
+		p.Correct += row.Correct
+		p.Answered += row.Answered
+		p.Quizzes += row.Quizzes
+		t.Correct += row.Correct
+		t.Answered += row.Answered
+	}
+
+	result := make([]TopicAccuracy, 0, len(order))
+	for _, key := range order {
+		t := byTopic[key]
+		t.Accuracy = percentage(t.Correct, t.Answered)
+		for i := range t.Trend {
+			t.Trend[i].Accuracy = percentage(t.Trend[i].Correct, t.Trend[i].Answered)
+		}
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Answered > result[j].Answered })
+	return result, nil
+}
+
+// Streak summarizes consecutive active days in the user's zone
+type Streak struct {
+	Current        int    `json:"current"`
+	Longest        int    `json:"longest"`
+	LastActiveDate string `json:"last_active_date,omitempty"`
+	ActiveDays     int    `json:"active_days"`
+}
+
+// ActiveDates returns the distinct local dates (YYYY-MM-DD, ascending) on
+// which the user sent a message, completed a quiz or reviewed a flashcard
+// since the given instant. These are the events that advance the streak.
+func ActiveDates(userID int, since time.Time, loc *time.Location) ([]string, error) {
+	var days []time.Time
+	err := config.DB.Select(&days, `
+		SELECT DISTINCT (at AT TIME ZONE $3)::date AS day FROM (
+			SELECT m.created_at AS at
+			FROM messages m JOIN chats c ON c.id = m.chat_id
+			WHERE c.user_id=$1 AND m.role='user' AND m.created_at >= $2
+			UNION ALL
+			SELECT completed_at FROM quizzes
+			WHERE user_id=$1 AND status='completed' AND completed_at >= $2
+			UNION ALL
+			SELECT reviewed_at FROM flashcard_reviews
+			WHERE user_id=$1 AND reviewed_at >= $2
+		) events
+		ORDER BY day
+	`, userID, since, loc.String())
+	if err != nil {
+		return nil, err
+	}
+	dates := make([]string, len(days))
+	for i, d := range days {
+		dates[i] = d.Format("2006-01-02")
+	}
+	return dates, nil
+}
+
+// UserStreak returns the learner's streak as the achievements summary reports
+// it: the gamification counters, with freeze tokens covering missed days.
+// Days are counted in the learner's own zone.
+func UserStreak(userID int) (Streak, error) {
+	loc := UserLocation(userID)
+	g := models.UserGamification{UserID: userID}
+	err := config.DB.Get(&g, `SELECT * FROM user_gamification WHERE user_id=$1`, userID)
+	if err != nil && err != sql.ErrNoRows {
+		return Streak{}, err
+	}
+	dates, err := ActiveDates(userID, time.Time{}, loc)
+	if err != nil {
+		return Streak{}, err
+	}
+
+	s := Streak{
+		Current:    EffectiveStreak(g, localDate(time.Now(), loc)),
+		Longest:    g.LongestStreak,
+		ActiveDays: len(dates),
+	}
+	if g.LastActiveDate != nil {
+		s.LastActiveDate = g.LastActiveDate.Format("2006-01-02")
+	}
+	return s, nil
+}
+
+// TimeSpent is study time estimated from sessions of activity
+type TimeSpent struct {
+	TotalMinutes int              `json:"total_minutes"`
+	Sessions     int              `json:"sessions"`
+	Buckets      []TimeSpentPoint `json:"buckets"`
+}
+
+// TimeSpentPoint is the study time attributed to one bucket
+type TimeSpentPoint struct {
+	Date     string `json:"date"`
+	Minutes  int    `json:"minutes"`
+	Sessions int    `json:"sessions"`
+}
+
+// EstimateTimeSpent groups the user's messages and quiz activity into
+// sessions separated by more than sessionGap of inactivity. A session lasts
+// from its first to its last event (at least minSessionLength) and is
+// attributed to the bucket it started in.
+func EstimateTimeSpent(userID int, r AnalyticsRange) (TimeSpent, error) {
+	var rows []struct {
+		Bucket  time.Time `db:"bucket"`
+		Seconds float64   `db:"seconds"`
+	}
+	err := config.DB.Select(&rows, `
+		WITH events AS (
+			SELECT m.created_at AS at
+			FROM messages m JOIN chats c ON c.id = m.chat_id
+			WHERE c.user_id=$1 AND m.created_at >= $2 AND m.created_at < $3
+			UNION ALL
+			SELECT created_at FROM quizzes WHERE user_id=$1 AND created_at >= $2 AND created_at < $3
+			UNION ALL
+			SELECT completed_at FROM quizzes WHERE user_id=$1 AND completed_at >= $2 AND completed_at < $3
+		),
+		marked AS (
+			SELECT at, CASE
+				WHEN LAG(at) OVER (ORDER BY at) IS NULL OR at - LAG(at) OVER (ORDER BY at) > make_interval(secs => $6) THEN 1
+				ELSE 0 END AS starts_session
+			FROM events
+		),
+		numbered AS (
+			SELECT at, SUM(starts_session) OVER (ORDER BY at) AS session FROM marked
+		)
+		SELECT date_trunc($4, MIN(at) AT TIME ZONE $5)::date AS bucket,
+			EXTRACT(EPOCH FROM MAX(at) - MIN(at))::float8 AS seconds
+		FROM numbered
+		GROUP BY session
+		ORDER BY 1
+	`, userID, r.From, r.To, r.Bucket, r.Location.String(), sessionGap.Seconds())
+	if err != nil {
+		return TimeSpent{}, err
+	}
+
+	out := TimeSpent{Buckets: []TimeSpentPoint{}}
+	index := map[string]int{}
+	total := time.Duration(0)
+	for _, row := range rows {
+		d := time.Duration(row.Seconds * float64(time.Second))
+		if d < minSessionLength {
+			d = minSessionLength
+		}
+		total += d
+		out.Sessions++
+		date := row.Bucket.Format("2006-01-02")
+		i, ok := index[date]
+		if !ok {
+			out.Buckets = append(out.Buckets, TimeSpentPoint{Date: date})
+			i = len(out.Buckets) - 1
+			index[date] = i
+		}
+		out.Buckets[i].Sessions++
+		out.Buckets[i].Minutes += int(d.Round(time.Minute) / time.Minute)
+	}
+	out.TotalMinutes = int(total.Round(time.Minute) / time.Minute)
+	return out, nil
+}
+
+// WeakSubtopic is a subtopic ranked by how often the learner gets it wrong
+type WeakSubtopic struct {
+	Subtopic string  `db:"subtopic" json:"subtopic"`
+	Topic    string  `db:"topic" json:"topic"`
+	Correct  int     `db:"correct" json:"correct"`
+	Answered int     `db:"answered" json:"answered"`
+	Accuracy float64 `db:"-" json:"accuracy"`
+}
+
+// WeakestSubtopics ranks subtopics by accuracy, lowest first. Questions drawn
+// from the bank carry their own subtopic; others fall back to the quiz topic.
+// Subtopics with fewer than minAnswered answers are left out as too noisy.
+func WeakestSubtopics(userID int, r AnalyticsRange, minAnswered int, limit int) ([]WeakSubtopic, error) {
+	var rows []WeakSubtopic
+	err := config.DB.Select(&rows, `
+		SELECT COALESCE(NULLIF(bq.subtopic, ''), q.topic) AS subtopic,
+			q.topic,
+			COUNT(*) FILTER (WHERE qq.is_correct)::int AS correct,
+			COUNT(*)::int AS answered
+		FROM quiz_questions qq
+		JOIN quizzes q ON q.id = qq.quiz_id
+		LEFT JOIN bank_questions bq ON bq.id = qq.bank_question_id
+		WHERE q.user_id=$1 AND q.created_at >= $2 AND q.created_at < $3
+		AND COALESCE(qq.user_answer, '') <> ''
+		GROUP BY 1, 2
+		HAVING COUNT(*) >= $4
+		ORDER BY COUNT(*) FILTER (WHERE qq.is_correct)::float / COUNT(*) ASC, COUNT(*) DESC
+		LIMIT $5
+	`, userID, r.From, r.To, minAnswered, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].Accuracy = percentage(rows[i].Correct, rows[i].Answered)
+	}
+	if rows == nil {
+		rows = []WeakSubtopic{}
+	}
+	return rows, nil
+}
+
+// QuizScore is one completed quiz in the score history
+type QuizScore struct {
+	QuizID      int       `db:"id" json:"quiz_id"`
+	Topic       string    `db:"topic" json:"topic"`
+	Score       int       `db:"score" json:"score"`
+	Total       int       `db:"total_questions" json:"total_questions"`
+	Percentage  float64   `db:"-" json:"percentage"`
+	CompletedAt time.Time `db:"completed_at" json:"completed_at"`
+}
+
+// ScoreHistory lists completed quizzes in the range, oldest first, with
+// completion times expressed in the range's zone
+func ScoreHistory(userID int, r AnalyticsRange) ([]QuizScore, error) {
+	var rows []QuizScore
+	err := config.DB.Select(&rows, `
+		SELECT id, topic, COALESCE(score, 0) AS score, total_questions, completed_at
+		FROM quizzes
+		WHERE user_id=$1 AND status='completed' AND completed_at >= $2 AND completed_at < $3
+		ORDER BY completed_at ASC
+	`, userID, r.From, r.To)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].Percentage = percentage(rows[i].Score, rows[i].Total)
+		rows[i].CompletedAt = rows[i].CompletedAt.In(r.Location)
+	}
+	if rows == nil {
+		rows = []QuizScore{}
+	}
+	return rows, nil
+}
+
+// percentage returns part/whole as a percentage rounded to one decimal
+func percentage(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return math.Round(float64(part)*1000/float64(whole)) / 10
+}
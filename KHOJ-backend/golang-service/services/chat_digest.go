@@ -0,0 +1,296 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+)
+
+const (
+	// chatTitleTurns is how many learner messages a chat needs before it is
+	// named
+	chatTitleTurns = 2
+	// chatSummaryEvery is how many new messages roll the summary forward
+	chatSummaryEvery = 6
+	// chatDigestMessageLimit caps how much of the chat goes into a title or
+	// summary prompt
+	chatDigestMessageLimit = 60
+	// studyNotesMessageLimit caps how much of the chat goes into study notes
+	studyNotesMessageLimit = 200
+	// ChatTitleMaxLen is the longest title, generated or set by the learner
+	ChatTitleMaxLen = 80
+)
+
+// ErrNothingToNote is returned for study notes on a chat with no discussion
+var ErrNothingToNote = errors.New("this chat has nothing to take notes from yet")
+
+// NoteDefinition is a term explained in study notes
+type NoteDefinition struct {
+	Term       string `json:"term"`
+	Definition string `json:"definition"`
+}
+
+// StudyNotes condense a chat into points to revise from
+type StudyNotes struct {
+	KeyPoints   []string         `json:"key_points"`
+	Definitions []NoteDefinition `json:"definitions"`
+}
+
+// ChatName is how a chat is labelled: its title, or its topic until it has one
+func ChatName(chat models.Chat) string {
+	if chat.Title != "" {
+		return chat.Title
+	}
+	return chat.Topic
+}
+
+// ChatTranscript writes the discussion as "role: content" lines for a
+// prompt. Commands, reminders and daily facts are skipped; they aren't part
+// of it.
+func ChatTranscript(topic string, messages []models.Message) string {
+	var b strings.Builder
+	for _, m := range discussion(topic, messages) {
+		fmt.Fprintf(&b, "%s: %s\n", m.Role, m.Content)
+	}
+	return b.String()
+}
+
+// discussion drops commands, reminders and daily facts from a chat's messages
+func discussion(topic string, messages []models.Message) []models.Message {
+	reminder := ReminderMessage(topic)
+	out := make([]models.Message, 0, len(messages))
+	for _, m := range messages {
+		if strings.HasPrefix(strings.TrimSpace(m.Content), "/") || m.Content == reminder || strings.HasPrefix(m.Content, FactMessagePrefix) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// chatDigestRunning holds the chats being refreshed, so a burst of messages
+// doesn't generate the same title or summary several times
+var chatDigestRunning sync.Map
+
+// RefreshChatDigestAsync runs RefreshChatDigest in the background after a
+// reply, skipping chats that are already being refreshed
+func RefreshChatDigestAsync(apiKey string, preferredModel string, chatID string) {
+	if _, busy := chatDigestRunning.LoadOrStore(chatID, true); busy {
+		return
+	}
+	go func() {
+		defer chatDigestRunning.Delete(chatID)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		if err := RefreshChatDigest(ctx, apiKey, preferredModel, chatID); err != nil {
+			fmt.Printf("Warning: failed to update title and summary of chat %s: %v\n", chatID, err)
+		}
+	}()
+}
+
+// RefreshChatDigest names a chat once the learner has sent chatTitleTurns
+// messages, unless they named it themselves, and rolls its summary forward
+// once chatSummaryEvery messages have been added since the last one. If the
+// last summarized message is no longer on the active branch (the learner
+// edited a message or switched branches) the summary is started again.
+func RefreshChatDigest(ctx context.Context, apiKey string, preferredModel string, chatID string) error {
+	var chat models.Chat
+	if err := config.DB.Get(&chat, "SELECT * FROM chats WHERE id=$1", chatID); err != nil {
+		return err
+	}
+	messages, err := ActivePath(chat.ID, 0)
+	if err != nil {
+		return err
+	}
+	messages = discussion(chat.Topic, messages)
+
+	// A chat that fails to get a title still gets its summary rolled
+	if chat.Title == "" && !chat.TitleByUser && learnerTurns(messages) >= chatTitleTurns {
+		if err := generateChatTitle(ctx, apiKey, preferredModel, chat, messages); err != nil {
+			fmt.Printf("Warning: failed to name chat %s: %v\n", chat.ID, err)
+		}
+	}
+
+	start, previous := 0, chat.Summary
+	if chat.SummaryThroughID != nil {
+		start = -1
+		for i, m := range messages {
+			if m.ID == *chat.SummaryThroughID {
+				start = i + 1
+			}
+		}
+		if start < 0 {
+			start, previous = 0, ""
+		}
+	}
+	if len(messages)-start < chatSummaryEvery {
+		return nil
+	}
+	unsummarized := messages[start:]
+	if len(unsummarized) > chatDigestMessageLimit {
+		unsummarized = unsummarized[len(unsummarized)-chatDigestMessageLimit:]
+	}
+	prompt, err := RenderPrompt(PromptChatDigest, chat.UserID, DigestPromptData{
+		Topic: chat.Topic, PreviousSummary: previous, Transcript: ChatTranscript(chat.Topic, unsummarized),
+	})
+	if err != nil {
+		return err
+	}
+	summary, model, err := GenerateGeminiText(ctx, apiKey, preferredModel, prompt)
+	if err != nil {
+		return fmt.Errorf("summary: %w", err)
+	}
+	LogPromptUsage(prompt, chat.UserID, model, PromptSourceChatDigest, chat.ID)
+	// Only if nobody summarized the chat in the meantime
+	_, err = config.DB.Exec(`
+		UPDATE chats SET summary=$2, summary_through_id=$3, updated_at=NOW()
+		WHERE id=$1 AND summary_through_id IS NOT DISTINCT FROM $4
+	`, chat.ID, strings.TrimSpace(summary), messages[len(messages)-1].ID, chat.SummaryThroughID)
+	return err
+}
+
+// learnerTurns counts the learner's messages
+func learnerTurns(messages []models.Message) int {
+	n := 0
+	for _, m := range messages {
+		if m.Role == "user" {
+			n++
+		}
+	}
+	return n
+}
+
+// generateChatTitle names a chat from its opening turns
+func generateChatTitle(ctx context.Context, apiKey string, preferredModel string, chat models.Chat, messages []models.Message) error {
+	if len(messages) > chatDigestMessageLimit {
+		messages = messages[:chatDigestMessageLimit]
+	}
+	prompt, err := RenderPrompt(PromptChatTitle, chat.UserID, SummaryPromptData{Topic: chat.Topic, Transcript: ChatTranscript(chat.Topic, messages)})
+	if err != nil {
+		return err
+	}
+	out, model, err := GenerateGeminiText(ctx, apiKey, preferredModel, prompt)
+	if err != nil {
+		return err
+	}
+	usageID := LogPromptUsage(prompt, chat.UserID, model, PromptSourceChatTitle, chat.ID)
+	title := CleanChatTitle(out)
+	if title == "" {
+		RecordPromptOutcome(usageID, MetricValidationFailure, 1)
+		return fmt.Errorf("empty title generated")
+	}
+	// The learner may have named the chat while the title was generated
+	_, err = config.DB.Exec("UPDATE chats SET title=$2, updated_at=NOW() WHERE id=$1 AND title='' AND NOT title_by_user", chat.ID, title)
+	return err
+}
+
+// CleanChatTitle tidies a title to one line of at most ChatTitleMaxLen
+// characters, without the quotes or label a model may wrap it in
+func CleanChatTitle(title string) string {
+	title = strings.TrimSpace(title)
+	if i := strings.IndexByte(title, '\n'); i >= 0 {
+		title = title[:i]
+	}
+	const wrapping, label = "\"'*#`. ", "title:"
+	title = strings.Trim(title, wrapping)
+	if len(title) >= len(label) && strings.EqualFold(title[:len(label)], label) {
+		title = title[len(label):]
+	}
+	title = strings.Trim(title, wrapping)
+	if r := []rune(title); len(r) > ChatTitleMaxLen {
+		title = strings.TrimSpace(string(r[:ChatTitleMaxLen]))
+	}
+	return title
+}
+
+// ChatStudyNotes returns study notes for a chat's active branch. Notes are
+// kept until the branch changes; refresh generates them again regardless.
+func ChatStudyNotes(ctx context.Context, apiKey string, preferredModel string, chat models.Chat, refresh bool) (StudyNotes, models.ChatNotes, error) {
+	var notes StudyNotes
+	var stored models.ChatNotes
+	messages, err := ActivePath(chat.ID, studyNotesMessageLimit)
+	if err != nil {
+		return notes, stored, err
+	}
+	transcript := ChatTranscript(chat.Topic, messages)
+	if transcript == "" {
+		return notes, stored, ErrNothingToNote
+	}
+	leafID := messages[len(messages)-1].ID
+
+	if !refresh {
+		err := config.DB.Get(&stored, "SELECT * FROM chat_notes WHERE chat_id=$1 AND leaf_id=$2", chat.ID, leafID)
+		if err == nil && json.Unmarshal([]byte(stored.Notes), &notes) == nil {
+			return notes, stored, nil
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return notes, stored, err
+		}
+	}
+
+	prompt, err := RenderPrompt(PromptStudyNotes, chat.UserID, SummaryPromptData{Topic: chat.Topic, Transcript: transcript})
+	if err != nil {
+		return notes, stored, err
+	}
+	out, model, err := GenerateGeminiText(ctx, apiKey, preferredModel, prompt)
+	if err != nil {
+		return notes, stored, err
+	}
+	usageID := LogPromptUsage(prompt, chat.UserID, model, PromptSourceStudyNotes, chat.ID)
+	if notes, err = parseStudyNotes(out); err != nil {
+		RecordPromptOutcome(usageID, MetricValidationFailure, 1)
+		return notes, stored, err
+	}
+
+	encoded, err := json.Marshal(notes)
+	if err != nil {
+		return notes, stored, err
+	}
+	err = config.DB.Get(&stored, `
+		INSERT INTO chat_notes (chat_id, leaf_id, notes, model, created_at) VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (chat_id) DO UPDATE SET leaf_id=EXCLUDED.leaf_id, notes=EXCLUDED.notes, model=EXCLUDED.model, created_at=EXCLUDED.created_at
+		RETURNING *
+	`, chat.ID, leafID, string(encoded), model)
+	return notes, stored, err
+}
+
+// parseStudyNotes reads the JSON object the study notes prompt asks for,
+// dropping empty entries
+func parseStudyNotes(out string) (StudyNotes, error) {
+	var raw StudyNotes
+	if err := json.Unmarshal([]byte(trimCodeFence(out)), &raw); err != nil {
+		return raw, fmt.Errorf("failed to parse study notes: %w", err)
+	}
+	notes := StudyNotes{KeyPoints: []string{}, Definitions: []NoteDefinition{}}
+	for _, p := range raw.KeyPoints {
+		if p = strings.TrimSpace(p); p != "" {
+			notes.KeyPoints = append(notes.KeyPoints, p)
+		}
+	}
+	for _, d := range raw.Definitions {
+		d.Term, d.Definition = strings.TrimSpace(d.Term), strings.TrimSpace(d.Definition)
+		if d.Term != "" && d.Definition != "" {
+			notes.Definitions = append(notes.Definitions, d)
+		}
+	}
+	if len(notes.KeyPoints) == 0 && len(notes.Definitions) == 0 {
+		return notes, fmt.Errorf("study notes came back empty")
+	}
+	return notes, nil
+}
+
+// trimCodeFence removes the Markdown code fence a model may wrap JSON in
+func trimCodeFence(out string) string {
+	out = strings.TrimSpace(out)
+	out = strings.TrimPrefix(out, "```json")
+	out = strings.TrimPrefix(out, "```")
+	return strings.TrimSpace(strings.TrimSuffix(out, "```"))
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+)
+
+// Chat export formats
+const (
+	ChatFormatMarkdown = "markdown"
+	ChatFormatPDF      = "pdf"
+	ChatFormatJSON     = "json"
+)
+
+// ChatArchive is a chat with everything an export includes
+type ChatArchive struct {
+	Chat        models.Chat
+	Messages    []models.Message // The active branch, oldest first
+	AllMessages []models.Message // Every message, including other branches
+	Quizzes     []QuizDocument   // Quizzes taken in the chat, oldest first
+	Location    *time.Location   // The learner's zone, for printed times
+}
+
+// LoadChatArchive loads a chat's messages and quizzes for export
+func LoadChatArchive(chat models.Chat) (ChatArchive, error) {
+	a := ChatArchive{Chat: chat, Location: UserLocation(chat.UserID)}
+	var err error
+	if a.Messages, err = ActivePath(chat.ID, 0); err != nil {
+		return a, err
+	}
+	err = config.DB.Select(&a.AllMessages, `
+		SELECT m.id, m.chat_id, m.role, m.content, m.created_at, m.parent_id, m.model, m.aside,
+			(SELECT f.rating FROM message_feedback f WHERE f.message_id = m.id AND f.user_id = $2) AS rating
+		FROM messages m WHERE m.chat_id=$1
+		ORDER BY m.created_at, m.id
+	`, chat.ID, chat.UserID)
+	if err != nil {
+		return a, err
+	}
+
+	var quizzes []models.Quiz
+	if err := config.DB.Select(&quizzes, "SELECT * FROM quizzes WHERE chat_id=$1 ORDER BY created_at, id", chat.ID); err != nil {
+		return a, err
+	}
+	for _, quiz := range quizzes {
+		doc := QuizDocument{Quiz: quiz}
+		err := config.DB.Select(&doc.Questions, `
+			SELECT id, quiz_id, question, answer, COALESCE(options, '[]') as options,
+				COALESCE(user_answer, '') as user_answer, COALESCE(is_correct, false) as is_correct, order_num
+			FROM quiz_questions WHERE quiz_id=$1 ORDER BY order_num ASC
+		`, quiz.ID)
+		if err != nil {
+			return a, err
+		}
+		a.Quizzes = append(a.Quizzes, doc)
+	}
+	return a, nil
+}
+
+// ExportChat renders a chat in the given format, returning the file
+// contents, its MIME type and a file extension
+func ExportChat(format string, a ChatArchive) ([]byte, string, string, error) {
+	switch strings.ToLower(format) {
+	case ChatFormatMarkdown, "md":
+		return []byte(chatMarkdown(a)), "text/markdown; charset=utf-8", "md", nil
+	case ChatFormatPDF:
+		out, err := chatPDF(a)
+		return out, "application/pdf", "pdf", err
+	case ChatFormatJSON:
+		out, err := chatJSON(a)
+		return out, "application/json", "json", err
+	}
+	return nil, "", "", fmt.Errorf("unsupported format %q (use markdown, pdf or json)", format)
+}
+
+// ChatExportName is a file name for a chat's export, without extension
+func ChatExportName(chat models.Chat) string {
+	slug := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, ChatName(chat))
+	for strings.Contains(slug, "--") {
+		slug = strings.ReplaceAll(slug, "--", "-")
+	}
+	slug = strings.Trim(slug, "-")
+	if slug == "" {
+		slug = "chat"
+	}
+	id := chat.ID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return slug + "-" + id
+}
+
+// speaker names a message's role in exports
+func speaker(role string) string {
+	if role == "user" {
+		return "You"
+	}
+	return "Tutor"
+}
+
+// quizSummary is the heading line of a quiz in exports
+func quizSummary(doc QuizDocument, loc *time.Location) string {
+	q := doc.Quiz
+	if q.Status == "completed" {
+		s := fmt.Sprintf("Quiz: %d/%d", q.Score, q.TotalQues)
+		if q.CompletedAt != nil {
+			s += " on " + q.CompletedAt.In(loc).Format("2 Jan 2006")
+		}
+		return s
+	}
+	return fmt.Sprintf("Quiz (%s), %d questions", strings.ReplaceAll(q.Status, "_", " "), q.TotalQues)
+}
+
+// chatMarkdown renders the active branch as notes. Replies are already
+// Markdown, so they are kept as they are.
+func chatMarkdown(a ChatArchive) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", ChatName(a.Chat))
+	fmt.Fprintf(&b, "_%s · started %s · exported %s_\n\n", a.Chat.Topic,
+		a.Chat.CreatedAt.In(a.Location).Format("2 Jan 2006"), time.Now().In(a.Location).Format("2 Jan 2006 15:04"))
+
+	if a.Chat.Summary != "" {
+		fmt.Fprintf(&b, "## Summary\n\n%s\n\n", a.Chat.Summary)
+	}
+	b.WriteString("## Conversation\n\n")
+	for _, m := range a.Messages {
+		fmt.Fprintf(&b, "### %s · %s\n\n", speaker(m.Role), m.CreatedAt.In(a.Location).Format("2 Jan 2006 15:04"))
+		b.WriteString(strings.TrimSpace(m.Content))
+		b.WriteString("\n\n")
+	}
+
+	for _, doc := range a.Quizzes {
+		fmt.Fprintf(&b, "## %s\n\n", quizSummary(doc, a.Location))
+		for i, q := range doc.Questions {
+			fmt.Fprintf(&b, "%d. %s\n", i+1, strings.TrimSpace(q.Question))
+			options := questionOptions(q)
+			correct := correctOptionIndex(q.Answer, options)
+			for j, opt := range options {
+				letter := string(rune('A' + j))
+				line := fmt.Sprintf("%s) %s", letter, opt)
+				if j == correct {
+					line = "**" + line + "** (correct)"
+				}
+				if strings.EqualFold(strings.TrimSpace(q.UserAnswer), letter) && j != correct {
+					line += " (your answer)"
+				}
+				fmt.Fprintf(&b, "   - %s\n", line)
+			}
+			if len(options) == 0 {
+				fmt.Fprintf(&b, "   - Answer: **%s**\n", q.Answer)
+				if q.UserAnswer != "" {
+					fmt.Fprintf(&b, "   - Your answer: %s\n", q.UserAnswer)
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// chatArchiveJSON is the shape of the JSON export
+type chatArchiveJSON struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Chat       struct {
+		ID           string    `json:"id"`
+		Topic        string    `json:"topic"`
+		Title        string    `json:"title"`
+		Summary      string    `json:"summary"`
+		CreatedAt    time.Time `json:"created_at"`
+		UpdatedAt    time.Time `json:"updated_at"`
+		ActiveLeafID *string   `json:"active_leaf_id"`
+	} `json:"chat"`
+	Messages []archivedMessage `json:"messages"`
+	Quizzes  []archivedQuiz    `json:"quizzes"`
+}
+
+// archivedMessage keeps the tree: parent_id links every branch, and
+// active marks the messages the chat shows
+type archivedMessage struct {
+	ID        string    `json:"id"`
+	ParentID  *string   `json:"parent_id"`
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	Model     string    `json:"model,omitempty"`
+	Rating    *int      `json:"rating,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type archivedQuiz struct {
+	ID             int                `json:"id"`
+	Status         string             `json:"status"`
+	Score          int                `json:"score"`
+	TotalQuestions int                `json:"total_questions"`
+	CreatedAt      time.Time          `json:"created_at"`
+	CompletedAt    *time.Time         `json:"completed_at,omitempty"`
+	Questions      []archivedQuestion `json:"questions"`
+}
+
+type archivedQuestion struct {
+	Question   string   `json:"question"`
+	Options    []string `json:"options"`
+	Answer     string   `json:"answer"`
+	UserAnswer string   `json:"user_answer,omitempty"`
+	IsCorrect  bool     `json:"is_correct"`
+}
+
+func chatJSON(a ChatArchive) ([]byte, error) {
+	out := chatArchiveJSON{Format: "khoj-chat", Version: 1, ExportedAt: time.Now(), Messages: []archivedMessage{}, Quizzes: []archivedQuiz{}}
+	out.Chat.ID = a.Chat.ID
+	out.Chat.Topic = a.Chat.Topic
+	out.Chat.Title = a.Chat.Title
+	out.Chat.Summary = a.Chat.Summary
+	out.Chat.CreatedAt = a.Chat.CreatedAt
+	out.Chat.UpdatedAt = a.Chat.UpdatedAt
+	out.Chat.ActiveLeafID = a.Chat.ActiveLeafID
+
+	active := map[string]bool{}
+	for _, m := range a.Messages {
+		active[m.ID] = true
+	}
+	for _, m := range a.AllMessages {
+		out.Messages = append(out.Messages, archivedMessage{
+			ID: m.ID, ParentID: m.ParentID, Role: m.Role, Content: m.Content, Model: m.Model,
+			Rating: m.Rating, Active: active[m.ID], CreatedAt: m.CreatedAt,
+		})
+	}
+	for _, doc := range a.Quizzes {
+		q := archivedQuiz{
+			ID: doc.Quiz.ID, Status: doc.Quiz.Status, Score: doc.Quiz.Score, TotalQuestions: doc.Quiz.TotalQues,
+			CreatedAt: doc.Quiz.CreatedAt, CompletedAt: doc.Quiz.CompletedAt, Questions: []archivedQuestion{},
+		}
+		for _, qq := range doc.Questions {
+			options := questionOptions(qq)
+			if options == nil {
+				options = []string{}
+			}
+			q.Questions = append(q.Questions, archivedQuestion{
+				Question: qq.Question, Options: options, Answer: qq.Answer, UserAnswer: qq.UserAnswer, IsCorrect: qq.IsCorrect,
+			})
+		}
+		out.Quizzes = append(out.Quizzes, q)
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
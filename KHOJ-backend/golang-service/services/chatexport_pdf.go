@@ -0,0 +1,658 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The PDF export lays out the Markdown the tutor writes: headings, lists,
+// quotes, fenced code in a monospace box and LaTeX math ($...$, $$...$$,
+// \(...\), \[...\]) set in italics with Greek letters, operators, and
+// raised or lowered scripts.
+
+const (
+	pdfMargin    = 56.0
+	pdfBodySize  = 10.5
+	pdfCodeSize  = 8.5
+	pdfLeading   = 1.4 // Line height as a multiple of the font size
+	pdfIndent    = 16.0
+	pdfFooterGap = 28.0
+)
+
+// pdfChar is a glyph with its size and baseline shift
+type pdfChar struct {
+	pdfGlyph
+	size float64
+	rise float64
+}
+
+// pdfWord is a run of characters that is never broken across lines
+type pdfWord []pdfChar
+
+func (w pdfWord) width() float64 {
+	total := 0.0
+	for _, c := range w {
+		total += glyphWidth(c.pdfGlyph, c.size)
+	}
+	return total
+}
+
+// styledRune is a character before encoding; a space separates words
+type styledRune struct {
+	r    rune
+	font pdfFont
+	size float64
+	rise float64
+}
+
+// pdfWords encodes text into words, breaking at spaces
+func pdfWords(runes []styledRune) []pdfWord {
+	var words []pdfWord
+	var cur pdfWord
+	for _, sr := range runes {
+		if unicode.IsSpace(sr.r) {
+			if len(cur) > 0 {
+				words = append(words, cur)
+				cur = nil
+			}
+			continue
+		}
+		cur = append(cur, pdfChar{encodeRune(sr.r, sr.font), sr.size, sr.rise})
+	}
+	if len(cur) > 0 {
+		words = append(words, cur)
+	}
+	return words
+}
+
+func plainRunes(s string, font pdfFont, size float64) []styledRune {
+	out := make([]styledRune, 0, len(s))
+	for _, r := range s {
+		out = append(out, styledRune{r: r, font: font, size: size})
+	}
+	return out
+}
+
+// pdfInline matches the inline Markdown the layout understands: code, math,
+// bold and italics
+var pdfInline = regexp.MustCompile("`[^`]+`|\\$[^$\\s](?:[^$]*[^$\\s])?\\$|\\\\\\((?:.+?)\\\\\\)|\\*\\*[^*]+\\*\\*|__[^_]+__|\\*[^*\\s][^*]*\\*")
+
+// inlineRunes styles a line of Markdown text
+func inlineRunes(s string, font pdfFont, size float64) []styledRune {
+	var out []styledRune
+	last := 0
+	for _, m := range pdfInline.FindAllStringIndex(s, -1) {
+		out = append(out, plainRunes(s[last:m[0]], font, size)...)
+		tok := s[m[0]:m[1]]
+		switch {
+		case strings.HasPrefix(tok, "`"):
+			out = append(out, plainRunes(tok[1:len(tok)-1], fontMono, size*0.95)...)
+		case strings.HasPrefix(tok, "$"):
+			out = append(out, mathRunes(tok[1:len(tok)-1], size, 0)...)
+		case strings.HasPrefix(tok, `\(`):
+			out = append(out, mathRunes(tok[2:len(tok)-2], size, 0)...)
+		case strings.HasPrefix(tok, "**"), strings.HasPrefix(tok, "__"):
+			out = append(out, inlineRunes(tok[2:len(tok)-2], fontBold, size)...)
+		default:
+			style := fontItalic
+			if font == fontBold {
+				style = fontBold
+			}
+			out = append(out, inlineRunes(tok[1:len(tok)-1], style, size)...)
+		}
+		last = m[1]
+	}
+	return append(out, plainRunes(s[last:], font, size)...)
+}
+
+// latexSymbols maps LaTeX commands onto the characters they stand for
+var latexSymbols = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ε", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ",
+	"sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "φ", "varphi": "ϕ",
+	"chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"le": "≤", "leq": "≤", "ge": "≥", "geq": "≥", "ne": "≠", "neq": "≠", "approx": "≈",
+	"equiv": "≡", "sim": "~", "cong": "≅", "propto": "∝", "times": "×", "cdot": "⋅",
+	"div": "÷", "pm": "±", "ast": "∗", "infty": "∞", "partial": "∂",
+	"nabla": "∇", "sum": "∑", "prod": "∏", "int": "∫", "oint": "∫", "to": "→",
+	"rightarrow": "→", "leftarrow": "←", "gets": "←", "uparrow": "↑", "downarrow": "↓",
+	"leftrightarrow": "↔", "Rightarrow": "⇒", "implies": "⇒", "Leftarrow": "⇐",
+	"Leftrightarrow": "⇔", "iff": "⇔", "mapsto": "→", "in": "∈", "notin": "∉", "ni": "∋",
+	"cap": "∩", "cup": "∪", "subset": "⊂", "subseteq": "⊆", "supset": "⊃", "supseteq": "⊇",
+	"forall": "∀", "exists": "∃", "emptyset": "∅", "varnothing": "∅", "angle": "∠",
+	"perp": "⊥", "neg": "¬", "lnot": "¬", "land": "∧", "wedge": "∧", "lor": "∨", "vee": "∨",
+	"oplus": "⊕", "otimes": "⊗", "circ": "°", "degree": "°", "prime": "′", "aleph": "ℵ",
+	"ldots": "…", "dots": "…", "cdots": "⋅⋅⋅", "langle": "⟨", "rangle": "⟩",
+	"lbrace": "{", "rbrace": "}", "vert": "|", "mid": "|", "|": "‖", "%": "%", "$": "$",
+	"{": "{", "}": "}", "_": "_", "&": "&", "#": "#",
+	",": " ", ";": " ", ":": " ", " ": " ", "quad": " ", "qquad": " ", "\\": " ", "!": "",
+	"left": "", "right": "", "big": "", "Big": "", "bigg": "", "displaystyle": "", "limits": "", "nolimits": "",
+}
+
+// latexOperators are set upright, like \sin in TeX
+var latexOperators = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true, "arcsin": true,
+	"arccos": true, "arctan": true, "sinh": true, "cosh": true, "tanh": true, "log": true, "ln": true,
+	"lg": true, "exp": true, "lim": true, "max": true, "min": true, "sup": true, "inf": true,
+	"det": true, "gcd": true, "deg": true, "dim": true, "ker": true, "arg": true, "mod": true, "bmod": true,
+}
+
+// mathRelations get breakable space around them
+const mathRelations = "=<>≤≥≠≈≡→⇒⇔←∈"
+
+// latexGroup reads the argument starting at i: a braced group, a command or
+// one character. It returns the argument and the index after it.
+func latexGroup(s string, i int) (string, int) {
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+	if i >= len(s) {
+		return "", i
+	}
+	switch s[i] {
+	case '{':
+		depth := 0
+		for j := i; j < len(s); j++ {
+			switch {
+			case s[j] == '\\':
+				j++
+			case s[j] == '{':
+				depth++
+			case s[j] == '}':
+				depth--
+				if depth == 0 {
+					return s[i+1 : j], j + 1
+				}
+			}
+		}
+		return s[i+1:], len(s)
+	case '\\':
+		_, next := latexCommand(s, i)
+		return s[i:next], next
+	}
+	r := []rune(s[i:])[0]
+	return string(r), i + len(string(r))
+}
+
+// latexCommand reads the name of the command at s[i] == '\\'
+func latexCommand(s string, i int) (string, int) {
+	j := i + 1
+	for j < len(s) && (s[j] >= 'a' && s[j] <= 'z' || s[j] >= 'A' && s[j] <= 'Z') {
+		j++
+	}
+	if j == i+1 && j < len(s) {
+		j++ // One-character command such as \{ or \,
+	}
+	return s[i+1 : j], j
+}
+
+// mathRunes typesets a LaTeX formula as styled characters
+func mathRunes(s string, size float64, rise float64) []styledRune {
+	var out []styledRune
+	emit := func(text string, font pdfFont) {
+		for _, r := range text {
+			if strings.ContainsRune(mathRelations, r) {
+				out = append(out, styledRune{r: ' ', size: size}, styledRune{r: r, font: fontRegular, size: size, rise: rise}, styledRune{r: ' ', size: size})
+				continue
+			}
+			out = append(out, styledRune{r: r, font: font, size: size, rise: rise})
+		}
+	}
+	group := func(arg string) []styledRune {
+		inner := mathRunes(arg, size, rise)
+		if len([]rune(strings.TrimSpace(arg))) > 1 {
+			inner = append(append([]styledRune{{r: '(', size: size, rise: rise}}, inner...), styledRune{r: ')', size: size, rise: rise})
+		}
+		return inner
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\':
+			name, next := latexCommand(s, i)
+			i = next
+			switch {
+			case name == "frac" || name == "dfrac" || name == "tfrac":
+				var num, den string
+				num, i = latexGroup(s, i)
+				den, i = latexGroup(s, i)
+				out = append(out, group(num)...)
+				emit("/", fontRegular)
+				out = append(out, group(den)...)
+			case name == "sqrt":
+				if i < len(s) && s[i] == '[' {
+					if end := strings.IndexByte(s[i:], ']'); end >= 0 {
+						i += end + 1
+					}
+				}
+				var arg string
+				arg, i = latexGroup(s, i)
+				emit("√", fontRegular)
+				out = append(out, group(arg)...)
+			case name == "text" || name == "mathrm" || name == "textrm" || name == "operatorname" || name == "mbox":
+				var arg string
+				arg, i = latexGroup(s, i)
+				for _, r := range arg {
+					out = append(out, styledRune{r: r, font: fontRegular, size: size, rise: rise})
+				}
+			case name == "textbf" || name == "mathbf" || name == "boldsymbol":
+				var arg string
+				arg, i = latexGroup(s, i)
+				for _, sr := range mathRunes(arg, size, rise) {
+					sr.font = fontBold
+					out = append(out, sr)
+				}
+			case name == "mathit" || name == "mathcal" || name == "mathbb" || name == "vec" || name == "hat" || name == "bar" || name == "overline" || name == "tilde":
+				var arg string
+				arg, i = latexGroup(s, i)
+				out = append(out, mathRunes(arg, size, rise)...)
+			case latexOperators[name]:
+				emit(name, fontRegular)
+			default:
+				if sym, ok := latexSymbols[name]; ok {
+					emit(sym, fontRegular)
+				} else {
+					emit(name, fontRegular)
+				}
+			}
+		case c == '^' || c == '_':
+			var arg string
+			arg, i = latexGroup(s, i+1)
+			shift := size * 0.38
+			if c == '_' {
+				shift = -size * 0.18
+			}
+			out = append(out, mathRunes(arg, size*0.7, rise+shift)...)
+		case c == '{' || c == '}' || c == ' ':
+			i++
+		case c == '&':
+			emit(" ", fontRegular)
+			i++
+		default:
+			r := []rune(s[i:])[0]
+			i += len(string(r))
+			switch {
+			case unicode.IsLetter(r):
+				emit(string(r), fontItalic)
+			case r == '-':
+				emit("−", fontRegular)
+			case r == '*':
+				emit("∗", fontRegular)
+			case r == '\'':
+				emit("′", fontRegular)
+			default:
+				emit(string(r), fontRegular)
+			}
+		}
+	}
+	return out
+}
+
+// pdfLayout places blocks of text on pages from the top down
+type pdfLayout struct {
+	doc  *pdfDoc
+	y    float64 // Top of the free space on the page
+	lost []rune  // Characters drawn as "?", in order of first use
+}
+
+func newPDFLayout(title string) *pdfLayout {
+	l := &pdfLayout{doc: &pdfDoc{title: title}}
+	l.newPage()
+	return l
+}
+
+func (l *pdfLayout) newPage() {
+	l.doc.newPage()
+	l.y = pdfPageHeight - pdfMargin
+}
+
+// reserve starts a new page unless h points fit above the footer
+func (l *pdfLayout) reserve(h float64) {
+	if l.y-h < pdfMargin+pdfFooterGap && l.y < pdfPageHeight-pdfMargin {
+		l.newPage()
+	}
+}
+
+func (l *pdfLayout) gap(h float64) {
+	l.y -= h
+}
+
+// lines wraps words into lines of the given width starting at x and draws
+// them, centred when asked. background > 0 fills each line's band in that
+// gray. marker is drawn left of the first line (list bullets).
+func (l *pdfLayout) lines(words []pdfWord, x, width, gray float64, center bool, background float64, marker pdfWord) {
+	var line []pdfWord
+	lineWidth := 0.0
+	first := true
+	flush := func() {
+		size := pdfBodySize * 0.7
+		for _, w := range line {
+			for _, c := range w {
+				size = max(size, c.size)
+			}
+		}
+		h := size * pdfLeading
+		l.reserve(h)
+		l.y -= h
+		if background > 0 {
+			l.doc.rect(x-4, l.y, width+8, h, background)
+		}
+		baseline := l.y + (h-size)/2 + size*0.22
+		start := x
+		if center {
+			start += (width - lineWidth) / 2
+		}
+		if first && marker != nil {
+			l.word(start-marker.width()-5, baseline, marker, gray)
+		}
+		first = false
+		for i, w := range line {
+			if i > 0 {
+				start += spaceWidth(w)
+			}
+			l.word(start, baseline, w, gray)
+			start += w.width()
+		}
+		line, lineWidth = nil, 0
+	}
+	for _, w := range words {
+		ww := w.width()
+		// Words wider than a line are split wherever they overflow
+		for ww > width && len(w) > 1 {
+			if len(line) > 0 {
+				flush()
+			}
+			cut, acc := 0, 0.0
+			for cut < len(w)-1 && acc+glyphWidth(w[cut].pdfGlyph, w[cut].size) <= width {
+				acc += glyphWidth(w[cut].pdfGlyph, w[cut].size)
+				cut++
+			}
+			line, lineWidth = []pdfWord{w[:max(cut, 1)]}, acc
+			flush()
+			w = w[max(cut, 1):]
+			ww = w.width()
+		}
+		if len(line) > 0 && lineWidth+spaceWidth(w)+ww > width {
+			flush()
+		}
+		if len(line) > 0 {
+			lineWidth += spaceWidth(w)
+		}
+		line = append(line, w)
+		lineWidth += ww
+	}
+	if len(line) > 0 || (first && marker != nil) {
+		flush()
+	}
+}
+
+func spaceWidth(w pdfWord) float64 {
+	return glyphWidth(pdfGlyph{code: ' ', font: fontRegular}, w[0].size)
+}
+
+// word draws a word, one text object per stretch of the same font and size
+func (l *pdfLayout) word(x, baseline float64, w pdfWord, gray float64) {
+	for start := 0; start < len(w); {
+		end := start + 1
+		for end < len(w) && w[end].font == w[start].font && w[end].size == w[start].size && w[end].rise == w[start].rise {
+			end++
+		}
+		glyphs := make([]pdfGlyph, end-start)
+		for i := range glyphs {
+			glyphs[i] = w[start+i].pdfGlyph
+			if r := glyphs[i].lost; r != 0 && !slices.Contains(l.lost, r) {
+				l.lost = append(l.lost, r)
+			}
+		}
+		l.doc.text(x, baseline, glyphs, w[start].size, w[start].rise, gray)
+		x += w[start:end].width()
+		start = end
+	}
+}
+
+var (
+	mdHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	mdListItem = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	mdRule     = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	mdTableSep = regexp.MustCompile(`^\s*\|?[\s:|-]+\|[\s:|-]*$`)
+)
+
+// markdown lays out a Markdown text
+func (l *pdfLayout) markdown(text string, x, width float64) {
+	var para []string
+	paraKind, listDepth, listMarker := "", 0, ""
+	flushPara := func() {
+		if len(para) == 0 {
+			return
+		}
+		joined := strings.Join(para, " ")
+		switch paraKind {
+		case "list":
+			indent := pdfIndent * float64(listDepth+1)
+			l.lines(pdfWords(inlineRunes(joined, fontRegular, pdfBodySize)), x+indent, width-indent, 0, false, 0,
+				pdfWords(plainRunes(listMarker, fontRegular, pdfBodySize))[0])
+			l.gap(2)
+		case "quote":
+			top := l.y
+			l.lines(pdfWords(inlineRunes(joined, fontItalic, pdfBodySize)), x+pdfIndent, width-pdfIndent, 0.3, false, 0, nil)
+			if l.y < top {
+				l.doc.line(x+4, top, x+4, l.y, 1.5, 0.75)
+			}
+			l.gap(4)
+		default:
+			l.lines(pdfWords(inlineRunes(joined, fontRegular, pdfBodySize)), x, width, 0, false, 0, nil)
+			l.gap(5)
+		}
+		para, paraKind = nil, ""
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"), strings.HasPrefix(trimmed, "~~~"):
+			flushPara()
+			fence := trimmed[:3]
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			l.code(code, x, width)
+		case strings.HasPrefix(trimmed, "$$") || strings.HasPrefix(trimmed, `\[`):
+			flushPara()
+			open, closing := "$$", "$$"
+			if strings.HasPrefix(trimmed, `\[`) {
+				open, closing = `\[`, `\]`
+			}
+			src := strings.TrimPrefix(trimmed, open)
+			for !strings.Contains(src, closing) && i+1 < len(lines) {
+				i++
+				src += " " + strings.TrimSpace(lines[i])
+			}
+			if end := strings.Index(src, closing); end >= 0 {
+				src = src[:end]
+			}
+			l.gap(3)
+			l.lines(pdfWords(mathRunes(src, pdfBodySize*1.1, 0)), x, width, 0, true, 0, nil)
+			l.gap(6)
+		case trimmed == "":
+			flushPara()
+		case mdRule.MatchString(line):
+			flushPara()
+			l.reserve(10)
+			l.gap(5)
+			l.doc.line(x, l.y, x+width, l.y, 0.5, 0.7)
+			l.gap(5)
+		case mdHeading.MatchString(trimmed):
+			flushPara()
+			m := mdHeading.FindStringSubmatch(trimmed)
+			size := pdfBodySize * max(1.0, 1.45-0.12*float64(len(m[1])-1))
+			l.gap(4)
+			l.reserve(size * pdfLeading * 2) // Keep the heading with what follows
+			l.lines(pdfWords(inlineRunes(m[2], fontBold, size)), x, width, 0, false, 0, nil)
+			l.gap(3)
+		case strings.HasPrefix(trimmed, "|"):
+			flushPara()
+			var rows []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				if !mdTableSep.MatchString(lines[i]) {
+					rows = append(rows, strings.TrimSpace(lines[i]))
+				}
+			}
+			i--
+			l.code(rows, x, width)
+		case mdListItem.MatchString(line):
+			flushPara()
+			m := mdListItem.FindStringSubmatch(line)
+			paraKind, listDepth, listMarker = "list", len(m[1])/2, "•"
+			if m[2][0] >= '0' && m[2][0] <= '9' {
+				listMarker = m[2]
+			}
+			para = []string{m[3]}
+		case strings.HasPrefix(trimmed, ">"):
+			if paraKind != "quote" {
+				flushPara()
+				paraKind = "quote"
+			}
+			para = append(para, strings.TrimSpace(strings.TrimPrefix(trimmed, ">")))
+		default:
+			if paraKind == "quote" {
+				flushPara()
+			}
+			para = append(para, trimmed)
+		}
+	}
+	flushPara()
+}
+
+// code lays out preformatted lines in a shaded monospace box, wrapping long
+// lines by character
+func (l *pdfLayout) code(lines []string, x, width float64) {
+	perLine := max(1, int((width-8)/(0.6*pdfCodeSize)))
+	l.gap(3)
+	for _, line := range lines {
+		runes := []rune(strings.ReplaceAll(line, "\t", "    "))
+		for len(runes) > 0 || line == "" {
+			n := min(len(runes), perLine)
+			chunk := make(pdfWord, 0, n)
+			for _, r := range runes[:n] {
+				chunk = append(chunk, pdfChar{encodeRune(r, fontMono), pdfCodeSize, 0})
+			}
+			h := pdfCodeSize * pdfLeading
+			l.reserve(h)
+			l.y -= h
+			l.doc.rect(x-4, l.y, width+8, h, 0.94)
+			// Spaces are kept so indentation survives
+			l.word(x, l.y+(h-pdfCodeSize)/2+pdfCodeSize*0.22, chunk, 0.1)
+			runes = runes[n:]
+			if line == "" {
+				break
+			}
+		}
+	}
+	l.gap(6)
+}
+
+// UnsupportedCharsError is returned for a PDF export of text the standard
+// PDF fonts have no glyphs for, such as CJK scripts or emoji
+type UnsupportedCharsError struct {
+	Chars []rune
+}
+
+func (e *UnsupportedCharsError) Error() string {
+	const shown = 10
+	chars := make([]string, 0, shown)
+	for _, r := range e.Chars[:min(len(e.Chars), shown)] {
+		chars = append(chars, string(r))
+	}
+	more := ""
+	if len(e.Chars) > shown {
+		more = fmt.Sprintf(" and %d more", len(e.Chars)-shown)
+	}
+	return fmt.Sprintf("the PDF export can't show %s%s; export as markdown or json instead", strings.Join(chars, " "), more)
+}
+
+// chatPDF renders the active branch and the chat's quizzes as a PDF. It
+// fails with an UnsupportedCharsError rather than print "?" in their place.
+func chatPDF(a ChatArchive) ([]byte, error) {
+	l := newPDFLayout(ChatName(a.Chat))
+	x, width := pdfMargin, pdfPageWidth-2*pdfMargin
+
+	l.lines(pdfWords(plainRunes(ChatName(a.Chat), fontBold, 20)), x, width, 0, false, 0, nil)
+	l.gap(2)
+	sub := fmt.Sprintf("%s · started %s · exported %s", a.Chat.Topic, a.Chat.CreatedAt.In(a.Location).Format("2 Jan 2006"), time.Now().In(a.Location).Format("2 Jan 2006 15:04"))
+	l.lines(pdfWords(plainRunes(sub, fontRegular, 9)), x, width, 0.45, false, 0, nil)
+	l.gap(10)
+	l.doc.line(x, l.y, x+width, l.y, 0.75, 0.6)
+	l.gap(12)
+
+	for _, m := range a.Messages {
+		l.reserve(pdfBodySize * pdfLeading * 3)
+		label := append(plainRunes(speaker(m.Role), fontBold, 10), plainRunes("  "+m.CreatedAt.In(a.Location).Format("2 Jan 15:04"), fontRegular, 8)...)
+		gray := 0.15
+		if m.Role == "user" {
+			gray = 0.35
+		}
+		l.lines(pdfWords(label), x, width, gray, false, 0, nil)
+		l.gap(3)
+		l.markdown(m.Content, x, width)
+		l.gap(8)
+	}
+
+	for _, doc := range a.Quizzes {
+		l.gap(6)
+		l.reserve(60)
+		l.lines(pdfWords(plainRunes(quizSummary(doc, a.Location), fontBold, 14)), x, width, 0, false, 0, nil)
+		l.gap(6)
+		for i, q := range doc.Questions {
+			l.reserve(pdfBodySize * pdfLeading * 3)
+			l.lines(pdfWords(inlineRunes(strings.TrimSpace(q.Question), fontBold, pdfBodySize)), x+pdfIndent, width-pdfIndent, 0, false, 0,
+				pdfWords(plainRunes(fmt.Sprintf("%d.", i+1), fontBold, pdfBodySize))[0])
+			l.gap(2)
+			options := questionOptions(q)
+			correct := correctOptionIndex(q.Answer, options)
+			for j, opt := range options {
+				letter := string(rune('A' + j))
+				font, note := fontRegular, ""
+				switch {
+				case j == correct:
+					font, note = fontBold, "  (correct)"
+				case strings.EqualFold(strings.TrimSpace(q.UserAnswer), letter):
+					note = "  (your answer)"
+				}
+				runes := append(inlineRunes(letter+") "+opt, font, pdfBodySize), plainRunes(note, fontItalic, pdfBodySize*0.9)...)
+				l.lines(pdfWords(runes), x+2*pdfIndent, width-2*pdfIndent, 0, false, 0, nil)
+			}
+			if len(options) == 0 {
+				l.lines(pdfWords(plainRunes("Answer: "+q.Answer, fontBold, pdfBodySize)), x+2*pdfIndent, width-2*pdfIndent, 0, false, 0, nil)
+				if q.UserAnswer != "" {
+					l.lines(pdfWords(plainRunes("Your answer: "+q.UserAnswer, fontItalic, pdfBodySize)), x+2*pdfIndent, width-2*pdfIndent, 0, false, 0, nil)
+				}
+			}
+			l.gap(8)
+		}
+	}
+
+	// Page numbers, now that the count is known
+	for i := range l.doc.pages {
+		l.doc.current = i
+		var footer pdfWord
+		for _, r := range fmt.Sprintf("%s · %d / %d", ChatName(a.Chat), i+1, len(l.doc.pages)) {
+			footer = append(footer, pdfChar{encodeRune(r, fontRegular), 8, 0})
+		}
+		l.word(pdfPageWidth-pdfMargin-footer.width(), pdfMargin-10, footer, 0.5)
+	}
+	if len(l.lost) > 0 {
+		return nil, &UnsupportedCharsError{Chars: l.lost}
+	}
+	return l.doc.bytes(), nil
+}
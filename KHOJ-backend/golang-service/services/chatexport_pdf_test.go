@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-service/models"
+)
+
+// pdfPageText checks the structure of a PDF written by pdfDoc: the header,
+// a cross-reference table whose offsets land on their objects, streams that
+// inflate to their declared length, and balanced text objects. It returns
+// the text drawn on each page, per font, with "|" between text runs.
+func pdfPageText(t *testing.T, data []byte) []map[int]string {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("missing PDF header or trailer")
+	}
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n0 ")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+	var count int
+	fmt.Sscanf(string(data[xref:]), "xref\n0 %d", &count)
+	entries := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllSubmatch(data[xref:], -1)
+	if len(entries) != count-1 {
+		t.Fatalf("xref lists %d objects, header says %d", len(entries), count-1)
+	}
+	objects := map[int][]byte{}
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		head := fmt.Sprintf("%d 0 obj\n", i+1)
+		if !bytes.HasPrefix(data[off:], []byte(head)) {
+			t.Fatalf("xref offset of object %d does not point at it", i+1)
+		}
+		end := bytes.Index(data[off:], []byte("\nendobj\n"))
+		objects[i+1] = data[off+len(head) : off+end]
+	}
+
+	pages := regexp.MustCompile(`/Count (\d+)`).FindSubmatch(objects[2])
+	if pages == nil {
+		t.Fatal("page tree has no count")
+	}
+	n, _ := strconv.Atoi(string(pages[1]))
+	var out []map[int]string
+	for p := 0; p < n; p++ {
+		page := objects[4+len(pdfFontNames)+2*p]
+		if !bytes.Contains(page, []byte("/Type /Page ")) {
+			t.Fatalf("object for page %d is not a page", p+1)
+		}
+		obj := objects[4+len(pdfFontNames)+2*p+1]
+		var length int
+		fmt.Sscanf(string(obj), "<< /Length %d", &length)
+		start := bytes.Index(obj, []byte("stream\n")) + len("stream\n")
+		if start+length > len(obj) || !bytes.HasPrefix(obj[start+length:], []byte("\nendstream")) {
+			t.Fatalf("page %d stream length is wrong", p+1)
+		}
+		r, err := zlib.NewReader(bytes.NewReader(obj[start : start+length]))
+		if err != nil {
+			t.Fatalf("page %d stream: %v", p+1, err)
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("page %d stream: %v", p+1, err)
+		}
+		if bytes.Count(content, []byte("BT ")) != bytes.Count(content, []byte(" ET\n")) {
+			t.Fatalf("page %d has unbalanced text objects", p+1)
+		}
+		out = append(out, pdfTextRuns(t, content))
+	}
+	return out
+}
+
+var pdfTextRun = regexp.MustCompile(`/F(\d) [\d.]+ Tf [-\d.]+ Ts [-\d.]+ [-\d.]+ Td \(((?:\\[()\\]|\\[0-7]{3}|[^()\\])*)\) Tj ET`)
+
+func pdfTextRuns(t *testing.T, content []byte) map[int]string {
+	t.Helper()
+	runs := map[int]string{}
+	for _, m := range pdfTextRun.FindAllSubmatch(content, -1) {
+		font, _ := strconv.Atoi(string(m[1]))
+		var s strings.Builder
+		raw := m[2]
+		for i := 0; i < len(raw); i++ {
+			switch {
+			case raw[i] == '\\' && raw[i+1] >= '0' && raw[i+1] <= '7':
+				v, _ := strconv.ParseUint(string(raw[i+1:i+4]), 8, 8)
+				s.WriteByte(byte(v))
+				i += 3
+			case raw[i] == '\\':
+				s.WriteByte(raw[i+1])
+				i++
+			default:
+				s.WriteByte(raw[i])
+			}
+		}
+		runs[font] += s.String() + "|"
+	}
+	if len(runs) == 0 {
+		t.Fatal("no text drawn")
+	}
+	return runs
+}
+
+func testArchive(messages ...string) ChatArchive {
+	created := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	a := ChatArchive{
+		Chat:     models.Chat{ID: "0123456789abcdef", Topic: "algebra", CreatedAt: created},
+		Location: time.UTC,
+	}
+	for i, content := range messages {
+		role := "bot"
+		if i%2 == 0 {
+			role = "user"
+		}
+		a.Messages = append(a.Messages, models.Message{ID: strconv.Itoa(i), Role: role, Content: content, CreatedAt: created.Add(time.Duration(i) * time.Minute)})
+	}
+	return a
+}
+
+func TestChatPDFRenders(t *testing.T) {
+	a := testArchive(
+		"How do I solve $x^2 + 2x = 0$ when $\\alpha \\ne 0$?",
+		"Factor it: \\(x(x + 2) = 0\\), so $x = 0$ or $x = -2$.\n\n```go\nfunc roots() (int, int) { return 0, -2 }\n```",
+		"And if it costs $5 and I pay $ 10, what's left? Also an unterminated $x + 1",
+		"**Note:** the change is $5.",
+	)
+	a.Quizzes = []QuizDocument{{
+		Quiz:      models.Quiz{Topic: "algebra", Status: "in_progress", TotalQues: 1},
+		Questions: []models.QuizQuestion{{Question: "What is $\\pi$ to two places?", Options: `["3.14","3.41"]`, Answer: "A"}},
+	}}
+
+	out, contentType, ext, err := ExportChat(ChatFormatPDF, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/pdf" || ext != "pdf" {
+		t.Errorf("got %s / %s", contentType, ext)
+	}
+	pages := pdfPageText(t, out)
+	var regular, mono, italic, symbol string
+	for _, p := range pages {
+		regular += p[int(fontRegular)+1] + p[int(fontBold)+1]
+		italic += p[int(fontItalic)+1]
+		mono += p[int(fontMono)+1]
+		symbol += p[int(fontSymbol)+1]
+	}
+
+	// Math is set in italics, with Greek letters and signs from Symbol
+	if !strings.Contains(italic, "x") {
+		t.Errorf("no italic math in %q", italic)
+	}
+	for _, code := range []byte{symbolCodes['α'], symbolCodes['≠'], symbolCodes['π']} {
+		if !strings.Contains(symbol, string([]byte{code})) {
+			t.Errorf("symbol font text %q lacks %q", symbol, code)
+		}
+	}
+	if strings.Contains(regular+italic, "\\alpha") || strings.Contains(regular+italic, "\\(") {
+		t.Errorf("LaTeX source leaked into the text: %q", regular)
+	}
+	// Fenced code keeps its spacing in Courier
+	if !strings.Contains(mono, "func roots() (int, int) { return 0, -2 }") {
+		t.Errorf("code block missing from %q", mono)
+	}
+	// Dollar amounts and a lone $ are text, not math
+	for _, want := range []string{"$5", "$", "unterminated", "$x"} {
+		if !strings.Contains(regular, want) {
+			t.Errorf("text %q lacks %q", regular, want)
+		}
+	}
+}
+
+func TestChatPDFManyPages(t *testing.T) {
+	var messages []string
+	for i := 0; i < 60; i++ {
+		messages = append(messages, strings.Repeat("A long line of tutoring about $x_1 + x_2$. ", 8))
+	}
+	out, _, _, err := ExportChat(ChatFormatPDF, testArchive(messages...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages := pdfPageText(t, out); len(pages) < 2 {
+		t.Errorf("got %d pages, want the layout to break across pages", len(pages))
+	}
+}
+
+func TestChatPDFRejectsUnsupportedChars(t *testing.T) {
+	a := testArchive("What does 学习 mean?", "It means to study 📚. Also ∑ and α are fine.")
+	_, _, _, err := ExportChat(ChatFormatPDF, a)
+	var unsupported *UnsupportedCharsError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("got %v, want an UnsupportedCharsError", err)
+	}
+	if got := string(unsupported.Chars); got != "学习📚" {
+		t.Errorf("unsupported chars = %q, want %q", got, "学习📚")
+	}
+	if !strings.Contains(err.Error(), "markdown or json") {
+		t.Errorf("error %q does not point to the other formats", err)
+	}
+
+	// The other formats carry the same chat
+	for _, format := range []string{ChatFormatMarkdown, ChatFormatJSON} {
+		if _, _, _, err := ExportChat(format, a); err != nil {
+			t.Errorf("%s export: %v", format, err)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// A chat is a tree of messages: each message's parent is the one before it
+// on its branch. Editing a message or regenerating a reply adds a sibling,
+// which starts a new branch; the chat shows the path from the root to its
+// active leaf, with any asides posted along it.
+
+// branchPreviewLength caps the last-message preview in a branch listing
+const branchPreviewLength = 120
+
+var (
+	// ErrNotATutorReply is returned when regenerating a message that isn't a
+	// tutor's answer to a learner message, e.g. a reminder, a daily fact or a
+	// command's reply
+	ErrNotATutorReply = errors.New("only tutor replies can be regenerated")
+	// ErrNotInChat is returned for a message id from another chat
+	ErrNotInChat = errors.New("message is not in this chat")
+)
+
+// AppendMessage adds a message after the active leaf of a chat and makes it
+// the new leaf. q may be a transaction, which then holds the chat's lock
+// until it ends.
+func AppendMessage(ctx context.Context, q sqlx.QueryerContext, chatID string, role string, content string, model string) (models.Message, error) {
+	return addMessage(ctx, q, chatID, role, content, model, false, nil)
+}
+
+// BranchMessage adds a message under parent (nil for a new first message)
+// and makes it the active leaf. If parent already has children, the chat
+// forks there.
+func BranchMessage(ctx context.Context, q sqlx.QueryerContext, chatID string, parentID *string, role string, content string, model string) (models.Message, error) {
+	return addMessage(ctx, q, chatID, role, content, model, true, parentID)
+}
+
+func addMessage(ctx context.Context, q sqlx.QueryerContext, chatID string, role string, content string, model string, branch bool, parentID *string) (models.Message, error) {
+	if db, ok := q.(*sqlx.DB); ok {
+		tx, err := db.BeginTxx(ctx, nil)
+		if err != nil {
+			return models.Message{}, err
+		}
+		defer tx.Rollback()
+		msg, err := addMessage(ctx, tx, chatID, role, content, model, branch, parentID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		return msg, err
+	}
+
+	// Concurrent messages chain one after another: the chat row is locked in
+	// a statement of its own, so the insert below runs on a snapshot taken
+	// after any message that held the lock was committed and sees it as the
+	// leaf. (A lock taken in the same statement would leave the subqueries on
+	// the snapshot from before the wait, and both messages would get the
+	// same parent.)
+	var leafID *string
+	if err := sqlx.GetContext(ctx, q, &leafID, "SELECT active_leaf_id FROM chats WHERE id=$1 FOR UPDATE", chatID); err != nil {
+		return models.Message{}, err
+	}
+
+	// A chat without a leaf (or a stale one) continues from its latest message.
+	// Bumping updated_at lets clients syncing the chat list see the change.
+	var msg models.Message
+	err := sqlx.GetContext(ctx, q, &msg, `
+		WITH msg AS (
+			INSERT INTO messages (id, chat_id, role, content, created_at, parent_id, model)
+			VALUES ($1, $2, $3, $4, $5,
+				CASE WHEN $7 THEN $8 ELSE COALESCE(
+					(SELECT id FROM messages WHERE id = $9 AND chat_id = $2),
+					(SELECT id FROM messages WHERE chat_id = $2 AND NOT aside ORDER BY created_at DESC, id DESC LIMIT 1)
+				) END,
+				$6)
+			RETURNING *
+		), leaf AS (
+			UPDATE chats SET active_leaf_id = msg.id, updated_at = GREATEST(chats.updated_at, msg.created_at)
+			FROM msg WHERE chats.id = msg.chat_id
+		)
+		SELECT * FROM msg
+	`, uuid.New().String(), chatID, role, content, time.Now(), model, branch, parentID, leafID)
+	return msg, err
+}
+
+// AppendAside posts a message after the active leaf of a chat without making
+// it the leaf: it shows on the branch but the conversation continues from
+// the leaf, and the chat's updated_at is left alone so the chat doesn't move
+// up the learner's list.
+func AppendAside(ctx context.Context, q sqlx.QueryerContext, chatID string, role string, content string) (models.Message, error) {
+	var msg models.Message
+	err := sqlx.GetContext(ctx, q, &msg, `
+		INSERT INTO messages (id, chat_id, role, content, created_at, parent_id, aside)
+		SELECT $1, c.id, $3, $4, $5, COALESCE(
+			(SELECT id FROM messages WHERE id = c.active_leaf_id AND chat_id = c.id),
+			(SELECT id FROM messages WHERE chat_id = c.id AND NOT aside ORDER BY created_at DESC, id DESC LIMIT 1)
+		), true
+		FROM chats c WHERE c.id=$2
+		RETURNING *
+	`, uuid.New().String(), chatID, role, content, time.Now())
+	return msg, err
+}
+
+// ActivePath returns the messages on a chat's active branch, oldest first,
+// with how many versions each has and the owner's rating. limit > 0 keeps
+// only the latest messages.
+func ActivePath(chatID string, limit int) ([]models.Message, error) {
+	messages, _, err := activePath(chatID, PageQuery{Limit: limit})
+	return messages, err
+}
+
+// ActivePathPage returns a page of a chat's active branch, oldest first: the
+// latest p.Limit messages before p.Cursor (or of the whole branch), or the
+// first p.Limit after p.Since. more reports whether the branch continues past
+// the page in that direction.
+func ActivePathPage(chatID string, p PageQuery) (messages []models.Message, more bool, err error) {
+	p.Limit++
+	messages, more, err = activePath(chatID, p)
+	if more {
+		if p.Since != nil {
+			messages = messages[:len(messages)-1]
+		} else {
+			messages = messages[1:]
+		}
+	}
+	return messages, more, err
+}
+
+// activePath loads part of the active branch; more is set when it found
+// p.Limit messages, i.e. there may be more. The walk up the branch is kept
+// to the page: paging back starts at the cursor's message and stops after
+// p.Limit messages, and syncing stops at the first message before p.Since.
+func activePath(chatID string, p PageQuery) ([]models.Message, bool, error) {
+	messages := []models.Message{}
+	beforeTime, beforeID := cursorArgs(p.Cursor)
+	sinceTime, sinceID := cursorArgs(p.Since)
+	err := config.DB.Select(&messages, `
+		WITH RECURSIVE anchor AS (
+			SELECT CASE WHEN m.aside THEN m.parent_id ELSE m.id END AS id
+			FROM messages m WHERE m.chat_id=$1 AND m.id=$4::text
+		), start AS (
+			SELECT COALESCE(
+				(SELECT id FROM anchor),
+				(SELECT m.id FROM chats c JOIN messages m ON m.id = c.active_leaf_id AND m.chat_id = c.id WHERE c.id=$1),
+				(SELECT id FROM messages WHERE chat_id=$1 AND NOT aside ORDER BY created_at DESC, id DESC LIMIT 1)
+			) AS id,
+			-- A cursor that isn't a message of the chat is only used by time,
+			-- which needs the whole branch
+			$2 > 0 AND $5::timestamptz IS NULL AND ($4::text IS NULL OR EXISTS (SELECT 1 FROM anchor)) AS bounded
+		), path AS (
+			SELECT m.id, m.chat_id, m.role, m.content, m.created_at, m.parent_id, m.model, m.aside, 1 AS depth
+			FROM messages m JOIN start ON m.id = start.id
+			UNION ALL
+			SELECT m.id, m.chat_id, m.role, m.content, m.created_at, m.parent_id, m.model, m.aside, path.depth + 1
+			FROM messages m JOIN path ON m.id = path.parent_id
+			WHERE (NOT (SELECT bounded FROM start) OR path.depth <= $2)
+			AND ($5::timestamptz IS NULL OR (path.created_at, path.id) > ($5, $6::text))
+		), shown AS (
+			SELECT id, chat_id, role, content, created_at, parent_id, model, aside FROM path
+			UNION ALL
+			SELECT m.id, m.chat_id, m.role, m.content, m.created_at, m.parent_id, m.model, m.aside FROM messages m
+			WHERE m.chat_id=$1 AND m.aside AND (m.parent_id IS NULL OR m.parent_id IN (SELECT id FROM path))
+		)
+		SELECT id, chat_id, role, content, created_at, parent_id, model, aside, versions, rating FROM (
+			SELECT p.id, p.chat_id, p.role, p.content, p.created_at, p.parent_id, p.model, p.aside,
+				CASE WHEN p.aside THEN 1 ELSE (SELECT COUNT(*) FROM messages s
+					WHERE s.chat_id = p.chat_id AND s.parent_id IS NOT DISTINCT FROM p.parent_id AND s.role = p.role AND NOT s.aside) END AS versions,
+				(SELECT f.rating FROM message_feedback f JOIN chats c ON c.id = p.chat_id
+					WHERE f.message_id = p.id AND f.user_id = c.user_id) AS rating
+			FROM shown p
+			WHERE ($3::timestamptz IS NULL OR (p.created_at, p.id) < ($3, $4::text))
+			AND ($5::timestamptz IS NULL OR (p.created_at, p.id) > ($5, $6::text))
+			ORDER BY
+				CASE WHEN $5::timestamptz IS NULL THEN p.created_at END DESC,
+				CASE WHEN $5::timestamptz IS NULL THEN p.id END DESC,
+				p.created_at, p.id
+			LIMIT NULLIF($2, 0)
+		) page
+		ORDER BY created_at, id
+	`, chatID, p.Limit, beforeTime, beforeID, sinceTime, sinceID)
+	return messages, p.Limit > 0 && len(messages) == p.Limit, err
+}
+
+// MessageVersions returns a message and its other versions, i.e. messages of
+// the same role with the same parent, oldest first. An aside has no others.
+func MessageVersions(msg models.Message) ([]models.Message, error) {
+	if msg.Aside {
+		return []models.Message{msg}, nil
+	}
+	versions := []models.Message{}
+	err := config.DB.Select(&versions, `
+		SELECT * FROM messages
+		WHERE chat_id=$1 AND parent_id IS NOT DISTINCT FROM $2 AND role=$3 AND NOT aside
+		ORDER BY created_at, id
+	`, msg.ChatID, msg.ParentID, msg.Role)
+	return versions, err
+}
+
+// ReplyParent returns the learner message a bot reply answers
+func ReplyParent(msg models.Message) (models.Message, error) {
+	var parent models.Message
+	if msg.Role != "bot" || msg.ParentID == nil || msg.Aside {
+		return parent, ErrNotATutorReply
+	}
+	err := config.DB.Get(&parent, "SELECT * FROM messages WHERE id=$1", *msg.ParentID)
+	if err == sql.ErrNoRows || (err == nil && parent.Role != "user") {
+		return parent, ErrNotATutorReply
+	}
+	if err != nil {
+		return parent, err
+	}
+	// Commands are answered by the command, not the tutor
+	if _, isCommand := ParseIntent(parent.Content, ChatContext{}); isCommand {
+		return parent, ErrNotATutorReply
+	}
+	return parent, nil
+}
+
+// Branch is one line of conversation in a chat, identified by its leaf
+type Branch struct {
+	LeafID      string    `json:"leaf_id"`
+	Active      bool      `json:"active"`
+	Length      int       `json:"length"`      // Messages from the first to the leaf
+	ForkID      string    `json:"fork_id"`     // First message not shared with the active branch; empty for the active branch
+	ForkedFrom  *string   `json:"forked_from"` // Last message shared with the active branch; nil if they share none
+	LastRole    string    `json:"last_role"`
+	LastMessage string    `json:"last_message"` // Preview of the leaf
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// chatTree is a chat's messages indexed for walking the tree
+type chatTree struct {
+	byID     map[string]models.Message
+	children map[string][]string // Keyed by parent id, "" for first messages
+}
+
+// loadChatTree leaves asides out; they aren't part of any branch
+func loadChatTree(chatID string) (chatTree, error) {
+	var messages []models.Message
+	err := config.DB.Select(&messages, "SELECT * FROM messages WHERE chat_id=$1 AND NOT aside ORDER BY created_at, id", chatID)
+	t := chatTree{byID: map[string]models.Message{}, children: map[string][]string{}}
+	for _, m := range messages {
+		t.byID[m.ID] = m
+		parent := ""
+		if m.ParentID != nil {
+			parent = *m.ParentID
+		}
+		t.children[parent] = append(t.children[parent], m.ID)
+	}
+	return t, err
+}
+
+// path returns the ids from the first message to id
+func (t chatTree) path(id string) []string {
+	var ids []string
+	for seen := map[string]bool{}; id != "" && !seen[id]; {
+		seen[id] = true
+		ids = append([]string{id}, ids...)
+		m, ok := t.byID[id]
+		if !ok || m.ParentID == nil {
+			break
+		}
+		id = *m.ParentID
+	}
+	return ids
+}
+
+// latestLeaf returns the most recent leaf under id (or id itself)
+func (t chatTree) latestLeaf(id string) string {
+	best := ""
+	stack := []string{id}
+	for seen := map[string]bool{}; len(stack) > 0; {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[cur] {
+			continue
+		}
+		seen[cur] = true
+		kids := t.children[cur]
+		if len(kids) == 0 && (best == "" || t.byID[cur].CreatedAt.After(t.byID[best].CreatedAt)) {
+			best = cur
+		}
+		stack = append(stack, kids...)
+	}
+	if best == "" {
+		return id
+	}
+	return best
+}
+
+// activeLeaf returns the leaf the chat shows
+func (t chatTree) activeLeaf(chat models.Chat) string {
+	if chat.ActiveLeafID != nil {
+		if _, ok := t.byID[*chat.ActiveLeafID]; ok {
+			return *chat.ActiveLeafID
+		}
+	}
+	var latest models.Message
+	for _, m := range t.byID {
+		if latest.ID == "" || m.CreatedAt.After(latest.CreatedAt) {
+			latest = m
+		}
+	}
+	return latest.ID
+}
+
+// ChatBranches lists the branches of a chat, the active one first and then
+// the most recently updated
+func ChatBranches(chat models.Chat) ([]Branch, error) {
+	t, err := loadChatTree(chat.ID)
+	if err != nil {
+		return nil, err
+	}
+	active := t.activeLeaf(chat)
+	onActive := map[string]bool{}
+	for _, id := range t.path(active) {
+		onActive[id] = true
+	}
+
+	branches := []Branch{}
+	for id, m := range t.byID {
+		if len(t.children[id]) > 0 {
+			continue
+		}
+		path := t.path(id)
+		b := Branch{LeafID: id, Active: id == active, Length: len(path), LastRole: m.Role, UpdatedAt: m.CreatedAt}
+		if r := []rune(m.Content); len(r) > branchPreviewLength {
+			b.LastMessage = string(r[:branchPreviewLength]) + "…"
+		} else {
+			b.LastMessage = m.Content
+		}
+		if !b.Active {
+			for i, pid := range path {
+				if !onActive[pid] {
+					b.ForkID = pid
+					if i > 0 {
+						b.ForkedFrom = &path[i-1]
+					}
+					break
+				}
+			}
+		}
+		branches = append(branches, b)
+	}
+	sort.Slice(branches, func(i, j int) bool {
+		if branches[i].Active != branches[j].Active {
+			return branches[i].Active
+		}
+		return branches[i].UpdatedAt.After(branches[j].UpdatedAt)
+	})
+	return branches, nil
+}
+
+// SwitchBranch makes the chat show the branch through a message, continuing
+// to the most recent leaf below it, and returns that leaf
+func SwitchBranch(chat models.Chat, messageID string) (string, error) {
+	t, err := loadChatTree(chat.ID)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := t.byID[messageID]; !ok {
+		return "", ErrNotInChat
+	}
+	leaf := t.latestLeaf(messageID)
+	_, err = config.DB.Exec("UPDATE chats SET active_leaf_id=$2, updated_at=NOW() WHERE id=$1", chat.ID, leaf)
+	return leaf, err
+}
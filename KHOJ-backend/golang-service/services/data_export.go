@@ -0,0 +1,278 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+)
+
+// Data export job states
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+const (
+	// dataExportTTL is how long a finished zip can be downloaded
+	dataExportTTL = 7 * 24 * time.Hour
+	// dataExportStale is when a running job is presumed lost (e.g. the server
+	// restarted) and picked up again
+	dataExportStale    = 30 * time.Minute
+	dataExportInterval = time.Minute
+)
+
+// dataExportColumns are the columns of data_exports without the zip itself
+const dataExportColumns = "id, user_id, status, error, size_bytes, created_at, started_at, finished_at, expires_at"
+
+// dataExportTables are the per-user records a data export includes besides
+// the chats, one JSON file each. Secrets (password, refresh token, push
+// keys, calendar feed tokens) are left out.
+var dataExportTables = []struct {
+	name  string
+	query string
+}{
+	{"account", `SELECT id, username, email, role, timezone, leaderboard_opt_out, quiet_hours_start, quiet_hours_end,
+		dnd_days, preferred_hours_start, preferred_hours_end, created_at, updated_at FROM users WHERE id=$1`},
+	{"learner_profile", "SELECT * FROM learner_profiles WHERE user_id=$1"},
+	{"onboarding_answers", "SELECT * FROM user_answers WHERE user_id=$1"},
+	{"schedules", "SELECT * FROM schedules WHERE user_id=$1 ORDER BY id"},
+	{"reminders", "SELECT * FROM reminder_instances WHERE user_id=$1 ORDER BY id"},
+	{"reminder_deliveries", "SELECT * FROM reminder_deliveries WHERE user_id=$1 ORDER BY id"},
+	{"study_plans", "SELECT * FROM study_plans WHERE user_id=$1 ORDER BY id"},
+	{"study_plan_items", "SELECT * FROM study_plan_items WHERE user_id=$1 ORDER BY id"},
+	{"xp_events", "SELECT * FROM xp_events WHERE user_id=$1 ORDER BY id"},
+	{"gamification", "SELECT * FROM user_gamification WHERE user_id=$1"},
+	{"achievements", "SELECT * FROM user_achievements WHERE user_id=$1"},
+	{"study_groups", "SELECT * FROM study_group_members WHERE user_id=$1"},
+	{"notification_preferences", "SELECT * FROM notification_preferences WHERE user_id=$1"},
+	{"notification_deliveries", "SELECT * FROM notification_deliveries WHERE user_id=$1 ORDER BY id"},
+	{"reply_ratings", "SELECT * FROM message_feedback WHERE user_id=$1"},
+	{"question_flags", "SELECT * FROM bank_question_flags WHERE user_id=$1"},
+	{"flashcard_decks", "SELECT * FROM flashcard_decks WHERE user_id=$1 ORDER BY id"},
+	{"flashcards", "SELECT * FROM flashcards WHERE user_id=$1 ORDER BY id"},
+	{"flashcard_reviews", "SELECT * FROM flashcard_reviews WHERE user_id=$1 ORDER BY id"},
+	{"fact_deliveries", `SELECT d.*, f.fact FROM fact_deliveries d JOIN topic_facts f ON f.id = d.fact_id
+		WHERE d.user_id=$1 ORDER BY d.id`},
+	{"fact_mutes", "SELECT * FROM fact_mutes WHERE user_id=$1 ORDER BY created_at"},
+}
+
+const dataExportReadme = `Your KHOJ data
+
+chats/      One folder per chat: chat.md to read, chat.json with every
+            message (including edited and regenerated versions) and quizzes
+data/       Everything else about your account, one JSON file per kind of
+            record: profile, onboarding answers, reminders, study plans,
+            achievements, flashcards, daily facts, notification settings and
+            ratings
+`
+
+// exportWake starts the export worker as soon as a job is requested
+var exportWake = make(chan struct{}, 1)
+
+// RequestDataExport queues a data export for a user. A user has at most one
+// job in progress; asking again returns it (created is false).
+func RequestDataExport(userID int) (job models.DataExport, created bool, err error) {
+	err = config.DB.Get(&job, `
+		INSERT INTO data_exports (user_id) VALUES ($1)
+		ON CONFLICT (user_id) WHERE status IN ('pending', 'running') DO NOTHING
+		RETURNING `+dataExportColumns, userID)
+	if err == sql.ErrNoRows {
+		err = config.DB.Get(&job, "SELECT "+dataExportColumns+" FROM data_exports WHERE user_id=$1 AND status IN ('pending', 'running')", userID)
+		return job, false, err
+	}
+	if err != nil {
+		return job, false, err
+	}
+	select {
+	case exportWake <- struct{}{}:
+	default:
+	}
+	return job, true, nil
+}
+
+// DataExports lists a user's recent export jobs, newest first
+func DataExports(userID int) ([]models.DataExport, error) {
+	jobs := []models.DataExport{}
+	err := config.DB.Select(&jobs, "SELECT "+dataExportColumns+" FROM data_exports WHERE user_id=$1 ORDER BY created_at DESC LIMIT 20", userID)
+	return jobs, err
+}
+
+// DataExport loads one of a user's export jobs, with the zip when withArchive
+func DataExport(userID int, id int, withArchive bool) (models.DataExport, error) {
+	var job models.DataExport
+	columns := dataExportColumns
+	if withArchive {
+		columns += ", archive"
+	}
+	err := config.DB.Get(&job, "SELECT "+columns+" FROM data_exports WHERE id=$1 AND user_id=$2", id, userID)
+	return job, err
+}
+
+// runDataExport claims the oldest waiting job (or one whose worker was lost)
+// and builds its zip. It returns false when there was nothing to do.
+func runDataExport(ctx context.Context) (bool, error) {
+	var job models.DataExport
+	err := config.DB.Get(&job, `
+		UPDATE data_exports SET status=$1, started_at=NOW(), error=''
+		WHERE id = (
+			SELECT id FROM data_exports
+			WHERE status=$2 OR (status=$1 AND started_at < $3)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+dataExportColumns, ExportRunning, ExportPending, time.Now().Add(-dataExportStale))
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	archive, buildErr := buildDataExport(ctx, job.UserID)
+	if buildErr != nil {
+		_, err = config.DB.Exec("UPDATE data_exports SET status=$2, error=$3, finished_at=NOW() WHERE id=$1", job.ID, ExportFailed, buildErr.Error())
+		if err != nil {
+			return true, err
+		}
+		return true, fmt.Errorf("data export %d: %w", job.ID, buildErr)
+	}
+	_, err = config.DB.Exec(`
+		UPDATE data_exports SET status=$2, archive=$3, size_bytes=$4, finished_at=NOW(), expires_at=$5
+		WHERE id=$1
+	`, job.ID, ExportDone, archive, len(archive), time.Now().Add(dataExportTTL))
+	return true, err
+}
+
+// buildDataExport zips everything stored about a user
+func buildDataExport(ctx context.Context, userID int) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, data []byte) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	if err := add("README.txt", []byte(dataExportReadme)); err != nil {
+		return nil, err
+	}
+
+	var chats []models.Chat
+	if err := config.DB.Select(&chats, "SELECT * FROM chats WHERE user_id=$1 ORDER BY created_at, id", userID); err != nil {
+		return nil, err
+	}
+	for _, chat := range chats {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		a, err := LoadChatArchive(chat)
+		if err != nil {
+			return nil, fmt.Errorf("chat %s: %w", chat.ID, err)
+		}
+		dir := "chats/" + ChatExportName(chat) + "/"
+		if err := add(dir+"chat.md", []byte(chatMarkdown(a))); err != nil {
+			return nil, err
+		}
+		out, err := chatJSON(a)
+		if err == nil {
+			err = add(dir+"chat.json", out)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, t := range dataExportTables {
+		rows, err := userRows(ctx, t.query, userID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+		out, err := json.MarshalIndent(rows, "", "  ")
+		if err == nil {
+			err = add("data/"+t.name+".json", out)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// userRows reads a query's rows as column-to-value maps for JSON
+func userRows(ctx context.Context, query string, userID int) ([]map[string]interface{}, error) {
+	rows, err := config.DB.QueryxContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []map[string]interface{}{}
+	for rows.Next() {
+		row := map[string]interface{}{}
+		if err := rows.MapScan(row); err != nil {
+			return nil, err
+		}
+		for k, v := range row {
+			// Text comes back as bytes, which JSON would base64
+			if b, ok := v.([]byte); ok {
+				row[k] = string(b)
+			}
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// purgeDataExports deletes expired zips and old failed jobs
+func purgeDataExports() error {
+	_, err := config.DB.Exec(`
+		DELETE FROM data_exports
+		WHERE expires_at < NOW() OR (status=$1 AND finished_at < $2)
+	`, ExportFailed, time.Now().Add(-dataExportTTL))
+	return err
+}
+
+// StartDataExports runs requested data exports in the background, one at a
+// time, and deletes them once expired
+func StartDataExports(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(dataExportInterval)
+		defer ticker.Stop()
+		for {
+			for {
+				ran, err := runDataExport(ctx)
+				if err != nil {
+					fmt.Printf("Warning: %v\n", err)
+				} else if ran {
+					fmt.Printf("📦 Finished a data export\n")
+				}
+				if !ran || ctx.Err() != nil {
+					break
+				}
+			}
+			if err := purgeDataExports(); err != nil {
+				fmt.Printf("Warning: failed to purge data exports: %v\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-exportWake:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EmbeddingModel is the Gemini model used for text embeddings
+const EmbeddingModel = "text-embedding-004"
+
+// EmbedText returns an embedding vector for text using the Gemini REST API
+func EmbedText(ctx context.Context, apiKey string, text string) ([]float64, error) {
+	if strings.TrimSpace(apiKey) == "" {
+		return nil, fmt.Errorf("GEMINI_API_KEY not set")
+	}
+
+	bodyObj := map[string]interface{}{
+		"model": "models/" + EmbeddingModel,
+		"content": map[string]interface{}{
+			"parts": []interface{}{map[string]interface{}{"text": text}},
+		},
+	}
+	b, _ := json.Marshal(bodyObj)
+	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:embedContent?key=%s", EmbeddingModel, apiKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embedding request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("embedding request failed with status %d", resp.StatusCode)
+	}
+
+	var parsed struct {
+		Embedding struct {
+			Values []float64 `json:"values"`
+		} `json:"embedding"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse embedding response: %w", err)
+	}
+	if len(parsed.Embedding.Values) == 0 {
+		return nil, fmt.Errorf("embedding response was empty")
+	}
+	return parsed.Embedding.Values, nil
+}
+
+// EncodeEmbedding serializes a vector for storage in a TEXT column
+func EncodeEmbedding(v []float64) string {
+	if len(v) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// DecodeEmbedding parses a stored vector; empty or invalid input yields nil
+func DecodeEmbedding(s string) []float64 {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	var v []float64
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil
+	}
+	return v
+}
+
+// CosineSimilarity returns the cosine of the angle between a and b, or 0 when
+// the vectors are empty or of different lengths
+func CosineSimilarity(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
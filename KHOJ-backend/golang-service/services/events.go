@@ -0,0 +1,60 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Learning events published by the handlers
+const (
+	EventMessageSent     = "message_sent"
+	EventQuizCompleted   = "quiz_completed"
+	EventReviewCompleted = "review_completed"
+)
+
+// Event describes something a learner did. SourceID identifies the record
+// behind it (message id, quiz id, ...) so subscribers can process it idempotently.
+type Event struct {
+	Type     string
+	UserID   int
+	SourceID string
+	Topic    string
+	Score    int // Quiz events: correct answers
+	Total    int // Quiz events: number of questions
+	At       time.Time
+}
+
+var (
+	subscribersMu sync.RWMutex
+	subscribers   = map[string][]func(Event){}
+)
+
+// Subscribe registers h to be called for every published event of the given type
+func Subscribe(eventType string, h func(Event)) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	subscribers[eventType] = append(subscribers[eventType], h)
+}
+
+// Publish delivers e to its subscribers in the background so request
+// handlers never wait on (or fail because of) side effects such as XP
+func Publish(e Event) {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	subscribersMu.RLock()
+	handlers := subscribers[e.Type]
+	subscribersMu.RUnlock()
+
+	for _, h := range handlers {
+		go func(h func(Event)) {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Printf("Warning: %s event handler panicked: %v\n", e.Type, r)
+				}
+			}()
+			h(e)
+		}(h)
+	}
+}
@@ -0,0 +1,635 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Fact and fact delivery statuses
+const (
+	FactActive  = "active"
+	FactRetired = "retired"
+
+	FactScheduled = "scheduled"
+	FactSent      = "sent"
+	FactCancelled = "cancelled"
+	FactFailed    = "failed" // Could not be settled; not retried
+)
+
+const (
+	// FactMessagePrefix starts the in-app message a fact is posted as, so the
+	// chat digest can leave it out
+	FactMessagePrefix = "💡 Did you know? "
+	// MaxFactLength caps a generated fact; longer ones are dropped
+	MaxFactLength = 300
+	// factBatchSize is how many facts one generation asks for
+	factBatchSize = 8
+	// factPoolMin is how many unretired facts a topic keeps in stock
+	factPoolMin = 5
+	// factRefillsPerSweep caps how many topics are topped up per sweep, to
+	// keep the background load on Gemini low
+	factRefillsPerSweep = 3
+	// factExistingLimit caps the stored facts the prompt is told to avoid
+	factExistingLimit = 50
+	// factTopicLimit is how many of the learner's latest topics facts are
+	// drawn from
+	factTopicLimit = 5
+	// factRetireDownvotes retires a fact with at least this many thumbs down
+	// and more down than up
+	factRetireDownvotes = 3
+	// factDefaultWindowStart and factDefaultWindowEnd bound delivery for
+	// learners without preferred hours
+	factDefaultWindowStart = "09:00"
+	factDefaultWindowEnd   = "20:00"
+	// factSweepInterval is how often facts are planned and sent
+	factSweepInterval = 5 * time.Minute
+)
+
+var (
+	// ErrFactNotReceived is returned when rating or muting a fact the learner
+	// was never sent
+	ErrFactNotReceived = errors.New("fact not found or not sent to this user")
+	// ErrInvalidFactRating is returned for a rating other than 1, -1 or 0
+	ErrInvalidFactRating = errors.New("rating must be 1 (helpful), -1 (not helpful) or 0 (clear)")
+	// ErrNoFactTopic is returned when muting without a topic
+	ErrNoFactTopic = errors.New("a topic is required")
+)
+
+// UserFact is a fact sent to a learner, with their rating
+type UserFact struct {
+	models.FactDelivery
+	Fact string `db:"fact" json:"fact"`
+}
+
+// FactsEnabled reports whether the learner opted into fact notifications
+// during onboarding (or later in their profile)
+func FactsEnabled(userID int) (bool, error) {
+	var enabled bool
+	err := config.DB.Get(&enabled, "SELECT facts_notifications FROM learner_profiles WHERE user_id=$1", userID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// UserFacts returns the facts sent to a learner, newest first
+func UserFacts(userID int, limit int) ([]UserFact, error) {
+	facts := []UserFact{}
+	err := config.DB.Select(&facts, `
+		SELECT d.*, f.fact FROM fact_deliveries d JOIN topic_facts f ON f.id = d.fact_id
+		WHERE d.user_id=$1 AND d.status=$2
+		ORDER BY d.sent_at DESC, d.id DESC
+		LIMIT $3
+	`, userID, FactSent, limit)
+	return facts, err
+}
+
+// NextUserFact returns the learner's planned fact delivery, without the fact
+// so it stays a surprise
+func NextUserFact(userID int) (*models.FactDelivery, error) {
+	var d models.FactDelivery
+	err := config.DB.Get(&d, "SELECT * FROM fact_deliveries WHERE user_id=$1 AND status=$2 ORDER BY scheduled_for LIMIT 1", userID, FactScheduled)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// FactMutes lists the topics a learner muted facts for
+func FactMutes(userID int) ([]models.FactMute, error) {
+	mutes := []models.FactMute{}
+	err := config.DB.Select(&mutes, "SELECT * FROM fact_mutes WHERE user_id=$1 ORDER BY created_at, topic_key", userID)
+	return mutes, err
+}
+
+// MuteFactTopic stops facts on a topic for a learner and cancels a planned
+// one on it
+func MuteFactTopic(userID int, topic string) (models.FactMute, error) {
+	var mute models.FactMute
+	key := CanonicalTopic(topic)
+	if key == "" {
+		return mute, ErrNoFactTopic
+	}
+	err := config.DB.Get(&mute, `
+		INSERT INTO fact_mutes (user_id, topic_key, topic) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, topic_key) DO UPDATE SET topic_key=EXCLUDED.topic_key
+		RETURNING *
+	`, userID, key, strings.TrimSpace(topic))
+	if err != nil {
+		return mute, err
+	}
+	_, err = config.DB.Exec("UPDATE fact_deliveries SET status=$4 WHERE user_id=$1 AND topic_key=$2 AND status=$3",
+		userID, key, FactScheduled, FactCancelled)
+	return mute, err
+}
+
+// UnmuteFactTopic lets facts on a topic through again. It reports whether
+// the topic was muted.
+func UnmuteFactTopic(userID int, topic string) (bool, error) {
+	res, err := config.DB.Exec("DELETE FROM fact_mutes WHERE user_id=$1 AND topic_key=$2", userID, CanonicalTopic(topic))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// MuteFact mutes the topic of a fact the learner was sent
+func MuteFact(userID int, factID int) (models.FactMute, error) {
+	var topic string
+	err := config.DB.Get(&topic, "SELECT topic FROM fact_deliveries WHERE user_id=$1 AND fact_id=$2 AND status=$3", userID, factID, FactSent)
+	if err == sql.ErrNoRows {
+		return models.FactMute{}, ErrFactNotReceived
+	}
+	if err != nil {
+		return models.FactMute{}, err
+	}
+	return MuteFactTopic(userID, topic)
+}
+
+// RateFact records a learner's thumbs up (1) or down (-1) on a fact they
+// were sent; 0 clears it. Vote counts are kept on the fact, and a fact most
+// learners dislike is retired so nobody else is sent it.
+func RateFact(userID int, factID int, rating int) (UserFact, error) {
+	var fact UserFact
+	if rating < -1 || rating > 1 {
+		return fact, ErrInvalidFactRating
+	}
+	var value *int
+	if rating != 0 {
+		value = &rating
+	}
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return fact, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE fact_deliveries SET rating=$3 WHERE user_id=$1 AND fact_id=$2 AND status=$4", userID, factID, value, FactSent)
+	if err != nil {
+		return fact, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fact, err
+	} else if n == 0 {
+		return fact, ErrFactNotReceived
+	}
+	_, err = tx.Exec(`
+		UPDATE topic_facts f SET upvotes=v.up, downvotes=v.down,
+			status=CASE WHEN v.down >= $2 AND v.down > v.up THEN $3 ELSE f.status END
+		FROM (
+			SELECT COUNT(*) FILTER (WHERE rating = 1) AS up, COUNT(*) FILTER (WHERE rating = -1) AS down
+			FROM fact_deliveries WHERE fact_id=$1
+		) v
+		WHERE f.id=$1
+	`, factID, factRetireDownvotes, FactRetired)
+	if err != nil {
+		return fact, err
+	}
+	err = tx.Get(&fact, `
+		SELECT d.*, f.fact FROM fact_deliveries d JOIN topic_facts f ON f.id = d.fact_id
+		WHERE d.user_id=$1 AND d.fact_id=$2
+	`, userID, factID)
+	if err != nil {
+		return fact, err
+	}
+	return fact, tx.Commit()
+}
+
+// RefillTopicFacts has Gemini write a batch of facts on a topic, checks each
+// one with a second prompt and stores those that pass. Facts already stored
+// for the topic are skipped. It returns how many were added.
+func RefillTopicFacts(ctx context.Context, apiKey string, topic string) (int, error) {
+	key := CanonicalTopic(topic)
+	if key == "" {
+		return 0, ErrNoFactTopic
+	}
+	data := FactPromptData{Count: factBatchSize, Topic: topic}
+	err := config.DB.Select(&data.Existing, `
+		SELECT fact FROM topic_facts WHERE topic_key=$1 ORDER BY created_at DESC, id DESC LIMIT $2
+	`, key, factExistingLimit)
+	if err != nil {
+		return 0, err
+	}
+	prompt, err := RenderPrompt(PromptFacts, 0, data)
+	if err != nil {
+		return 0, err
+	}
+	out, model, err := GenerateGeminiText(ctx, apiKey, "", prompt)
+	if err != nil {
+		return 0, err
+	}
+	usageID := LogPromptUsage(prompt, 0, model, PromptSourceFacts, key)
+
+	var generated []string
+	if err := json.Unmarshal([]byte(trimCodeFence(out)), &generated); err != nil {
+		RecordPromptOutcome(usageID, MetricValidationFailure, 1)
+		return 0, fmt.Errorf("failed to parse generated facts: %w", err)
+	}
+	added := 0
+	for _, fact := range generated {
+		fact = strings.TrimSpace(fact)
+		normalized := NormalizeQuestionText(fact)
+		if normalized == "" || len([]rune(fact)) > MaxFactLength {
+			continue
+		}
+		var known bool
+		err := config.DB.Get(&known, "SELECT EXISTS(SELECT 1 FROM topic_facts WHERE topic_key=$1 AND normalized_fact=$2)", key, normalized)
+		if err != nil {
+			return added, err
+		}
+		if known {
+			continue
+		}
+		ok, err := checkFact(ctx, apiKey, topic, key, fact)
+		if err != nil {
+			return added, err
+		}
+		if !ok {
+			continue
+		}
+		res, err := config.DB.Exec(`
+			INSERT INTO topic_facts (topic_key, fact, normalized_fact, model) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (topic_key, normalized_fact) DO NOTHING
+		`, key, fact, normalized, model)
+		if err != nil {
+			return added, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			added++
+		}
+	}
+	if added == 0 && len(generated) == 0 {
+		RecordPromptOutcome(usageID, MetricValidationFailure, 1)
+	}
+	return added, nil
+}
+
+// checkFact asks Gemini whether a generated fact holds up. Anything but a
+// clear YES rejects it.
+func checkFact(ctx context.Context, apiKey string, topic string, key string, fact string) (bool, error) {
+	prompt, err := RenderPrompt(PromptFactCheck, 0, FactCheckPromptData{Topic: topic, Fact: fact})
+	if err != nil {
+		return false, err
+	}
+	out, model, err := GenerateGeminiText(ctx, apiKey, "", prompt)
+	if err != nil {
+		return false, err
+	}
+	usageID := LogPromptUsage(prompt, 0, model, PromptSourceFactCheck, key)
+	answer := strings.ToUpper(strings.Trim(strings.TrimSpace(out), "\"'.*`"))
+	if answer != "YES" && answer != "NO" {
+		RecordPromptOutcome(usageID, MetricValidationFailure, 1)
+	}
+	return answer == "YES", nil
+}
+
+// factTopic is a topic a learner studies, with the chat the fact goes to
+type factTopic struct {
+	Topic  string
+	Key    string
+	ChatID string
+}
+
+// factTopics returns the learner's latest topics, one per canonical topic,
+// leaving out muted ones. Topics they were sent a fact on least recently
+// come first, so facts rotate between topics.
+func factTopics(userID int) ([]factTopic, error) {
+	var chats []models.Chat
+	if err := config.DB.Select(&chats, "SELECT * FROM chats WHERE user_id=$1 ORDER BY updated_at DESC LIMIT 50", userID); err != nil {
+		return nil, err
+	}
+	var muted []string
+	if err := config.DB.Select(&muted, "SELECT topic_key FROM fact_mutes WHERE user_id=$1", userID); err != nil {
+		return nil, err
+	}
+	var recent []string
+	err := config.DB.Select(&recent, `
+		SELECT topic_key FROM fact_deliveries WHERE user_id=$1 AND status<>$2
+		GROUP BY topic_key ORDER BY MAX(local_date) DESC
+	`, userID, FactCancelled)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, k := range muted {
+		seen[k] = true
+	}
+	var topics []factTopic
+	for _, c := range chats {
+		key := CanonicalTopic(c.Topic)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		topics = append(topics, factTopic{Topic: c.Topic, Key: key, ChatID: c.ID})
+		if len(topics) == factTopicLimit {
+			break
+		}
+	}
+	// recent is newest first: a topic further down it (or missing) is due
+	rank := map[string]int{}
+	for i, k := range recent {
+		rank[k] = len(recent) - i
+	}
+	sort.SliceStable(topics, func(i, j int) bool { return rank[topics[i].Key] < rank[topics[j].Key] })
+	return topics, nil
+}
+
+// unsentFact picks an active fact on the topic the learner was never sent,
+// best rated first
+func unsentFact(userID int, key string) (models.TopicFact, error) {
+	var fact models.TopicFact
+	err := config.DB.Get(&fact, `
+		SELECT * FROM topic_facts f
+		WHERE f.topic_key=$2 AND f.status=$3
+			AND NOT EXISTS (SELECT 1 FROM fact_deliveries d WHERE d.user_id=$1 AND d.fact_id=f.id)
+		ORDER BY f.upvotes - f.downvotes DESC, f.created_at, f.id
+		LIMIT 1
+	`, userID, key, FactActive)
+	return fact, err
+}
+
+// factWindow is when the learner likes to study: their preferred hours, else
+// the first window from onboarding, else factDefaultWindowStart-End
+func factWindow(userID int) (string, string) {
+	var prefs models.ReminderPreferences
+	if err := config.DB.Get(&prefs, "SELECT preferred_hours_start, preferred_hours_end FROM users WHERE id=$1", userID); err == nil && prefs.PreferredHoursStart != "" {
+		return prefs.PreferredHoursStart, prefs.PreferredHoursEnd
+	}
+	var windows []string
+	var profile models.LearnerProfile
+	if err := config.DB.Get(&profile, "SELECT * FROM learner_profiles WHERE user_id=$1", userID); err == nil {
+		windows = profile.PreferredWindows
+	}
+	if len(windows) > 0 {
+		if start, end, err := ValidatePreferredWindow(windows[0]); err == nil {
+			return start, end
+		}
+	}
+	return factDefaultWindowStart, factDefaultWindowEnd
+}
+
+// planUserFact schedules the learner's next fact: today if today's window
+// hasn't passed and they haven't had one, otherwise tomorrow. The moment is
+// picked inside their preferred window the way reminders are, around quiet
+// hours. It reports whether a fact was scheduled.
+func planUserFact(ctx context.Context, apiKey string, userID int, now time.Time) (bool, error) {
+	loc := UserLocation(userID)
+	q := UserQuietHours(userID)
+	start, end := factWindow(userID)
+	from, err := ParseClock(start)
+	if err != nil {
+		return false, err
+	}
+
+	today := now.In(loc)
+	for offset := 0; offset < 2; offset++ {
+		occurrence := LocalTime(today.Year(), today.Month(), today.Day()+offset, from/60, from%60, 0, loc)
+		day := localDate(occurrence, loc)
+		var planned bool
+		if err := config.DB.Get(&planned, "SELECT EXISTS(SELECT 1 FROM fact_deliveries WHERE user_id=$1 AND local_date=$2)", userID, day); err != nil {
+			return false, err
+		}
+		if planned {
+			return false, nil
+		}
+
+		s := models.Schedule{UserID: userID, Topic: "facts", ReminderTime: start, ReminderTimeEnd: end, WindowStrategy: WindowActivity}
+		fire := PlanFireTime(s, occurrence, q)
+		if fire.Before(now) {
+			if !now.Before(occurrence.Add(reminderWindow(s))) || !q.Allowed(now) {
+				continue
+			}
+			fire = now
+		}
+		return scheduleFact(ctx, apiKey, userID, day, fire)
+	}
+	return false, nil
+}
+
+// scheduleFact picks the topic and fact for one day's delivery, generating
+// more facts for a topic the learner has used up
+func scheduleFact(ctx context.Context, apiKey string, userID int, day time.Time, fire time.Time) (bool, error) {
+	topics, err := factTopics(userID)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range topics {
+		fact, err := unsentFact(userID, t.Key)
+		if err == sql.ErrNoRows && apiKey != "" {
+			if _, err := RefillTopicFacts(ctx, apiKey, t.Topic); err != nil {
+				fmt.Printf("Warning: failed to generate facts on %q: %v\n", t.Topic, err)
+				continue
+			}
+			fact, err = unsentFact(userID, t.Key)
+		}
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		res, err := config.DB.Exec(`
+			INSERT INTO fact_deliveries (user_id, fact_id, topic, topic_key, chat_id, local_date, scheduled_for)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT DO NOTHING
+		`, userID, fact.ID, t.Topic, t.Key, t.ChatID, day, fire)
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		return n > 0, err
+	}
+	return false, nil
+}
+
+// PlanFacts schedules a fact for every opted-in learner who has none
+// planned. It returns how many were scheduled.
+func PlanFacts(ctx context.Context, apiKey string, now time.Time) (int, error) {
+	var users []int
+	err := config.DB.Select(&users, `
+		SELECT p.user_id FROM learner_profiles p
+		WHERE p.facts_notifications
+			AND NOT EXISTS (SELECT 1 FROM fact_deliveries d WHERE d.user_id = p.user_id AND d.status=$1)
+		ORDER BY p.user_id
+	`, FactScheduled)
+	if err != nil {
+		return 0, err
+	}
+	planned := 0
+	for _, userID := range users {
+		if ctx.Err() != nil {
+			break
+		}
+		ok, err := planUserFact(ctx, apiKey, userID, now)
+		if err != nil {
+			fmt.Printf("Warning: failed to plan a fact for user %d: %v\n", userID, err)
+			continue
+		}
+		if ok {
+			planned++
+		}
+	}
+	return planned, nil
+}
+
+// deliverDueFact sends one fact whose time has come. A fact is cancelled
+// instead if the learner opted out, muted its topic or it was retired since
+// it was planned. It reports whether there was a fact to handle; a fact that
+// fails is marked failed so the sweep can go on to the next one.
+func deliverDueFact(ctx context.Context, now time.Time) (bool, error) {
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var d models.FactDelivery
+	err = tx.Get(&d, `
+		SELECT * FROM fact_deliveries WHERE status=$1 AND scheduled_for <= $2
+		ORDER BY scheduled_for, id LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, FactScheduled, now)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	fact, status, err := settleFactDelivery(tx, d, now)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
+		if _, markErr := config.DB.Exec("UPDATE fact_deliveries SET status=$2 WHERE id=$1 AND status=$3", d.ID, FactFailed, FactScheduled); markErr != nil {
+			// Still scheduled: stop here rather than pick it again
+			return false, err
+		}
+		return true, fmt.Errorf("fact delivery %d failed: %w", d.ID, err)
+	}
+	if status != FactSent {
+		return true, nil
+	}
+
+	// The fact is posted as an aside so the chat doesn't continue from it
+	// and doesn't move to the top of the learner's list
+	n := Notification{
+		UserID:    d.UserID,
+		Kind:      NotifyDailyFact,
+		Data:      map[string]interface{}{"topic": d.Topic, "fact": fact.Fact, "fact_id": fact.ID},
+		DedupeKey: fmt.Sprintf("fact-%d", d.ID),
+		Aside:     true,
+	}
+	if d.ChatID != nil {
+		n.ChatID = *d.ChatID
+	}
+	Notify(ctx, n)
+	return true, nil
+}
+
+// settleFactDelivery decides whether a locked delivery is sent or cancelled
+// and records it
+func settleFactDelivery(tx *sqlx.Tx, d models.FactDelivery, now time.Time) (models.TopicFact, string, error) {
+	var fact models.TopicFact
+	if err := tx.Get(&fact, "SELECT * FROM topic_facts WHERE id=$1", d.FactID); err != nil {
+		return fact, "", err
+	}
+	var wanted bool
+	err := tx.Get(&wanted, `
+		SELECT COALESCE((SELECT facts_notifications FROM learner_profiles WHERE user_id=$1), false)
+			AND NOT EXISTS (SELECT 1 FROM fact_mutes WHERE user_id=$1 AND topic_key=$2)
+	`, d.UserID, d.TopicKey)
+	if err != nil {
+		return fact, "", err
+	}
+	status, sentAt := FactSent, &now
+	if !wanted || fact.Status != FactActive {
+		status, sentAt = FactCancelled, nil
+	}
+	if _, err := tx.Exec("UPDATE fact_deliveries SET status=$2, sent_at=$3 WHERE id=$1", d.ID, status, sentAt); err != nil {
+		return fact, "", err
+	}
+	return fact, status, nil
+}
+
+// refillFactPools tops up the topics opted-in learners study that are
+// running low on facts, a few per sweep
+func refillFactPools(ctx context.Context, apiKey string) error {
+	var topics []string
+	err := config.DB.Select(&topics, `
+		SELECT DISTINCT ON (d.topic_key) d.topic FROM fact_deliveries d
+		JOIN learner_profiles p ON p.user_id = d.user_id AND p.facts_notifications
+		WHERE (SELECT COUNT(*) FROM topic_facts f WHERE f.topic_key = d.topic_key AND f.status=$1) < $2
+		ORDER BY d.topic_key, d.created_at DESC
+		LIMIT $3
+	`, FactActive, factPoolMin, factRefillsPerSweep)
+	if err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		if ctx.Err() != nil {
+			break
+		}
+		if _, err := RefillTopicFacts(ctx, apiKey, topic); err != nil {
+			fmt.Printf("Warning: failed to generate facts on %q: %v\n", topic, err)
+		}
+	}
+	return nil
+}
+
+// StartFacts plans and sends daily topic facts in the background. Without a
+// Gemini key only facts already stored are sent.
+func StartFacts(ctx context.Context) {
+	apiKey := ResolveGeminiAPIKeyFromEnv()
+	if apiKey == "" {
+		fmt.Printf("Warning: GEMINI_API_KEY not set; daily facts use stored facts only\n")
+	}
+	go func() {
+		ticker := time.NewTicker(factSweepInterval)
+		defer ticker.Stop()
+		for {
+			now := time.Now()
+			if n, err := PlanFacts(ctx, apiKey, now); err != nil {
+				fmt.Printf("Warning: failed to plan facts: %v\n", err)
+			} else if n > 0 {
+				fmt.Printf("💡 Planned %d daily fact(s)\n", n)
+			}
+			for ctx.Err() == nil {
+				ran, err := deliverDueFact(ctx, now)
+				if err != nil {
+					fmt.Printf("Warning: failed to send a fact: %v\n", err)
+				}
+				if !ran {
+					break
+				}
+			}
+			if apiKey != "" {
+				if err := refillFactPools(ctx, apiKey); err != nil {
+					fmt.Printf("Warning: failed to top up facts: %v\n", err)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
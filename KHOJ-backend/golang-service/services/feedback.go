@@ -0,0 +1,143 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+)
+
+// Ratings of a bot reply
+const (
+	RatingUp   = 1
+	RatingDown = -1
+
+	// MaxFeedbackReason caps the length of a rating's reason
+	MaxFeedbackReason = 500
+)
+
+// ErrNotABotReply is returned when rating a message the tutor didn't write
+var ErrNotABotReply = errors.New("only bot replies can be rated")
+
+// RateMessage stores a learner's rating of a bot reply, replacing any earlier
+// one, and records it against the prompt versions behind the reply
+func RateMessage(msg models.Message, userID int, rating int, reason string) (models.MessageFeedback, error) {
+	var fb models.MessageFeedback
+	if msg.Role != "bot" {
+		return fb, ErrNotABotReply
+	}
+	if r := []rune(reason); len(r) > MaxFeedbackReason {
+		reason = string(r[:MaxFeedbackReason])
+	}
+	err := config.DB.Get(&fb, `
+		INSERT INTO message_feedback (message_id, user_id, rating, reason, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (message_id, user_id) DO UPDATE SET rating=EXCLUDED.rating, reason=EXCLUDED.reason, updated_at=EXCLUDED.updated_at
+		RETURNING *
+	`, msg.ID, userID, rating, reason, time.Now())
+	if err != nil {
+		return fb, err
+	}
+	recordRatingOutcome(msg.ID, rating)
+	return fb, nil
+}
+
+// ClearMessageRating removes a learner's rating of a reply
+func ClearMessageRating(messageID string, userID int) error {
+	if _, err := config.DB.Exec("DELETE FROM message_feedback WHERE message_id=$1 AND user_id=$2", messageID, userID); err != nil {
+		return err
+	}
+	recordRatingOutcome(messageID, 0)
+	return nil
+}
+
+// recordRatingOutcome replaces the thumbs outcome of the prompts behind a
+// reply, so changing a rating doesn't count twice. Failures are only logged.
+func recordRatingOutcome(messageID string, rating int) {
+	if err := ClearPromptOutcomesForSource(PromptSourceMessage, messageID, MetricThumbsUp, MetricThumbsDown); err != nil {
+		fmt.Printf("Warning: failed to record rating of message %s: %v\n", messageID, err)
+		return
+	}
+	metric := MetricThumbsUp
+	switch rating {
+	case 0:
+		return
+	case RatingDown:
+		metric = MetricThumbsDown
+	}
+	if _, err := RecordPromptOutcomeForSource(PromptSourceMessage, messageID, metric, 1); err != nil {
+		fmt.Printf("Warning: failed to record rating of message %s: %v\n", messageID, err)
+	}
+}
+
+// FeedbackReportRow summarises the ratings of replies on one topic by one
+// model
+type FeedbackReportRow struct {
+	Topic     string    `db:"topic" json:"topic"`
+	Model     string    `db:"model" json:"model"`
+	Rated     int       `db:"rated" json:"rated"`
+	Up        int       `db:"up" json:"up"`
+	Down      int       `db:"down" json:"down"`
+	DownRate  float64   `db:"down_rate" json:"down_rate"`
+	LastRated time.Time `db:"last_rated" json:"last_rated"`
+}
+
+// FeedbackReport groups the ratings given since a time by topic and model,
+// worst rated first. Groups with fewer than minRated ratings are left out
+// as too small to judge.
+func FeedbackReport(since time.Time, minRated int) ([]FeedbackReportRow, error) {
+	rows := []FeedbackReportRow{}
+	err := config.DB.Select(&rows, `
+		SELECT LOWER(c.topic) AS topic, m.model,
+			COUNT(*) AS rated,
+			COUNT(*) FILTER (WHERE f.rating > 0) AS up,
+			COUNT(*) FILTER (WHERE f.rating < 0) AS down,
+			COUNT(*) FILTER (WHERE f.rating < 0)::float / COUNT(*) AS down_rate,
+			MAX(f.updated_at) AS last_rated
+		FROM message_feedback f
+		JOIN messages m ON m.id = f.message_id
+		JOIN chats c ON c.id = m.chat_id
+		WHERE f.updated_at >= $1
+		GROUP BY LOWER(c.topic), m.model
+		HAVING COUNT(*) >= $2
+		ORDER BY down_rate DESC, down DESC, topic
+	`, since, minRated)
+	return rows, err
+}
+
+// LowRatedReply is a thumbs-down reply with the learner message it answered
+type LowRatedReply struct {
+	MessageID string    `db:"message_id" json:"message_id"`
+	ChatID    string    `db:"chat_id" json:"chat_id"`
+	Topic     string    `db:"topic" json:"topic"`
+	Model     string    `db:"model" json:"model"`
+	Question  string    `db:"question" json:"question"`
+	Reply     string    `db:"reply" json:"reply"`
+	Reason    string    `db:"reason" json:"reason"`
+	Versions  int       `db:"versions" json:"versions"` // Versions the learner generated of the reply
+	RatedAt   time.Time `db:"rated_at" json:"rated_at"`
+}
+
+// LowRatedReplies lists recent thumbs-down replies, optionally only on one
+// topic or by one model
+func LowRatedReplies(topic string, model string, since time.Time, limit int) ([]LowRatedReply, error) {
+	replies := []LowRatedReply{}
+	err := config.DB.Select(&replies, `
+		SELECT m.id AS message_id, m.chat_id, c.topic, m.model,
+			COALESCE(p.content, '') AS question, m.content AS reply, f.reason,
+			(SELECT GREATEST(COUNT(*), 1) FROM messages v WHERE v.parent_id = m.parent_id AND v.role='bot') AS versions,
+			f.updated_at AS rated_at
+		FROM message_feedback f
+		JOIN messages m ON m.id = f.message_id
+		JOIN chats c ON c.id = m.chat_id
+		LEFT JOIN messages p ON p.id = m.parent_id
+		WHERE f.rating < 0 AND f.updated_at >= $1
+		AND ($2 = '' OR LOWER(c.topic) = LOWER($2))
+		AND ($3 = '' OR m.model = $3)
+		ORDER BY f.updated_at DESC
+		LIMIT $4
+	`, since, topic, model, limit)
+	return replies, err
+}
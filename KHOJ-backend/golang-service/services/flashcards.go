@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+)
+
+// Flashcard sources
+const (
+	FlashcardGenerated = "generated"
+	FlashcardManual    = "manual"
+)
+
+const (
+	DefaultFlashcardCount = 10
+	MaxFlashcardCount     = 30
+	// MaxFlashcardText caps the front and the back of a card
+	MaxFlashcardText = 2000
+	// flashcardChatMessageLimit caps how much of a chat goes into the prompt
+	flashcardChatMessageLimit = 100
+	// flashcardExistingLimit caps the existing fronts the prompt is told to avoid
+	flashcardExistingLimit = 100
+
+	sm2InitialEase = 2.5
+	sm2MinEase     = 1.3
+	// MaxFlashcardGrade is perfect recall; grades below sm2PassGrade are lapses
+	MaxFlashcardGrade = 5
+	sm2PassGrade      = 3
+)
+
+var (
+	// ErrNoDeckTopic is returned for a deck without a topic
+	ErrNoDeckTopic = errors.New("a topic is required")
+	// ErrInvalidFlashcard is returned for a card with an empty or too long side
+	ErrInvalidFlashcard = fmt.Errorf("a card needs a front and a back of at most %d characters", MaxFlashcardText)
+	// ErrDuplicateFlashcard is returned for a card whose front is already in the deck
+	ErrDuplicateFlashcard = errors.New("the deck already has a card with this front")
+	// ErrInvalidGrade is returned for a recall grade out of range
+	ErrInvalidGrade = fmt.Errorf("grade must be between 0 and %d", MaxFlashcardGrade)
+)
+
+// FlashcardDeckFor returns the user's deck on a topic, creating it if needed.
+// Topics are matched by CanonicalTopic, so "Linear-Algebra" and "linear
+// algebra" share a deck.
+func FlashcardDeckFor(userID int, topic string) (models.FlashcardDeck, error) {
+	var deck models.FlashcardDeck
+	key := CanonicalTopic(topic)
+	if key == "" {
+		return deck, ErrNoDeckTopic
+	}
+	err := config.DB.Get(&deck, `
+		INSERT INTO flashcard_decks (user_id, topic, topic_key) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, topic_key) DO UPDATE SET topic_key=EXCLUDED.topic_key
+		RETURNING *
+	`, userID, strings.TrimSpace(topic), key)
+	return deck, err
+}
+
+// FlashcardDecks lists a user's decks, most recently changed first, with how
+// many cards each has and how many are due at now. deckID > 0 loads only
+// that deck.
+func FlashcardDecks(userID int, deckID int, now time.Time) ([]models.FlashcardDeck, error) {
+	decks := []models.FlashcardDeck{}
+	err := config.DB.Select(&decks, `
+		SELECT d.*, COUNT(f.id) AS card_count, COUNT(f.id) FILTER (WHERE f.due_at <= $3) AS due_count
+		FROM flashcard_decks d LEFT JOIN flashcards f ON f.deck_id = d.id
+		WHERE d.user_id=$1 AND ($2 = 0 OR d.id=$2)
+		GROUP BY d.id
+		ORDER BY d.updated_at DESC, d.id DESC
+	`, userID, deckID, now)
+	return decks, err
+}
+
+// DueFlashcards returns the user's cards due at now, longest overdue first.
+// deckID > 0 keeps one deck's cards.
+func DueFlashcards(userID int, deckID int, now time.Time, limit int) ([]models.Flashcard, error) {
+	cards := []models.Flashcard{}
+	err := config.DB.Select(&cards, `
+		SELECT * FROM flashcards
+		WHERE user_id=$1 AND ($2 = 0 OR deck_id=$2) AND due_at <= $3
+		ORDER BY due_at, id
+		LIMIT $4
+	`, userID, deckID, now, limit)
+	return cards, err
+}
+
+// cleanFlashcard trims a card's sides and checks they fit
+func cleanFlashcard(front string, back string) (string, string, error) {
+	front, back = strings.TrimSpace(front), strings.TrimSpace(back)
+	if front == "" || back == "" || len([]rune(front)) > MaxFlashcardText || len([]rune(back)) > MaxFlashcardText {
+		return front, back, ErrInvalidFlashcard
+	}
+	return front, back, nil
+}
+
+// AddFlashcard adds a card to a deck, due for review straight away. chatID
+// is the chat a generated card came from, if any.
+func AddFlashcard(deck models.FlashcardDeck, chatID *string, front string, back string, source string) (models.Flashcard, error) {
+	var card models.Flashcard
+	front, back, err := cleanFlashcard(front, back)
+	if err != nil {
+		return card, err
+	}
+	err = config.DB.Get(&card, `
+		INSERT INTO flashcards (deck_id, user_id, chat_id, front, back, normalized_front, source, ease)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (deck_id, normalized_front) DO NOTHING
+		RETURNING *
+	`, deck.ID, deck.UserID, chatID, front, back, NormalizeQuestionText(front), source, sm2InitialEase)
+	if err == sql.ErrNoRows {
+		return card, ErrDuplicateFlashcard
+	}
+	if err != nil {
+		return card, err
+	}
+	_, err = config.DB.Exec("UPDATE flashcard_decks SET updated_at=NOW() WHERE id=$1", deck.ID)
+	return card, err
+}
+
+// UpdateFlashcard edits a card's text. Its review schedule is kept.
+func UpdateFlashcard(card models.Flashcard, front string, back string) (models.Flashcard, error) {
+	front, back, err := cleanFlashcard(front, back)
+	if err != nil {
+		return card, err
+	}
+	normalized := NormalizeQuestionText(front)
+	err = config.DB.Get(&card, `
+		UPDATE flashcards SET front=$2, back=$3, normalized_front=$4, updated_at=NOW()
+		WHERE id=$1 AND NOT EXISTS (
+			SELECT 1 FROM flashcards o WHERE o.deck_id=$5 AND o.normalized_front=$4 AND o.id <> $1
+		)
+		RETURNING *
+	`, card.ID, front, back, normalized, card.DeckID)
+	if err == sql.ErrNoRows {
+		return card, ErrDuplicateFlashcard
+	}
+	return card, err
+}
+
+// GenerateFlashcards has Gemini write count cards for the user's deck on the
+// topic, from a chat's active branch when chat is set or from the topic
+// alone. Cards whose front is already in the deck are skipped.
+func GenerateFlashcards(ctx context.Context, apiKey string, preferredModel string, userID int, topic string, chat *models.Chat, count int) (models.FlashcardDeck, []models.Flashcard, int, error) {
+	added := []models.Flashcard{}
+	deck, err := FlashcardDeckFor(userID, topic)
+	if err != nil {
+		return deck, added, 0, err
+	}
+
+	data := FlashcardPromptData{Count: count, Topic: deck.Topic}
+	var chatID *string
+	if chat != nil {
+		messages, err := ActivePath(chat.ID, flashcardChatMessageLimit)
+		if err != nil {
+			return deck, added, 0, err
+		}
+		if data.Transcript = ChatTranscript(chat.Topic, messages); data.Transcript == "" {
+			return deck, added, 0, ErrNothingToNote
+		}
+		chatID = &chat.ID
+	}
+	err = config.DB.Select(&data.Existing, `
+		SELECT front FROM flashcards WHERE deck_id=$1 ORDER BY created_at DESC, id DESC LIMIT $2
+	`, deck.ID, flashcardExistingLimit)
+	if err != nil {
+		return deck, added, 0, err
+	}
+
+	prompt, err := RenderPrompt(PromptFlashcards, userID, data)
+	if err != nil {
+		return deck, added, 0, err
+	}
+	out, model, err := GenerateGeminiText(ctx, apiKey, preferredModel, prompt)
+	if err != nil {
+		return deck, added, 0, err
+	}
+	usageID := LogPromptUsage(prompt, userID, model, PromptSourceFlashcards, fmt.Sprint(deck.ID))
+
+	var generated []struct {
+		Front string `json:"front"`
+		Back  string `json:"back"`
+	}
+	if err := json.Unmarshal([]byte(trimCodeFence(out)), &generated); err != nil {
+		RecordPromptOutcome(usageID, MetricValidationFailure, 1)
+		return deck, added, 0, fmt.Errorf("failed to parse generated flashcards: %w", err)
+	}
+	skipped := 0
+	for _, g := range generated {
+		if len(added) == count {
+			break
+		}
+		card, err := AddFlashcard(deck, chatID, g.Front, g.Back, FlashcardGenerated)
+		if err == ErrDuplicateFlashcard || err == ErrInvalidFlashcard {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return deck, added, skipped, err
+		}
+		added = append(added, card)
+	}
+	if len(added) == 0 && skipped == 0 {
+		RecordPromptOutcome(usageID, MetricValidationFailure, 1)
+		return deck, added, 0, fmt.Errorf("no flashcards were generated")
+	}
+	return deck, added, skipped, nil
+}
+
+// scheduleSM2 applies a recall grade (0-5) to a card's schedule with SM-2. A
+// grade below 3 is a lapse and starts the card over; otherwise the interval
+// goes 1 day, 6 days, then grows by the ease factor. The ease drops with
+// hard recalls and rises with easy ones.
+func scheduleSM2(card *models.Flashcard, grade int, now time.Time) {
+	if grade < sm2PassGrade {
+		card.Repetitions = 0
+		card.IntervalDays = 1
+		card.Lapses++
+	} else {
+		card.Repetitions++
+		switch card.Repetitions {
+		case 1:
+			card.IntervalDays = 1
+		case 2:
+			card.IntervalDays = 6
+		default:
+			card.IntervalDays = int(math.Round(float64(card.IntervalDays) * card.Ease))
+		}
+	}
+	miss := float64(MaxFlashcardGrade - grade)
+	card.Ease = math.Max(sm2MinEase, card.Ease+0.1-miss*(0.08+miss*0.02))
+	card.DueAt = now.AddDate(0, 0, card.IntervalDays)
+	card.LastReviewedAt = &now
+}
+
+// ReviewFlashcard records a recall grade for a card and schedules its next
+// review. A learner's first review in a deck each day counts as a review
+// for XP and study plans.
+func ReviewFlashcard(cardID int, userID int, grade int, now time.Time) (models.Flashcard, error) {
+	var card models.Flashcard
+	if grade < 0 || grade > MaxFlashcardGrade {
+		return card, ErrInvalidGrade
+	}
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return card, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.Get(&card, "SELECT * FROM flashcards WHERE id=$1 AND user_id=$2 FOR UPDATE", cardID, userID); err != nil {
+		return card, err
+	}
+	scheduleSM2(&card, grade, now)
+	_, err = tx.Exec(`
+		UPDATE flashcards SET ease=$2, interval_days=$3, repetitions=$4, lapses=$5, due_at=$6, last_reviewed_at=$7
+		WHERE id=$1
+	`, card.ID, card.Ease, card.IntervalDays, card.Repetitions, card.Lapses, card.DueAt, now)
+	if err != nil {
+		return card, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO flashcard_reviews (card_id, user_id, grade, interval_days, ease, reviewed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, card.ID, userID, grade, card.IntervalDays, card.Ease, now)
+	if err != nil {
+		return card, err
+	}
+	var topic string
+	if err := tx.Get(&topic, "SELECT topic FROM flashcard_decks WHERE id=$1", card.DeckID); err != nil {
+		return card, err
+	}
+	if err := tx.Commit(); err != nil {
+		return card, err
+	}
+
+	day := localDate(now, UserLocation(userID)).Format("2006-01-02")
+	Publish(Event{
+		Type:     EventReviewCompleted,
+		UserID:   userID,
+		SourceID: fmt.Sprintf("flashcards:%d:%s", card.DeckID, day),
+		Topic:    topic,
+		At:       now,
+	})
+	return card, nil
+}
@@ -0,0 +1,435 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// XP awarded per event
+const (
+	xpPerMessage       = 2
+	xpMessageDailyCap  = 40 // Message XP per local day, so chatting can't be farmed
+	xpPerQuiz          = 10
+	xpPerCorrectAnswer = 2
+	xpPerfectQuizBonus = 10
+	xpPerReview        = 5
+)
+
+// Streak freeze tokens: one is earned every freezeTokenEvery streak days and
+// each covers one missed day
+const (
+	freezeTokenEvery = 7
+	maxFreezeTokens  = 2
+)
+
+// xpEventAchievement is the xp_events type used for achievement rewards
+const xpEventAchievement = "achievement"
+
+// achievementRule is the JSON rule of an achievement definition: the badge is
+// earned once the metric reaches threshold
+type achievementRule struct {
+	Metric    string `json:"metric"`
+	Threshold int    `json:"threshold"`
+}
+
+// defaultAchievements are seeded on startup. Existing rows are left alone so
+// definitions can be edited in the database.
+var defaultAchievements = []models.AchievementDefinition{
+	{Code: "first_words", Name: "First Words", Description: "Send your first message to the tutor", Icon: "💬", Rule: `{"metric":"messages_sent","threshold":1}`, XPReward: 5},
+	{Code: "curious_mind", Name: "Curious Mind", Description: "Send 100 messages", Icon: "🧠", Rule: `{"metric":"messages_sent","threshold":100}`, XPReward: 50},
+	{Code: "first_quiz", Name: "Quiz Taker", Description: "Complete your first quiz", Icon: "📝", Rule: `{"metric":"quizzes_completed","threshold":1}`, XPReward: 10},
+	{Code: "quiz_regular", Name: "Quiz Regular", Description: "Complete 10 quizzes", Icon: "📚", Rule: `{"metric":"quizzes_completed","threshold":10}`, XPReward: 50},
+	{Code: "quiz_master", Name: "Quiz Master", Description: "Complete 50 quizzes", Icon: "🏆", Rule: `{"metric":"quizzes_completed","threshold":50}`, XPReward: 200},
+	{Code: "perfectionist", Name: "Perfectionist", Description: "Score 100% on a quiz", Icon: "🎯", Rule: `{"metric":"perfect_quizzes","threshold":1}`, XPReward: 25},
+	{Code: "reviewer", Name: "Reviewer", Description: "Review 10 completed quizzes or flashcard decks", Icon: "🔁", Rule: `{"metric":"reviews_completed","threshold":10}`, XPReward: 30},
+	{Code: "streak_3", Name: "Warming Up", Description: "Study 3 days in a row", Icon: "🔥", Rule: `{"metric":"current_streak","threshold":3}`, XPReward: 15},
+	{Code: "streak_7", Name: "On Fire", Description: "Study 7 days in a row", Icon: "🔥", Rule: `{"metric":"current_streak","threshold":7}`, XPReward: 50},
+	{Code: "streak_30", Name: "Unstoppable", Description: "Study 30 days in a row", Icon: "⚡", Rule: `{"metric":"current_streak","threshold":30}`, XPReward: 250},
+	{Code: "xp_1000", Name: "Scholar", Description: "Earn 1000 XP", Icon: "⭐", Rule: `{"metric":"total_xp","threshold":1000}`, XPReward: 0},
+}
+
+// StartGamification seeds the achievement definitions and subscribes the XP
+// and achievement processing to learning events
+func StartGamification() {
+	for i, def := range defaultAchievements {
+		_, err := config.DB.Exec(`
+			INSERT INTO achievement_definitions (code, name, description, icon, rule, xp_reward, sort_order, active)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, true)
+			ON CONFLICT (code) DO NOTHING
+		`, def.Code, def.Name, def.Description, def.Icon, def.Rule, def.XPReward, i+1)
+		if err != nil {
+			fmt.Printf("Warning: failed to seed achievement %s: %v\n", def.Code, err)
+		}
+	}
+
+	for _, t := range []string{EventMessageSent, EventQuizCompleted, EventReviewCompleted} {
+		Subscribe(t, func(e Event) {
+			if err := applyGamificationEvent(e); err != nil {
+				fmt.Printf("Warning: failed to apply %s event for user %d: %v\n", e.Type, e.UserID, err)
+			}
+		})
+	}
+}
+
+// localDate returns the calendar date of t in loc as midnight UTC, the way
+// Postgres DATE values are scanned
+func localDate(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// applyGamificationEvent awards XP, advances the streak and evaluates
+// achievements for one event. Events already in the XP ledger are ignored.
+func applyGamificationEvent(e Event) error {
+	loc := UserLocation(e.UserID)
+	day := localDate(e.At, loc)
+
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the user's row so concurrent events update counters one at a time
+	if _, err := tx.Exec(`INSERT INTO user_gamification (user_id) VALUES ($1) ON CONFLICT (user_id) DO NOTHING`, e.UserID); err != nil {
+		return err
+	}
+	var g models.UserGamification
+	if err := tx.Get(&g, `SELECT * FROM user_gamification WHERE user_id=$1 FOR UPDATE`, e.UserID); err != nil {
+		return err
+	}
+
+	xp, err := eventXP(tx, e, loc)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`
+		INSERT INTO xp_events (user_id, event_type, source_id, xp, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, event_type, source_id) DO NOTHING
+	`, e.UserID, e.Type, e.SourceID, xp, e.At)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	g.TotalXP += xp
+	switch e.Type {
+	case EventMessageSent:
+		g.MessagesSent++
+	case EventQuizCompleted:
+		g.QuizzesCompleted++
+		if e.Total > 0 && e.Score == e.Total {
+			g.PerfectQuizzes++
+		}
+	case EventReviewCompleted:
+		g.ReviewsCompleted++
+	}
+	advanceStreak(&g, day)
+
+	if err := evaluateAchievements(tx, &g, e.At, day); err != nil {
+		return err
+	}
+
+	// The date is sent as text: a timestamp would be cast to DATE in the
+	// session's time zone and could land on the wrong day
+	_, err = tx.Exec(`
+		UPDATE user_gamification SET
+			total_xp=$2, current_streak=$3, longest_streak=$4, last_active_date=$5, freeze_tokens=$6,
+			messages_sent=$7, quizzes_completed=$8, perfect_quizzes=$9, reviews_completed=$10, updated_at=NOW()
+		WHERE user_id=$1
+	`, g.UserID, g.TotalXP, g.CurrentStreak, g.LongestStreak, g.LastActiveDate.Format("2006-01-02"), g.FreezeTokens,
+		g.MessagesSent, g.QuizzesCompleted, g.PerfectQuizzes, g.ReviewsCompleted)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// eventXP returns the XP an event is worth
+func eventXP(tx *sqlx.Tx, e Event, loc *time.Location) (int, error) {
+	switch e.Type {
+	case EventMessageSent:
+		start := e.At.In(loc)
+		start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+		var today int
+		err := tx.Get(&today, `
+			SELECT COALESCE(SUM(xp), 0) FROM xp_events
+			WHERE user_id=$1 AND event_type=$2 AND created_at >= $3 AND created_at < $4
+		`, e.UserID, EventMessageSent, start, start.AddDate(0, 0, 1))
+		if err != nil {
+			return 0, err
+		}
+		if today >= xpMessageDailyCap {
+			return 0, nil
+		}
+		return xpPerMessage, nil
+	case EventQuizCompleted:
+		xp := xpPerQuiz + xpPerCorrectAnswer*e.Score
+		if e.Total > 0 && e.Score == e.Total {
+			xp += xpPerfectQuizBonus
+		}
+		return xp, nil
+	case EventReviewCompleted:
+		return xpPerReview, nil
+	}
+	return 0, nil
+}
+
+// advanceStreak records activity on day (a local calendar date). Missed days
+// are covered by freeze tokens when the learner has enough of them.
+func advanceStreak(g *models.UserGamification, day time.Time) {
+	if g.LastActiveDate == nil {
+		g.CurrentStreak = 1
+	} else {
+		gap := int(math.Round(day.Sub(*g.LastActiveDate).Hours() / 24))
+		switch {
+		case gap <= 0:
+			// Same day, or a late event for an earlier day: nothing changes
+			return
+		case gap == 1:
+			g.CurrentStreak++
+		case gap-1 <= g.FreezeTokens:
+			g.FreezeTokens -= gap - 1
+			g.CurrentStreak++
+		default:
+			g.CurrentStreak = 1
+		}
+	}
+	g.LastActiveDate = &day
+	if g.CurrentStreak > g.LongestStreak {
+		g.LongestStreak = g.CurrentStreak
+	}
+	if g.CurrentStreak%freezeTokenEvery == 0 && g.FreezeTokens < maxFreezeTokens {
+		g.FreezeTokens++
+	}
+}
+
+// EffectiveStreak is the streak as of today: it survives while the missed
+// days since the last active day can still be covered by freeze tokens
+func EffectiveStreak(g models.UserGamification, today time.Time) int {
+	if g.LastActiveDate == nil {
+		return 0
+	}
+	gap := int(math.Round(today.Sub(*g.LastActiveDate).Hours() / 24))
+	// Today itself doesn't count as missed yet
+	if gap <= 1 || gap-1 <= g.FreezeTokens {
+		return g.CurrentStreak
+	}
+	return 0
+}
+
+// metricValue reads the counter an achievement rule refers to. The current
+// streak is taken as of today, so a lapsed streak shows no progress.
+func metricValue(g *models.UserGamification, metric string, today time.Time) (int, bool) {
+	switch metric {
+	case "messages_sent":
+		return g.MessagesSent, true
+	case "quizzes_completed":
+		return g.QuizzesCompleted, true
+	case "perfect_quizzes":
+		return g.PerfectQuizzes, true
+	case "reviews_completed":
+		return g.ReviewsCompleted, true
+	case "current_streak":
+		return EffectiveStreak(*g, today), true
+	case "longest_streak":
+		return g.LongestStreak, true
+	case "total_xp":
+		return g.TotalXP, true
+	}
+	return 0, false
+}
+
+func parseAchievementRule(def models.AchievementDefinition) (achievementRule, error) {
+	var rule achievementRule
+	if err := json.Unmarshal([]byte(def.Rule), &rule); err != nil {
+		return rule, fmt.Errorf("invalid rule for achievement %s: %w", def.Code, err)
+	}
+	if rule.Threshold <= 0 {
+		rule.Threshold = 1
+	}
+	return rule, nil
+}
+
+// evaluateAchievements updates progress on every unearned achievement and
+// awards the ones whose rule is now met. Rewards add XP, which may satisfy
+// XP-based rules, so evaluation repeats until nothing new is earned. today is
+// the learner's local date at the time of the event.
+func evaluateAchievements(tx *sqlx.Tx, g *models.UserGamification, at, today time.Time) error {
+	var defs []models.AchievementDefinition
+	if err := tx.Select(&defs, `SELECT * FROM achievement_definitions WHERE active ORDER BY sort_order, code`); err != nil {
+		return err
+	}
+	var earnedCodes []string
+	if err := tx.Select(&earnedCodes, `SELECT achievement_code FROM user_achievements WHERE user_id=$1 AND earned_at IS NOT NULL`, g.UserID); err != nil {
+		return err
+	}
+	earned := map[string]bool{}
+	for _, code := range earnedCodes {
+		earned[code] = true
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, def := range defs {
+			if earned[def.Code] {
+				continue
+			}
+			rule, err := parseAchievementRule(def)
+			if err != nil {
+				fmt.Printf("Warning: %v\n", err)
+				continue
+			}
+			value, ok := metricValue(g, rule.Metric, today)
+			if !ok {
+				fmt.Printf("Warning: achievement %s uses unknown metric %q\n", def.Code, rule.Metric)
+				continue
+			}
+
+			var earnedAt *time.Time
+			if value >= rule.Threshold {
+				earnedAt = &at
+			}
+			_, err = tx.Exec(`
+				INSERT INTO user_achievements (user_id, achievement_code, progress, target, earned_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, NOW())
+				ON CONFLICT (user_id, achievement_code) DO UPDATE SET
+					progress=EXCLUDED.progress, target=EXCLUDED.target,
+					earned_at=COALESCE(user_achievements.earned_at, EXCLUDED.earned_at), updated_at=NOW()
+			`, g.UserID, def.Code, min(value, rule.Threshold), rule.Threshold, earnedAt)
+			if err != nil {
+				return err
+			}
+			if earnedAt == nil {
+				continue
+			}
+
+			earned[def.Code] = true
+			changed = true
+			if def.XPReward > 0 {
+				res, err := tx.Exec(`
+					INSERT INTO xp_events (user_id, event_type, source_id, xp, created_at)
+					VALUES ($1, $2, $3, $4, $5)
+					ON CONFLICT (user_id, event_type, source_id) DO NOTHING
+				`, g.UserID, xpEventAchievement, def.Code, def.XPReward, at)
+				if err != nil {
+					return err
+				}
+				if n, _ := res.RowsAffected(); n > 0 {
+					g.TotalXP += def.XPReward
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// AchievementStatus is an achievement together with the learner's progress
+type AchievementStatus struct {
+	Code        string     `json:"code"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Icon        string     `json:"icon"`
+	XPReward    int        `json:"xp_reward"`
+	Progress    int        `json:"progress"`
+	Target      int        `json:"target"`
+	Percent     float64    `json:"percent"`
+	EarnedAt    *time.Time `json:"earned_at,omitempty"`
+}
+
+// GamificationSummary is what the achievements endpoint returns
+type GamificationSummary struct {
+	TotalXP        int                 `json:"total_xp"`
+	Level          int                 `json:"level"`
+	LevelXP        int                 `json:"level_xp"`      // XP needed to reach the current level
+	NextLevelXP    int                 `json:"next_level_xp"` // XP needed to reach the next level
+	CurrentStreak  int                 `json:"current_streak"`
+	LongestStreak  int                 `json:"longest_streak"`
+	FreezeTokens   int                 `json:"freeze_tokens"`
+	LastActiveDate string              `json:"last_active_date,omitempty"`
+	Timezone       string              `json:"timezone"`
+	Earned         []AchievementStatus `json:"earned"`
+	InProgress     []AchievementStatus `json:"in_progress"`
+}
+
+// levelForXP uses a quadratic curve: level n starts at 100*(n-1)^2 XP
+func levelForXP(xp int) int {
+	return int(math.Sqrt(float64(xp)/100)) + 1
+}
+
+// GetGamificationSummary returns a learner's XP, level, streak and
+// achievements. Progress on unearned achievements is computed from the
+// current counters so newly added definitions show up immediately.
+func GetGamificationSummary(userID int) (GamificationSummary, error) {
+	loc := UserLocation(userID)
+	summary := GamificationSummary{Timezone: loc.String(), Earned: []AchievementStatus{}, InProgress: []AchievementStatus{}}
+
+	g := models.UserGamification{UserID: userID}
+	err := config.DB.Get(&g, `SELECT * FROM user_gamification WHERE user_id=$1`, userID)
+	if err != nil && err != sql.ErrNoRows {
+		return summary, err
+	}
+
+	summary.TotalXP = g.TotalXP
+	summary.Level = levelForXP(g.TotalXP)
+	summary.LevelXP = 100 * (summary.Level - 1) * (summary.Level - 1)
+	summary.NextLevelXP = 100 * summary.Level * summary.Level
+	today := localDate(time.Now(), loc)
+	summary.CurrentStreak = EffectiveStreak(g, today)
+	summary.LongestStreak = g.LongestStreak
+	summary.FreezeTokens = g.FreezeTokens
+	if g.LastActiveDate != nil {
+		summary.LastActiveDate = g.LastActiveDate.Format("2006-01-02")
+	}
+
+	var defs []models.AchievementDefinition
+	if err := config.DB.Select(&defs, `SELECT * FROM achievement_definitions WHERE active ORDER BY sort_order, code`); err != nil {
+		return summary, err
+	}
+	var rows []models.UserAchievement
+	if err := config.DB.Select(&rows, `SELECT * FROM user_achievements WHERE user_id=$1`, userID); err != nil {
+		return summary, err
+	}
+	progress := map[string]models.UserAchievement{}
+	for _, r := range rows {
+		progress[r.AchievementCode] = r
+	}
+
+	for _, def := range defs {
+		status := AchievementStatus{Code: def.Code, Name: def.Name, Description: def.Description, Icon: def.Icon, XPReward: def.XPReward}
+		if ua, ok := progress[def.Code]; ok && ua.EarnedAt != nil {
+			status.Progress, status.Target, status.EarnedAt = ua.Target, ua.Target, ua.EarnedAt
+			status.Percent = 100
+			summary.Earned = append(summary.Earned, status)
+			continue
+		}
+		rule, err := parseAchievementRule(def)
+		if err != nil {
+			continue
+		}
+		value, ok := metricValue(&g, rule.Metric, today)
+		if !ok {
+			continue
+		}
+		status.Progress = min(value, rule.Threshold)
+		status.Target = rule.Threshold
+		status.Percent = percentage(status.Progress, status.Target)
+		summary.InProgress = append(summary.InProgress, status)
+	}
+	// Most recent badges first; closest-to-done goals first
+	sort.SliceStable(summary.Earned, func(i, j int) bool { return summary.Earned[i].EarnedAt.After(*summary.Earned[j].EarnedAt) })
+	sort.SliceStable(summary.InProgress, func(i, j int) bool { return summary.InProgress[i].Percent > summary.InProgress[j].Percent })
+	return summary, nil
+}
@@ -39,16 +39,29 @@ func ResolveGeminiAPIKeyFromEnv() string {
 	return ""
 }
 
-// GenerateGeminiReply calls Google Gemini with a topic-aware prompt
-func GenerateGeminiReply(ctx context.Context, apiKey string, preferredModel string, topic string, input string) (string, error) {
+// GenerateGeminiReply calls Google Gemini for a chat reply: the tutor's
+// system prompt (see TutorSystemPrompt) followed by the learner's message.
+// It also returns the model that answered.
+func GenerateGeminiReply(ctx context.Context, apiKey string, preferredModel string, systemPrompt RenderedPrompt, input string) (string, string, error) {
+	systemPrompt.Text += "\n\nUser: " + input
+	return GenerateGeminiText(ctx, apiKey, preferredModel, systemPrompt)
+}
+
+// GenerateGeminiText calls Google Gemini with a prompt rendered by
+// RenderPrompt, using the template's model settings. A model set on the
+// template is tried before preferredModel. It also returns the model that
+// answered.
+func GenerateGeminiText(ctx context.Context, apiKey string, preferredModel string, rendered RenderedPrompt) (string, string, error) {
 	if strings.TrimSpace(apiKey) == "" {
-		return "", fmt.Errorf("GEMINI_API_KEY not set")
+		return "", "", fmt.Errorf("GEMINI_API_KEY not set")
 	}
+	prompt, settings := rendered.Text, rendered.Settings
 
     // First, try REST (more tolerant across environments)
     {
     // candidate models to try via REST first (prioritize those your key lists)
         modelCandidates := []string{}
+        if m := settings.Model; m != "" { modelCandidates = append(modelCandidates, m) }
         if m := strings.TrimSpace(preferredModel); m != "" { modelCandidates = append(modelCandidates, m) }
         modelCandidates = append(modelCandidates,
             "gemini-2.5-flash",
@@ -58,20 +71,22 @@ func GenerateGeminiReply(ctx context.Context, apiKey string, preferredModel stri
             "gemini-2.0-flash-lite-001",
             "gemini-2.0-flash-lite",
         )
-        prompt := fmt.Sprintf("You are a helpful tutor. The chat topic is '%s'. Answer ONLY within this topic. User: %s", topic, input)
-        if out, err := httpFallbackGenerate(prompt, apiKey, modelCandidates); err == nil && strings.TrimSpace(out) != "" {
-            return out, nil
+        if out, model, err := httpFallbackGenerate(prompt, settings, apiKey, modelCandidates); err == nil && strings.TrimSpace(out) != "" {
+            return out, model, nil
         }
     }
 
     client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
 	if err != nil {
-		return "", fmt.Errorf("gemini client init failed: %w", err)
+		return "", "", fmt.Errorf("gemini client init failed: %w", err)
 	}
 	defer client.Close()
 
 	// Try a set of commonly available models for v1beta
     modelCandidates := []string{}
+	if m := settings.Model; m != "" {
+		modelCandidates = append(modelCandidates, m)
+	}
 	if m := strings.TrimSpace(preferredModel); m != "" {
 		modelCandidates = append(modelCandidates, m)
 	}
@@ -84,14 +99,15 @@ func GenerateGeminiReply(ctx context.Context, apiKey string, preferredModel stri
         "gemini-2.0-flash-lite",
     )
 
-	prompt := fmt.Sprintf(
-		"You are a helpful tutor. The chat topic is '%s'. Answer ONLY within this topic. User: %s",
-		topic, input,
-	)
-
 	var lastErr error
 	for _, modelName := range modelCandidates {
 		model := client.GenerativeModel(modelName)
+		if settings.Temperature != nil {
+			model.SetTemperature(float32(*settings.Temperature))
+		}
+		if settings.MaxOutputTokens != nil {
+			model.SetMaxOutputTokens(int32(*settings.MaxOutputTokens))
+		}
 		resp, err := model.GenerateContent(ctx, genai.Text(prompt))
 		if err != nil {
 			// If a model is not found/unsupported, try the next
@@ -100,13 +116,13 @@ func GenerateGeminiReply(ctx context.Context, apiKey string, preferredModel stri
 				lastErr = fmt.Errorf("model %s: %w", modelName, err)
 				continue
 			}
-			return "", fmt.Errorf("gemini request failed: %w", err)
+			return "", "", fmt.Errorf("gemini request failed: %w", err)
 		}
 
 		for _, cand := range resp.Candidates {
 			for _, part := range cand.Content.Parts {
 				if t, ok := part.(genai.Text); ok {
-					return string(t), nil
+					return string(t), modelName, nil
 				}
 			}
 		}
@@ -115,16 +131,16 @@ func GenerateGeminiReply(ctx context.Context, apiKey string, preferredModel stri
 
 	// If SDK attempts failed, try raw HTTP REST (v1 then v1beta)
 	if lastErr != nil {
-		if out, err := httpFallbackGenerate(prompt, apiKey, modelCandidates); err == nil && strings.TrimSpace(out) != "" {
-			return out, nil
+		if out, model, err := httpFallbackGenerate(prompt, settings, apiKey, modelCandidates); err == nil && strings.TrimSpace(out) != "" {
+			return out, model, nil
 		}
-		return "", lastErr
+		return "", "", lastErr
 	}
-	return "", fmt.Errorf("gemini returned no text")
+	return "", "", fmt.Errorf("gemini returned no text")
 }
 
 // httpFallbackGenerate calls the REST API directly, trying v1 then v1beta
-func httpFallbackGenerate(prompt string, apiKey string, modelCandidates []string) (string, error) {
+func httpFallbackGenerate(prompt string, settings GenerationSettings, apiKey string, modelCandidates []string) (string, string, error) {
 	versions := []string{"v1", "v1beta"}
 	bodyObj := map[string]interface{}{
 		"contents": []interface{}{
@@ -133,6 +149,16 @@ func httpFallbackGenerate(prompt string, apiKey string, modelCandidates []string
 			},
 		},
 	}
+	genConfig := map[string]interface{}{}
+	if settings.Temperature != nil {
+		genConfig["temperature"] = *settings.Temperature
+	}
+	if settings.MaxOutputTokens != nil {
+		genConfig["maxOutputTokens"] = *settings.MaxOutputTokens
+	}
+	if len(genConfig) > 0 {
+		bodyObj["generationConfig"] = genConfig
+	}
 
 	for _, ver := range versions {
 		for _, model := range modelCandidates {
@@ -167,13 +193,13 @@ func httpFallbackGenerate(prompt string, apiKey string, modelCandidates []string
 			for _, c := range parsed.Candidates {
 				for _, p := range c.Content.Parts {
 					if strings.TrimSpace(p.Text) != "" {
-						return p.Text, nil
+						return p.Text, model, nil
 					}
 				}
 			}
 		}
 	}
-	return "", fmt.Errorf("no supported model found via REST v1/v1beta")
+	return "", "", fmt.Errorf("no supported model found via REST v1/v1beta")
 }
 
 
@@ -0,0 +1,344 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang-service/models"
+)
+
+// iCalendar (RFC 5545) export of reminder schedules. Recurring schedules become
+// one VEVENT with an RRULE in the user's zone, described by a VTIMEZONE, so
+// calendar apps keep 09:00 at 09:00 across DST changes.
+
+const (
+	icalProdID = "-//KHOJ//Study Reminders//EN"
+	// icalDefaultDuration is the length of a reminder without a time window
+	icalDefaultDuration = 15 * time.Minute
+	// icalLineOctets is where content lines are folded
+	icalLineOctets = 75
+)
+
+// NewCalendarToken returns a random token for a private feed URL
+func NewCalendarToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// icalWriter collects content lines and folds them
+type icalWriter struct {
+	b strings.Builder
+}
+
+// line writes "name:value", folding at 75 octets without splitting a UTF-8
+// character
+func (w *icalWriter) line(name string, value string) {
+	s := name + ":" + value
+	limit := icalLineOctets
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.b.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = icalLineOctets - 1 // Continuation lines start with a space
+	}
+	w.b.WriteString(s + "\r\n")
+}
+
+// icalText escapes a TEXT value
+func icalText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// icalDateTime formats t for a property: UTC with a Z suffix, or local time
+// with a TZID parameter added to name
+func icalDateTime(name string, t time.Time, loc *time.Location) (string, string) {
+	if loc == time.UTC {
+		return name, t.UTC().Format("20060102T150405Z")
+	}
+	return name + ";TZID=" + loc.String(), t.In(loc).Format("20060102T150405")
+}
+
+// icalDuration formats d as an RFC 5545 duration, e.g. PT1H30M
+func icalDuration(d time.Duration) string {
+	if d <= 0 {
+		d = icalDefaultDuration
+	}
+	out := "PT"
+	if h := int(d / time.Hour); h > 0 {
+		out += fmt.Sprintf("%dH", h)
+	}
+	if m := int(d % time.Hour / time.Minute); m > 0 || out == "PT" {
+		out += fmt.Sprintf("%dM", m)
+	}
+	return out
+}
+
+// icalRule renders the rule for export. A floating or date UNTIL is resolved
+// to UTC, since RFC 5545 requires UTC when DTSTART carries a TZID.
+func (rec Recurrence) icalRule() string {
+	r := rec.Rule
+	if until, ok := rec.untilInstant(); ok && r.untilKind != untilUTC {
+		r.untilRaw = until.UTC().Format("20060102T150405Z")
+		r.untilKind = untilUTC
+	}
+	return r.String()
+}
+
+// scheduleDuration is the reminder's window, or the default length
+func scheduleDuration(s models.Schedule) time.Duration {
+	start, err1 := ParseClock(s.ReminderTime)
+	end, err2 := ParseClock(s.ReminderTimeEnd)
+	if err1 != nil || err2 != nil || start == end {
+		return icalDefaultDuration
+	}
+	if end < start {
+		end += 24 * 60 // Window past midnight
+	}
+	return time.Duration(end-start) * time.Minute
+}
+
+// ScheduleCalendar renders schedules as a VCALENDAR in loc. Schedules with an
+// invalid rule are left out rather than failing the whole feed.
+func ScheduleCalendar(name string, schedules []models.Schedule, loc *time.Location, now time.Time) string {
+	var events icalWriter
+	earliest := now
+	for _, s := range schedules {
+		start, ok := writeScheduleEvent(&events, s, loc, now)
+		if ok && start.Before(earliest) {
+			earliest = start
+		}
+	}
+
+	var w icalWriter
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", icalProdID)
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("METHOD", "PUBLISH")
+	w.line("X-WR-CALNAME", icalText(name))
+	w.line("X-WR-TIMEZONE", loc.String())
+	// Ask subscribers to poll every few hours
+	w.line("REFRESH-INTERVAL;VALUE=DURATION", "PT4H")
+	w.line("X-PUBLISHED-TTL", "PT4H")
+	if loc != time.UTC {
+		// From the year before the first event, so its start is covered by an observance
+		writeVTimezone(&w, loc, earliest.In(loc).Year()-1, now.In(loc).Year())
+	}
+	w.b.WriteString(events.b.String())
+	w.line("END", "VCALENDAR")
+	return w.b.String()
+}
+
+// writeScheduleEvent writes one VEVENT and returns its DTSTART
+func writeScheduleEvent(w *icalWriter, s models.Schedule, loc *time.Location, now time.Time) (time.Time, bool) {
+	rec, recurring, err := ScheduleRecurrence(s)
+	if err != nil {
+		fmt.Printf("Warning: schedule %d left out of calendar: %v\n", s.ID, err)
+		return time.Time{}, false
+	}
+	start := ScheduleOccurrence(s)
+	if recurring {
+		rec.Start = rec.Start.In(loc)
+		// DTSTART must be the first instance; the stored anchor need not match
+		// the rule (e.g. created on a Tuesday for Mondays only)
+		first, ok := rec.Next(rec.Start.Add(-time.Nanosecond))
+		if !ok {
+			return time.Time{}, false
+		}
+		start = first
+	}
+
+	w.line("BEGIN", "VEVENT")
+	w.line("UID", fmt.Sprintf("schedule-%d@khoj", s.ID))
+	w.line("DTSTAMP", now.UTC().Format("20060102T150405Z"))
+	w.line("CREATED", s.CreatedAt.UTC().Format("20060102T150405Z"))
+	w.line(icalDateTime("DTSTART", start, loc))
+	w.line("DURATION", icalDuration(scheduleDuration(s)))
+	if recurring {
+		w.line("RRULE", rec.icalRule())
+		for _, ex := range icalExDates(rec) {
+			w.line(icalDateTime("EXDATE", ex, loc))
+		}
+	}
+	link := notificationURL(Notification{ChatID: s.ChatID})
+	w.line("SUMMARY", icalText("📚 KHOJ quiz: "+s.Topic))
+	w.line("DESCRIPTION", icalText(fmt.Sprintf("Time for your quiz on '%s'.\n%s", s.Topic, link)))
+	w.line("URL", link)
+	w.line("CATEGORIES", "KHOJ,Study")
+	w.line("BEGIN", "VALARM")
+	w.line("ACTION", "DISPLAY")
+	w.line("DESCRIPTION", icalText("Quiz on "+s.Topic))
+	w.line("TRIGGER", "PT0S")
+	w.line("END", "VALARM")
+	w.line("END", "VEVENT")
+	return start, true
+}
+
+// icalExDates lists the excluded instances. Whole-day exdates become the
+// occurrences on that day, since a DATE exdate can't exclude a DATE-TIME event.
+func icalExDates(rec Recurrence) []time.Time {
+	var out []time.Time
+	loc := rec.Start.Location()
+	for _, e := range rec.ExDates {
+		if !e.AllDay {
+			out = append(out, e.At)
+			continue
+		}
+		dayStart := LocalTime(e.At.Year(), e.At.Month(), e.At.Day(), 0, 0, 0, loc)
+		dayEnd := LocalTime(e.At.Year(), e.At.Month(), e.At.Day()+1, 0, 0, 0, loc)
+		unfiltered := Recurrence{Rule: rec.Rule, Start: rec.Start}
+		for _, t := range unfiltered.NextN(dayStart.Add(-time.Nanosecond), 48) {
+			if !t.Before(dayEnd) {
+				break
+			}
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// zoneTransition is a change of UTC offset
+type zoneTransition struct {
+	At         time.Time // The instant of the change
+	OffsetFrom int       // Seconds east of UTC before
+	OffsetTo   int       // Seconds east of UTC after
+	Name       string    // Abbreviation after, e.g. "EDT"
+	DST        bool
+}
+
+// zoneTransitions finds the offset changes of loc in [from, to), stepping by
+// day and bisecting to the second
+func zoneTransitions(loc *time.Location, from, to time.Time) []zoneTransition {
+	var out []zoneTransition
+	prev := from
+	_, prevOffset := prev.In(loc).Zone()
+	for prev.Before(to) {
+		t := prev.Add(24 * time.Hour)
+		if t.After(to) {
+			t = to
+		}
+		_, offset := t.In(loc).Zone()
+		if offset != prevOffset {
+			lo, hi := prev, t
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if _, o := mid.In(loc).Zone(); o == prevOffset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			name, _ := hi.In(loc).Zone()
+			out = append(out, zoneTransition{At: hi.Truncate(time.Second), OffsetFrom: prevOffset, OffsetTo: offset, Name: name, DST: hi.In(loc).IsDST()})
+			prevOffset = offset
+		}
+		prev = t
+	}
+	return out
+}
+
+// writeVTimezone describes loc from fromYear on. Zones with a regular yearly
+// DST pattern get yearly RRULE observances; irregular zones list each
+// transition through a few years ahead.
+func writeVTimezone(w *icalWriter, loc *time.Location, fromYear int, nowYear int) {
+	w.line("BEGIN", "VTIMEZONE")
+	w.line("TZID", loc.String())
+	w.line("X-LIC-LOCATION", loc.String())
+
+	yearStart := func(y int) time.Time { return time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC) }
+	first := zoneTransitions(loc, yearStart(fromYear), yearStart(fromYear+1))
+	second := zoneTransitions(loc, yearStart(fromYear+1), yearStart(fromYear+2))
+
+	switch {
+	case len(first) == 0 && len(second) == 0:
+		// No DST: a single observance with the zone's offset
+		name, offset := yearStart(fromYear).In(loc).Zone()
+		writeObservance(w, zoneTransition{At: yearStart(1970).Add(-time.Duration(offset) * time.Second), OffsetFrom: offset, OffsetTo: offset, Name: name}, "")
+	case len(first) == len(second):
+		rules := make([]string, len(first))
+		regular := true
+		for i := range first {
+			if rules[i] = yearlyRule(first[i], second[i]); rules[i] == "" {
+				regular = false
+				break
+			}
+		}
+		if regular {
+			for i, t := range first {
+				writeObservance(w, t, rules[i])
+			}
+			break
+		}
+		fallthrough
+	default:
+		for _, t := range zoneTransitions(loc, yearStart(fromYear), yearStart(nowYear+5)) {
+			writeObservance(w, t, "")
+		}
+	}
+	w.line("END", "VTIMEZONE")
+}
+
+// yearlyRule finds a BYMONTH/BYDAY rule (e.g. the second Sunday of March or
+// the last Sunday of October) that produces both transitions, or ""
+func yearlyRule(a, b zoneTransition) string {
+	la := a.At.Add(time.Duration(a.OffsetFrom) * time.Second).UTC()
+	lb := b.At.Add(time.Duration(b.OffsetFrom) * time.Second).UTC()
+	if la.Month() != lb.Month() || la.Weekday() != lb.Weekday() || la.Format("150405") != lb.Format("150405") ||
+		a.OffsetFrom != b.OffsetFrom || a.OffsetTo != b.OffsetTo {
+		return ""
+	}
+	day := rruleWeekdayNames[la.Weekday()]
+	nth := func(t time.Time) int { return (t.Day()-1)/7 + 1 }
+	last := func(t time.Time) bool { return t.Day()+7 > daysIn(t.Year(), t.Month()) }
+	switch {
+	case last(la) && last(lb):
+		return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=-1%s", int(la.Month()), day)
+	case nth(la) == nth(lb):
+		return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%d%s", int(la.Month()), nth(la), day)
+	}
+	return ""
+}
+
+// writeObservance writes a STANDARD or DAYLIGHT block whose DTSTART is the
+// local time of the change in the offset before it
+func writeObservance(w *icalWriter, t zoneTransition, rule string) {
+	kind := "STANDARD"
+	if t.DST {
+		kind = "DAYLIGHT"
+	}
+	w.line("BEGIN", kind)
+	w.line("DTSTART", t.At.Add(time.Duration(t.OffsetFrom)*time.Second).UTC().Format("20060102T150405"))
+	w.line("TZOFFSETFROM", icalOffset(t.OffsetFrom))
+	w.line("TZOFFSETTO", icalOffset(t.OffsetTo))
+	if t.Name != "" {
+		w.line("TZNAME", icalText(t.Name))
+	}
+	if rule != "" {
+		w.line("RRULE", rule)
+	}
+	w.line("END", kind)
+}
+
+// icalOffset formats seconds east of UTC as +HHMM (or +HHMMSS)
+func icalOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	out := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+	if s := seconds % 60; s != 0 {
+		out += fmt.Sprintf("%02d", s)
+	}
+	return out
+}
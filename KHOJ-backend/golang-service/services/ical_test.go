@@ -0,0 +1,176 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang-service/models"
+)
+
+func TestScheduleCalendar(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	t.Setenv("APP_BASE_URL", "")
+	s := models.Schedule{
+		ID:              7,
+		ChatID:          "c1",
+		Topic:           "Théorie des ensembles — cardinalité, ordinaux et l’axiome du choix",
+		RecurrenceType:  "once",
+		ScheduledTime:   time.Date(2025, 3, 10, 13, 0, 0, 0, time.UTC),
+		CreatedAt:       time.Date(2025, 2, 1, 8, 0, 0, 0, time.UTC),
+		ReminderTime:    "09:00",
+		ReminderTimeEnd: "09:30",
+	}
+	got := ScheduleCalendar("Study", []models.Schedule{s}, ny, time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+
+	// The multibyte SUMMARY and DESCRIPTION are folded at 75 octets without
+	// splitting a character
+	want := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//KHOJ//Study Reminders//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Study",
+		"X-WR-TIMEZONE:America/New_York",
+		"REFRESH-INTERVAL;VALUE=DURATION:PT4H",
+		"X-PUBLISHED-TTL:PT4H",
+		"BEGIN:VTIMEZONE",
+		"TZID:America/New_York",
+		"X-LIC-LOCATION:America/New_York",
+		"BEGIN:DAYLIGHT",
+		"DTSTART:20240310T020000",
+		"TZOFFSETFROM:-0500",
+		"TZOFFSETTO:-0400",
+		"TZNAME:EDT",
+		"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU",
+		"END:DAYLIGHT",
+		"BEGIN:STANDARD",
+		"DTSTART:20241103T020000",
+		"TZOFFSETFROM:-0400",
+		"TZOFFSETTO:-0500",
+		"TZNAME:EST",
+		"RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU",
+		"END:STANDARD",
+		"END:VTIMEZONE",
+		"BEGIN:VEVENT",
+		"UID:schedule-7@khoj",
+		"DTSTAMP:20250301T120000Z",
+		"CREATED:20250201T080000Z",
+		"DTSTART;TZID=America/New_York:20250310T090000",
+		"DURATION:PT30M",
+		"SUMMARY:📚 KHOJ quiz: Théorie des ensembles — cardinalité\\, ordinaux ",
+		" et l’axiome du choix",
+		"DESCRIPTION:Time for your quiz on 'Théorie des ensembles — cardinalité\\",
+		" , ordinaux et l’axiome du choix'.\\nhttp://localhost:3000/chat/c1",
+		"URL:http://localhost:3000/chat/c1",
+		"CATEGORIES:KHOJ,Study",
+		"BEGIN:VALARM",
+		"ACTION:DISPLAY",
+		"DESCRIPTION:Quiz on Théorie des ensembles — cardinalité\\, ordinaux et l",
+		" ’axiome du choix",
+		"TRIGGER:PT0S",
+		"END:VALARM",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+	if got != want {
+		t.Errorf("ScheduleCalendar =\n%s\nwant\n%s", got, want)
+	}
+	for _, line := range strings.Split(got, "\r\n") {
+		if len(line) > icalLineOctets {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+	}
+}
+
+func TestWriteVTimezoneNoDST(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	var w icalWriter
+	writeVTimezone(&w, kolkata, 2024, 2025)
+	want := strings.Join([]string{
+		"BEGIN:VTIMEZONE",
+		"TZID:Asia/Kolkata",
+		"X-LIC-LOCATION:Asia/Kolkata",
+		"BEGIN:STANDARD",
+		"DTSTART:19700101T000000",
+		"TZOFFSETFROM:+0530",
+		"TZOFFSETTO:+0530",
+		"TZNAME:IST",
+		"END:STANDARD",
+		"END:VTIMEZONE",
+		"",
+	}, "\r\n")
+	if got := w.b.String(); got != want {
+		t.Errorf("writeVTimezone =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestICalRecurrence(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	const layout = "2006-01-02 15:04 MST"
+	tests := []struct {
+		name    string
+		rule    string
+		start   time.Time
+		exdates string
+		rrule   string
+		want    []string
+	}{
+		{
+			name:    "date UNTIL becomes the end of that day in UTC",
+			rule:    "FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20250331",
+			start:   LocalTime(2025, 3, 3, 9, 0, 0, ny),
+			exdates: "2025-03-17T09:00:00",
+			rrule:   "FREQ=WEEKLY;UNTIL=20250401T035959Z;BYDAY=MO,WE",
+			want:    []string{"2025-03-17 09:00 EDT"},
+		},
+		{
+			name:  "floating UNTIL is read in the start's zone",
+			rule:  "FREQ=DAILY;UNTIL=20250110T090000",
+			start: LocalTime(2025, 1, 1, 9, 0, 0, ny),
+			rrule: "FREQ=DAILY;UNTIL=20250110T140000Z",
+		},
+		{
+			name:    "all-day exdate expands to that day's instances",
+			rule:    "FREQ=DAILY;BYHOUR=9,18",
+			start:   LocalTime(2025, 3, 1, 9, 0, 0, ny),
+			exdates: "2025-03-09",
+			rrule:   "FREQ=DAILY;BYHOUR=9,18",
+			want:    []string{"2025-03-09 09:00 EDT", "2025-03-09 18:00 EDT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseRRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ex, err := ParseExDates(tt.exdates, ny)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := Recurrence{Rule: r, Start: tt.start, ExDates: ex}
+			if got := rec.icalRule(); got != tt.rrule {
+				t.Errorf("icalRule = %q, want %q", got, tt.rrule)
+			}
+			var got []string
+			for _, e := range icalExDates(rec) {
+				got = append(got, e.In(ny).Format(layout))
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("icalExDates = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
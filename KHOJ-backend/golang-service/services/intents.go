@@ -0,0 +1,228 @@
+package services
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chat intents recognised before a message reaches the LLM
+const (
+	IntentStartQuiz   = "start_quiz"
+	IntentAnswerQuiz  = "answer_quiz"
+	IntentSetReminder = "set_reminder"
+	IntentSummarize   = "summarize"
+	IntentDashboard   = "dashboard"
+	IntentSnooze      = "snooze"
+	IntentSkip        = "skip"
+	IntentHelp        = "help"
+)
+
+// Intent is a chat message understood as a command. Args hold the parsed
+// parameters, e.g. "duration" for a quiz or "reminder_time" for a reminder.
+type Intent struct {
+	Name  string
+	Args  map[string]string
+	Slash bool // Typed as a /command rather than recognised from a phrase
+}
+
+// ChatContext is what the interpreter needs to know about the conversation
+type ChatContext struct {
+	QuizActive bool // The chat has a quiz waiting for an answer
+}
+
+// ChatCommandHelp lists the slash commands for /help
+const ChatCommandHelp = "Commands:\n" +
+	"/quiz [minutes] - start a quiz on this topic (or type 'quiz here')\n" +
+	"/answer <A-D or text> - answer the current quiz question\n" +
+	"/remind <when> - e.g. '/remind 7pm daily', '/remind 18:30 on weekdays', '/remind in 30 minutes'\n" +
+	"/summary - summarize this chat\n" +
+	"/snooze [minutes] - snooze the latest reminder\n" +
+	"/skip - skip the latest reminder\n" +
+	"/dashboard - open your dashboard"
+
+var (
+	slashCommands = map[string]string{
+		"quiz": IntentStartQuiz, "start": IntentStartQuiz,
+		"answer": IntentAnswerQuiz, "a": IntentAnswerQuiz,
+		"remind": IntentSetReminder, "reminder": IntentSetReminder,
+		"summary": IntentSummarize, "summarize": IntentSummarize, "summarise": IntentSummarize,
+		"dashboard": IntentDashboard,
+		"snooze":    IntentSnooze,
+		"skip":      IntentSkip,
+		"help":      IntentHelp, "commands": IntentHelp,
+	}
+
+	startQuizPattern  = regexp.MustCompile(`^(?:let'?s\s+|can you\s+|please\s+|i want to\s+|i'd like to\s+)?(?:take|start|begin|do|give me|quiz me)(?:\s+(?:a|the|my|me))?(?:\s+(?:quick|short))?(?:\s+(\d{1,2})[\s-]?(?:min|mins|minute|minutes))?(?:\s+quiz)?(?:\s+(?:here|now|on this|on this topic))?(?:\s+(?:for\s+)?(\d{1,2})\s*(?:min|mins|minute|minutes))?\s*(?:please)?[.!]?$`)
+	quizHerePattern   = regexp.MustCompile(`^(?:quiz here|quiz me|quiz|take quiz here)[.!]?$`)
+	answerPattern     = regexp.MustCompile(`^(?:(?:my\s+)?answer(?:\s+is)?|option|i (?:choose|pick|think it'?s)|it'?s)?\s*[:\-]?\s*\(?([a-d])\)?[.!]?$`)
+	remindPattern     = regexp.MustCompile(`^(?:please\s+)?(?:set (?:a |up a )?reminder|remind me)\b`)
+	summarizePattern  = regexp.MustCompile(`^(?:please\s+|can you\s+)?(?:summari[sz]e|give me a summary of|sum up|recap)(?:\s+(?:this|the|our))?(?:\s+(?:chat|conversation|discussion))?(?:\s+so far)?\s*(?:please)?[.!?]?$`)
+	dashboardPattern  = regexp.MustCompile(`^(?:go to |open |show me )?(?:the |my )?dashboard[.!]?$`)
+	snoozePattern     = regexp.MustCompile(`^(?:please\s+)?snooze(?:\s+(?:it|this|the reminder))?(?:\s+(?:for\s+)?(\d{1,4})\s*(?:m|min|mins|minutes|h|hr|hrs|hours?))?[.!]?$`)
+	skipPattern       = regexp.MustCompile(`^(?:skip(?:\s+(?:it|this|this one|today|the reminder))?|not today)[.!]?$`)
+	reminderClock     = regexp.MustCompile(`\b(?:at\s+)?(\d{1,2})(?::(\d{2}))?\s*(am|pm)\b|\b(?:at\s+)?(\d{1,2}):(\d{2})\b|\bat\s+(\d{1,2})\b`)
+	reminderIn        = regexp.MustCompile(`\bin\s+(\d{1,4})\s*(m|min|mins|minutes|h|hr|hrs|hours?)\b`)
+	reminderDaily     = regexp.MustCompile(`\b(daily|every ?day|each day|every night|every morning|every evening|nightly)\b`)
+	leadingMinutes    = regexp.MustCompile(`^(\d{1,2})`)
+	leadingSnooze     = regexp.MustCompile(`^(\d{1,4})\s*(h|hr|hrs|hours?)?`)
+	hoursSuffix       = regexp.MustCompile(`(h|hr|hrs|hours?)[.!]?$`)
+	reminderWeekdays  = map[string]string{"sun": "0", "mon": "1", "tue": "2", "wed": "3", "thu": "4", "fri": "5", "sat": "6"}
+	reminderDayTokens = regexp.MustCompile(`\b(sun|mon|tue|tues|wed|thu|thur|thurs|fri|sat)(?:day|nesday|sday|urday|rsday)?s?\b`)
+)
+
+// ParseIntent recognises a command in a chat message. Messages that aren't
+// commands return ok=false and go to the LLM as before. Bare answers like
+// "B" only count as quiz answers while a quiz is active.
+func ParseIntent(message string, chat ChatContext) (Intent, bool) {
+	text := strings.TrimSpace(message)
+	if text == "" {
+		return Intent{}, false
+	}
+
+	if strings.HasPrefix(text, "/") {
+		fields := strings.Fields(text[1:])
+		if len(fields) == 0 {
+			return Intent{}, false
+		}
+		name, ok := slashCommands[strings.ToLower(fields[0])]
+		if !ok {
+			return Intent{Name: IntentHelp, Args: map[string]string{"unknown": fields[0]}, Slash: true}, true
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(text[1:], fields[0]))
+		intent := Intent{Name: name, Args: map[string]string{}, Slash: true}
+		switch name {
+		case IntentStartQuiz:
+			if m := leadingMinutes.FindStringSubmatch(rest); m != nil {
+				intent.Args["duration"] = m[1]
+			}
+		case IntentAnswerQuiz:
+			intent.Args["answer"] = rest
+		case IntentSetReminder:
+			for k, v := range parseReminderPhrase(strings.ToLower(rest)) {
+				intent.Args[k] = v
+			}
+		case IntentSnooze:
+			if m := leadingSnooze.FindStringSubmatch(rest); m != nil {
+				intent.Args["minutes"] = snoozeMinutes(m[1], m[2])
+			}
+		}
+		return intent, true
+	}
+
+	lower := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	switch {
+	case chat.QuizActive && answerPattern.MatchString(lower):
+		return Intent{Name: IntentAnswerQuiz, Args: map[string]string{"answer": strings.ToUpper(answerPattern.FindStringSubmatch(lower)[1])}}, true
+	case quizHerePattern.MatchString(lower):
+		return Intent{Name: IntentStartQuiz, Args: map[string]string{}}, true
+	case strings.Contains(lower, "quiz") && startQuizPattern.MatchString(lower):
+		intent := Intent{Name: IntentStartQuiz, Args: map[string]string{}}
+		if m := startQuizPattern.FindStringSubmatch(lower); m[1] != "" || m[2] != "" {
+			intent.Args["duration"] = m[1] + m[2]
+		}
+		return intent, true
+	case remindPattern.MatchString(lower):
+		args := parseReminderPhrase(lower)
+		if args["reminder_time"] == "" && args["in_minutes"] == "" {
+			return Intent{}, false // "remind me what a monad is" is a question, not a command
+		}
+		return Intent{Name: IntentSetReminder, Args: args}, true
+	case summarizePattern.MatchString(lower):
+		return Intent{Name: IntentSummarize, Args: map[string]string{}}, true
+	case dashboardPattern.MatchString(lower):
+		return Intent{Name: IntentDashboard, Args: map[string]string{}}, true
+	case snoozePattern.MatchString(lower):
+		intent := Intent{Name: IntentSnooze, Args: map[string]string{}}
+		if m := snoozePattern.FindStringSubmatch(lower); m[1] != "" {
+			unit := hoursSuffix.FindString(lower)
+			intent.Args["minutes"] = snoozeMinutes(m[1], unit)
+		}
+		return intent, true
+	case skipPattern.MatchString(lower):
+		return Intent{Name: IntentSkip, Args: map[string]string{}}, true
+	}
+	return Intent{}, false
+}
+
+func snoozeMinutes(n string, unit string) string {
+	v, _ := strconv.Atoi(n)
+	if strings.HasPrefix(unit, "h") {
+		v *= 60
+	}
+	return strconv.Itoa(v)
+}
+
+// parseReminderPhrase reads a time and recurrence out of phrases like
+// "7pm daily", "at 18:30 on weekdays", "every monday and thursday at 9am",
+// "tomorrow at 8" or "in 45 minutes". It returns the schedule fields:
+// recurrence_type, reminder_time, days_of_week, and day ("today"/"tomorrow")
+// or in_minutes for one-time reminders.
+func parseReminderPhrase(text string) map[string]string {
+	args := map[string]string{}
+
+	if m := reminderIn.FindStringSubmatch(text); m != nil {
+		args["in_minutes"] = snoozeMinutes(m[1], m[2])
+		args["recurrence_type"] = "once"
+		return args
+	}
+
+	if m := reminderClock.FindStringSubmatch(text); m != nil {
+		var hour, min int
+		switch {
+		case m[1] != "":
+			hour, _ = strconv.Atoi(m[1])
+			min, _ = strconv.Atoi(m[2])
+			if hour < 1 || hour > 12 {
+				hour = -1
+				break
+			}
+			hour %= 12
+			if m[3] == "pm" {
+				hour += 12
+			}
+		case m[4] != "":
+			hour, _ = strconv.Atoi(m[4])
+			min, _ = strconv.Atoi(m[5])
+		default:
+			hour, _ = strconv.Atoi(m[6])
+			// "at 7" with no am/pm: study reminders are more likely evening,
+			// so 1-7 mean pm and 8 onwards are taken as written
+			if hour >= 1 && hour <= 7 {
+				hour += 12
+			}
+		}
+		if hour >= 0 && hour <= 23 && min >= 0 && min <= 59 {
+			args["reminder_time"] = time.Date(2000, 1, 1, hour, min, 0, 0, time.UTC).Format("15:04")
+		}
+	}
+
+	switch {
+	case strings.Contains(text, "weekday"):
+		args["recurrence_type"], args["days_of_week"] = "weekly", "1,2,3,4,5"
+	case strings.Contains(text, "weekend"):
+		args["recurrence_type"], args["days_of_week"] = "weekly", "0,6"
+	case reminderDaily.MatchString(text):
+		args["recurrence_type"] = "daily"
+	default:
+		var days []string
+		seen := map[string]bool{}
+		for _, m := range reminderDayTokens.FindAllStringSubmatch(text, -1) {
+			d := reminderWeekdays[m[1][:3]]
+			if !seen[d] {
+				seen[d] = true
+				days = append(days, d)
+			}
+		}
+		switch {
+		case len(days) > 0:
+			args["recurrence_type"], args["days_of_week"] = "weekly", strings.Join(days, ",")
+		case strings.Contains(text, "tomorrow"):
+			args["recurrence_type"], args["day"] = "once", "tomorrow"
+		default:
+			args["recurrence_type"], args["day"] = "once", "today"
+		}
+	}
+	return args
+}
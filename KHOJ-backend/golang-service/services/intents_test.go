@@ -0,0 +1,113 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIntent(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		chat    ChatContext
+		ok      bool
+		want    Intent
+	}{
+		{
+			name:    "question that starts like a reminder",
+			message: "remind me what a monad is",
+		},
+		{
+			name:    "bare hour reads as the evening",
+			message: "remind me at 7",
+			ok:      true,
+			want:    Intent{Name: IntentSetReminder, Args: map[string]string{"reminder_time": "19:00", "recurrence_type": "once", "day": "today"}},
+		},
+		{
+			name:    "bare letter during a quiz",
+			message: "b",
+			chat:    ChatContext{QuizActive: true},
+			ok:      true,
+			want:    Intent{Name: IntentAnswerQuiz, Args: map[string]string{"answer": "B"}},
+		},
+		{
+			name:    "bare letter without a quiz",
+			message: "b",
+		},
+		{
+			name:    "snooze in hours",
+			message: "snooze for 2 hours",
+			ok:      true,
+			want:    Intent{Name: IntentSnooze, Args: map[string]string{"minutes": "120"}},
+		},
+		{
+			name:    "slash snooze in hours",
+			message: "/snooze 1h",
+			ok:      true,
+			want:    Intent{Name: IntentSnooze, Args: map[string]string{"minutes": "60"}, Slash: true},
+		},
+		{
+			name:    "weekday tokens",
+			message: "remind me every monday and thursday at 9am",
+			ok:      true,
+			want:    Intent{Name: IntentSetReminder, Args: map[string]string{"reminder_time": "09:00", "recurrence_type": "weekly", "days_of_week": "1,4"}},
+		},
+		{
+			name:    "plural weekday tokens",
+			message: "remind me on tuesdays and thursdays at 18:30",
+			ok:      true,
+			want:    Intent{Name: IntentSetReminder, Args: map[string]string{"reminder_time": "18:30", "recurrence_type": "weekly", "days_of_week": "2,4"}},
+		},
+		{
+			name:    "slash quiz with a non-numeric duration",
+			message: "/quiz soon",
+			ok:      true,
+			want:    Intent{Name: IntentStartQuiz, Args: map[string]string{}, Slash: true},
+		},
+		{
+			name:    "slash remind without a time",
+			message: "/remind whenever",
+			ok:      true,
+			want:    Intent{Name: IntentSetReminder, Args: map[string]string{"recurrence_type": "once", "day": "today"}, Slash: true},
+		},
+		{
+			name:    "unknown slash command",
+			message: "/frobnicate now",
+			ok:      true,
+			want:    Intent{Name: IntentHelp, Args: map[string]string{"unknown": "frobnicate"}, Slash: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseIntent(tt.message, tt.chat)
+			if ok != tt.ok {
+				t.Fatalf("ParseIntent(%q) ok = %v, want %v", tt.message, ok, tt.ok)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseIntent(%q) = %+v, want %+v", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReminderPhrase(t *testing.T) {
+	tests := []struct {
+		phrase string
+		want   map[string]string
+	}{
+		{"7pm daily", map[string]string{"reminder_time": "19:00", "recurrence_type": "daily"}},
+		{"at 18:30 on weekdays", map[string]string{"reminder_time": "18:30", "recurrence_type": "weekly", "days_of_week": "1,2,3,4,5"}},
+		{"tomorrow at 8", map[string]string{"reminder_time": "08:00", "recurrence_type": "once", "day": "tomorrow"}},
+		{"12am on weekends", map[string]string{"reminder_time": "00:00", "recurrence_type": "weekly", "days_of_week": "0,6"}},
+		{"in 45 minutes", map[string]string{"in_minutes": "45", "recurrence_type": "once"}},
+		{"in 2 hours", map[string]string{"in_minutes": "120", "recurrence_type": "once"}},
+		// Out-of-range clock times leave the time unset
+		{"at 25:00", map[string]string{"recurrence_type": "once", "day": "today"}},
+		{"13pm", map[string]string{"recurrence_type": "once", "day": "today"}},
+	}
+	for _, tt := range tests {
+		if got := parseReminderPhrase(tt.phrase); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseReminderPhrase(%q) = %v, want %v", tt.phrase, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang-service/config"
+)
+
+// Leaderboard windows
+const (
+	LeaderboardWeekly  = "weekly"
+	LeaderboardMonthly = "monthly"
+	LeaderboardAllTime = "all_time"
+)
+
+// Leaderboard scopes
+const (
+	ScopeGlobal = "global"
+	ScopeTopic  = "topic"
+	ScopeGroup  = "group"
+)
+
+// leaderboardTTL bounds how stale a cached leaderboard can get; completed
+// quizzes and privacy changes invalidate it sooner
+const leaderboardTTL = 5 * time.Minute
+
+// LeaderboardEntry is one ranked learner. Points are the sum of quiz
+// percentages (0-100 per quiz) so quizzes of different lengths compare fairly.
+type LeaderboardEntry struct {
+	Rank          int       `json:"rank"`
+	UserID        int       `json:"user_id"`
+	Username      string    `json:"username"`
+	Points        int       `json:"points"`
+	Quizzes       int       `json:"quizzes"`
+	Correct       int       `json:"correct"`
+	Answered      int       `json:"answered"`
+	Accuracy      float64   `json:"accuracy"`
+	LastCompleted time.Time `json:"last_completed"`
+}
+
+// leaderboardRow is the per-user, per-topic aggregate the leaderboards are built from
+type leaderboardRow struct {
+	UserID        int       `db:"user_id"`
+	Username      string    `db:"username"`
+	Topic         string    `db:"topic"`
+	Points        int       `db:"points"`
+	Quizzes       int       `db:"quizzes"`
+	Correct       int       `db:"correct"`
+	Answered      int       `db:"answered"`
+	LastCompleted time.Time `db:"last_completed"`
+}
+
+type leaderboardCacheEntry struct {
+	rows    []leaderboardRow
+	expires time.Time
+}
+
+var (
+	leaderboardMu    sync.Mutex
+	leaderboardCache = map[string]leaderboardCacheEntry{}
+)
+
+// StartLeaderboards drops cached leaderboards whenever a quiz is completed
+func StartLeaderboards() {
+	Subscribe(EventQuizCompleted, func(Event) { InvalidateLeaderboards() })
+}
+
+// InvalidateLeaderboards clears every cached leaderboard
+func InvalidateLeaderboards() {
+	leaderboardMu.Lock()
+	leaderboardCache = map[string]leaderboardCacheEntry{}
+	leaderboardMu.Unlock()
+}
+
+// ValidLeaderboardWindow reports whether w is a supported window
+func ValidLeaderboardWindow(w string) bool {
+	return w == LeaderboardWeekly || w == LeaderboardMonthly || w == LeaderboardAllTime
+}
+
+// leaderboardWindowStart returns the start of the current week (Monday) or
+// month in loc, or the zero time for all-time
+func leaderboardWindowStart(window string, now time.Time, loc *time.Location) time.Time {
+	now = now.In(loc)
+	switch window {
+	case LeaderboardWeekly:
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case LeaderboardMonthly:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	}
+	return time.Time{}
+}
+
+// leaderboardRows returns the aggregates for a window, from the cache when fresh
+func leaderboardRows(window string, loc *time.Location) ([]leaderboardRow, time.Time, error) {
+	since := leaderboardWindowStart(window, time.Now(), loc)
+	key := window + "|" + loc.String() + "|" + since.Format(time.RFC3339)
+
+	leaderboardMu.Lock()
+	entry, ok := leaderboardCache[key]
+	leaderboardMu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.rows, since, nil
+	}
+
+	var rows []leaderboardRow
+	err := config.DB.Select(&rows, `
+		SELECT q.user_id, u.username, q.topic,
+			SUM(ROUND(100.0 * COALESCE(q.score, 0) / q.total_questions))::int AS points,
+			COUNT(*)::int AS quizzes,
+			SUM(COALESCE(q.score, 0))::int AS correct,
+			SUM(q.total_questions)::int AS answered,
+			MAX(q.completed_at) AS last_completed
+		FROM quizzes q
+		JOIN users u ON u.id = q.user_id
+		WHERE q.status='completed' AND q.total_questions > 0 AND q.completed_at >= $1
+		AND NOT u.leaderboard_opt_out
+		GROUP BY q.user_id, u.username, q.topic
+	`, since)
+	if err != nil {
+		return nil, since, err
+	}
+
+	leaderboardMu.Lock()
+	for k, e := range leaderboardCache {
+		if time.Now().After(e.expires) {
+			delete(leaderboardCache, k)
+		}
+	}
+	leaderboardCache[key] = leaderboardCacheEntry{rows: rows, expires: time.Now().Add(leaderboardTTL)}
+	leaderboardMu.Unlock()
+	return rows, since, nil
+}
+
+// LeaderboardQuery selects which leaderboard to build
+type LeaderboardQuery struct {
+	Window   string
+	Scope    string
+	Topic    string // Canonical topic, for ScopeTopic
+	GroupID  int    // For ScopeGroup
+	Location *time.Location
+}
+
+// BuildLeaderboard ranks learners for the query. Learners who opted out are
+// left out entirely. Ties are broken by accuracy, then by who reached their
+// score first, then by user id, so the order is stable between requests.
+func BuildLeaderboard(q LeaderboardQuery) ([]LeaderboardEntry, time.Time, error) {
+	rows, since, err := leaderboardRows(q.Window, q.Location)
+	if err != nil {
+		return nil, since, err
+	}
+
+	var members map[int]bool
+	if q.Scope == ScopeGroup {
+		var ids []int
+		if err := config.DB.Select(&ids, "SELECT user_id FROM study_group_members WHERE group_id=$1", q.GroupID); err != nil {
+			return nil, since, err
+		}
+		members = map[int]bool{}
+		for _, id := range ids {
+			members[id] = true
+		}
+	}
+
+	byUser := map[int]*LeaderboardEntry{}
+	for _, r := range rows {
+		if q.Scope == ScopeTopic && CanonicalTopic(r.Topic) != q.Topic {
+			continue
+		}
+		if members != nil && !members[r.UserID] {
+			continue
+		}
+		e, ok := byUser[r.UserID]
+		if !ok {
+			e = &LeaderboardEntry{UserID: r.UserID, Username: r.Username}
+			byUser[r.UserID] = e
+		}
+		e.Points += r.Points
+		e.Quizzes += r.Quizzes
+		e.Correct += r.Correct
+		e.Answered += r.Answered
+		if r.LastCompleted.After(e.LastCompleted) {
+			e.LastCompleted = r.LastCompleted
+		}
+	}
+
+	entries := make([]LeaderboardEntry, 0, len(byUser))
+	for _, e := range byUser {
+		e.Accuracy = percentage(e.Correct, e.Answered)
+		e.LastCompleted = e.LastCompleted.In(q.Location)
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		if a.Accuracy != b.Accuracy {
+			return a.Accuracy > b.Accuracy
+		}
+		if !a.LastCompleted.Equal(b.LastCompleted) {
+			return a.LastCompleted.Before(b.LastCompleted)
+		}
+		return a.UserID < b.UserID
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries, since, nil
+}
+
+// NewJoinCode returns a random 8 character group join code without
+// look-alike characters
+func NewJoinCode() string {
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%08X", time.Now().UnixNano()&0xffffffff)
+	}
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b)
+}
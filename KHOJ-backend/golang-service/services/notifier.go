@@ -0,0 +1,389 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+)
+
+// Notification channels
+const (
+	ChannelInApp   = "in_app"
+	ChannelEmail   = "email"
+	ChannelPush    = "push"
+	ChannelWebhook = "webhook"
+)
+
+// NotificationChannels lists every channel in the order they are tried
+var NotificationChannels = []string{ChannelInApp, ChannelEmail, ChannelPush, ChannelWebhook}
+
+// Notification kinds; each has a template
+const (
+	NotifyQuizReminder = "quiz_reminder"
+	NotifyTest         = "test"
+	NotifyDailyFact    = "daily_fact"
+)
+
+const (
+	notifyMaxAttempts = 3
+	notifyRetryDelay  = 2 * time.Second
+)
+
+// ErrNoRecipient means the channel has nowhere to deliver for this user (no
+// email address, push subscription or webhook URL); the delivery is skipped
+var ErrNoRecipient = errors.New("no recipient configured for this channel")
+
+// Notification is one message to a user, rendered per channel from the
+// template named by Kind
+type Notification struct {
+	UserID int
+	ChatID string // Chat the in-app message goes to, and the link in other channels
+	Kind   string
+	Data   map[string]interface{}
+	// DedupeKey makes delivery idempotent: a channel never sends the same
+	// key to the same user twice
+	DedupeKey string
+	// Skip lists channels that were already handled by the caller
+	Skip []string
+	// Aside posts the in-app message beside the conversation instead of
+	// continuing it (see AppendAside)
+	Aside bool
+}
+
+// RenderedMessage is a notification's text for one channel
+type RenderedMessage struct {
+	Subject string
+	Body    string
+	URL     string
+}
+
+// Recipient is where a channel delivers for one user
+type Recipient struct {
+	UserID   int
+	Username string
+	Email    string
+	Target   string // Channel-specific override from the user's preferences
+}
+
+// Notifier delivers notifications on one channel
+type Notifier interface {
+	Channel() string
+	Send(ctx context.Context, to Recipient, n Notification, msg RenderedMessage) error
+}
+
+var (
+	notifiersMu sync.RWMutex
+	notifiers   = map[string]Notifier{}
+)
+
+// RegisterNotifier installs (or replaces) the notifier for its channel
+func RegisterNotifier(n Notifier) {
+	notifiersMu.Lock()
+	defer notifiersMu.Unlock()
+	notifiers[n.Channel()] = n
+}
+
+func notifierFor(channel string) (Notifier, bool) {
+	notifiersMu.RLock()
+	defer notifiersMu.RUnlock()
+	n, ok := notifiers[channel]
+	return n, ok
+}
+
+// ChannelAvailable reports whether the server can deliver on a channel
+func ChannelAvailable(channel string) bool {
+	_, ok := notifierFor(channel)
+	return ok
+}
+
+// ValidChannel reports whether channel is a known notification channel
+func ValidChannel(channel string) bool {
+	for _, c := range NotificationChannels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// StartNotifications registers every channel that is configured. Email needs
+// MAIL_SERVER/MAIL_USERNAME (the same variables as the Python mailer) or a
+// mail sink, push needs VAPID keys, webhooks need NOTIFY_WEBHOOK_SECRET.
+// NOTIFY_DEV_SINKS=true swaps in local stand-ins for development and tests.
+func StartNotifications() {
+	RegisterNotifier(inAppNotifier{})
+
+	dev := DevSinksEnabled()
+	if email, ok := newEmailNotifier(dev); ok {
+		RegisterNotifier(email)
+	}
+	if push, ok := newPushNotifier(dev); ok {
+		RegisterNotifier(push)
+	}
+	if hook, ok := newWebhookNotifier(dev); ok {
+		RegisterNotifier(hook)
+	}
+
+	var enabled []string
+	for _, c := range NotificationChannels {
+		if ChannelAvailable(c) {
+			enabled = append(enabled, c)
+		}
+	}
+	fmt.Printf("🔔 Notification channels: %s\n", strings.Join(enabled, ", "))
+}
+
+// DevSinksEnabled reports whether the local mail and push stand-ins are on
+func DevSinksEnabled() bool {
+	v := strings.ToLower(os.Getenv("NOTIFY_DEV_SINKS"))
+	return v == "true" || v == "1" || v == "yes"
+}
+
+// notificationTemplates hold a subject and body per kind; "kind/channel"
+// overrides the default for one channel
+var notificationTemplates = map[string]*template.Template{}
+
+func init() {
+	defs := map[string][2]string{
+		NotifyQuizReminder: {
+			"Time for your {{.topic}} quiz",
+			"Hi {{.username}}, it's time for your quiz on '{{.topic}}'. Open Khoj to take it: {{.url}}",
+		},
+		NotifyQuizReminder + "/" + ChannelInApp: {
+			"",
+			ReminderMessage("{{.topic}}"), // Same text the scheduler posts
+		},
+		NotifyQuizReminder + "/" + ChannelPush: {
+			"Quiz time: {{.topic}}",
+			"Your {{.topic}} quiz is ready. Tap to start.",
+		},
+		NotifyDailyFact: {
+			"Did you know? ({{.topic}})",
+			"Hi {{.username}}, here's today's fact about '{{.topic}}': {{.fact}} Rate it or mute these facts in Khoj: {{.url}}",
+		},
+		NotifyDailyFact + "/" + ChannelInApp: {
+			"",
+			FactMessagePrefix + "{{.fact}}",
+		},
+		NotifyDailyFact + "/" + ChannelPush: {
+			"💡 {{.topic}}",
+			"{{.fact}}",
+		},
+		NotifyTest: {
+			"Khoj test notification",
+			"Hi {{.username}}, notifications are working on this channel.",
+		},
+	}
+	for name, d := range defs {
+		notificationTemplates[name] = template.Must(template.New(name).Parse(
+			`{{define "subject"}}` + d[0] + `{{end}}{{define "body"}}` + d[1] + `{{end}}`))
+	}
+}
+
+// RenderNotification renders the notification's template for a channel
+func RenderNotification(n Notification, channel string, to Recipient) (RenderedMessage, error) {
+	tmpl, ok := notificationTemplates[n.Kind+"/"+channel]
+	if !ok {
+		if tmpl, ok = notificationTemplates[n.Kind]; !ok {
+			return RenderedMessage{}, fmt.Errorf("no template for notification kind %q", n.Kind)
+		}
+	}
+	data := map[string]interface{}{"username": to.Username, "url": notificationURL(n)}
+	for k, v := range n.Data {
+		data[k] = v
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return RenderedMessage{}, err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return RenderedMessage{}, err
+	}
+	return RenderedMessage{Subject: subject.String(), Body: body.String(), URL: notificationURL(n)}, nil
+}
+
+// notificationURL links to the notification's chat in the frontend
+// (APP_BASE_URL, default http://localhost:3000)
+func notificationURL(n Notification) string {
+	base := strings.TrimRight(os.Getenv("APP_BASE_URL"), "/")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	if n.ChatID == "" {
+		return base + "/dashboard"
+	}
+	return base + "/chat/" + n.ChatID
+}
+
+// UserNotificationPreferences returns the user's setting for every channel.
+// Channels without a stored row default to in-app only.
+func UserNotificationPreferences(userID int) ([]models.NotificationPreference, error) {
+	var stored []models.NotificationPreference
+	if err := config.DB.Select(&stored, "SELECT * FROM notification_preferences WHERE user_id=$1", userID); err != nil {
+		return nil, err
+	}
+	byChannel := map[string]models.NotificationPreference{}
+	for _, p := range stored {
+		byChannel[p.Channel] = p
+	}
+	prefs := make([]models.NotificationPreference, 0, len(NotificationChannels))
+	for _, c := range NotificationChannels {
+		p, ok := byChannel[c]
+		if !ok {
+			p = models.NotificationPreference{UserID: userID, Channel: c, Enabled: c == ChannelInApp}
+		}
+		prefs = append(prefs, p)
+	}
+	return prefs, nil
+}
+
+// Notify delivers n on every channel the user has enabled and the server
+// supports, recording each attempt in notification_deliveries. Channels are
+// independent: one failing does not stop the others.
+func Notify(ctx context.Context, n Notification) []models.NotificationDelivery {
+	prefs, err := UserNotificationPreferences(n.UserID)
+	if err != nil {
+		fmt.Printf("Warning: failed to load notification preferences for user %d: %v\n", n.UserID, err)
+		return nil
+	}
+	var to Recipient
+	err = config.DB.QueryRow("SELECT username, email FROM users WHERE id=$1", n.UserID).Scan(&to.Username, &to.Email)
+	if err != nil {
+		fmt.Printf("Warning: notification for unknown user %d: %v\n", n.UserID, err)
+		return nil
+	}
+	to.UserID = n.UserID
+
+	var out []models.NotificationDelivery
+	for _, p := range prefs {
+		if !p.Enabled || containsString(n.Skip, p.Channel) {
+			continue
+		}
+		notifier, ok := notifierFor(p.Channel)
+		if !ok {
+			continue
+		}
+		r := to
+		r.Target = p.Target
+		out = append(out, deliverNotification(ctx, notifier, r, n))
+	}
+	return out
+}
+
+// NotifyAsync runs Notify in the background, for callers that shouldn't
+// wait on SMTP or push services
+func NotifyAsync(n Notification) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Printf("Warning: notification for user %d panicked: %v\n", n.UserID, r)
+			}
+		}()
+		Notify(context.Background(), n)
+	}()
+}
+
+// deliverNotification sends on one channel with a few quick retries and
+// records the outcome
+func deliverNotification(ctx context.Context, notifier Notifier, to Recipient, n Notification) models.NotificationDelivery {
+	channel := notifier.Channel()
+	d := models.NotificationDelivery{UserID: n.UserID, Channel: channel, Kind: n.Kind, DedupeKey: n.DedupeKey, Target: to.Target, Status: "pending"}
+
+	if n.DedupeKey != "" {
+		var sent bool
+		err := config.DB.Get(&sent, `
+			SELECT EXISTS(SELECT 1 FROM notification_deliveries WHERE user_id=$1 AND channel=$2 AND dedupe_key=$3 AND status='sent')
+		`, n.UserID, channel, n.DedupeKey)
+		if err == nil && sent {
+			d.Status = "skipped"
+			d.Error = "already sent"
+			return d
+		}
+	}
+
+	msg, err := RenderNotification(n, channel, to)
+	if err == nil {
+		for d.Attempts < notifyMaxAttempts {
+			d.Attempts++
+			if err = notifier.Send(ctx, to, n, msg); err == nil || errors.Is(err, ErrNoRecipient) {
+				break
+			}
+			if d.Attempts < notifyMaxAttempts {
+				select {
+				case <-ctx.Done():
+					err = ctx.Err()
+				case <-time.After(notifyRetryDelay * time.Duration(d.Attempts)):
+				}
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+
+	switch {
+	case err == nil:
+		now := time.Now()
+		d.Status, d.SentAt = "sent", &now
+	case errors.Is(err, ErrNoRecipient):
+		d.Status, d.Error = "skipped", err.Error()
+	default:
+		d.Status, d.Error = "failed", err.Error()
+		fmt.Printf("Warning: %s notification to user %d failed: %v\n", channel, n.UserID, err)
+	}
+
+	err = config.DB.QueryRow(`
+		INSERT INTO notification_deliveries (user_id, channel, kind, dedupe_key, target, status, error, attempts, sent_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at
+	`, d.UserID, d.Channel, d.Kind, d.DedupeKey, d.Target, d.Status, d.Error, d.Attempts, d.SentAt).Scan(&d.ID, &d.CreatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Printf("Warning: failed to record %s notification for user %d: %v\n", channel, n.UserID, err)
+	}
+	return d
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// inAppNotifier posts the message into the notification's chat as the bot
+type inAppNotifier struct{}
+
+func (inAppNotifier) Channel() string { return ChannelInApp }
+
+func (inAppNotifier) Send(ctx context.Context, to Recipient, n Notification, msg RenderedMessage) error {
+	if n.ChatID == "" {
+		return ErrNoRecipient
+	}
+	var owned bool
+	err := config.DB.GetContext(ctx, &owned, "SELECT EXISTS(SELECT 1 FROM chats WHERE id=$1 AND user_id=$2)", n.ChatID, to.UserID)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return ErrNoRecipient
+	}
+	if n.Aside {
+		_, err = AppendAside(ctx, config.DB, n.ChatID, "bot", msg.Body)
+	} else {
+		_, err = AppendMessage(ctx, config.DB, n.ChatID, "bot", msg.Body, "")
+	}
+	return err
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+// RFC 8291 Appendix A
+const (
+	rfc8291Plaintext  = "When I grow up, I want to be a watermelon"
+	rfc8291ASPrivate  = "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"
+	rfc8291UAPrivate  = "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"
+	rfc8291UAPublic   = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	rfc8291AuthSecret = "BTBZMqHH6r4Tts7J_aSIgg"
+	rfc8291Salt       = "DGv6ra1nlYgDCS1FRnbzlw"
+	rfc8291Body       = "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+)
+
+func mustBase64URL(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := decodeBase64URL(s)
+	if err != nil {
+		t.Fatalf("decode %q: %v", s, err)
+	}
+	return b
+}
+
+func TestEncryptPushRecordRFC8291Vector(t *testing.T) {
+	asKey, err := ecdh.P256().NewPrivateKey(mustBase64URL(t, rfc8291ASPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := encryptPushRecord(rfc8291UAPublic, rfc8291AuthSecret, []byte(rfc8291Plaintext), asKey, mustBase64URL(t, rfc8291Salt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mustBase64URL(t, rfc8291Body); !bytes.Equal(got, want) {
+		t.Errorf("body = %s\nwant   %s", base64.RawURLEncoding.EncodeToString(got), rfc8291Body)
+	}
+}
+
+// decryptPushPayload is the user agent's side of RFC 8291
+func decryptPushPayload(t *testing.T, uaKey *ecdh.PrivateKey, auth []byte, body []byte) []byte {
+	t.Helper()
+	if len(body) < 21 || int(body[20]) != 65 || len(body) < 21+65 {
+		t.Fatalf("malformed aes128gcm header")
+	}
+	salt, recordSize, keyID := body[:16], binary.BigEndian.Uint32(body[16:20]), body[21:21+65]
+	if recordSize != pushRecordSize {
+		t.Errorf("record size = %d, want %d", recordSize, pushRecordSize)
+	}
+	asPublic, err := ecdh.P256().NewPublicKey(keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := uaKey.ECDH(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaKey.PublicKey().Bytes()...), keyID...)
+	ikm, _ := hkdf.Key(sha256.New, shared, auth, string(keyInfo), 32)
+	cek, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	record, err := gcm.Open(nil, nonce, body[21+65:], nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if len(record) == 0 || record[len(record)-1] != 0x02 {
+		t.Fatalf("record does not end with the last-record delimiter")
+	}
+	return record[:len(record)-1]
+}
+
+func TestEncryptPushPayloadDecrypts(t *testing.T) {
+	uaKey, err := ecdh.P256().NewPrivateKey(mustBase64URL(t, rfc8291UAPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Padded base64url, as some libraries send it, is accepted too
+	body, err := EncryptPushPayload(rfc8291UAPublic, rfc8291AuthSecret+"==", []byte(rfc8291Plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decryptPushPayload(t, uaKey, mustBase64URL(t, rfc8291AuthSecret), body); string(got) != rfc8291Plaintext {
+		t.Errorf("decrypted %q, want %q", got, rfc8291Plaintext)
+	}
+
+	again, _ := EncryptPushPayload(rfc8291UAPublic, rfc8291AuthSecret, []byte(rfc8291Plaintext))
+	if bytes.Equal(body, again) {
+		t.Error("two encryptions produced the same body; key and salt must be fresh")
+	}
+}
+
+func TestEncryptPushPayloadRejects(t *testing.T) {
+	tests := []struct {
+		name, p256dh, auth string
+		size               int
+	}{
+		{"bad p256dh encoding", "not base64!", rfc8291AuthSecret, 10},
+		{"p256dh not on the curve", base64.RawURLEncoding.EncodeToString(make([]byte, 65)), rfc8291AuthSecret, 10},
+		{"short auth secret", rfc8291UAPublic, "AAAA", 10},
+		{"payload over one record", rfc8291UAPublic, rfc8291AuthSecret, pushRecordSize},
+	}
+	for _, tt := range tests {
+		if _, err := EncryptPushPayload(tt.p256dh, tt.auth, make([]byte, tt.size)); err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	// Expected value computed independently:
+	// HMAC-SHA256("whsec_test", "1700000000." + body) in hex
+	const want = "94f5692e57a0c550df2b9a1b63fcb9a1aab3b8c1b4c7776a94f40fa745a70389"
+	body := []byte(`{"kind":"test"}`)
+	if got := SignWebhook([]byte("whsec_test"), "1700000000", body); got != want {
+		t.Errorf("SignWebhook = %s, want %s", got, want)
+	}
+	if SignWebhook([]byte("whsec_test"), "1700000001", body) == want {
+		t.Error("signature does not cover the timestamp")
+	}
+	if SignWebhook([]byte("other"), "1700000000", body) == want {
+		t.Error("signature does not depend on the secret")
+	}
+}
+
+func TestRenderNotificationChannelFallback(t *testing.T) {
+	t.Setenv("APP_BASE_URL", "https://khoj.example/")
+	fact := Notification{ChatID: "chat-1", Kind: NotifyDailyFact, Data: map[string]interface{}{"topic": "algebra", "fact": "Zero is even."}}
+	to := Recipient{Username: "asha"}
+	tests := []struct {
+		name          string
+		n             Notification
+		channel       string
+		subject, body string
+	}{
+		{
+			name:    "in-app override",
+			n:       fact,
+			channel: ChannelInApp,
+			body:    FactMessagePrefix + "Zero is even.",
+		},
+		{
+			name:    "push override",
+			n:       fact,
+			channel: ChannelPush,
+			subject: "💡 algebra",
+			body:    "Zero is even.",
+		},
+		{
+			name:    "email falls back to the kind's template",
+			n:       fact,
+			channel: ChannelEmail,
+			subject: "Did you know? (algebra)",
+			body:    "Hi asha, here's today's fact about 'algebra': Zero is even. Rate it or mute these facts in Khoj: https://khoj.example/chat/chat-1",
+		},
+		{
+			name:    "kind without overrides",
+			n:       Notification{Kind: NotifyTest},
+			channel: ChannelPush,
+			subject: "Khoj test notification",
+			body:    "Hi asha, notifications are working on this channel.",
+		},
+	}
+	for _, tt := range tests {
+		msg, err := RenderNotification(tt.n, tt.channel, to)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if msg.Subject != tt.subject || msg.Body != tt.body {
+			t.Errorf("%s: got %q / %q, want %q / %q", tt.name, msg.Subject, msg.Body, tt.subject, tt.body)
+		}
+	}
+
+	if msg, _ := RenderNotification(Notification{Kind: NotifyTest}, ChannelEmail, to); msg.URL != "https://khoj.example/dashboard" {
+		t.Errorf("URL without a chat = %q", msg.URL)
+	}
+	if _, err := RenderNotification(Notification{Kind: "no-such-kind"}, ChannelEmail, to); err == nil {
+		t.Error("unknown kind rendered without an error")
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+		"64:ff9b::a00:1":   false,
+	} {
+		if got := PublicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("PublicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestValidPushEndpoint(t *testing.T) {
+	t.Setenv("NOTIFY_DEV_SINKS", "")
+	for endpoint, want := range map[string]bool{
+		"https://fcm.googleapis.com/fcm/send/abc":              true,
+		"https://updates.push.services.mozilla.com/wpush/v2/x": true,
+		"https://web.push.apple.com/QGx":                       true,
+		"https://wns2-par02p.notify.windows.com/w/?token=x":    true,
+		"http://fcm.googleapis.com/fcm/send/abc":               false,
+		"https://fcm.googleapis.com:8443/fcm/send/abc":         false,
+		"https://push.apple.com.evil.example/x":                false,
+		"https://evilpush.apple.com/x":                         false,
+		"https://169.254.169.254/latest/meta-data":             false,
+		"http://localhost:8080/api/dev/push-sink/1":            false,
+	} {
+		if got := ValidPushEndpoint(endpoint); got != want {
+			t.Errorf("ValidPushEndpoint(%s) = %v, want %v", endpoint, got, want)
+		}
+	}
+	t.Setenv("NOTIFY_DEV_SINKS", "true")
+	if !ValidPushEndpoint("http://localhost:8080/api/dev/push-sink/1") {
+		t.Error("dev push sink rejected with NOTIFY_DEV_SINKS set")
+	}
+}
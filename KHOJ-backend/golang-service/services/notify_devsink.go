@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Local stand-ins for development and tests, enabled with NOTIFY_DEV_SINKS.
+// The mail sink keeps rendered emails in memory instead of sending them; the
+// push sink is a fake push service (POST /api/dev/push-sink/:id) that
+// subscriptions can point at. Both keep the most recent devSinkSize entries.
+
+const devSinkSize = 100
+
+// SinkMail is an email captured by the mail sink
+type SinkMail struct {
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	At      time.Time `json:"at"`
+}
+
+// SinkPush is a request received by the fake push endpoint. The payload stays
+// encrypted; the headers show whether VAPID and aes128gcm were applied.
+type SinkPush struct {
+	Subscription    string    `json:"subscription"`
+	Authorization   string    `json:"authorization"`
+	ContentEncoding string    `json:"content_encoding"`
+	TTL             string    `json:"ttl"`
+	Bytes           int       `json:"bytes"`
+	At              time.Time `json:"at"`
+}
+
+var devSinks struct {
+	sync.Mutex
+	mail []SinkMail
+	push []SinkPush
+}
+
+// sinkEmailNotifier is the email channel when NOTIFY_DEV_SINKS is on and no
+// NOTIFY_MAIL_SINK directory is set
+type sinkEmailNotifier struct {
+	from string
+}
+
+func (sinkEmailNotifier) Channel() string { return ChannelEmail }
+
+func (s sinkEmailNotifier) Send(ctx context.Context, to Recipient, n Notification, msg RenderedMessage) error {
+	rcpt := to.Target
+	if rcpt == "" {
+		rcpt = to.Email
+	}
+	if rcpt == "" {
+		return ErrNoRecipient
+	}
+	if _, err := buildEmail(s.from, rcpt, msg); err != nil {
+		return err
+	}
+	devSinks.Lock()
+	defer devSinks.Unlock()
+	devSinks.mail = appendBounded(devSinks.mail, SinkMail{From: s.from, To: rcpt, Subject: msg.Subject, Body: msg.Body, At: time.Now()})
+	return nil
+}
+
+// RecordSinkPush stores a request made to the fake push endpoint
+func RecordSinkPush(p SinkPush) {
+	devSinks.Lock()
+	defer devSinks.Unlock()
+	devSinks.push = appendBounded(devSinks.push, p)
+}
+
+// DevSinkContents returns copies of the captured emails and pushes
+func DevSinkContents() ([]SinkMail, []SinkPush) {
+	devSinks.Lock()
+	defer devSinks.Unlock()
+	return append([]SinkMail{}, devSinks.mail...), append([]SinkPush{}, devSinks.push...)
+}
+
+// ClearDevSinks empties both sinks
+func ClearDevSinks() {
+	devSinks.Lock()
+	defer devSinks.Unlock()
+	devSinks.mail, devSinks.push = nil, nil
+}
+
+func appendBounded[T any](list []T, v T) []T {
+	list = append(list, v)
+	if len(list) > devSinkSize {
+		list = list[len(list)-devSinkSize:]
+	}
+	return list
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emailNotifier sends plain-text mail over SMTP. It reads the same MAIL_*
+// variables as the Python mailer so both can share one configuration:
+// MAIL_SERVER, MAIL_PORT, MAIL_USERNAME, MAIL_PASSWORD, MAIL_USE_TLS (STARTTLS),
+// MAIL_USE_SSL (implicit TLS) and MAIL_DEFAULT_SENDER.
+type emailNotifier struct {
+	server   string
+	port     int
+	username string
+	password string
+	startTLS bool
+	implicit bool
+	from     string
+	// sinkDir, when set, writes each message to a .eml file instead of sending it
+	sinkDir string
+}
+
+func newEmailNotifier(dev bool) (Notifier, bool) {
+	n := emailNotifier{
+		server:   envOr("MAIL_SERVER", "smtp.gmail.com"),
+		port:     587,
+		username: os.Getenv("MAIL_USERNAME"),
+		password: os.Getenv("MAIL_PASSWORD"),
+		startTLS: envBool("MAIL_USE_TLS", true),
+		implicit: envBool("MAIL_USE_SSL", false),
+		from:     envOr("MAIL_DEFAULT_SENDER", os.Getenv("MAIL_USERNAME")),
+		sinkDir:  os.Getenv("NOTIFY_MAIL_SINK"),
+	}
+	if p, err := strconv.Atoi(os.Getenv("MAIL_PORT")); err == nil {
+		n.port = p
+	}
+	if n.sinkDir != "" {
+		if n.from == "" {
+			n.from = "khoj@localhost"
+		}
+		return n, true
+	}
+	if dev {
+		if n.from == "" {
+			n.from = "khoj@localhost"
+		}
+		return sinkEmailNotifier{from: n.from}, true
+	}
+	if n.username == "" || n.from == "" {
+		return nil, false
+	}
+	return n, true
+}
+
+func (emailNotifier) Channel() string { return ChannelEmail }
+
+func (e emailNotifier) Send(ctx context.Context, to Recipient, n Notification, msg RenderedMessage) error {
+	rcpt := to.Target
+	if rcpt == "" {
+		rcpt = to.Email
+	}
+	if rcpt == "" {
+		return ErrNoRecipient
+	}
+	data, err := buildEmail(e.from, rcpt, msg)
+	if err != nil {
+		return err
+	}
+
+	if e.sinkDir != "" {
+		if err := os.MkdirAll(e.sinkDir, 0o755); err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%d-user%d-%s.eml", time.Now().UnixNano(), to.UserID, n.Kind)
+		return os.WriteFile(filepath.Join(e.sinkDir, name), data, 0o644)
+	}
+	return e.sendSMTP(ctx, rcpt, data)
+}
+
+func (e emailNotifier) sendSMTP(ctx context.Context, rcpt string, data []byte) error {
+	addr := net.JoinHostPort(e.server, strconv.Itoa(e.port))
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	var conn net.Conn
+	var err error
+	if e.implicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: e.server}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp connect: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(time.Minute))
+	}
+
+	client, err := smtp.NewClient(conn, e.server)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp handshake: %w", err)
+	}
+	defer client.Close()
+
+	if e.startTLS && !e.implicit {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: e.server}); err != nil {
+				return fmt.Errorf("smtp starttls: %w", err)
+			}
+		}
+	}
+	if e.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.username, e.password, e.server)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	from, err := mail.ParseAddress(e.from)
+	if err != nil {
+		return fmt.Errorf("invalid MAIL_DEFAULT_SENDER: %w", err)
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(rcpt); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildEmail renders a plain-text RFC 5322 message
+func buildEmail(from string, to string, msg RenderedMessage) ([]byte, error) {
+	if _, err := mail.ParseAddress(to); err != nil {
+		return nil, fmt.Errorf("invalid email address %q", to)
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes(), nil
+}
+
+func envOr(key string, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envBool(key string, fallback bool) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(key))) {
+	case "true", "1", "yes":
+		return true
+	case "false", "0", "no":
+		return false
+	}
+	return fallback
+}
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// pushRecordSize is the aes128gcm record size; payloads must fit in one record
+const pushRecordSize = 4096
+
+// pushServiceHosts are the browser push services subscriptions may point
+// at; a host matches exactly or as a subdomain of an entry starting with "."
+var pushServiceHosts = []string{
+	"fcm.googleapis.com",                // Chrome, Edge on Android, Opera
+	"android.googleapis.com",            // Older Chrome subscriptions
+	"updates.push.services.mozilla.com", // Firefox
+	".push.apple.com",                   // Safari
+	".notify.windows.com",               // Edge on Windows
+}
+
+// ValidPushEndpoint accepts https endpoints on a known push service, and the
+// dev push sink when NOTIFY_DEV_SINKS is on
+func ValidPushEndpoint(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	if DevSinksEnabled() && strings.HasPrefix(u.Path, "/api/dev/push-sink/") {
+		return true
+	}
+	if u.Scheme != "https" || u.Port() != "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, h := range pushServiceHosts {
+		if host == h || (strings.HasPrefix(h, ".") && strings.HasSuffix(host, h)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pushNotifier sends Web Push messages (RFC 8030) encrypted with aes128gcm
+// (RFC 8291) and authenticated with VAPID (RFC 8292). Keys come from
+// VAPID_PUBLIC_KEY / VAPID_PRIVATE_KEY as unpadded base64url, the format
+// printed by `npx web-push generate-vapid-keys`; VAPID_SUBJECT is a mailto:
+// or https: contact for push services.
+type pushNotifier struct {
+	key     *ecdsa.PrivateKey
+	public  []byte // Uncompressed P-256 point
+	subject string
+	client  *http.Client
+}
+
+var vapidPublicKey string
+
+// VAPIDPublicKey returns the application server key browsers pass to
+// pushManager.subscribe, or "" when push is not configured
+func VAPIDPublicKey() string {
+	return vapidPublicKey
+}
+
+func newPushNotifier(dev bool) (Notifier, bool) {
+	priv := strings.TrimSpace(os.Getenv("VAPID_PRIVATE_KEY"))
+	var key *ecdh.PrivateKey
+	var err error
+	switch {
+	case priv != "":
+		raw, derr := base64.RawURLEncoding.DecodeString(strings.TrimRight(priv, "="))
+		if derr != nil {
+			fmt.Printf("Warning: VAPID_PRIVATE_KEY is not base64url: %v\n", derr)
+			return nil, false
+		}
+		if key, err = ecdh.P256().NewPrivateKey(raw); err != nil {
+			fmt.Printf("Warning: invalid VAPID_PRIVATE_KEY: %v\n", err)
+			return nil, false
+		}
+	case dev:
+		// Throwaway keys: subscriptions made with them stop working on restart
+		if key, err = ecdh.P256().GenerateKey(rand.Reader); err != nil {
+			return nil, false
+		}
+		fmt.Println("Warning: VAPID keys not set; using temporary development keys")
+	default:
+		return nil, false
+	}
+
+	public := key.PublicKey().Bytes()
+	if configured := os.Getenv("VAPID_PUBLIC_KEY"); configured != "" && strings.TrimRight(configured, "=") != base64.RawURLEncoding.EncodeToString(public) {
+		fmt.Println("Warning: VAPID_PUBLIC_KEY does not match VAPID_PRIVATE_KEY; using the key derived from the private key")
+	}
+	signer := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(public[1:33]),
+			Y:     new(big.Int).SetBytes(public[33:]),
+		},
+		D: new(big.Int).SetBytes(key.Bytes()),
+	}
+	vapidPublicKey = base64.RawURLEncoding.EncodeToString(public)
+	return pushNotifier{
+		key:     signer,
+		public:  public,
+		subject: envOr("VAPID_SUBJECT", "mailto:admin@localhost"),
+		client:  &http.Client{Timeout: 15 * time.Second},
+	}, true
+}
+
+func (pushNotifier) Channel() string { return ChannelPush }
+
+// Send pushes to every subscription of the user. Subscriptions the push
+// service reports as gone (404/410) are deleted.
+func (p pushNotifier) Send(ctx context.Context, to Recipient, n Notification, msg RenderedMessage) error {
+	var subs []models.PushSubscription
+	if err := config.DB.Select(&subs, "SELECT * FROM push_subscriptions WHERE user_id=$1", to.UserID); err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return ErrNoRecipient
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"title": msg.Subject,
+		"body":  msg.Body,
+		"url":   msg.URL,
+		"kind":  n.Kind,
+		"tag":   n.DedupeKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	delivered := 0
+	for _, sub := range subs {
+		gone, err := p.push(ctx, sub, payload)
+		if gone {
+			config.DB.Exec("DELETE FROM push_subscriptions WHERE id=$1", sub.ID)
+			continue
+		}
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		delivered++
+	}
+	if delivered == 0 && len(failures) == 0 {
+		return ErrNoRecipient
+	}
+	if delivered == 0 {
+		return fmt.Errorf("push failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// push sends one encrypted message; gone reports an expired subscription
+func (p pushNotifier) push(ctx context.Context, sub models.PushSubscription, payload []byte) (gone bool, err error) {
+	// Subscriptions saved before endpoints were restricted may point anywhere
+	if !ValidPushEndpoint(sub.Endpoint) {
+		return false, ErrUnsafeTarget
+	}
+	body, err := EncryptPushPayload(sub.P256dh, sub.Auth, payload)
+	if err != nil {
+		return false, err
+	}
+	auth, err := p.vapidAuthorization(sub.Endpoint)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", "86400")
+	req.Header.Set("Urgency", "normal")
+	req.Header.Set("Authorization", auth)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("push request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return true, nil
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return false, fmt.Errorf("push service returned %s", resp.Status)
+	}
+	return false, nil
+}
+
+// vapidAuthorization builds the RFC 8292 "vapid t=<jwt>, k=<key>" header for
+// the push service that owns endpoint
+func (p pushNotifier) vapidAuthorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid push endpoint %q", endpoint)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": p.subject,
+	})
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		return "", err
+	}
+	return "vapid t=" + signed + ", k=" + base64.RawURLEncoding.EncodeToString(p.public), nil
+}
+
+// EncryptPushPayload encrypts a message for a subscription per RFC 8291:
+// an ephemeral ECDH key agreed with the browser's p256dh key, mixed with its
+// auth secret, derives the aes128gcm content key for a single record
+func EncryptPushPayload(p256dh string, authSecret string, plaintext []byte) ([]byte, error) {
+	asKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return encryptPushRecord(p256dh, authSecret, plaintext, asKey, salt)
+}
+
+// encryptPushRecord is EncryptPushPayload with the sender's ephemeral key and
+// the salt supplied, so the RFC 8291 test vector can be reproduced
+func encryptPushRecord(p256dh string, authSecret string, plaintext []byte, asKey *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	uaPublic, err := decodeBase64URL(p256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh: %w", err)
+	}
+	auth, err := decodeBase64URL(authSecret)
+	if err != nil || len(auth) < 16 {
+		return nil, fmt.Errorf("invalid auth secret")
+	}
+	uaKey, err := ecdh.P256().NewPublicKey(uaPublic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh: %w", err)
+	}
+	asPublic := asKey.PublicKey().Bytes()
+	shared, err := asKey.ECDH(uaKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+	ikm, err := hkdf.Key(sha256.New, shared, auth, string(keyInfo), 32)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// A single (last) record: content followed by the 0x02 padding delimiter
+	record := append(append([]byte{}, plaintext...), 0x02)
+	if len(record)+gcm.Overhead() > pushRecordSize {
+		return nil, fmt.Errorf("push payload too large")
+	}
+
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, pushRecordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+// decodeBase64URL accepts base64url with or without padding (browsers send
+// it unpadded, some libraries pad)
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(s), "="))
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// WebhookSignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256>" where
+// the HMAC is computed over "<t>.<body>" with NOTIFY_WEBHOOK_SECRET. Receivers
+// should recompute it and reject timestamps older than a few minutes.
+const WebhookSignatureHeader = "X-Khoj-Signature"
+
+// webhookNotifier POSTs a signed JSON payload to the user's webhook URL
+type webhookNotifier struct {
+	secret []byte
+	client *http.Client
+}
+
+func newWebhookNotifier(dev bool) (Notifier, bool) {
+	secret := os.Getenv("NOTIFY_WEBHOOK_SECRET")
+	if secret == "" && dev {
+		secret = "dev-webhook-secret"
+	}
+	if secret == "" {
+		return nil, false
+	}
+	return webhookNotifier{secret: []byte(secret), client: publicHTTPClient(10 * time.Second)}, true
+}
+
+func (webhookNotifier) Channel() string { return ChannelWebhook }
+
+func (w webhookNotifier) Send(ctx context.Context, to Recipient, n Notification, msg RenderedMessage) error {
+	if to.Target == "" {
+		return ErrNoRecipient
+	}
+	// The target was checked when saved; the client checks the address it
+	// connects to again, in case the name now resolves somewhere private
+	if !validHTTPURL(to.Target) {
+		return ErrUnsafeTarget
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"kind":       n.Kind,
+		"user_id":    n.UserID,
+		"chat_id":    n.ChatID,
+		"subject":    msg.Subject,
+		"body":       msg.Body,
+		"url":        msg.URL,
+		"data":       n.Data,
+		"dedupe_key": n.DedupeKey,
+		"sent_at":    time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, to.Target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, "t="+ts+",v1="+SignWebhook(w.secret, ts, body))
+	if n.DedupeKey != "" {
+		req.Header.Set("Idempotency-Key", n.DedupeKey)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>"
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ErrUnsafeTarget is returned for a webhook or push URL the server won't
+// call: not http(s), or resolving to a loopback, private or link-local address
+var ErrUnsafeTarget = errors.New("the URL must be a public http(s) address")
+
+// nonPublicPrefixes are ranges outside the checks net/netip has methods for
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, can reach IPv4 private ranges
+}
+
+// PublicAddr reports whether addr is a public unicast address, the only kind
+// notification channels may connect to
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckWebhookURL accepts absolute http(s) URLs whose host resolves only to
+// public addresses
+func CheckWebhookURL(ctx context.Context, raw string) error {
+	if !validHTTPURL(raw) {
+		return ErrUnsafeTarget
+	}
+	u, _ := url.Parse(raw)
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("could not resolve %s", u.Hostname())
+	}
+	for _, a := range addrs {
+		if !PublicAddr(a) {
+			return ErrUnsafeTarget
+		}
+	}
+	return nil
+}
+
+// validHTTPURL accepts absolute http(s) URLs
+func validHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Hostname() != ""
+}
+
+// publicHTTPClient refuses to connect to anything but public addresses. The
+// check runs on the address actually dialed, so it also covers redirects and
+// names that resolve differently than when the URL was saved.
+func publicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil || !PublicAddr(ap.Addr()) {
+				return ErrUnsafeTarget
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+	}
+}
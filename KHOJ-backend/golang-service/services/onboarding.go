@@ -0,0 +1,632 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Onboarding question types
+const (
+	QuestionRank    = "rank"    // Order every option; answered as a comma-separated list
+	QuestionText    = "text"    // Free text
+	QuestionChoice  = "choice"  // One of the options
+	QuestionBoolean = "boolean" // Yes or No
+)
+
+// Canonical profile values
+const (
+	StyleVideos        = "videos"
+	StyleDocuments     = "documents"
+	StyleConversations = "conversations"
+	StylePractice      = "practice"
+
+	FrequencyDaily   = "daily"
+	FrequencyThrice  = "3x_week"
+	FrequencyWeekly  = "weekly"
+	FrequencyNever   = "never"
+	DefaultFrequency = FrequencyThrice
+	DefaultLanguage  = "en"
+)
+
+// QuestionOption is one allowed answer. Answers may give the value or the label.
+type QuestionOption struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+// OnboardingQuestion is one question of a questionnaire version. Key names
+// the profile field the answer fills.
+type OnboardingQuestion struct {
+	Number   int              `json:"number"`
+	Key      string           `json:"key"`
+	Question string           `json:"question"`
+	Type     string           `json:"type"`
+	Options  []QuestionOption `json:"options,omitempty"`
+	Required bool             `json:"required"`
+}
+
+// Questionnaire is a versioned set of onboarding questions. Question numbers
+// keep their meaning across versions, so older answers stay readable.
+type Questionnaire struct {
+	Version   int                  `json:"version"`
+	Questions []OnboardingQuestion `json:"questions"`
+}
+
+var (
+	// ErrUnknownQuestionnaire is returned for answers to a version that doesn't exist
+	ErrUnknownQuestionnaire = errors.New("unknown questionnaire version")
+	// ErrInvalidAnswer wraps answers that don't fit their question
+	ErrInvalidAnswer = errors.New("invalid onboarding answer")
+	// ErrInvalidProfile wraps profile changes that fail validation
+	ErrInvalidProfile = errors.New("invalid learner profile")
+
+	learningStyleOptions = []QuestionOption{
+		{Value: StyleVideos, Label: "Videos"},
+		{Value: StyleDocuments, Label: "Documents"},
+		{Value: StyleConversations, Label: "Conversations with the AI"},
+	}
+	frequencyOptions = []QuestionOption{
+		{Value: FrequencyDaily, Label: "Daily"},
+		{Value: FrequencyThrice, Label: "3x/week"},
+		{Value: FrequencyWeekly, Label: "Weekly"},
+		{Value: FrequencyNever, Label: "Never"},
+	}
+	languageQuestion = OnboardingQuestion{
+		Number: 5, Key: "language", Question: "Which language should your tutor use?", Type: QuestionChoice, Options: []QuestionOption{
+			{Value: "en", Label: "English"},
+			{Value: "hi", Label: "Hindi"},
+			{Value: "es", Label: "Spanish"},
+			{Value: "fr", Label: "French"},
+			{Value: "de", Label: "German"},
+		},
+	}
+	questionsV1 = []OnboardingQuestion{
+		{Number: 1, Key: "learning_styles", Question: "How do you prefer to learn? Arrange by priority", Type: QuestionRank, Options: learningStyleOptions, Required: true},
+		{Number: 2, Key: "preferred_windows", Question: "What are your preferred hours and time?", Type: QuestionText, Required: true},
+		{Number: 3, Key: "reminder_frequency", Question: "How often would you like to be reminded?", Type: QuestionChoice, Options: frequencyOptions, Required: true},
+		{Number: 4, Key: "facts_notifications", Question: "Would you like facts notifications about your topic?", Type: QuestionBoolean, Required: true},
+	}
+
+	// Questionnaires by version; the highest is served to new learners
+	Questionnaires = map[int]Questionnaire{
+		1: {Version: 1, Questions: questionsV1},
+		2: {Version: 2, Questions: append(append([]OnboardingQuestion{}, questionsV1...), languageQuestion)},
+		// v3 ranks practice too and asks for level and tutor style
+		3: {Version: 3, Questions: []OnboardingQuestion{
+			{Number: 1, Key: "learning_styles", Question: "How do you prefer to learn? Arrange by priority", Type: QuestionRank, Options: append(append([]QuestionOption{}, learningStyleOptions...), QuestionOption{Value: StylePractice, Label: "Practice problems first"}), Required: true},
+			questionsV1[1], questionsV1[2], questionsV1[3], languageQuestion,
+			{Number: 6, Key: "level", Question: "How much do you already know about the topics you want to learn?", Type: QuestionChoice, Options: []QuestionOption{
+				{Value: LevelBeginner, Label: "I'm new to them"},
+				{Value: LevelIntermediate, Label: "I know the basics"},
+				{Value: LevelAdvanced, Label: "I'm fairly advanced"},
+			}},
+			{Number: 7, Key: "tutor_style", Question: "How should your tutor teach?", Type: QuestionChoice, Options: []QuestionOption{
+				{Value: DefaultTutorStyle, Label: "Explain clearly with examples"},
+				{Value: "socratic", Label: "Ask me questions so I work it out"},
+				{Value: "concise", Label: "Keep answers short"},
+				{Value: "example_heavy", Label: "Lots of worked examples"},
+			}},
+		}},
+	}
+	CurrentQuestionnaireVersion = 3
+
+	languageTag       = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+	windowSeparators  = regexp.MustCompile(`\s*(?:,|;|\band\b|\bor\b|&)\s*`)
+	preferredWindowRe = regexp.MustCompile(`^(\d{2}:\d{2})-(\d{2}:\d{2})$`)
+)
+
+// OnboardingQuestionnaire returns the questionnaire of a version
+func OnboardingQuestionnaire(version int) (Questionnaire, error) {
+	q, ok := Questionnaires[version]
+	if !ok {
+		return q, ErrUnknownQuestionnaire
+	}
+	return q, nil
+}
+
+func (q Questionnaire) question(number int) (OnboardingQuestion, bool) {
+	for _, x := range q.Questions {
+		if x.Number == number {
+			return x, true
+		}
+	}
+	return OnboardingQuestion{}, false
+}
+
+// optionValue matches an answer to an option's value or label
+func optionValue(options []QuestionOption, answer string) (string, bool) {
+	answer = strings.TrimSpace(answer)
+	for _, o := range options {
+		if strings.EqualFold(answer, o.Value) || strings.EqualFold(answer, o.Label) {
+			return o.Value, true
+		}
+	}
+	return "", false
+}
+
+// NormalizeLearningStyles maps a ranked list such as "Videos, Documents,
+// Conversations with the AI" to the canonical style names, dropping unknowns
+// and repeats
+func NormalizeLearningStyles(answers []string) []string {
+	out := []string{}
+	seen := map[string]bool{}
+	for _, a := range answers {
+		a = strings.ToLower(strings.TrimSpace(a))
+		var style string
+		switch {
+		case a == "":
+			continue
+		case strings.Contains(a, "video"):
+			style = StyleVideos
+		case strings.Contains(a, "document") || strings.Contains(a, "read") || strings.Contains(a, "article"):
+			style = StyleDocuments
+		case strings.Contains(a, "conversation") || strings.Contains(a, "chat") || a == "ai":
+			style = StyleConversations
+		case strings.Contains(a, "practi") || strings.Contains(a, "exercise") || strings.Contains(a, "hands-on"):
+			style = StylePractice
+		default:
+			continue
+		}
+		if !seen[style] {
+			seen[style] = true
+			out = append(out, style)
+		}
+	}
+	return out
+}
+
+// NormalizeFrequency maps "Daily", "3x/week", "Weekly" or "Never" (or the
+// canonical values) to a canonical frequency
+func NormalizeFrequency(answer string) (string, bool) {
+	if v, ok := optionValue(frequencyOptions, answer); ok {
+		return v, true
+	}
+	a := strings.ToLower(answer)
+	switch {
+	case strings.Contains(a, "daily") || strings.Contains(a, "every day"):
+		return FrequencyDaily, true
+	case strings.Contains(a, "3") || strings.Contains(a, "three"):
+		return FrequencyThrice, true
+	case strings.Contains(a, "week"):
+		return FrequencyWeekly, true
+	case strings.Contains(a, "never") || a == "no":
+		return FrequencyNever, true
+	}
+	return "", false
+}
+
+// ParsePreferredWindows reads one or more time windows from the free-text
+// preferred hours answer, e.g. "7-8am and 6-9 pm" or "mornings, evenings".
+// Windows are "HH:MM-HH:MM". When a part doesn't parse on its own, as in
+// "between 6 and 8 pm", the answer is read as a single window.
+func ParsePreferredWindows(answer string) []string {
+	out := []string{}
+	seen := map[string]bool{}
+	for _, part := range windowSeparators.Split(answer, -1) {
+		start, end, ok := ParsePreferredHours(part)
+		if !ok {
+			if start, end, ok = ParsePreferredHours(answer); ok {
+				return []string{start + "-" + end}
+			}
+			return []string{}
+		}
+		if !seen[start+end] {
+			seen[start+end] = true
+			out = append(out, start+"-"+end)
+		}
+	}
+	return out
+}
+
+// ValidatePreferredWindow checks an "HH:MM-HH:MM" window and returns its ends
+func ValidatePreferredWindow(w string) (string, string, error) {
+	m := preferredWindowRe.FindStringSubmatch(strings.ReplaceAll(w, " ", ""))
+	if m == nil {
+		return "", "", fmt.Errorf("invalid window %q, use HH:MM-HH:MM", w)
+	}
+	for _, t := range m[1:] {
+		if _, err := ParseClock(t); err != nil {
+			return "", "", err
+		}
+	}
+	if m[1] == m[2] {
+		return "", "", fmt.Errorf("window %q is empty", w)
+	}
+	return m[1], m[2], nil
+}
+
+// NormalizeLanguage lowercases a BCP 47 tag and checks its shape
+func NormalizeLanguage(tag string) (string, error) {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if !languageTag.MatchString(tag) {
+		return "", fmt.Errorf("invalid language %q, use a code such as en or hi", tag)
+	}
+	return tag, nil
+}
+
+// applyAnswer fills the profile field a question's answer maps to
+func applyAnswer(p *models.LearnerProfile, q OnboardingQuestion, answer string) error {
+	switch q.Key {
+	case "learning_styles":
+		styles := NormalizeLearningStyles(strings.Split(answer, ","))
+		if len(styles) == 0 && strings.TrimSpace(answer) != "" {
+			return fmt.Errorf("question %d: no known learning style in %q", q.Number, answer)
+		}
+		p.LearningStyles = styles
+	case "preferred_windows":
+		p.PreferredWindows = ParsePreferredWindows(answer)
+	case "reminder_frequency":
+		freq, ok := NormalizeFrequency(answer)
+		if !ok {
+			return fmt.Errorf("question %d: unknown reminder frequency %q", q.Number, answer)
+		}
+		p.ReminderFrequency = freq
+	case "facts_notifications":
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "yes", "y", "true":
+			p.FactsNotifications = true
+		case "no", "n", "false", "":
+			p.FactsNotifications = false
+		default:
+			return fmt.Errorf("question %d: answer Yes or No", q.Number)
+		}
+	case "language":
+		if strings.TrimSpace(answer) == "" {
+			p.Language = DefaultLanguage
+			break
+		}
+		lang, ok := optionValue(q.Options, answer)
+		if !ok {
+			var err error
+			if lang, err = NormalizeLanguage(answer); err != nil {
+				return fmt.Errorf("question %d: %v", q.Number, err)
+			}
+		}
+		p.Language = lang
+	case "level":
+		level, ok := optionValue(q.Options, answer)
+		if !ok {
+			if level, ok = NormalizeLevel(answer); !ok && strings.TrimSpace(answer) != "" {
+				return fmt.Errorf("question %d: unknown level %q", q.Number, answer)
+			}
+		}
+		p.Level = level
+	case "tutor_style":
+		style, ok := optionValue(q.Options, answer)
+		if !ok && strings.TrimSpace(answer) != "" {
+			return fmt.Errorf("question %d: unknown tutor style %q", q.Number, answer)
+		}
+		if style == "" {
+			style = DefaultTutorStyle
+		}
+		p.TutorStyle = style
+	}
+	return nil
+}
+
+// SaveOnboardingAnswers stores answers to a questionnaire version and updates
+// the learner profile from them. Answers are upserted per question, so
+// submitting again (or answering one question later) replaces rather than
+// duplicates. The first preferred window also becomes the default reminder
+// window. Every answer is checked before anything is stored.
+func SaveOnboardingAnswers(userID int, version int, answers []models.UserAnswer) (models.LearnerProfile, error) {
+	var profile models.LearnerProfile
+	q, err := OnboardingQuestionnaire(version)
+	if err != nil {
+		return profile, err
+	}
+	var scratch models.LearnerProfile
+	for _, a := range answers {
+		question, ok := q.question(a.QuestionNumber)
+		if !ok {
+			return profile, fmt.Errorf("%w: questionnaire version %d has no question %d", ErrInvalidAnswer, version, a.QuestionNumber)
+		}
+		if err := applyAnswer(&scratch, question, a.Answer); err != nil {
+			return profile, fmt.Errorf("%w: %v", ErrInvalidAnswer, err)
+		}
+	}
+
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return profile, err
+	}
+	defer tx.Rollback()
+	for _, a := range answers {
+		question, _ := q.question(a.QuestionNumber)
+		_, err := tx.Exec(`
+			INSERT INTO user_answers (user_id, question_number, question, answer, questionnaire_version, answered_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+			ON CONFLICT (user_id, question_number) DO UPDATE
+			SET question=EXCLUDED.question, answer=EXCLUDED.answer, questionnaire_version=EXCLUDED.questionnaire_version, answered_at=NOW()
+		`, userID, a.QuestionNumber, question.Question, a.Answer, version)
+		if err != nil {
+			return profile, err
+		}
+	}
+	submitted := map[int]bool{}
+	for _, a := range answers {
+		submitted[a.QuestionNumber] = true
+	}
+	if profile, err = rebuildLearnerProfile(tx, userID, submitted); err != nil {
+		return profile, err
+	}
+	// An unreadable hours answer leaves the current reminder window alone
+	if len(profile.PreferredWindows) > 0 {
+		if err := syncPreferredHours(tx, userID, profile.PreferredWindows); err != nil {
+			return profile, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return profile, err
+	}
+	return LoadLearnerProfile(userID)
+}
+
+// rebuildLearnerProfile applies the user's stored answers to their profile.
+// Only the questions in only are applied (all when nil), so answering one
+// question again doesn't undo profile edits made since onboarding. Each
+// answer is read with the questionnaire version it was given under.
+func rebuildLearnerProfile(tx *sqlx.Tx, userID int, only map[int]bool) (models.LearnerProfile, error) {
+	p := defaultLearnerProfile(userID)
+	p.QuestionnaireVersion = 0
+	err := tx.Get(&p, "SELECT *, '' AS timezone FROM learner_profiles WHERE user_id=$1 FOR UPDATE", userID)
+	if err == sql.ErrNoRows {
+		only = nil // A new profile takes every answer
+	} else if err != nil {
+		return p, err
+	}
+	var rows []struct {
+		QuestionNumber int    `db:"question_number"`
+		Answer         string `db:"answer"`
+		Version        int    `db:"questionnaire_version"`
+	}
+	err = tx.Select(&rows, `
+		SELECT question_number, answer, questionnaire_version FROM user_answers WHERE user_id=$1 ORDER BY question_number
+	`, userID)
+	if err != nil {
+		return p, err
+	}
+
+	answered := map[int]bool{}
+	for _, r := range rows {
+		p.QuestionnaireVersion = max(p.QuestionnaireVersion, r.Version)
+		answered[r.QuestionNumber] = true
+		q, ok := Questionnaires[r.Version]
+		if !ok || (only != nil && !only[r.QuestionNumber]) {
+			continue
+		}
+		question, ok := q.question(r.QuestionNumber)
+		if !ok {
+			continue
+		}
+		if err := applyAnswer(&p, question, r.Answer); err != nil {
+			// Answers saved before validation existed may not parse
+			fmt.Printf("Warning: ignoring onboarding answer of user %d: %v\n", userID, err)
+			answered[r.QuestionNumber] = false
+		}
+	}
+	if p.QuestionnaireVersion == 0 {
+		p.QuestionnaireVersion = CurrentQuestionnaireVersion
+	}
+	complete := len(rows) > 0
+	for _, question := range Questionnaires[p.QuestionnaireVersion].Questions {
+		if question.Required && !answered[question.Number] {
+			complete = false
+		}
+	}
+
+	if err := upsertLearnerProfile(tx, p); err != nil {
+		return p, err
+	}
+	err = tx.Get(&p, `
+		UPDATE learner_profiles
+		SET completed_at=CASE WHEN $2::boolean THEN COALESCE(completed_at, NOW()) END
+		WHERE user_id=$1
+		RETURNING *, '' AS timezone
+	`, userID, complete)
+	return p, err
+}
+
+// upsertLearnerProfile writes every field of a profile
+func upsertLearnerProfile(tx *sqlx.Tx, p models.LearnerProfile) error {
+	_, err := tx.Exec(`
+		INSERT INTO learner_profiles (user_id, questionnaire_version, learning_styles, preferred_windows, reminder_frequency, facts_notifications, language, level, tutor_style)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (user_id) DO UPDATE SET
+			questionnaire_version=EXCLUDED.questionnaire_version,
+			learning_styles=EXCLUDED.learning_styles,
+			preferred_windows=EXCLUDED.preferred_windows,
+			reminder_frequency=EXCLUDED.reminder_frequency,
+			facts_notifications=EXCLUDED.facts_notifications,
+			language=EXCLUDED.language,
+			level=EXCLUDED.level,
+			tutor_style=EXCLUDED.tutor_style,
+			updated_at=NOW()
+	`, p.UserID, p.QuestionnaireVersion, p.LearningStyles, p.PreferredWindows, p.ReminderFrequency, p.FactsNotifications, p.Language, p.Level, p.TutorStyle)
+	return err
+}
+
+// syncPreferredHours makes the first preferred window the default reminder
+// window the scheduler uses
+func syncPreferredHours(tx *sqlx.Tx, userID int, windows []string) error {
+	start, end := "", ""
+	if len(windows) > 0 {
+		var err error
+		if start, end, err = ValidatePreferredWindow(windows[0]); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`
+		UPDATE users SET preferred_hours_start=$2, preferred_hours_end=$3, updated_at=NOW()
+		WHERE id=$1 AND (preferred_hours_start, preferred_hours_end) IS DISTINCT FROM ($2, $3)
+	`, userID, start, end)
+	return err
+}
+
+// LoadLearnerProfile returns the user's profile. Users who onboarded before
+// profiles existed get one built from their stored answers on first read.
+func LoadLearnerProfile(userID int) (models.LearnerProfile, error) {
+	var p models.LearnerProfile
+	err := config.DB.Get(&p, `
+		SELECT p.*, u.timezone FROM learner_profiles p JOIN users u ON u.id = p.user_id WHERE p.user_id=$1
+	`, userID)
+	if err != sql.ErrNoRows {
+		return p, err
+	}
+
+	var answered bool
+	if err := config.DB.Get(&answered, "SELECT EXISTS(SELECT 1 FROM user_answers WHERE user_id=$1)", userID); err != nil || !answered {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return p, err
+	}
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+	if _, err := rebuildLearnerProfile(tx, userID, nil); err != nil {
+		return p, err
+	}
+	if err := tx.Commit(); err != nil {
+		return p, err
+	}
+	err = config.DB.Get(&p, `
+		SELECT p.*, u.timezone FROM learner_profiles p JOIN users u ON u.id = p.user_id WHERE p.user_id=$1
+	`, userID)
+	return p, err
+}
+
+// LearnerProfilePatch holds the profile fields to change; nil fields are kept
+type LearnerProfilePatch struct {
+	LearningStyles     *[]string `json:"learning_styles"`
+	PreferredWindows   *[]string `json:"preferred_windows"`
+	ReminderFrequency  *string   `json:"reminder_frequency"`
+	FactsNotifications *bool     `json:"facts_notifications"`
+	Language           *string   `json:"language"`
+	Level              *string   `json:"level"` // Empty to infer from quiz scores
+	TutorStyle         *string   `json:"tutor_style"`
+	Timezone           *string   `json:"timezone"`
+}
+
+// UpdateLearnerProfile applies a patch after validating every field, so a bad
+// field changes nothing. Changed windows or zone re-plan pending reminders.
+func UpdateLearnerProfile(userID int, patch LearnerProfilePatch) (models.LearnerProfile, error) {
+	p, err := LoadLearnerProfile(userID)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := config.DB.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id=$1)", userID); err != nil || !exists {
+			return p, sql.ErrNoRows
+		}
+		p = defaultLearnerProfile(userID)
+	} else if err != nil {
+		return p, err
+	}
+
+	if patch.LearningStyles != nil {
+		styles := NormalizeLearningStyles(*patch.LearningStyles)
+		if len(styles) != len(*patch.LearningStyles) {
+			return p, fmt.Errorf("%w: learning_styles must be distinct values of %s, %s, %s or %s", ErrInvalidProfile, StyleVideos, StyleDocuments, StyleConversations, StylePractice)
+		}
+		p.LearningStyles = styles
+	}
+	windowsChanged := false
+	if patch.PreferredWindows != nil {
+		windows := pq.StringArray{}
+		for _, w := range *patch.PreferredWindows {
+			start, end, err := ValidatePreferredWindow(w)
+			if err != nil {
+				return p, fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+			}
+			windows = append(windows, start+"-"+end)
+		}
+		windowsChanged = strings.Join(windows, ",") != strings.Join(p.PreferredWindows, ",")
+		p.PreferredWindows = windows
+	}
+	if patch.ReminderFrequency != nil {
+		freq, ok := optionValue(frequencyOptions, *patch.ReminderFrequency)
+		if !ok {
+			return p, fmt.Errorf("%w: reminder_frequency must be one of %s, %s, %s or %s", ErrInvalidProfile, FrequencyDaily, FrequencyThrice, FrequencyWeekly, FrequencyNever)
+		}
+		p.ReminderFrequency = freq
+	}
+	if patch.FactsNotifications != nil {
+		p.FactsNotifications = *patch.FactsNotifications
+	}
+	if patch.Language != nil {
+		if p.Language, err = NormalizeLanguage(*patch.Language); err != nil {
+			return p, fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+		}
+	}
+	if patch.Level != nil {
+		if *patch.Level == "" {
+			p.Level = "" // Infer from quiz scores again
+		} else if level, ok := NormalizeLevel(*patch.Level); ok {
+			p.Level = level
+		} else {
+			return p, fmt.Errorf("%w: level must be %s, %s, %s or empty", ErrInvalidProfile, LevelBeginner, LevelIntermediate, LevelAdvanced)
+		}
+	}
+	if patch.TutorStyle != nil {
+		ok, err := ValidTutorStyle(*patch.TutorStyle)
+		if err != nil {
+			return p, err
+		}
+		if !ok {
+			styles, _ := TutorStyles()
+			return p, fmt.Errorf("%w: tutor_style must be one of %s", ErrInvalidProfile, strings.Join(sortedKeys(styles), ", "))
+		}
+		p.TutorStyle = *patch.TutorStyle
+	}
+	var previous, loc *time.Location
+	if patch.Timezone != nil {
+		if loc, err = ParseTimezone(*patch.Timezone); err != nil {
+			return p, fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+		}
+		previous = UserLocation(userID)
+	}
+
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+	if err := upsertLearnerProfile(tx, p); err != nil {
+		return p, err
+	}
+	if windowsChanged {
+		if err := syncPreferredHours(tx, userID, p.PreferredWindows); err != nil {
+			return p, err
+		}
+	}
+	if loc != nil {
+		if _, err := tx.Exec("UPDATE users SET timezone=$2, updated_at=NOW() WHERE id=$1", userID, loc.String()); err != nil {
+			return p, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return p, err
+	}
+
+	if loc != nil && loc.String() != previous.String() {
+		if err := RezoneSchedules(userID, previous, loc); err != nil {
+			fmt.Printf("Warning: failed to move reminders of user %d to %s: %v\n", userID, loc, err)
+		}
+	} else if windowsChanged {
+		if err := ReplanSchedules(userID); err != nil {
+			fmt.Printf("Warning: failed to re-plan reminders of user %d: %v\n", userID, err)
+		}
+	}
+	return LoadLearnerProfile(userID)
+}
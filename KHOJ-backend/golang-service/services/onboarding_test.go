@@ -0,0 +1,29 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePreferredWindows(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   []string
+	}{
+		{"7-8am and 6-9 pm", []string{"07:00-08:00", "18:00-21:00"}},
+		{"mornings, evenings", []string{"07:00-11:00", "17:00-21:00"}},
+		{"between 6 and 8 pm", []string{"18:00-20:00"}},
+		{"evening, evening", []string{"17:00-21:00"}},
+		// Durations are not windows
+		{"2-3 hours in the evening", []string{"17:00-21:00"}},
+		{"1-2 hours daily", []string{}},
+		{"I have 2 hours after 7pm", []string{"19:00-21:00"}},
+		{"2 hours in the morning and 1 hour at night", []string{"07:00-11:00", "20:00-23:00"}},
+		{"anytime", []string{}},
+	}
+	for _, tt := range tests {
+		if got := ParsePreferredWindows(tt.answer); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePreferredWindows(%q) = %q, want %q", tt.answer, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Lists are paginated by keyset: a cursor is the sort key (a time and an id)
+// of the last item a client has, and the next page starts right after it, so
+// pages stay stable while new items arrive.
+
+// ErrInvalidCursor is returned for a cursor this server didn't issue
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is a position in a list ordered by time and then id
+type Cursor struct {
+	Time time.Time
+	ID   string
+}
+
+// PageQuery selects one page of a list. Cursor continues a listing in its
+// usual order; Since instead lists what comes after a position, oldest first,
+// for incremental sync. At most one of them is set.
+type PageQuery struct {
+	Limit  int
+	Cursor *Cursor
+	Since  *Cursor
+}
+
+// cursorArgs returns the time and id of a cursor as query arguments, nil for
+// no cursor
+func cursorArgs(c *Cursor) (*time.Time, *string) {
+	if c == nil {
+		return nil, nil
+	}
+	return &c.Time, &c.ID
+}
+
+// EncodeCursor makes an opaque cursor string for a list position
+func EncodeCursor(t time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(t.UTC().Format(time.RFC3339Nano) + "|" + id))
+}
+
+// DecodeCursor parses a cursor from EncodeCursor
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{Time: t, ID: id}, nil
+}
+
+// ParseSince reads a sync position: a cursor, or an RFC 3339 time to get
+// everything from that time on
+func ParseSince(s string) (Cursor, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return Cursor{Time: t}, nil
+	}
+	return DecodeCursor(s)
+}
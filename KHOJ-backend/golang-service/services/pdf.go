@@ -0,0 +1,240 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+)
+
+// A minimal PDF writer for exports. It only uses the standard 14 fonts,
+// which every viewer has, so no font files are embedded: text is encoded in
+// WinAnsi, with Greek letters and math signs taken from the Symbol font.
+
+// pdfFont is one of the standard fonts a document can use
+type pdfFont int
+
+const (
+	fontRegular pdfFont = iota
+	fontBold
+	fontItalic
+	fontMono
+	fontSymbol
+)
+
+var pdfFontNames = [...]string{"Helvetica", "Helvetica-Bold", "Helvetica-Oblique", "Courier", "Symbol"}
+
+// A4 in points
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+)
+
+// helveticaWidths and helveticaBoldWidths are the glyph widths of ASCII 32-126
+// in thousandths of the font size (from the Adobe font metrics)
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+// winAnsiExtras are the characters of WinAnsi (cp1252) outside Latin-1
+var winAnsiExtras = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// symbolCodes are the Symbol font positions of Greek letters and math signs
+var symbolCodes = map[rune]byte{
+	'Α': 0x41, 'Β': 0x42, 'Χ': 0x43, 'Δ': 0x44, 'Ε': 0x45, 'Φ': 0x46, 'Γ': 0x47,
+	'Η': 0x48, 'Ι': 0x49, 'Κ': 0x4B, 'Λ': 0x4C, 'Μ': 0x4D, 'Ν': 0x4E, 'Ο': 0x4F,
+	'Π': 0x50, 'Θ': 0x51, 'Ρ': 0x52, 'Σ': 0x53, 'Τ': 0x54, 'Υ': 0x55, 'Ω': 0x57,
+	'Ξ': 0x58, 'Ψ': 0x59, 'Ζ': 0x5A,
+	'α': 0x61, 'β': 0x62, 'χ': 0x63, 'δ': 0x64, 'ε': 0x65, 'φ': 0x66, 'γ': 0x67,
+	'η': 0x68, 'ι': 0x69, 'ϕ': 0x6A, 'κ': 0x6B, 'λ': 0x6C, 'μ': 0x6D, 'ν': 0x6E,
+	'ο': 0x6F, 'π': 0x70, 'θ': 0x71, 'ρ': 0x72, 'σ': 0x73, 'τ': 0x74, 'υ': 0x75,
+	'ϖ': 0x76, 'ω': 0x77, 'ξ': 0x78, 'ψ': 0x79, 'ζ': 0x7A, 'ς': 0x56, 'ϑ': 0x4A,
+	'∀': 0x22, '∃': 0x24, '∋': 0x27, '∗': 0x2A, '−': 0x2D, '≅': 0x40, '⊥': 0x5E,
+	'′': 0xA2, '≤': 0xA3, '∞': 0xA5, '♣': 0xA7, '♦': 0xA8, '♥': 0xA9, '♠': 0xAA,
+	'↔': 0xAB, '←': 0xAC, '↑': 0xAD, '→': 0xAE, '↓': 0xAF, '″': 0xB2, '≥': 0xB3,
+	'∝': 0xB5, '∂': 0xB6, '≠': 0xB9, '≡': 0xBA, '≈': 0xBB, 'ℵ': 0xC0, 'ℑ': 0xC1,
+	'ℜ': 0xC2, '℘': 0xC3, '⊗': 0xC4, '⊕': 0xC5, '∅': 0xC6, '∩': 0xC7, '∪': 0xC8,
+	'⊃': 0xC9, '⊇': 0xCA, '⊄': 0xCB, '⊂': 0xCC, '⊆': 0xCD, '∈': 0xCE, '∉': 0xCF,
+	'∠': 0xD0, '∇': 0xD1, '∏': 0xD5, '√': 0xD6, '⋅': 0xD7, '∧': 0xD9, '∨': 0xDA,
+	'⇔': 0xDB, '⇐': 0xDC, '⇑': 0xDD, '⇒': 0xDE, '⇓': 0xDF, '⟨': 0xE1, '∑': 0xE5,
+	'⟩': 0xF1, '∫': 0xF2,
+}
+
+// pdfGlyph is one encoded character and the font that has it. lost is the
+// character a "?" stands in for when no standard font has it.
+type pdfGlyph struct {
+	code byte
+	font pdfFont
+	lost rune
+}
+
+// encodeRune finds a glyph for r in font f, falling back to the Symbol font
+// for math and to "?" for characters no standard font has
+func encodeRune(r rune, f pdfFont) pdfGlyph {
+	switch {
+	case r == '\t':
+		return pdfGlyph{code: ' ', font: f}
+	case r >= 0x20 && r < 0x7F, r >= 0xA0 && r <= 0xFF:
+		return pdfGlyph{code: byte(r), font: f}
+	}
+	if b, ok := winAnsiExtras[r]; ok {
+		return pdfGlyph{code: b, font: f}
+	}
+	if b, ok := symbolCodes[r]; ok {
+		return pdfGlyph{code: b, font: fontSymbol}
+	}
+	return pdfGlyph{code: '?', font: f, lost: r}
+}
+
+// glyphWidth is the advance of a glyph at the given size
+func glyphWidth(g pdfGlyph, size float64) float64 {
+	w := 556
+	switch {
+	case g.font == fontMono:
+		w = 600
+	case g.font == fontSymbol:
+		w = 600
+	case g.code >= 32 && g.code < 127 && g.font == fontBold:
+		w = helveticaBoldWidths[g.code-32]
+	case g.code >= 32 && g.code < 127:
+		w = helveticaWidths[g.code-32]
+	}
+	return float64(w) * size / 1000
+}
+
+// pdfDoc collects the content streams of a document's pages. Drawing goes
+// to the current page, which is the last one unless changed.
+type pdfDoc struct {
+	title   string
+	pages   []*bytes.Buffer
+	current int
+}
+
+func (d *pdfDoc) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.current = len(d.pages) - 1
+}
+
+func (d *pdfDoc) page() *bytes.Buffer {
+	if len(d.pages) == 0 {
+		d.newPage()
+	}
+	return d.pages[d.current]
+}
+
+// text draws glyphs of one font with their baseline at y, raised by rise,
+// in the given gray (0 is black)
+func (d *pdfDoc) text(x, y float64, glyphs []pdfGlyph, size, rise, gray float64) {
+	if len(glyphs) == 0 {
+		return
+	}
+	var s strings.Builder
+	for _, g := range glyphs {
+		switch {
+		case g.code == '(' || g.code == ')' || g.code == '\\':
+			s.WriteByte('\\')
+			s.WriteByte(g.code)
+		case g.code < 0x20 || g.code >= 0x7F:
+			fmt.Fprintf(&s, "\\%03o", g.code)
+		default:
+			s.WriteByte(g.code)
+		}
+	}
+	fmt.Fprintf(d.page(), "BT %.3f g /F%d %.2f Tf %.2f Ts %.2f %.2f Td (%s) Tj ET\n",
+		gray, int(glyphs[0].font)+1, size, rise, x, y, s.String())
+}
+
+// rect fills a rectangle whose lower left corner is at x, y
+func (d *pdfDoc) rect(x, y, w, h, gray float64) {
+	fmt.Fprintf(d.page(), "%.3f g %.2f %.2f %.2f %.2f re f\n", gray, x, y, w, h)
+}
+
+// line strokes a line
+func (d *pdfDoc) line(x1, y1, x2, y2, width, gray float64) {
+	fmt.Fprintf(d.page(), "%.3f G %.2f w %.2f %.2f m %.2f %.2f l S\n", gray, width, x1, y1, x2, y2)
+}
+
+// bytes writes out the document
+func (d *pdfDoc) bytes() []byte {
+	if len(d.pages) == 0 {
+		d.newPage()
+	}
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+	// 1: catalog, 2: page tree, 3: info, then the fonts and a page and its
+	// content stream per page
+	fontBase := 4
+	pageBase := fontBase + len(pdfFontNames)
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pageBase+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object(fmt.Sprintf("<< /Title %s /Producer (KHOJ) >>", pdfTextString(d.title)))
+	fonts := make([]string, len(pdfFontNames))
+	for i, name := range pdfFontNames {
+		encoding := " /Encoding /WinAnsiEncoding"
+		if pdfFont(i) == fontSymbol {
+			encoding = ""
+		}
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s%s >>", name, encoding))
+		fonts[i] = fmt.Sprintf("/F%d %d 0 R", i+1, fontBase+i)
+	}
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, strings.Join(fonts, " "), pageBase+2*i+1))
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		w.Write(content.Bytes())
+		w.Close()
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", z.Len(), z.Bytes()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// pdfTextString encodes s as a UTF-16 PDF string for document metadata
+func pdfTextString(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, r := range s {
+		if r > 0xFFFF {
+			r = '?'
+		}
+		fmt.Fprintf(&b, "%04X", r)
+	}
+	b.WriteString(">")
+	return b.String()
+}
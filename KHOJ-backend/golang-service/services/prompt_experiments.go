@@ -0,0 +1,426 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+
+	"github.com/lib/pq"
+)
+
+// Experiment statuses
+const (
+	ExperimentRunning = "running"
+	ExperimentStopped = "stopped"
+)
+
+// Prompt outcome metrics. Each is recorded against the prompt usage it
+// followed, with a value (1 unless it counts something).
+const (
+	MetricValidationFailure = "validation_failure" // Output couldn't be used as asked, e.g. not a JSON array
+	MetricInvalidQuestions  = "invalid_questions"  // Generated questions dropped as malformed
+	MetricMissingQuestions  = "missing_questions"  // Fewer questions generated than asked for
+	MetricThumbsUp          = "thumbs_up"
+	MetricThumbsDown        = "thumbs_down"
+)
+
+// Prompt usage sources: what a rendered prompt produced
+const (
+	PromptSourceMessage        = "message" // source_id is the bot message id
+	PromptSourceQuizGeneration = "quiz_generation"
+	PromptSourceAnswerCheck    = "answer_check" // source_id is the quiz question id
+	PromptSourceChatSummary    = "chat_summary" // source_id is the chat id
+	PromptSourceChatTitle      = "chat_title"   // source_id is the chat id
+	PromptSourceChatDigest     = "chat_digest"  // source_id is the chat id
+	PromptSourceStudyNotes     = "study_notes"  // source_id is the chat id
+	PromptSourceFlashcards     = "flashcards"   // source_id is the deck id
+	PromptSourceFacts          = "facts"        // source_id is the topic key
+	PromptSourceFactCheck      = "fact_check"   // source_id is the topic key
+)
+
+// ErrInvalidExperiment is returned for an experiment that can't be started
+var ErrInvalidExperiment = errors.New("invalid experiment")
+
+// ExperimentVariantInput is one arm of a new experiment
+type ExperimentVariantInput struct {
+	Label      string `json:"label"`
+	TemplateID int    `json:"template_id"`
+	Weight     int    `json:"weight"` // Defaults to 1
+}
+
+var (
+	experimentCacheMu sync.RWMutex
+	// Running experiment by prompt name; nil when there is none
+	experimentCache = map[string]*models.PromptExperiment{}
+)
+
+// runningExperiment returns the running experiment on a template, if any
+func runningExperiment(name string) (*models.PromptExperiment, error) {
+	experimentCacheMu.RLock()
+	exp, ok := experimentCache[name]
+	experimentCacheMu.RUnlock()
+	if ok {
+		return exp, nil
+	}
+
+	var row models.PromptExperiment
+	err := config.DB.Get(&row, "SELECT * FROM prompt_experiments WHERE prompt_name=$1 AND status=$2", name, ExperimentRunning)
+	switch {
+	case err == sql.ErrNoRows:
+		exp = nil
+	case err != nil:
+		return nil, err
+	default:
+		if err := config.DB.Select(&row.Variants, "SELECT * FROM prompt_experiment_variants WHERE experiment_id=$1 ORDER BY id", row.ID); err != nil {
+			return nil, err
+		}
+		exp = &row
+	}
+	experimentCacheMu.Lock()
+	experimentCache[name] = exp
+	experimentCacheMu.Unlock()
+	return exp, nil
+}
+
+func invalidateExperiment(name string) {
+	experimentCacheMu.Lock()
+	delete(experimentCache, name)
+	experimentCacheMu.Unlock()
+}
+
+// AssignVariant picks a learner's variant. The choice hashes the experiment
+// name with the user id, so a learner keeps the same variant for the whole
+// experiment and different experiments split learners independently.
+func AssignVariant(exp models.PromptExperiment, userID int) (models.PromptExpVariant, bool) {
+	total := 0
+	for _, v := range exp.Variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return models.PromptExpVariant{}, false
+	}
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s:%d", exp.Name, userID)
+	n := int(h.Sum32() % uint32(total))
+	for _, v := range exp.Variants {
+		if n < v.Weight {
+			return v, true
+		}
+		n -= v.Weight
+	}
+	return models.PromptExpVariant{}, false
+}
+
+// experimentVariant returns the running experiment on a template and the
+// learner's variant in it. Requests without a learner aren't enrolled.
+func experimentVariant(name string, userID int) (models.PromptExperiment, models.PromptExpVariant, bool) {
+	if userID == 0 {
+		return models.PromptExperiment{}, models.PromptExpVariant{}, false
+	}
+	exp, err := runningExperiment(name)
+	if err != nil {
+		fmt.Printf("Warning: failed to load experiment on prompt %s: %v\n", name, err)
+	}
+	if exp == nil {
+		return models.PromptExperiment{}, models.PromptExpVariant{}, false
+	}
+	v, ok := AssignVariant(*exp, userID)
+	return *exp, v, ok
+}
+
+// CreatePromptExperiment starts an experiment between stored versions of one
+// template. A template runs at most one experiment at a time.
+func CreatePromptExperiment(name string, promptName string, variants []ExperimentVariantInput, createdBy int) (models.PromptExperiment, error) {
+	var exp models.PromptExperiment
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return exp, fmt.Errorf("%w: name is required", ErrInvalidExperiment)
+	}
+	if len(variants) < 2 {
+		return exp, fmt.Errorf("%w: at least two variants are required", ErrInvalidExperiment)
+	}
+	labels := map[string]bool{}
+	for i, v := range variants {
+		v.Label = strings.TrimSpace(v.Label)
+		if v.Label == "" {
+			v.Label = string(rune('A' + i))
+		}
+		if labels[v.Label] {
+			return exp, fmt.Errorf("%w: duplicate variant label %q", ErrInvalidExperiment, v.Label)
+		}
+		labels[v.Label] = true
+		if v.Weight == 0 {
+			v.Weight = 1
+		}
+		if v.Weight < 0 {
+			return exp, fmt.Errorf("%w: variant %s has a negative weight", ErrInvalidExperiment, v.Label)
+		}
+		t, err := promptVersion(v.TemplateID)
+		if err == sql.ErrNoRows {
+			return exp, fmt.Errorf("%w: template %d not found", ErrInvalidExperiment, v.TemplateID)
+		}
+		if err != nil {
+			return exp, err
+		}
+		if t.name != promptName {
+			return exp, fmt.Errorf("%w: template %d is a version of %s, not %s", ErrInvalidExperiment, v.TemplateID, t.name, promptName)
+		}
+		variants[i] = v
+	}
+
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return exp, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('prompt_experiments:' || $1))", promptName); err != nil {
+		return exp, err
+	}
+	var running bool
+	err = tx.Get(&running, "SELECT EXISTS(SELECT 1 FROM prompt_experiments WHERE prompt_name=$1 AND status=$2)", promptName, ExperimentRunning)
+	if err != nil {
+		return exp, err
+	}
+	if running {
+		return exp, fmt.Errorf("%w: an experiment is already running on %s", ErrInvalidExperiment, promptName)
+	}
+	var taken bool
+	if err := tx.Get(&taken, "SELECT EXISTS(SELECT 1 FROM prompt_experiments WHERE name=$1)", name); err != nil {
+		return exp, err
+	}
+	if taken {
+		return exp, fmt.Errorf("%w: name %q is taken", ErrInvalidExperiment, name)
+	}
+	err = tx.Get(&exp, `
+		INSERT INTO prompt_experiments (name, prompt_name, status, created_by) VALUES ($1, $2, $3, $4) RETURNING *
+	`, name, promptName, ExperimentRunning, createdBy)
+	if err != nil {
+		return exp, err
+	}
+	for _, v := range variants {
+		var row models.PromptExpVariant
+		err := tx.Get(&row, `
+			INSERT INTO prompt_experiment_variants (experiment_id, label, template_id, weight) VALUES ($1, $2, $3, $4) RETURNING *
+		`, exp.ID, v.Label, v.TemplateID, v.Weight)
+		if err != nil {
+			return exp, err
+		}
+		exp.Variants = append(exp.Variants, row)
+	}
+	if err := tx.Commit(); err != nil {
+		return exp, err
+	}
+	invalidateExperiment(promptName)
+	return exp, nil
+}
+
+// StopPromptExperiment ends an experiment; everyone gets the active version
+// again. Its usages and outcomes are kept for the results.
+func StopPromptExperiment(id int) (models.PromptExperiment, error) {
+	var exp models.PromptExperiment
+	err := config.DB.Get(&exp, `
+		UPDATE prompt_experiments SET status=$2, stopped_at=COALESCE(stopped_at, $3) WHERE id=$1 RETURNING *
+	`, id, ExperimentStopped, time.Now())
+	if err != nil {
+		return exp, err
+	}
+	invalidateExperiment(exp.PromptName)
+	err = config.DB.Select(&exp.Variants, "SELECT * FROM prompt_experiment_variants WHERE experiment_id=$1 ORDER BY id", id)
+	return exp, err
+}
+
+// ListPromptExperiments returns experiments, newest first, optionally only
+// those on one template
+func ListPromptExperiments(promptName string) ([]models.PromptExperiment, error) {
+	exps := []models.PromptExperiment{}
+	err := config.DB.Select(&exps, `
+		SELECT * FROM prompt_experiments WHERE $1 = '' OR prompt_name=$1 ORDER BY created_at DESC
+	`, promptName)
+	if err != nil {
+		return nil, err
+	}
+	var variants []models.PromptExpVariant
+	if err := config.DB.Select(&variants, "SELECT * FROM prompt_experiment_variants ORDER BY id"); err != nil {
+		return nil, err
+	}
+	byExp := map[int][]models.PromptExpVariant{}
+	for _, v := range variants {
+		byExp[v.ExperimentID] = append(byExp[v.ExperimentID], v)
+	}
+	for i := range exps {
+		exps[i].Variants = byExp[exps[i].ID]
+	}
+	return exps, nil
+}
+
+// LogPromptUsage records that a rendered prompt (and any it includes) was
+// sent to the model, and returns the usage id outcomes are recorded against.
+// Failures are logged rather than returned: metrics must not break replies.
+func LogPromptUsage(p RenderedPrompt, userID int, model string, sourceType string, sourceID string) int64 {
+	var uid *int
+	if userID != 0 {
+		uid = &userID
+	}
+	var id int64
+	for i, r := range append([]RenderedPrompt{p}, p.Includes...) {
+		var templateID *int
+		if r.TemplateID != 0 {
+			templateID = &r.TemplateID
+		}
+		var usageID int64
+		err := config.DB.Get(&usageID, `
+			INSERT INTO prompt_usages (template_id, prompt_name, version, experiment_id, variant_id, user_id, source_type, source_id, model)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id
+		`, templateID, r.Name, r.Version, r.ExperimentID, r.VariantID, uid, sourceType, sourceID, model)
+		if err != nil {
+			fmt.Printf("Warning: failed to log usage of prompt %s: %v\n", r.Name, err)
+			continue
+		}
+		if i == 0 {
+			id = usageID
+		}
+	}
+	return id
+}
+
+// RecordPromptOutcome records a metric against a usage from LogPromptUsage
+func RecordPromptOutcome(usageID int64, metric string, value float64) {
+	if usageID == 0 {
+		return
+	}
+	if _, err := config.DB.Exec("INSERT INTO prompt_outcomes (usage_id, metric, value) VALUES ($1, $2, $3)", usageID, metric, value); err != nil {
+		fmt.Printf("Warning: failed to record prompt outcome %s: %v\n", metric, err)
+	}
+}
+
+// RecordPromptOutcomeForSource records a metric against every prompt used to
+// produce something, e.g. a thumbs up on a bot message. It returns how many
+// usages it was recorded against.
+func RecordPromptOutcomeForSource(sourceType string, sourceID string, metric string, value float64) (int64, error) {
+	res, err := config.DB.Exec(`
+		INSERT INTO prompt_outcomes (usage_id, metric, value)
+		SELECT id, $3, $4 FROM prompt_usages WHERE source_type=$1 AND source_id=$2
+	`, sourceType, sourceID, metric, value)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ClearPromptOutcomesForSource removes metrics recorded against the prompts
+// used to produce something, e.g. when a learner changes their rating
+func ClearPromptOutcomesForSource(sourceType string, sourceID string, metrics ...string) error {
+	_, err := config.DB.Exec(`
+		DELETE FROM prompt_outcomes o USING prompt_usages u
+		WHERE o.usage_id = u.id AND u.source_type=$1 AND u.source_id=$2 AND o.metric = ANY($3)
+	`, sourceType, sourceID, pq.Array(metrics))
+	return err
+}
+
+// OutcomeStat summarises one metric over a set of usages
+type OutcomeStat struct {
+	Usages int     `json:"usages"` // Usages with the metric recorded
+	Total  float64 `json:"total"`  // Sum of the recorded values
+	Rate   float64 `json:"rate"`   // Usages with the metric per use
+}
+
+// PromptMetrics are the uses and outcomes of one template version, or of one
+// experiment variant
+type PromptMetrics struct {
+	TemplateID int                    `db:"template_id" json:"template_id"`
+	Version    int                    `db:"version" json:"version"`
+	VariantID  *int                   `db:"variant_id" json:"variant_id,omitempty"`
+	Variant    string                 `db:"label" json:"variant,omitempty"`
+	Uses       int                    `db:"uses" json:"uses"`
+	Outcomes   map[string]OutcomeStat `db:"-" json:"outcomes"`
+}
+
+// PromptExperimentResults compares the variants of an experiment
+func PromptExperimentResults(id int) ([]PromptMetrics, error) {
+	rows := []PromptMetrics{}
+	err := config.DB.Select(&rows, `
+		SELECT v.id AS variant_id, v.label, v.template_id, t.version, COUNT(u.id) AS uses
+		FROM prompt_experiment_variants v
+		JOIN prompt_templates t ON t.id = v.template_id
+		LEFT JOIN prompt_usages u ON u.variant_id = v.id
+		WHERE v.experiment_id=$1
+		GROUP BY v.id, v.label, v.template_id, t.version
+		ORDER BY v.id
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	var outcomes []outcomeRow
+	err = config.DB.Select(&outcomes, `
+		SELECT u.variant_id AS key, o.metric, COUNT(DISTINCT u.id) AS usages, SUM(o.value) AS total
+		FROM prompt_outcomes o JOIN prompt_usages u ON u.id = o.usage_id
+		WHERE u.experiment_id=$1
+		GROUP BY u.variant_id, o.metric
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].Outcomes = outcomeStats(outcomes, *rows[i].VariantID, rows[i].Uses)
+	}
+	return rows, nil
+}
+
+// PromptVersionMetrics compares the versions of a template, counting every
+// use whether or not it was part of an experiment
+func PromptVersionMetrics(name string) ([]PromptMetrics, error) {
+	rows := []PromptMetrics{}
+	err := config.DB.Select(&rows, `
+		SELECT t.id AS template_id, t.version, COUNT(u.id) AS uses
+		FROM prompt_templates t LEFT JOIN prompt_usages u ON u.template_id = t.id
+		WHERE t.name=$1
+		GROUP BY t.id, t.version
+		ORDER BY t.version
+	`, name)
+	if err != nil {
+		return nil, err
+	}
+	var outcomes []outcomeRow
+	err = config.DB.Select(&outcomes, `
+		SELECT u.template_id AS key, o.metric, COUNT(DISTINCT u.id) AS usages, SUM(o.value) AS total
+		FROM prompt_outcomes o JOIN prompt_usages u ON u.id = o.usage_id
+		WHERE u.prompt_name=$1 AND u.template_id IS NOT NULL
+		GROUP BY u.template_id, o.metric
+	`, name)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].Outcomes = outcomeStats(outcomes, rows[i].TemplateID, rows[i].Uses)
+	}
+	return rows, nil
+}
+
+type outcomeRow struct {
+	Key    int     `db:"key"`
+	Metric string  `db:"metric"`
+	Usages int     `db:"usages"`
+	Total  float64 `db:"total"`
+}
+
+func outcomeStats(rows []outcomeRow, key int, uses int) map[string]OutcomeStat {
+	stats := map[string]OutcomeStat{}
+	for _, r := range rows {
+		if r.Key != key {
+			continue
+		}
+		s := OutcomeStat{Usages: r.Usages, Total: r.Total}
+		if uses > 0 {
+			s.Rate = float64(r.Usages) / float64(uses)
+		}
+		stats[r.Metric] = s
+	}
+	return stats
+}
@@ -0,0 +1,589 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"golang-service/config"
+	"golang-service/models"
+)
+
+// Prompt template names
+const (
+	PromptTutorSystem      = "tutor.system"
+	PromptTutorStylePrefix = "tutor.style."
+	PromptMCQGeneration    = "quiz.mcq_generation"
+	PromptAnswerCheck      = "quiz.answer_check"
+	PromptChatSummary      = "chat.summary"
+	PromptChatTitle        = "chat.title"
+	PromptChatDigest       = "chat.rolling_summary"
+	PromptStudyNotes       = "chat.study_notes"
+	PromptFlashcards       = "flashcards.generation"
+	PromptFacts            = "facts.generation"
+	PromptFactCheck        = "facts.verification"
+)
+
+// maxOutputTokensLimit caps a template's max_output_tokens setting
+const maxOutputTokensLimit = 8192
+
+var (
+	// ErrUnknownPrompt is returned for a template name with no built-in default
+	ErrUnknownPrompt = errors.New("unknown prompt template")
+	// ErrInvalidPromptSettings is returned for out of range model settings
+	ErrInvalidPromptSettings = errors.New("invalid prompt settings")
+
+	modelName = regexp.MustCompile(`^[a-z0-9][a-z0-9.\-]{1,63}$`)
+)
+
+// defaultPrompts are the built-in templates. They are stored as version 1 of
+// each name on startup; later versions are added through the admin API.
+var defaultPrompts = map[string]struct {
+	Description string
+	Body        string
+}{
+	PromptTutorSystem: {
+		Description: "System prompt for chat replies; fills in the learner's persona",
+		Body: `You are a helpful tutor. The chat topic is '{{.Topic}}'. Answer ONLY within this topic.
+The learner's level on this topic is {{.Level}}{{if .LearningStyles}}, and they prefer to learn through {{join .LearningStyles ", then "}}{{end}}.
+{{- if .PracticeFirst}}
+Lead with a short exercise or problem for the learner to try, then explain.
+{{- else if .Visual}}
+Describe ideas visually: use diagrams in text, tables and step-by-step walkthroughs, and suggest a good video to watch when it helps.
+{{- else if .Reading}}
+Write structured explanations with headings and precise definitions, and point to further reading when it helps.
+{{- end}}
+{{- if eq .Level "beginner"}}
+Avoid jargon, define every new term and build up from the basics.
+{{- else if eq .Level "advanced"}}
+Skip the basics and go into depth, edge cases and trade-offs.
+{{- end}}
+{{.StyleGuide}}
+{{- if ne .Language "en"}}
+Always reply in {{.LanguageName}}.
+{{- end}}`,
+	},
+	PromptTutorStylePrefix + "balanced": {
+		Description: "Tutor style: clear explanations with an example where useful",
+		Body:        `Give clear, friendly explanations with an example where it helps, and check understanding with a short question at the end when the topic is new.`,
+	},
+	PromptTutorStylePrefix + "socratic": {
+		Description: "Tutor style: guide with questions instead of giving answers",
+		Body:        `Teach Socratically: don't hand over answers. Ask one guiding question at a time that leads the learner to work it out, and confirm or gently correct their reasoning before moving on.`,
+	},
+	PromptTutorStylePrefix + "concise": {
+		Description: "Tutor style: short, to-the-point answers",
+		Body:        `Be concise: answer in a few sentences or a short list, with no preamble. Offer to go deeper rather than doing so unasked.`,
+	},
+	PromptTutorStylePrefix + "example_heavy": {
+		Description: "Tutor style: teach through worked examples",
+		Body:        `Teach through examples: open with a concrete worked example, add a second one that varies it, and only then state the general rule.`,
+	},
+	PromptMCQGeneration: {
+		Description: "Generates multiple choice questions for a quiz; output must be a JSON array",
+		Body: `Generate EXACTLY {{.Count}} multiple choice questions (MCQ) about "{{.Topic}}".
+IMPORTANT: You MUST generate exactly {{.Count}} questions, no more, no less.
+
+For each question, provide exactly 4 options labeled A, B, C, and D, and a short subtopic name the question belongs to.
+Return the response as a JSON array with this exact format:
+[
+  {
+    "question": "Question text here?",
+    "options": ["Option A text", "Option B text", "Option C text", "Option D text"],
+    "answer": "A",
+    "subtopic": "Subtopic name"
+  },
+  {
+    "question": "Another question here?",
+    "options": ["Option A text", "Option B text", "Option C text", "Option D text"],
+    "answer": "B",
+    "subtopic": "Subtopic name"
+  }
+  ... (continue for all {{.Count}} questions)
+]
+Make sure the questions are relevant to the topic "{{.Topic}}" and test understanding, not just recall.
+Return ONLY the JSON array, no additional text. Count your questions to ensure you have exactly {{.Count}} questions.`,
+	},
+	PromptAnswerCheck: {
+		Description: "Grades a free-text quiz answer; output must be YES or NO",
+		Body: `You are an educational evaluator. Determine if the student's answer is correct for the given question.
+
+Question: {{.Question}}
+Correct Answer: {{.CorrectAnswer}}
+Student's Answer: {{.StudentAnswer}}
+
+Evaluate if the student's answer demonstrates understanding of the concept, even if the wording is different. Consider:
+- Is the core concept correct?
+- Are key terms and ideas present?
+- Is the answer factually accurate?
+
+Respond with ONLY "YES" if correct or "NO" if incorrect. No explanations, just YES or NO.`,
+	},
+	PromptChatSummary: {
+		Description: "Summarizes a chat for /summary",
+		Body: `Summarize this study conversation about '{{.Topic}}' in a few short bullet points: the key concepts covered, what the learner found difficult, and what to review next.
+
+{{.Transcript}}`,
+	},
+	PromptChatTitle: {
+		Description: "Names a chat after its first few turns; output is the title alone",
+		Body: `Write a short, descriptive title (at most 8 words) for this study conversation about '{{.Topic}}'. Name what it is specifically about, not just the topic.
+Return ONLY the title, with no quotes or punctuation at the end.
+
+{{.Transcript}}`,
+	},
+	PromptChatDigest: {
+		Description: "Keeps a chat's summary up to date as it grows; output is the new summary",
+		Body: `You keep a running summary of a study conversation about '{{.Topic}}'.
+{{- if .PreviousSummary}}
+The summary so far:
+{{.PreviousSummary}}
+
+Update it with the new messages below.
+{{- else}}
+Summarize the messages below.
+{{- end}}
+Write 2 to 4 sentences of plain text: what was covered and where the learner is now. Return ONLY the summary.
+
+{{.Transcript}}`,
+	},
+	PromptStudyNotes: {
+		Description: "Condenses a chat into study notes; output must be a JSON object",
+		Body: `Condense this study conversation about '{{.Topic}}' into study notes for the learner to revise from.
+Return a JSON object with this exact format:
+{
+  "key_points": ["One idea per point, in a full sentence"],
+  "definitions": [{"term": "Term", "definition": "What it means, in one or two sentences"}]
+}
+Include only what the conversation covered, in the order it came up. Use an empty list when there is nothing to put in it.
+Return ONLY the JSON object, no additional text.
+
+{{.Transcript}}`,
+	},
+	PromptFlashcards: {
+		Description: "Generates flashcards from a chat or a topic; output must be a JSON array",
+		Body: `Write {{.Count}} flashcards about '{{.Topic}}'{{if .Transcript}} from the study conversation below, covering what it explained{{end}}.
+Each card tests one idea: the front is a question or a term, the back answers it in one or two sentences.
+{{- if .Existing}}
+The learner already has cards with these fronts; don't repeat them:
+{{range .Existing}}- {{.}}
+{{end}}
+{{- end}}
+Return the response as a JSON array with this exact format:
+[
+  {"front": "What is ...?", "back": "..."}
+]
+Return ONLY the JSON array, no additional text.
+{{- if .Transcript}}
+
+{{.Transcript}}
+{{- end}}`,
+	},
+	PromptFacts: {
+		Description: "Generates short facts about a topic for daily fact notifications; output must be a JSON array",
+		Body: `Write {{.Count}} short, surprising but accurate facts about '{{.Topic}}' for a learner studying it.
+Each fact is one or two sentences, stands on its own, and is something a reliable textbook or encyclopedia would confirm. Avoid opinions, rumours, statistics that change over time and anything you are not sure of.
+{{- if .Existing}}
+These facts are already known; don't repeat them:
+{{range .Existing}}- {{.}}
+{{end}}
+{{- end}}
+Return the response as a JSON array of strings, for example:
+["Fact one.", "Fact two."]
+Return ONLY the JSON array, no additional text.`,
+	},
+	PromptFactCheck: {
+		Description: "Checks one generated fact before it is sent; output must be YES or NO",
+		Body: `You are a careful fact checker. Is the following statement about '{{.Topic}}' accurate and well established?
+Statement: {{.Fact}}
+
+Answer NO if any part of it is wrong, misleading or disputed.
+Respond with ONLY "YES" or "NO".`,
+	},
+}
+
+// promptSamples are rendered when a new version is saved, so a template that
+// refers to a missing field is rejected rather than failing later in a chat
+var promptSamples = map[string]interface{}{
+	PromptTutorSystem: TutorPromptData{Topic: "Photosynthesis", Level: "beginner", Language: "hi", LanguageName: "Hindi",
+		LearningStyles: []string{"videos", "conversations"}, Visual: true, TutorStyle: "balanced", StyleGuide: "Be friendly."},
+	PromptMCQGeneration: MCQPromptData{Count: 5, Topic: "Photosynthesis"},
+	PromptAnswerCheck:   AnswerCheckPromptData{Question: "What do plants release?", CorrectAnswer: "Oxygen", StudentAnswer: "O2"},
+	PromptChatSummary:   SummaryPromptData{Topic: "Photosynthesis", Transcript: "user: What is chlorophyll?\nbot: The green pigment..."},
+	PromptChatTitle:     SummaryPromptData{Topic: "Photosynthesis", Transcript: "user: What is chlorophyll?\nbot: The green pigment..."},
+	PromptChatDigest: DigestPromptData{Topic: "Photosynthesis", PreviousSummary: "The learner asked what chlorophyll is.",
+		Transcript: "user: Why is it green?\nbot: It reflects green light..."},
+	PromptStudyNotes: SummaryPromptData{Topic: "Photosynthesis", Transcript: "user: What is chlorophyll?\nbot: The green pigment..."},
+	PromptFlashcards: FlashcardPromptData{Count: 10, Topic: "Photosynthesis", Existing: []string{"What is chlorophyll?"},
+		Transcript: "user: What is chlorophyll?\nbot: The green pigment..."},
+	PromptFacts:     FactPromptData{Count: 5, Topic: "Photosynthesis", Existing: []string{"Chlorophyll reflects green light."}},
+	PromptFactCheck: FactCheckPromptData{Topic: "Photosynthesis", Fact: "Chlorophyll reflects green light."},
+}
+
+// MCQPromptData fills the quiz.mcq_generation template
+type MCQPromptData struct {
+	Count int
+	Topic string
+}
+
+// AnswerCheckPromptData fills the quiz.answer_check template
+type AnswerCheckPromptData struct {
+	Question      string
+	CorrectAnswer string
+	StudentAnswer string
+}
+
+// SummaryPromptData fills the chat.summary, chat.title and chat.study_notes
+// templates
+type SummaryPromptData struct {
+	Topic      string
+	Transcript string
+}
+
+// FlashcardPromptData fills the flashcards.generation template
+type FlashcardPromptData struct {
+	Count      int
+	Topic      string
+	Existing   []string // Fronts of cards already in the deck
+	Transcript string   // Empty when generating from the topic alone
+}
+
+// FactPromptData fills the facts.generation template
+type FactPromptData struct {
+	Count    int
+	Topic    string
+	Existing []string // Facts already stored for the topic
+}
+
+// FactCheckPromptData fills the facts.verification template
+type FactCheckPromptData struct {
+	Topic string
+	Fact  string
+}
+
+// DigestPromptData fills the chat.rolling_summary template
+type DigestPromptData struct {
+	Topic           string
+	PreviousSummary string // Empty for a chat's first summary
+	Transcript      string // Messages since the previous summary
+}
+
+var promptFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// GenerationSettings are the model settings stored with a template version.
+// Empty fields leave the choice to the caller and Gemini's defaults.
+type GenerationSettings struct {
+	Model           string   `json:"model,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+	MaxOutputTokens *int     `json:"max_output_tokens,omitempty"`
+}
+
+// RenderedPrompt is a filled-in template along with the version (and
+// experiment variant) it came from, so outcomes can be attributed to it
+type RenderedPrompt struct {
+	Text         string
+	Name         string
+	TemplateID   int // 0 when the built-in default was used
+	Version      int
+	ExperimentID *int
+	VariantID    *int
+	Variant      string
+	Settings     GenerationSettings
+	// Includes are prompts rendered into this one, e.g. the tutor style
+	// guide in the tutor system prompt
+	Includes []RenderedPrompt
+}
+
+type compiledPrompt struct {
+	id       int
+	name     string
+	version  int
+	tmpl     *template.Template
+	settings GenerationSettings
+}
+
+var (
+	promptCacheMu sync.RWMutex
+	promptCache   = map[string]compiledPrompt{} // Active version by name
+	promptByID    = map[int]compiledPrompt{}    // Versions used by experiment variants
+)
+
+func parsePrompt(name string, body string) (*template.Template, error) {
+	return template.New(name).Funcs(promptFuncs).Option("missingkey=error").Parse(body)
+}
+
+func compilePrompt(row models.PromptTemplate) (compiledPrompt, error) {
+	tmpl, err := parsePrompt(row.Name, row.Body)
+	if err != nil {
+		return compiledPrompt{}, err
+	}
+	return compiledPrompt{id: row.ID, name: row.Name, version: row.Version, tmpl: tmpl, settings: GenerationSettings{
+		Model: row.Model, Temperature: row.Temperature, MaxOutputTokens: row.MaxOutputTokens,
+	}}, nil
+}
+
+// SeedPromptTemplates stores the built-in templates as version 1 of any name
+// that has no versions yet
+func SeedPromptTemplates() error {
+	for name, p := range defaultPrompts {
+		_, err := config.DB.Exec(`
+			INSERT INTO prompt_templates (name, version, body, description, active)
+			SELECT $1, 1, $2, $3, true
+			WHERE NOT EXISTS (SELECT 1 FROM prompt_templates WHERE name=$1)
+		`, name, p.Body, p.Description)
+		if err != nil {
+			return fmt.Errorf("seeding prompt %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// activePrompt returns the compiled active version of a template. If the
+// database can't be read or holds a broken template, the built-in default is
+// used so replies keep working.
+func activePrompt(name string) (compiledPrompt, error) {
+	promptCacheMu.RLock()
+	p, ok := promptCache[name]
+	promptCacheMu.RUnlock()
+	if ok {
+		return p, nil
+	}
+
+	var row models.PromptTemplate
+	err := config.DB.Get(&row, "SELECT * FROM prompt_templates WHERE name=$1 AND active", name)
+	if err == nil {
+		p, err = compilePrompt(row)
+	}
+	if err != nil {
+		def, ok := defaultPrompts[name]
+		if !ok {
+			return p, fmt.Errorf("%w %q: %v", ErrUnknownPrompt, name, err)
+		}
+		fmt.Printf("Warning: using built-in prompt %s: %v\n", name, err)
+		tmpl, perr := parsePrompt(name, def.Body)
+		if perr != nil {
+			return p, perr
+		}
+		// Not cached, so the stored template is retried next time
+		return compiledPrompt{name: name, tmpl: tmpl}, nil
+	}
+
+	promptCacheMu.Lock()
+	promptCache[name] = p
+	promptCacheMu.Unlock()
+	return p, nil
+}
+
+// promptVersion returns a compiled template version by id
+func promptVersion(id int) (compiledPrompt, error) {
+	promptCacheMu.RLock()
+	p, ok := promptByID[id]
+	promptCacheMu.RUnlock()
+	if ok {
+		return p, nil
+	}
+	var row models.PromptTemplate
+	if err := config.DB.Get(&row, "SELECT * FROM prompt_templates WHERE id=$1", id); err != nil {
+		return p, err
+	}
+	p, err := compilePrompt(row)
+	if err != nil {
+		return p, err
+	}
+	promptCacheMu.Lock()
+	promptByID[id] = p
+	promptCacheMu.Unlock()
+	return p, nil
+}
+
+func invalidatePrompt(name string) {
+	promptCacheMu.Lock()
+	delete(promptCache, name)
+	for id, p := range promptByID {
+		if p.name == name {
+			delete(promptByID, id)
+		}
+	}
+	promptCacheMu.Unlock()
+}
+
+// RenderPrompt fills a named template with data. If an experiment is running
+// on the template, the learner gets the version of their variant; otherwise,
+// and for userID 0, the active version.
+func RenderPrompt(name string, userID int, data interface{}) (RenderedPrompt, error) {
+	p, err := activePrompt(name)
+	if err != nil {
+		return RenderedPrompt{}, err
+	}
+	r := RenderedPrompt{Name: name}
+	if exp, variant, ok := experimentVariant(name, userID); ok {
+		if vp, err := promptVersion(variant.TemplateID); err == nil {
+			p = vp
+			r.ExperimentID, r.VariantID, r.Variant = &exp.ID, &variant.ID, variant.Label
+		} else {
+			fmt.Printf("Warning: variant %s of experiment %s: %v\n", variant.Label, exp.Name, err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, data); err != nil {
+		return RenderedPrompt{}, fmt.Errorf("rendering prompt %s v%d: %w", name, p.version, err)
+	}
+	r.Text = strings.TrimSpace(buf.String())
+	r.TemplateID, r.Version, r.Settings = p.id, p.version, p.settings
+	return r, nil
+}
+
+// promptSample returns the data a template is test-rendered with
+func promptSample(name string) interface{} {
+	if strings.HasPrefix(name, PromptTutorStylePrefix) {
+		return promptSamples[PromptTutorSystem]
+	}
+	return promptSamples[name]
+}
+
+// CreatePromptVersion stores body as the next version of a template after
+// checking it parses and renders. Only names with a built-in default and
+// tutor styles (tutor.style.<name>) can be created. activate makes it the
+// version in use.
+func CreatePromptVersion(name string, body string, description string, settings GenerationSettings, activate bool, createdBy int) (models.PromptTemplate, error) {
+	var row models.PromptTemplate
+	if err := validateGenerationSettings(settings); err != nil {
+		return row, err
+	}
+	name = strings.TrimSpace(name)
+	_, builtin := defaultPrompts[name]
+	style := strings.TrimPrefix(name, PromptTutorStylePrefix)
+	if !builtin && (!strings.HasPrefix(name, PromptTutorStylePrefix) || !tutorStyleName.MatchString(style)) {
+		return row, fmt.Errorf("%w %q", ErrUnknownPrompt, name)
+	}
+	tmpl, err := parsePrompt(name, body)
+	if err != nil {
+		return row, fmt.Errorf("invalid template: %w", err)
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, promptSample(name)); err != nil {
+		return row, fmt.Errorf("invalid template: %w", err)
+	}
+
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return row, err
+	}
+	defer tx.Rollback()
+	// Serialise versions of the same name
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('prompt_templates:' || $1))", name); err != nil {
+		return row, err
+	}
+	if activate {
+		if _, err := tx.Exec("UPDATE prompt_templates SET active=false WHERE name=$1 AND active", name); err != nil {
+			return row, err
+		}
+	}
+	err = tx.Get(&row, `
+		INSERT INTO prompt_templates (name, version, body, description, active, created_by, model, temperature, max_output_tokens)
+		VALUES ($1, (SELECT COALESCE(MAX(version), 0) + 1 FROM prompt_templates WHERE name=$1), $2, $3, $4, $5, $6, $7, $8)
+		RETURNING *
+	`, name, body, description, activate, createdBy, settings.Model, settings.Temperature, settings.MaxOutputTokens)
+	if err != nil {
+		return row, err
+	}
+	if err := tx.Commit(); err != nil {
+		return row, err
+	}
+	invalidatePrompt(name)
+	return row, nil
+}
+
+// ActivatePromptVersion makes a stored version the one in use, e.g. to roll
+// back
+func ActivatePromptVersion(id int) (models.PromptTemplate, error) {
+	var row models.PromptTemplate
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return row, err
+	}
+	defer tx.Rollback()
+	if err := tx.Get(&row, "SELECT * FROM prompt_templates WHERE id=$1 FOR UPDATE", id); err != nil {
+		return row, err
+	}
+	if _, err := tx.Exec("UPDATE prompt_templates SET active=false WHERE name=$1 AND active AND id != $2", row.Name, id); err != nil {
+		return row, err
+	}
+	if err := tx.Get(&row, "UPDATE prompt_templates SET active=true WHERE id=$1 RETURNING *", id); err != nil {
+		return row, err
+	}
+	if err := tx.Commit(); err != nil {
+		return row, err
+	}
+	invalidatePrompt(row.Name)
+	return row, nil
+}
+
+// UpdatePromptSettings changes the model settings of a stored version. Unlike
+// the body, settings can be tuned in place: they don't change what the
+// template asks for.
+func UpdatePromptSettings(id int, settings GenerationSettings) (models.PromptTemplate, error) {
+	var row models.PromptTemplate
+	if err := validateGenerationSettings(settings); err != nil {
+		return row, err
+	}
+	err := config.DB.Get(&row, `
+		UPDATE prompt_templates SET model=$2, temperature=$3, max_output_tokens=$4 WHERE id=$1 RETURNING *
+	`, id, settings.Model, settings.Temperature, settings.MaxOutputTokens)
+	if err != nil {
+		return row, err
+	}
+	invalidatePrompt(row.Name)
+	return row, nil
+}
+
+func validateGenerationSettings(s GenerationSettings) error {
+	if s.Model != "" && !modelName.MatchString(s.Model) {
+		return fmt.Errorf("%w: model %q", ErrInvalidPromptSettings, s.Model)
+	}
+	if s.Temperature != nil && (*s.Temperature < 0 || *s.Temperature > 2) {
+		return fmt.Errorf("%w: temperature must be between 0 and 2", ErrInvalidPromptSettings)
+	}
+	if s.MaxOutputTokens != nil && (*s.MaxOutputTokens < 1 || *s.MaxOutputTokens > maxOutputTokensLimit) {
+		return fmt.Errorf("%w: max_output_tokens must be between 1 and %d", ErrInvalidPromptSettings, maxOutputTokensLimit)
+	}
+	return nil
+}
+
+// TutorStyles lists the styles learners can pick: those with an active
+// tutor.style.<name> template, with its description
+func TutorStyles() (map[string]string, error) {
+	var rows []models.PromptTemplate
+	err := config.DB.Select(&rows, "SELECT * FROM prompt_templates WHERE active AND name LIKE 'tutor.style.%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	styles := map[string]string{}
+	for _, r := range rows {
+		styles[strings.TrimPrefix(r.Name, PromptTutorStylePrefix)] = r.Description
+	}
+	return styles, nil
+}
+
+// SortedTutorStyles returns the style names of TutorStyles in order
+func SortedTutorStyles(styles map[string]string) []string {
+	return sortedKeys(styles)
+}
+
+// sortedKeys returns a map's keys in order, for stable output
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
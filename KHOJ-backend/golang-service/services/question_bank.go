@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"golang-service/config"
+	"golang-service/models"
+)
+
+const (
+	BankStatusUnreviewed = "unreviewed"
+	BankStatusApproved   = "approved"
+	BankStatusRejected   = "rejected"
+
+	// BankFlagHideThreshold is the number of unresolved learner flags after
+	// which an unreviewed question is withheld from quizzes until an admin
+	// looks at it
+	BankFlagHideThreshold = 2
+
+	// bankDuplicateSimilarity is the embedding cosine similarity above which
+	// two questions on the same topic are considered the same question
+	bankDuplicateSimilarity = 0.92
+)
+
+// IsBankQuestionUsable reports whether a bank question may be put into a quiz
+func IsBankQuestionUsable(q models.BankQuestion) bool {
+	switch q.Status {
+	case BankStatusApproved:
+		return true
+	case BankStatusUnreviewed:
+		return q.FlagCount < BankFlagHideThreshold
+	}
+	return false
+}
+
+// DrawBankQuestions picks up to n usable questions on a canonical topic that
+// the user has not already been asked, preferring approved and less-used ones
+func DrawBankQuestions(userID int, topic string, n int) ([]models.BankQuestion, error) {
+	var questions []models.BankQuestion
+	if n <= 0 {
+		return questions, nil
+	}
+	err := config.DB.Select(&questions, `
+		SELECT bq.* FROM bank_questions bq
+		WHERE bq.topic=$1
+		AND (bq.status='approved' OR (bq.status='unreviewed' AND bq.flag_count < $2))
+		AND NOT EXISTS (
+			SELECT 1 FROM quiz_questions qq
+			JOIN quizzes q ON q.id = qq.quiz_id
+			WHERE qq.bank_question_id = bq.id AND q.user_id = $3
+		)
+		ORDER BY (bq.status='approved') DESC, bq.times_used ASC, random()
+		LIMIT $4
+	`, topic, BankFlagHideThreshold, userID, n)
+	return questions, err
+}
+
+// SaveToBank stores q under its canonical topic unless an equivalent question
+// is already there. It returns the stored (or pre-existing) row and whether a
+// new row was created. Duplicates are detected by normalized text first and
+// then, when an embedding can be computed, by cosine similarity.
+func SaveToBank(ctx context.Context, apiKey string, q models.BankQuestion) (models.BankQuestion, bool, error) {
+	q.Topic = CanonicalTopic(q.Topic)
+	q.NormalizedText = NormalizeQuestionText(q.Question)
+	if q.Topic == "" || q.NormalizedText == "" {
+		return q, false, fmt.Errorf("question and topic are required")
+	}
+	if strings.TrimSpace(q.Options) == "" {
+		q.Options = "[]"
+	}
+	if q.Source == "" {
+		q.Source = "generated"
+	}
+	if q.Status == "" {
+		q.Status = BankStatusUnreviewed
+	}
+
+	var existing models.BankQuestion
+	err := config.DB.Get(&existing, "SELECT * FROM bank_questions WHERE topic=$1 AND normalized_text=$2", q.Topic, q.NormalizedText)
+	if err == nil {
+		return existing, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return q, false, err
+	}
+
+	if vec, err := EmbedText(ctx, apiKey, q.Question); err == nil {
+		q.Embedding = EncodeEmbedding(vec)
+		if id := findSimilarBankQuestion(q.Topic, vec); id != 0 {
+			if err := config.DB.Get(&existing, "SELECT * FROM bank_questions WHERE id=$1", id); err == nil {
+				return existing, false, nil
+			}
+		}
+	}
+
+	// Another request may have inserted the same question meanwhile; the no-op
+	// update lets RETURNING hand back the existing row in that case
+	var created bool
+	err = config.DB.QueryRow(`
+		INSERT INTO bank_questions (topic, subtopic, question, normalized_text, options, answer, embedding, source, status, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (topic, normalized_text) DO UPDATE SET topic=EXCLUDED.topic
+		RETURNING id, (xmax = 0)
+	`, q.Topic, q.Subtopic, q.Question, q.NormalizedText, q.Options, q.Answer, q.Embedding, q.Source, q.Status, q.CreatedBy).Scan(&q.ID, &created)
+	if err != nil {
+		return q, false, err
+	}
+	if err := config.DB.Get(&q, "SELECT * FROM bank_questions WHERE id=$1", q.ID); err != nil {
+		return q, created, err
+	}
+	return q, created, nil
+}
+
+// findSimilarBankQuestion returns the id of the closest question on topic whose
+// similarity to vec exceeds bankDuplicateSimilarity, or 0
+func findSimilarBankQuestion(topic string, vec []float64) int {
+	var rows []struct {
+		ID        int    `db:"id"`
+		Embedding string `db:"embedding"`
+	}
+	if err := config.DB.Select(&rows, "SELECT id, embedding FROM bank_questions WHERE topic=$1 AND embedding <> ''", topic); err != nil {
+		fmt.Printf("Warning: failed to load bank embeddings for %q: %v\n", topic, err)
+		return 0
+	}
+
+	bestID, best := 0, bankDuplicateSimilarity
+	for _, r := range rows {
+		if sim := CosineSimilarity(vec, DecodeEmbedding(r.Embedding)); sim >= best {
+			bestID, best = r.ID, sim
+		}
+	}
+	return bestID
+}
+
+// MarkBankQuestionsUsed bumps the usage counter of questions put into a quiz
+func MarkBankQuestionsUsed(ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := config.DB.Exec("UPDATE bank_questions SET times_used = times_used + 1 WHERE id = ANY($1)", pq.Array(ids))
+	return err
+}
+
+// FlagBankQuestion records a learner's report that a question is wrong. Each
+// learner counts once per question.
+func FlagBankQuestion(questionID int, userID int, reason string) (models.BankQuestion, error) {
+	var q models.BankQuestion
+	_, err := config.DB.Exec(`
+		INSERT INTO bank_question_flags (question_id, user_id, reason)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (question_id, user_id) DO UPDATE SET reason=EXCLUDED.reason, resolved=false, created_at=NOW()
+	`, questionID, userID, reason)
+	if err != nil {
+		return q, err
+	}
+	_, err = config.DB.Exec(`
+		UPDATE bank_questions
+		SET flag_count = (SELECT COUNT(*) FROM bank_question_flags WHERE question_id=$1 AND resolved=false),
+			updated_at = NOW()
+		WHERE id=$1
+	`, questionID)
+	if err != nil {
+		return q, err
+	}
+	err = config.DB.Get(&q, "SELECT * FROM bank_questions WHERE id=$1", questionID)
+	return q, err
+}
+
+// ReviewBankQuestion sets an admin verdict on a question and resolves any
+// outstanding flags against it
+func ReviewBankQuestion(questionID int, adminID int, status string) error {
+	if status != BankStatusApproved && status != BankStatusRejected && status != BankStatusUnreviewed {
+		return fmt.Errorf("invalid status %q", status)
+	}
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE bank_questions SET status=$1, reviewed_by=$2, flag_count=0, updated_at=NOW()
+		WHERE id=$3
+	`, status, adminID, questionID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec("UPDATE bank_question_flags SET resolved=true WHERE question_id=$1", questionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,427 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+)
+
+var (
+	// ErrNoQuizQuestions is returned when neither the bank, Gemini nor the
+	// fallback produced questions for a quiz
+	ErrNoQuizQuestions = errors.New("failed to generate quiz questions")
+	// ErrNoActiveQuiz is returned when answering in a chat without a running quiz
+	ErrNoActiveQuiz = errors.New("no active quiz found")
+	// ErrQuizAnswered is returned when every question of the quiz is answered
+	ErrQuizAnswered = errors.New("all questions answered")
+)
+
+// QuizStart is a quiz started, or resumed, in a chat
+type QuizStart struct {
+	QuizID         int
+	Topic          string
+	TotalQuestions int
+	Duration       int  // Minutes; 0 when resuming
+	Existing       bool // An unfinished quiz was resumed
+}
+
+// QuizAnswer is the outcome of answering a chat quiz's current question
+type QuizAnswer struct {
+	Correct   bool
+	Score     int
+	Response  string // The bot message posted in reply
+	Completed bool
+}
+
+// StartQuiz starts a multiple choice quiz in one of the user's chats, sized
+// for duration minutes (about 3 minutes a question, 3 to 20 questions). An
+// unfinished quiz in the chat is resumed instead. Questions come from the
+// shared bank first, then Gemini, then a simple fallback. It returns
+// sql.ErrNoRows when the chat isn't the user's.
+func StartQuiz(ctx context.Context, apiKey string, userID int, chatID string, topic string, duration int) (QuizStart, error) {
+	var chat models.Chat
+	if err := config.DB.Get(&chat, "SELECT * FROM chats WHERE id=$1 AND user_id=$2", chatID, userID); err != nil {
+		return QuizStart{}, err
+	}
+
+	// Check if quiz already exists for this chat (not completed)
+	var existingQuiz models.Quiz
+	err := config.DB.Get(&existingQuiz, `
+		SELECT * FROM quizzes
+		WHERE chat_id=$1 AND status != 'completed'
+		ORDER BY created_at DESC LIMIT 1
+	`, chatID)
+	if err == nil {
+		var questionCount int
+		config.DB.Get(&questionCount, `SELECT COUNT(*) FROM quiz_questions WHERE quiz_id=$1`, existingQuiz.ID)
+		if questionCount > 0 {
+			// Quiz exists with questions - return it so user can continue
+			return QuizStart{QuizID: existingQuiz.ID, Topic: existingQuiz.Topic, TotalQuestions: existingQuiz.TotalQues, Existing: true}, nil
+		}
+		// Quiz exists but has no questions (likely failed generation) - delete it and create new one
+		fmt.Printf("Existing quiz %d has no questions, deleting and creating new one\n", existingQuiz.ID)
+		config.DB.Exec(`DELETE FROM quizzes WHERE id=$1`, existingQuiz.ID)
+	}
+
+	// Calculate number of questions based on duration (approx 2-3 min per question)
+	numQuestions := min(max(duration/3, 3), 20)
+
+	// Draw from the shared question bank first; only generate what's missing
+	canonicalTopic := CanonicalTopic(topic)
+	var questions []mcqQuestion
+	banked, err := DrawBankQuestions(userID, canonicalTopic, numQuestions)
+	if err != nil {
+		fmt.Printf("Warning: Failed to draw from question bank: %v\n", err)
+	}
+	for _, bq := range banked {
+		questions = append(questions, mcqFromBank(bq))
+	}
+
+	if missing := numQuestions - len(questions); missing > 0 {
+		generated, err := generateMCQQuestions(ctx, apiKey, userID, topic, missing)
+		if err != nil {
+			fmt.Printf("Warning: Failed to generate MCQ questions with Gemini: %v\n", err)
+		}
+		questions = append(questions, saveGeneratedToBank(ctx, apiKey, canonicalTopic, generated, questions)...)
+	}
+
+	// If the bank and Gemini together came up short, supplement with fallback
+	if len(questions) < numQuestions {
+		fmt.Printf("Warning: Bank and Gemini provided %d questions but %d requested. Supplementing with fallback questions.\n", len(questions), numQuestions)
+		questions = append(questions, generateSimpleMCQQuestions(topic, numQuestions-len(questions))...)
+	}
+	if len(questions) == 0 {
+		return QuizStart{}, ErrNoQuizQuestions
+	}
+	if len(questions) > numQuestions {
+		questions = questions[:numQuestions]
+	}
+	for i, q := range questions {
+		if len(q.Options) == 0 {
+			fmt.Printf("Warning: Question %d has no options, adding defaults\n", i+1)
+			questions[i].Options = []string{"Option A", "Option B", "Option C", "Option D"}
+		}
+	}
+
+	var quizID int
+	err = config.DB.QueryRow(`
+		INSERT INTO quizzes (user_id, chat_id, topic, status, total_questions, created_at)
+		VALUES ($1, $2, $3, 'pending', $4, $5)
+		RETURNING id
+	`, userID, chatID, topic, len(questions), time.Now()).Scan(&quizID)
+	if err != nil {
+		return QuizStart{}, fmt.Errorf("failed to create quiz: %w", err)
+	}
+
+	var bankIDs []int
+	for i, q := range questions {
+		optionsJSON, marshalErr := json.Marshal(q.Options)
+		if marshalErr != nil {
+			fmt.Printf("Warning: Failed to marshal options for question %d: %v\n", i+1, marshalErr)
+			optionsJSON = []byte("[]")
+		}
+		var bankID *int
+		if q.BankID != 0 {
+			bankID = &questions[i].BankID
+			bankIDs = append(bankIDs, q.BankID)
+		}
+		_, err = config.DB.Exec(`
+			INSERT INTO quiz_questions (quiz_id, question, answer, options, order_num, bank_question_id)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, quizID, q.Question, q.Answer, string(optionsJSON), i+1, bankID)
+		if err != nil {
+			return QuizStart{}, fmt.Errorf("failed to create questions: %w", err)
+		}
+	}
+	if err := MarkBankQuestionsUsed(bankIDs); err != nil {
+		fmt.Printf("Warning: Failed to update bank usage counters: %v\n", err)
+	}
+	return QuizStart{QuizID: quizID, Topic: topic, TotalQuestions: len(questions), Duration: duration}, nil
+}
+
+// NextQuizQuestion returns the first unanswered question of a quiz, or
+// sql.ErrNoRows when all are answered
+func NextQuizQuestion(quizID int) (models.QuizQuestion, error) {
+	var q models.QuizQuestion
+	err := config.DB.Get(&q, `
+		SELECT id, quiz_id, question, answer, COALESCE(options, '[]') as options,
+			COALESCE(user_answer, '') as user_answer, COALESCE(is_correct, false) as is_correct, order_num
+		FROM quiz_questions
+		WHERE quiz_id=$1 AND (user_answer IS NULL OR user_answer = '')
+		ORDER BY order_num ASC LIMIT 1
+	`, quizID)
+	return q, err
+}
+
+// AnswerQuiz answers the current question of the chat's running quiz. The
+// learner's answer and the bot's reply (the verdict, then the next question
+// or the final score) are both posted in the chat.
+func AnswerQuiz(ctx context.Context, userID int, chatID string, answer string) (QuizAnswer, error) {
+	var out QuizAnswer
+	var quiz models.Quiz
+	err := config.DB.Get(&quiz, `
+		SELECT * FROM quizzes
+		WHERE chat_id=$1 AND user_id=$2 AND status IN ('pending', 'in_progress')
+		ORDER BY created_at DESC LIMIT 1
+	`, chatID, userID)
+	if err == sql.ErrNoRows {
+		return out, ErrNoActiveQuiz
+	}
+	if err != nil {
+		return out, err
+	}
+	if quiz.Status == "pending" {
+		config.DB.Exec("UPDATE quizzes SET status='in_progress' WHERE id=$1", quiz.ID)
+	}
+
+	currentQ, err := NextQuizQuestion(quiz.ID)
+	if err == sql.ErrNoRows {
+		return out, ErrQuizAnswered
+	}
+	if err != nil {
+		return out, err
+	}
+
+	// Check answer: MCQ answers are the option letter, others a simple string match
+	if currentQ.Options != "" && currentQ.Options != "[]" {
+		out.Correct = strings.EqualFold(strings.TrimSpace(currentQ.Answer), strings.TrimSpace(answer))
+	} else {
+		out.Correct = strings.Contains(strings.ToLower(currentQ.Answer), strings.ToLower(answer)) ||
+			strings.Contains(strings.ToLower(answer), strings.ToLower(currentQ.Answer))
+	}
+	if _, err := config.DB.Exec("UPDATE quiz_questions SET user_answer=$1, is_correct=$2 WHERE id=$3", answer, out.Correct, currentQ.ID); err != nil {
+		return out, err
+	}
+	if out.Correct {
+		quiz.Score++
+		config.DB.Exec("UPDATE quizzes SET score=$1 WHERE id=$2", quiz.Score, quiz.ID)
+	}
+	out.Score = quiz.Score
+
+	AppendMessage(ctx, config.DB, chatID, "user", answer, "")
+
+	if out.Correct {
+		out.Response = "✅ Correct! "
+	} else {
+		out.Response = fmt.Sprintf("❌ Not quite. The answer is: %s. ", currentQ.Answer)
+	}
+	nextQ, err := NextQuizQuestion(quiz.ID)
+	if err != nil { // No more questions - quiz complete
+		now := time.Now()
+		config.DB.Exec("UPDATE quizzes SET status='completed', completed_at=$1, score=$2 WHERE id=$3", now, quiz.Score, quiz.ID)
+		PublishQuizCompleted(quiz, quiz.Score, now)
+		out.Completed = true
+		out.Response += fmt.Sprintf("\n🎉 Quiz completed! Your score: %d/%d", quiz.Score, quiz.TotalQues)
+	} else {
+		out.Response += "\n" + FormatQuizQuestion(nextQ, quiz.TotalQues)
+	}
+
+	AppendMessage(ctx, config.DB, chatID, "bot", out.Response, "")
+	return out, nil
+}
+
+// FormatQuizQuestion renders a quiz question with its lettered options
+func FormatQuizQuestion(q models.QuizQuestion, total int) string {
+	text := fmt.Sprintf("📝 Question %d/%d:\n%s", q.OrderNum, total, q.Question)
+	var options []string
+	json.Unmarshal([]byte(q.Options), &options)
+	for i, opt := range options {
+		letter := string(rune('A' + i))
+		if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(opt)), letter+")") && !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(opt)), letter+".") {
+			opt = letter + ") " + opt
+		}
+		text += "\n" + opt
+	}
+	return text
+}
+
+// PublishQuizCompleted announces a finished quiz to the event subscribers
+func PublishQuizCompleted(quiz models.Quiz, score int, completedAt time.Time) {
+	Publish(Event{
+		Type:     EventQuizCompleted,
+		UserID:   quiz.UserID,
+		SourceID: fmt.Sprintf("quiz:%d", quiz.ID),
+		Topic:    quiz.Topic,
+		Score:    score,
+		Total:    quiz.TotalQues,
+		At:       completedAt,
+	})
+}
+
+// mcqQuestion is a quiz question before it is written to quiz_questions
+type mcqQuestion struct {
+	Question string
+	Answer   string // "A", "B", "C", or "D"
+	Options  []string
+	Subtopic string
+	BankID   int // bank_questions.id, 0 for fallback questions that aren't banked
+}
+
+// mcqFromBank converts a stored bank question for use in a new quiz
+func mcqFromBank(bq models.BankQuestion) mcqQuestion {
+	var options []string
+	if err := json.Unmarshal([]byte(bq.Options), &options); err != nil {
+		options = []string{}
+	}
+	return mcqQuestion{
+		Question: bq.Question,
+		Answer:   bq.Answer,
+		Options:  options,
+		Subtopic: bq.Subtopic,
+		BankID:   bq.ID,
+	}
+}
+
+// saveGeneratedToBank stores freshly generated questions in the bank and
+// returns the ones usable for this quiz. A generated question that duplicates
+// one already in the quiz, or a bank entry that was rejected or flagged, is
+// dropped so the caller tops up with fallback questions instead.
+func saveGeneratedToBank(ctx context.Context, apiKey string, topic string, generated []mcqQuestion, existing []mcqQuestion) []mcqQuestion {
+	inQuiz := map[int]bool{}
+	for _, q := range existing {
+		if q.BankID != 0 {
+			inQuiz[q.BankID] = true
+		}
+	}
+
+	var kept []mcqQuestion
+	for _, q := range generated {
+		optionsJSON, _ := json.Marshal(q.Options)
+		stored, _, err := SaveToBank(ctx, apiKey, models.BankQuestion{
+			Topic:    topic,
+			Subtopic: q.Subtopic,
+			Question: q.Question,
+			Options:  string(optionsJSON),
+			Answer:   q.Answer,
+			Source:   "generated",
+		})
+		if err != nil {
+			// The question is still fine for this quiz, it just won't be reused
+			fmt.Printf("Warning: Failed to save question to bank: %v\n", err)
+			kept = append(kept, q)
+			continue
+		}
+		if inQuiz[stored.ID] || !IsBankQuestionUsable(stored) {
+			continue
+		}
+		inQuiz[stored.ID] = true
+		kept = append(kept, mcqFromBank(stored))
+	}
+	return kept
+}
+
+// generateMCQQuestions uses Gemini to generate MCQ questions. Malformed
+// questions are dropped and counted against the prompt version used.
+func generateMCQQuestions(ctx context.Context, apiKey string, userID int, topic string, numQuestions int) ([]mcqQuestion, error) {
+	// Not personalised: generated questions go into the shared question bank.
+	// The user only picks the variant of a running experiment.
+	prompt, err := RenderPrompt(PromptMCQGeneration, userID, MCQPromptData{Count: numQuestions, Topic: topic})
+	if err != nil {
+		return nil, err
+	}
+
+	response, model, err := GenerateGeminiText(ctx, apiKey, "", prompt)
+	if err != nil {
+		return nil, err
+	}
+	usageID := LogPromptUsage(prompt, userID, model, PromptSourceQuizGeneration, CanonicalTopic(topic))
+
+	// Parse JSON response
+	response = strings.TrimSpace(response)
+	// Remove markdown code blocks if present
+	if strings.HasPrefix(response, "```json") {
+		response = strings.TrimPrefix(response, "```json")
+		response = strings.TrimSuffix(response, "```")
+		response = strings.TrimSpace(response)
+	} else if strings.HasPrefix(response, "```") {
+		response = strings.TrimPrefix(response, "```")
+		response = strings.TrimSuffix(response, "```")
+		response = strings.TrimSpace(response)
+	}
+
+	var questionsJSON []struct {
+		Question string   `json:"question"`
+		Options  []string `json:"options"`
+		Answer   string   `json:"answer"`
+		Subtopic string   `json:"subtopic"`
+	}
+
+	if err := json.Unmarshal([]byte(response), &questionsJSON); err != nil {
+		RecordPromptOutcome(usageID, MetricValidationFailure, 1)
+		return nil, fmt.Errorf("failed to parse Gemini response: %w", err)
+	}
+
+	// Convert to return type, dropping questions that can't be answered
+	questions := make([]mcqQuestion, 0, len(questionsJSON))
+	invalid := 0
+	for _, q := range questionsJSON {
+		answer := strings.ToUpper(strings.TrimSpace(q.Answer))
+		if strings.TrimSpace(q.Question) == "" || len(q.Options) != 4 || len(answer) != 1 || answer < "A" || answer > "D" {
+			invalid++
+			continue
+		}
+		questions = append(questions, mcqQuestion{
+			Question: q.Question,
+			Answer:   answer,
+			Options:  q.Options,
+			Subtopic: q.Subtopic,
+		})
+	}
+	if invalid > 0 {
+		fmt.Printf("Warning: Dropped %d malformed generated questions\n", invalid)
+		RecordPromptOutcome(usageID, MetricInvalidQuestions, float64(invalid))
+	}
+	if len(questions) < numQuestions {
+		RecordPromptOutcome(usageID, MetricMissingQuestions, float64(numQuestions-len(questions)))
+	}
+
+	// Log how many questions we got
+	fmt.Printf("Generated %d questions (requested %d)\n", len(questions), numQuestions)
+
+	// Ensure we have the right number of questions
+	if len(questions) > numQuestions {
+		questions = questions[:numQuestions]
+		fmt.Printf("Trimmed to %d questions\n", len(questions))
+	} else if len(questions) < numQuestions {
+		fmt.Printf("Warning: Only got %d questions but requested %d\n", len(questions), numQuestions)
+	}
+
+	return questions, nil
+}
+
+// generateSimpleMCQQuestions creates basic MCQ questions as fallback
+func generateSimpleMCQQuestions(topic string, numQuestions int) []mcqQuestion {
+	topic = strings.ToLower(topic)
+	baseQuestions := []mcqQuestion{
+		{
+			Question: fmt.Sprintf("What is the main topic discussed about %s?", topic),
+			Options:  []string{topic, "A different topic", "Unrelated subject", "Random topic"},
+			Answer:   "A",
+		},
+		{
+			Question: fmt.Sprintf("Which is most relevant to %s?", topic),
+			Options:  []string{topic + " concepts", "Cooking recipes", "Sports news", "Weather forecast"},
+			Answer:   "A",
+		},
+		{
+			Question: fmt.Sprintf("What did you learn about %s?", topic),
+			Options:  []string{"Key concepts", "Nothing", "Random facts", "Unrelated info"},
+			Answer:   "A",
+		},
+	}
+
+	// Repeat base questions to reach numQuestions
+	questions := make([]mcqQuestion, numQuestions)
+	for i := 0; i < numQuestions; i++ {
+		questions[i] = baseQuestions[i%len(baseQuestions)]
+	}
+
+	return questions
+}
@@ -0,0 +1,404 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"golang-service/models"
+)
+
+// Supported quiz interchange formats
+const (
+	QuizFormatGIFT = "gift"
+	QuizFormatQTI  = "qti"
+	QuizFormatCSV  = "csv"
+	QuizFormatJSON = "json"
+)
+
+// maxImportQuestions caps how many questions a single import may contain
+const maxImportQuestions = 500
+
+// QuizDocument is the format-neutral form of a quiz that every importer
+// produces and every exporter consumes. Questions use the same encoding as
+// quiz_questions: Options is a JSON array ("[]" for free-text questions) and
+// Answer is the correct option letter or the expected free-text answer.
+type QuizDocument struct {
+	Quiz      models.Quiz
+	Questions []models.QuizQuestion
+}
+
+// ImportIssue is one finding in an import validation report
+type ImportIssue struct {
+	Item     int    `json:"item,omitempty"` // 1-based question position in the source, 0 for document-level issues
+	Line     int    `json:"line,omitempty"` // Source line when the format has meaningful lines
+	Severity string `json:"severity"`       // "error" (question skipped) or "warning" (imported with changes)
+	Message  string `json:"message"`
+}
+
+// ImportReport summarizes an import: how many questions were accepted and why
+// any were skipped or altered
+type ImportReport struct {
+	Format   string        `json:"format"`
+	Total    int           `json:"total"`
+	Imported int           `json:"imported"`
+	Skipped  int           `json:"skipped"`
+	Issues   []ImportIssue `json:"issues"`
+}
+
+func (r *ImportReport) addError(item, line int, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, ImportIssue{Item: item, Line: line, Severity: "error", Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *ImportReport) addWarning(item, line int, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, ImportIssue{Item: item, Line: line, Severity: "warning", Message: fmt.Sprintf(format, args...)})
+}
+
+// importedQuestion is a question as read from a source document, before
+// validation turns it into a models.QuizQuestion
+type importedQuestion struct {
+	Item     int
+	Line     int
+	Question string
+	Options  []string
+	Answer   string
+	Invalid  bool // The parser already reported why this question can't be imported
+}
+
+// QuizFormatFromFilename guesses the interchange format from a file extension
+func QuizFormatFromFilename(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gift", ".txt":
+		return QuizFormatGIFT
+	case ".zip", ".xml":
+		return QuizFormatQTI
+	case ".csv":
+		return QuizFormatCSV
+	case ".json":
+		return QuizFormatJSON
+	}
+	return ""
+}
+
+// ExportQuiz renders doc in the given format, returning the file contents,
+// its MIME type and a file extension
+func ExportQuiz(format string, doc QuizDocument) ([]byte, string, string, error) {
+	switch strings.ToLower(format) {
+	case QuizFormatGIFT:
+		return exportGIFT(doc), "text/plain; charset=utf-8", "gift", nil
+	case QuizFormatQTI:
+		out, err := exportQTI(doc)
+		return out, "application/zip", "zip", err
+	case QuizFormatCSV:
+		out, err := exportCSV(doc)
+		return out, "text/csv; charset=utf-8", "csv", err
+	case QuizFormatJSON:
+		out, err := exportJSON(doc)
+		return out, "application/json", "json", err
+	}
+	return nil, "", "", fmt.Errorf("unsupported format %q (use gift, qti, csv or json)", format)
+}
+
+// ImportQuiz parses data in the given format. Questions that fail validation
+// are left out of the returned document and listed in the report; the error
+// is only set when the document as a whole can't be read.
+func ImportQuiz(format string, data []byte) (QuizDocument, ImportReport, error) {
+	report := ImportReport{Format: strings.ToLower(format), Issues: []ImportIssue{}}
+	var doc QuizDocument
+	var items []importedQuestion
+	var err error
+
+	switch report.Format {
+	case QuizFormatGIFT:
+		items, doc.Quiz.Topic = importGIFT(data, &report)
+	case QuizFormatQTI:
+		items, doc.Quiz.Topic, err = importQTI(data, &report)
+	case QuizFormatCSV:
+		items, doc.Quiz.Topic, err = importCSV(data, &report)
+	case QuizFormatJSON:
+		items, doc.Quiz.Topic, err = importJSON(data, &report)
+	default:
+		return doc, report, fmt.Errorf("unsupported format %q (use gift, qti, csv or json)", format)
+	}
+	if err != nil {
+		return doc, report, err
+	}
+
+	report.Total = len(items)
+	if len(items) > maxImportQuestions {
+		report.addError(0, 0, "document has %d questions, only the first %d were read", len(items), maxImportQuestions)
+		items = items[:maxImportQuestions]
+	}
+
+	for _, item := range items {
+		if item.Invalid {
+			report.Skipped++
+			continue
+		}
+		q, ok := validateImportedQuestion(item, &report)
+		if !ok {
+			report.Skipped++
+			continue
+		}
+		q.OrderNum = len(doc.Questions) + 1
+		doc.Questions = append(doc.Questions, q)
+	}
+	report.Skipped += report.Total - len(items)
+	report.Imported = len(doc.Questions)
+	doc.Quiz.TotalQues = len(doc.Questions)
+	return doc, report, nil
+}
+
+// validateImportedQuestion checks a parsed question and converts it to the
+// stored representation, recording problems on the report
+func validateImportedQuestion(item importedQuestion, report *ImportReport) (models.QuizQuestion, bool) {
+	var q models.QuizQuestion
+	question := strings.TrimSpace(item.Question)
+	answer := strings.TrimSpace(item.Answer)
+	if question == "" {
+		report.addError(item.Item, item.Line, "question text is empty")
+		return q, false
+	}
+
+	if answer == "" {
+		report.addError(item.Item, item.Line, "no correct answer given")
+		return q, false
+	}
+	if len(item.Options) == 0 {
+		q.Question = question
+		q.Options = "[]"
+		q.Answer = answer
+		return q, true
+	}
+
+	// Resolve the answer against the options as written, before blanks and
+	// duplicates are dropped and the letters shift
+	correct := ""
+	if idx := correctOptionIndex(answer, item.Options); idx >= 0 {
+		correct = strings.TrimSpace(item.Options[idx])
+	} else {
+		// Accept the text of the correct option in place of its letter
+		for _, opt := range item.Options {
+			if strings.EqualFold(strings.TrimSpace(opt), answer) {
+				correct = strings.TrimSpace(opt)
+				break
+			}
+		}
+	}
+	if correct == "" {
+		report.addError(item.Item, item.Line, "answer %q does not match any option", answer)
+		return q, false
+	}
+
+	var options []string
+	seen := map[string]bool{}
+	answerIdx := -1
+	for _, opt := range item.Options {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			continue
+		}
+		if seen[strings.ToLower(opt)] {
+			report.addWarning(item.Item, item.Line, "duplicate option %q was dropped", opt)
+			continue
+		}
+		seen[strings.ToLower(opt)] = true
+		if answerIdx < 0 && strings.EqualFold(opt, correct) {
+			answerIdx = len(options)
+		}
+		options = append(options, opt)
+	}
+	if len(options) < 2 {
+		report.addError(item.Item, item.Line, "multiple choice question needs at least two distinct options")
+		return q, false
+	}
+	if len(options) > 26 {
+		report.addError(item.Item, item.Line, "question has %d options, at most 26 are supported", len(options))
+		return q, false
+	}
+	answer = string(rune('A' + answerIdx))
+
+	optionsJSON, _ := json.Marshal(options)
+	q.Question = question
+	q.Options = string(optionsJSON)
+	q.Answer = answer
+	return q, true
+}
+
+// questionOptions decodes the stored JSON options of a question
+func questionOptions(q models.QuizQuestion) []string {
+	var options []string
+	if q.Options != "" && q.Options != "null" {
+		json.Unmarshal([]byte(q.Options), &options)
+	}
+	return options
+}
+
+// correctOptionIndex maps an answer letter onto an option index, or -1
+func correctOptionIndex(answer string, options []string) int {
+	letter := strings.ToUpper(strings.TrimSpace(answer))
+	if len(letter) != 1 || letter[0] < 'A' || int(letter[0]-'A') >= len(options) {
+		return -1
+	}
+	return int(letter[0] - 'A')
+}
+
+// jsonQuizDocument is the on-disk shape of the JSON format
+type jsonQuizDocument struct {
+	Format    string             `json:"format"`
+	Version   int                `json:"version"`
+	Topic     string             `json:"topic"`
+	Questions []jsonQuizQuestion `json:"questions"`
+}
+
+type jsonQuizQuestion struct {
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+	Answer   string   `json:"answer"`
+}
+
+func exportJSON(doc QuizDocument) ([]byte, error) {
+	out := jsonQuizDocument{Format: "khoj-quiz", Version: 1, Topic: doc.Quiz.Topic, Questions: []jsonQuizQuestion{}}
+	for _, q := range doc.Questions {
+		options := questionOptions(q)
+		if options == nil {
+			options = []string{}
+		}
+		out.Questions = append(out.Questions, jsonQuizQuestion{Question: q.Question, Options: options, Answer: q.Answer})
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
+
+// importJSON accepts either the exported document or a bare array of questions
+func importJSON(data []byte, report *ImportReport) ([]importedQuestion, string, error) {
+	var in jsonQuizDocument
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &in.Questions); err != nil {
+			return nil, "", fmt.Errorf("invalid JSON: %w", err)
+		}
+	} else if err := json.Unmarshal(trimmed, &in); err != nil {
+		return nil, "", fmt.Errorf("invalid JSON: %w", err)
+	}
+	if in.Format != "" && in.Format != "khoj-quiz" {
+		report.addWarning(0, 0, "unknown format marker %q, reading it as khoj-quiz", in.Format)
+	}
+
+	items := make([]importedQuestion, len(in.Questions))
+	for i, q := range in.Questions {
+		items[i] = importedQuestion{Item: i + 1, Question: q.Question, Options: q.Options, Answer: q.Answer}
+	}
+	return items, in.Topic, nil
+}
+
+func exportCSV(doc QuizDocument) ([]byte, error) {
+	maxOptions := 0
+	for _, q := range doc.Questions {
+		if n := len(questionOptions(q)); n > maxOptions {
+			maxOptions = n
+		}
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	header := []string{"topic", "question", "answer"}
+	for i := 0; i < maxOptions; i++ {
+		header = append(header, "option_"+string(rune('a'+i)))
+	}
+	w.Write(header)
+	for _, q := range doc.Questions {
+		options := questionOptions(q)
+		row := []string{doc.Quiz.Topic, q.Question, q.Answer}
+		for i := 0; i < maxOptions; i++ {
+			if i < len(options) {
+				row = append(row, options[i])
+			} else {
+				row = append(row, "")
+			}
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// importCSV reads a header row naming at least "question" and "answer".
+// Options come from option_a.. / option1.. columns or a single "options"
+// column separated by "|".
+func importCSV(data []byte, report *ImportReport) ([]importedQuestion, string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid CSV: missing header row")
+	}
+	questionCol, answerCol, topicCol, optionsCol := -1, -1, -1, -1
+	var optionCols []int
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		switch {
+		case h == "question":
+			questionCol = i
+		case h == "answer" || h == "correct" || h == "correct_answer":
+			answerCol = i
+		case h == "topic":
+			topicCol = i
+		case h == "options":
+			optionsCol = i
+		case strings.HasPrefix(h, "option"):
+			optionCols = append(optionCols, i)
+		}
+	}
+	if questionCol < 0 || answerCol < 0 {
+		return nil, "", fmt.Errorf("invalid CSV: header must include question and answer columns")
+	}
+
+	var items []importedQuestion
+	topic := ""
+	line := 1
+	for {
+		record, err := r.Read()
+		line++
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.addError(len(items)+1, line, "unreadable row: %v", err)
+			continue
+		}
+		cell := func(i int) string {
+			if i < 0 || i >= len(record) {
+				return ""
+			}
+			return record[i]
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		item := importedQuestion{Item: len(items) + 1, Line: line, Question: cell(questionCol), Answer: cell(answerCol)}
+		if optionsCol >= 0 && strings.TrimSpace(cell(optionsCol)) != "" {
+			item.Options = strings.Split(cell(optionsCol), "|")
+		}
+		for _, col := range optionCols {
+			if v := strings.TrimSpace(cell(col)); v != "" {
+				item.Options = append(item.Options, v)
+			}
+		}
+		if t := strings.TrimSpace(cell(topicCol)); t != "" {
+			if topic != "" && !strings.EqualFold(topic, t) {
+				report.addWarning(item.Item, line, "topic %q differs from %q, all questions are imported under the first topic", t, topic)
+			} else {
+				topic = t
+			}
+		}
+		items = append(items, item)
+	}
+	return items, topic, nil
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// giftSpecial are the characters GIFT requires to be backslash-escaped
+const giftSpecial = "~=#{}:"
+
+var giftWeight = regexp.MustCompile(`^%(-?\d+(?:\.\d+)?)%`)
+
+func giftEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(giftSpecial, r) || r == '\\' {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	// GIFT ends a question at a blank line, so keep text on one line
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func giftUnescape(s string) string {
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		if escaped {
+			if r == 'n' {
+				b.WriteRune('\n')
+			} else {
+				b.WriteRune(r)
+			}
+			escaped = false
+			continue
+		}
+		if r == '\\' {
+			escaped = true
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// indexUnescaped returns the byte offset of the first c in s that is not
+// preceded by a backslash escape, or -1
+func indexUnescaped(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// exportGIFT writes multiple choice questions as {=right ~wrong ...} and
+// free-text questions as short-answer {=answer}
+func exportGIFT(doc QuizDocument) []byte {
+	var b strings.Builder
+	b.WriteString("// Exported from KHOJ\n")
+	if doc.Quiz.Topic != "" {
+		fmt.Fprintf(&b, "$CATEGORY: %s\n", strings.Join(strings.Fields(doc.Quiz.Topic), " "))
+	}
+	b.WriteString("\n")
+
+	for i, q := range doc.Questions {
+		fmt.Fprintf(&b, "::Q%d:: %s {\n", i+1, giftEscape(q.Question))
+		options := questionOptions(q)
+		if len(options) == 0 {
+			fmt.Fprintf(&b, "\t=%s\n", giftEscape(q.Answer))
+		} else {
+			correct := correctOptionIndex(q.Answer, options)
+			for j, opt := range options {
+				mark := "~"
+				if j == correct {
+					mark = "="
+				}
+				fmt.Fprintf(&b, "\t%s%s\n", mark, giftEscape(opt))
+			}
+		}
+		b.WriteString("}\n\n")
+	}
+	return []byte(b.String())
+}
+
+// importGIFT parses multiple choice, true/false and short answer questions.
+// Essay, matching and numerical questions have no equivalent in our quizzes
+// and are reported rather than imported.
+func importGIFT(data []byte, report *ImportReport) ([]importedQuestion, string) {
+	var items []importedQuestion
+	topic := ""
+
+	var block []string
+	blockLine := 0
+	flush := func() {
+		if len(block) == 0 {
+			return
+		}
+		text := strings.Join(block, "\n")
+		block = nil
+		item := importedQuestion{Item: len(items) + 1, Line: blockLine}
+		item.Invalid = !parseGIFTQuestion(text, &item, report)
+		items = append(items, item)
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "//"):
+			continue
+		case strings.HasPrefix(trimmed, "$CATEGORY:"):
+			flush()
+			category := strings.TrimSpace(strings.TrimPrefix(trimmed, "$CATEGORY:"))
+			category = strings.TrimPrefix(category, "$course$/")
+			category = strings.TrimPrefix(category, "$system$/")
+			if parts := strings.Split(category, "/"); len(parts) > 0 && topic == "" {
+				topic = strings.TrimSpace(parts[len(parts)-1])
+			}
+			continue
+		case trimmed == "":
+			flush()
+			continue
+		}
+		if len(block) == 0 {
+			blockLine = i + 1
+		}
+		block = append(block, line)
+	}
+	flush()
+
+	return items, topic
+}
+
+// parseGIFTQuestion fills item from one GIFT question block. It returns false
+// (after recording why) when the block can't become a quiz question.
+func parseGIFTQuestion(text string, item *importedQuestion, report *ImportReport) bool {
+	text = strings.TrimSpace(text)
+
+	// Optional ::title::
+	if strings.HasPrefix(text, "::") {
+		if end := strings.Index(text[2:], "::"); end >= 0 {
+			text = strings.TrimSpace(text[end+4:])
+		}
+	}
+	// Optional [html]/[markdown]/[plain] text format marker
+	if strings.HasPrefix(text, "[") {
+		if end := strings.Index(text, "]"); end > 0 && end < 12 {
+			text = strings.TrimSpace(text[end+1:])
+		}
+	}
+
+	open := indexUnescaped(text, '{')
+	if open < 0 {
+		report.addError(item.Item, item.Line, "no answer block {...} found")
+		return false
+	}
+	closeRel := indexUnescaped(text[open+1:], '}')
+	if closeRel < 0 {
+		report.addError(item.Item, item.Line, "answer block is not closed with }")
+		return false
+	}
+	answers := strings.TrimSpace(text[open+1 : open+1+closeRel])
+	before := giftUnescape(text[:open])
+	after := giftUnescape(text[open+2+closeRel:])
+	item.Question = before
+	if after != "" {
+		// "Missing word" format: the answer block sits inside the sentence
+		item.Question = before + " _____ " + after
+	}
+
+	switch {
+	case answers == "":
+		report.addError(item.Item, item.Line, "essay questions are not supported")
+		return false
+	case strings.HasPrefix(answers, "#"):
+		report.addError(item.Item, item.Line, "numerical questions are not supported")
+		return false
+	}
+
+	switch strings.ToUpper(strings.SplitN(answers, "#", 2)[0]) {
+	case "T", "TRUE":
+		item.Options = []string{"True", "False"}
+		item.Answer = "A"
+		return true
+	case "F", "FALSE":
+		item.Options = []string{"True", "False"}
+		item.Answer = "B"
+		return true
+	}
+
+	type choice struct {
+		text    string
+		correct bool
+	}
+	var choices []choice
+	hasWrong := false
+	for _, tok := range splitGIFTAnswers(answers) {
+		mark, body := tok[0], strings.TrimSpace(tok[1:])
+		if i := indexUnescaped(body, '#'); i >= 0 {
+			body = body[:i] // drop per-answer feedback
+		}
+		if strings.Contains(body, "->") {
+			report.addError(item.Item, item.Line, "matching questions are not supported")
+			return false
+		}
+		correct := mark == '='
+		if m := giftWeight.FindStringSubmatch(body); m != nil {
+			body = strings.TrimSpace(body[len(m[0]):])
+			switch m[1] {
+			case "100":
+				correct = true
+			case "0":
+				correct = false
+			default:
+				report.addWarning(item.Item, item.Line, "partial credit %s%% on %q treated as wrong", m[1], giftUnescape(body))
+				correct = false
+			}
+		}
+		if !correct {
+			hasWrong = true
+		}
+		choices = append(choices, choice{text: giftUnescape(body), correct: correct})
+	}
+
+	var correct []choice
+	for _, ch := range choices {
+		if ch.correct {
+			correct = append(correct, ch)
+		}
+	}
+	if len(correct) == 0 {
+		report.addError(item.Item, item.Line, "no answer is marked correct with =")
+		return false
+	}
+
+	if !hasWrong {
+		// Short answer: every choice is an accepted answer
+		if len(correct) > 1 {
+			report.addWarning(item.Item, item.Line, "short answer has %d accepted answers, only %q is kept", len(correct), correct[0].text)
+		}
+		item.Answer = correct[0].text
+		return true
+	}
+
+	if len(correct) > 1 {
+		report.addError(item.Item, item.Line, "questions with more than one correct option are not supported")
+		return false
+	}
+	for i, ch := range choices {
+		item.Options = append(item.Options, ch.text)
+		if ch.correct {
+			item.Answer = string(rune('A' + i))
+		}
+	}
+	return true
+}
+
+// splitGIFTAnswers splits an answer block on unescaped = and ~, keeping the
+// marker as the first byte of each token
+func splitGIFTAnswers(block string) []string {
+	var tokens []string
+	start := -1
+	for i := 0; i < len(block); i++ {
+		if block[i] == '\\' {
+			i++
+			continue
+		}
+		if block[i] == '=' || block[i] == '~' {
+			if start >= 0 {
+				tokens = append(tokens, block[start:i])
+			}
+			start = i
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, block[start:])
+	}
+	return tokens
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"golang-service/models"
+)
+
+const qtiNamespace = "http://www.imsglobal.org/xsd/imsqti_v2p1"
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// exportQTI builds an IMS content package: one assessmentItem file per
+// question, an assessmentTest that orders them, and the imsmanifest.xml
+func exportQTI(doc QuizDocument) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	title := doc.Quiz.Topic
+	if title == "" {
+		title = "KHOJ quiz"
+	}
+
+	var itemRefs, resources strings.Builder
+	for i, q := range doc.Questions {
+		id := fmt.Sprintf("item%d", i+1)
+		href := fmt.Sprintf("items/%s.xml", id)
+		w, err := zw.Create(href)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(w, qtiItemXML(id, fmt.Sprintf("Question %d", i+1), q)); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&itemRefs, "      <assessmentItemRef identifier=\"%s\" href=\"%s\"/>\n", id, href)
+		fmt.Fprintf(&resources, `    <resource identifier="res-%s" type="imsqti_item_xmlv2p1" href="%s">
+      <file href="%s"/>
+    </resource>
+`, id, href, href)
+	}
+
+	test := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<assessmentTest xmlns="%s" identifier="test" title="%s">
+  <testPart identifier="part1" navigationMode="linear" submissionMode="individual">
+    <assessmentSection identifier="section1" title="%s" visible="true">
+%s    </assessmentSection>
+  </testPart>
+</assessmentTest>
+`, qtiNamespace, xmlEscape(title), xmlEscape(title), itemRefs.String())
+	manifest := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<manifest xmlns="http://www.imsglobal.org/xsd/imscp_v1p1" identifier="khoj-quiz">
+  <metadata>
+    <schema>QTIv2.1 Package</schema>
+    <schemaversion>1.0.0</schemaversion>
+  </metadata>
+  <organizations/>
+  <resources>
+    <resource identifier="res-test" type="imsqti_test_xmlv2p1" href="assessment.xml">
+      <file href="assessment.xml"/>
+    </resource>
+%s  </resources>
+</manifest>
+`, resources.String())
+
+	for _, f := range []struct{ name, content string }{{"assessment.xml", test}, {"imsmanifest.xml", manifest}} {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(w, f.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// qtiItemXML renders one question as a choiceInteraction (MCQ) or a
+// textEntryInteraction (free text) scored with the match_correct template
+func qtiItemXML(id string, title string, q models.QuizQuestion) string {
+	options := questionOptions(q)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>
+<assessmentItem xmlns="%s" identifier="%s" title="%s" adaptive="false" timeDependent="false">
+`, qtiNamespace, id, xmlEscape(title))
+
+	if len(options) > 0 {
+		correct := correctOptionIndex(q.Answer, options)
+		fmt.Fprintf(&b, `  <responseDeclaration identifier="RESPONSE" cardinality="single" baseType="identifier">
+    <correctResponse>
+      <value>%s</value>
+    </correctResponse>
+  </responseDeclaration>
+`, qtiChoiceID(correct))
+	} else {
+		fmt.Fprintf(&b, `  <responseDeclaration identifier="RESPONSE" cardinality="single" baseType="string">
+    <correctResponse>
+      <value>%s</value>
+    </correctResponse>
+  </responseDeclaration>
+`, xmlEscape(q.Answer))
+	}
+	b.WriteString(`  <outcomeDeclaration identifier="SCORE" cardinality="single" baseType="float"/>
+  <itemBody>
+`)
+	if len(options) > 0 {
+		fmt.Fprintf(&b, `    <choiceInteraction responseIdentifier="RESPONSE" shuffle="false" maxChoices="1">
+      <prompt>%s</prompt>
+`, xmlEscape(q.Question))
+		for i, opt := range options {
+			fmt.Fprintf(&b, "      <simpleChoice identifier=\"%s\">%s</simpleChoice>\n", qtiChoiceID(i), xmlEscape(opt))
+		}
+		b.WriteString("    </choiceInteraction>\n")
+	} else {
+		fmt.Fprintf(&b, `    <p>%s</p>
+    <p><textEntryInteraction responseIdentifier="RESPONSE" expectedLength="%d"/></p>
+`, xmlEscape(q.Question), len(q.Answer)+10)
+	}
+	b.WriteString(`  </itemBody>
+  <responseProcessing template="http://www.imsglobal.org/question/qti_v2p1/rptemplates/match_correct"/>
+</assessmentItem>
+`)
+	return b.String()
+}
+
+// qtiChoiceID names choice i the same way the app letters options (A, B, ...)
+func qtiChoiceID(i int) string {
+	if i < 0 {
+		return ""
+	}
+	return string(rune('A' + i))
+}
+
+// importQTI accepts either a content package (zip) or a single assessmentItem
+// XML document
+func importQTI(data []byte, report *ImportReport) ([]importedQuestion, string, error) {
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		item, ok := parseQTIItem(data, 1, report)
+		if !ok {
+			return []importedQuestion{{Item: 1, Invalid: true}}, "", nil
+		}
+		return []importedQuestion{item}, "", nil
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid QTI package: %w", err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !strings.HasSuffix(strings.ToLower(f.Name), ".xml") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid QTI package: %w", err)
+		}
+		content, err := io.ReadAll(io.LimitReader(rc, 10<<20))
+		rc.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid QTI package: %w", err)
+		}
+		files[f.Name] = content
+	}
+
+	// Prefer the order given by the assessmentTest; fall back to file names
+	var order []string
+	topic := ""
+	for name, content := range files {
+		if qtiRootElement(content) != "assessmentTest" {
+			continue
+		}
+		var test struct {
+			Title string `xml:"title,attr"`
+			Refs  []struct {
+				Href string `xml:"href,attr"`
+			} `xml:"testPart>assessmentSection>assessmentItemRef"`
+		}
+		if err := xml.Unmarshal(content, &test); err != nil {
+			report.addWarning(0, 0, "could not read %s: %v", name, err)
+			continue
+		}
+		topic = test.Title
+		for _, ref := range test.Refs {
+			order = append(order, path.Join(path.Dir(name), ref.Href))
+		}
+		break
+	}
+	if len(order) == 0 {
+		for name, content := range files {
+			if qtiRootElement(content) == "assessmentItem" {
+				order = append(order, name)
+			}
+		}
+		sort.Strings(order)
+	}
+	if len(order) == 0 {
+		return nil, "", fmt.Errorf("invalid QTI package: no assessmentItem found")
+	}
+
+	var items []importedQuestion
+	for i, name := range order {
+		content, ok := files[name]
+		if !ok {
+			report.addError(i+1, 0, "item %s listed in the test is missing from the package", name)
+			items = append(items, importedQuestion{Item: i + 1, Invalid: true})
+			continue
+		}
+		item, ok := parseQTIItem(content, i+1, report)
+		if !ok {
+			items = append(items, importedQuestion{Item: i + 1, Invalid: true})
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, topic, nil
+}
+
+// qtiRootElement returns the local name of the document element
+func qtiRootElement(data []byte) string {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se.Name.Local
+		}
+	}
+}
+
+// parseQTIItem walks an assessmentItem and extracts the stem, choices and
+// correct response of its single interaction
+func parseQTIItem(data []byte, n int, report *ImportReport) (importedQuestion, bool) {
+	item := importedQuestion{Item: n}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	var (
+		stack        []string
+		stem, prompt strings.Builder
+		choiceIDs    []string
+		choiceText   strings.Builder
+		correct      []string
+		baseType     string
+		interactions int
+		textEntry    bool
+		sawItem      bool
+	)
+	inside := func(name string) bool {
+		for _, s := range stack {
+			if s == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.addError(n, 0, "invalid XML: %v", err)
+			return item, false
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			attr := func(key string) string {
+				for _, a := range t.Attr {
+					if a.Name.Local == key {
+						return a.Value
+					}
+				}
+				return ""
+			}
+			switch name {
+			case "assessmentItem":
+				sawItem = true
+			case "responseDeclaration":
+				if attr("identifier") == "RESPONSE" || baseType == "" {
+					baseType = attr("baseType")
+					if c := attr("cardinality"); c != "" && c != "single" {
+						report.addError(n, 0, "%s response cardinality is not supported", c)
+						return item, false
+					}
+				}
+			case "choiceInteraction":
+				interactions++
+			case "textEntryInteraction", "extendedTextInteraction":
+				interactions++
+				textEntry = true
+			case "simpleChoice":
+				choiceIDs = append(choiceIDs, attr("identifier"))
+				choiceText.Reset()
+			case "matchInteraction", "orderInteraction", "associateInteraction", "gapMatchInteraction", "hotspotInteraction", "inlineChoiceInteraction", "sliderInteraction", "uploadInteraction":
+				report.addError(n, 0, "%s is not supported", name)
+				return item, false
+			}
+			stack = append(stack, name)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			if t.Name.Local == "simpleChoice" {
+				item.Options = append(item.Options, strings.Join(strings.Fields(choiceText.String()), " "))
+			}
+		case xml.CharData:
+			text := string(t)
+			switch {
+			case inside("correctResponse") && len(stack) > 0 && stack[len(stack)-1] == "value":
+				correct = append(correct, strings.TrimSpace(text))
+			case inside("simpleChoice"):
+				choiceText.WriteString(text)
+			case inside("prompt"):
+				prompt.WriteString(text)
+			case inside("itemBody") && !inside("choiceInteraction"):
+				stem.WriteString(text)
+				stem.WriteString(" ")
+			}
+		}
+	}
+
+	if !sawItem {
+		report.addError(n, 0, "document is not an assessmentItem")
+		return item, false
+	}
+	if interactions != 1 {
+		report.addError(n, 0, "expected exactly one interaction, found %d", interactions)
+		return item, false
+	}
+	item.Question = strings.Join(strings.Fields(stem.String()+" "+prompt.String()), " ")
+	if len(correct) == 0 {
+		report.addError(n, 0, "item has no correctResponse")
+		return item, false
+	}
+
+	if textEntry {
+		item.Options = nil
+		item.Answer = correct[0]
+		return item, true
+	}
+	if baseType != "" && baseType != "identifier" {
+		report.addWarning(n, 0, "unexpected baseType %q for a choice interaction", baseType)
+	}
+	for i, id := range choiceIDs {
+		if id == correct[0] {
+			item.Answer = string(rune('A' + i))
+		}
+	}
+	if item.Answer == "" {
+		report.addError(n, 0, "correct response %q is not one of the choices", correct[0])
+		return item, false
+	}
+	return item, true
+}
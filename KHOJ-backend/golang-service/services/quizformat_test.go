@@ -0,0 +1,190 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"golang-service/models"
+)
+
+// sampleQuiz exercises the characters each format has to escape, a correct
+// option that isn't first, and a free-text question
+func sampleQuiz() QuizDocument {
+	return QuizDocument{
+		Quiz: models.Quiz{Topic: "algebra & <basics>"},
+		Questions: []models.QuizQuestion{
+			{Question: "Solve 2x + 5 = 11. What is x?", Options: `["2","3","4","5"]`, Answer: "B"},
+			{Question: "Which is a prime: {a} ~ b = c?", Options: `["4","\"7\", surely","9"]`, Answer: "B"},
+			{Question: "Is x = -x true for x = 0?", Options: `["True","False"]`, Answer: "A"},
+			{Question: "Name the x in y = mx + c, one word", Options: "[]", Answer: "input"},
+		},
+	}
+}
+
+func assertSameQuestions(t *testing.T, got []models.QuizQuestion, want []models.QuizQuestion) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d questions, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Question != w.Question || g.Options != w.Options || g.Answer != w.Answer {
+			t.Errorf("question %d = {%q %s %q}, want {%q %s %q}", i+1, g.Question, g.Options, g.Answer, w.Question, w.Options, w.Answer)
+		}
+		if g.OrderNum != i+1 {
+			t.Errorf("question %d has order_num %d", i+1, g.OrderNum)
+		}
+	}
+}
+
+func TestQuizFormatRoundTrip(t *testing.T) {
+	want := sampleQuiz()
+	for _, format := range []string{QuizFormatGIFT, QuizFormatQTI, QuizFormatCSV, QuizFormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			data, _, _, err := ExportQuiz(format, want)
+			if err != nil {
+				t.Fatalf("export: %v", err)
+			}
+			got, report, err := ImportQuiz(format, data)
+			if err != nil {
+				t.Fatalf("import: %v", err)
+			}
+			if report.Imported != len(want.Questions) || report.Skipped != 0 || len(report.Issues) != 0 {
+				t.Errorf("report = %+v, want every question imported cleanly", report)
+			}
+			if got.Quiz.Topic != want.Quiz.Topic {
+				t.Errorf("topic = %q, want %q", got.Quiz.Topic, want.Quiz.Topic)
+			}
+			if got.Quiz.TotalQues != len(want.Questions) {
+				t.Errorf("total_ques = %d, want %d", got.Quiz.TotalQues, len(want.Questions))
+			}
+			assertSameQuestions(t, got.Questions, want.Questions)
+		})
+	}
+}
+
+func TestQuizFormatQTISingleItem(t *testing.T) {
+	want := sampleQuiz()
+	data, _, _, err := ExportQuiz(QuizFormatQTI, want)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("exported package is not a zip: %v", err)
+	}
+	var items [][]byte
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, "items/") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		items = append(items, content)
+	}
+	if len(items) != len(want.Questions) {
+		t.Fatalf("package has %d items, want %d", len(items), len(want.Questions))
+	}
+
+	// Item files are named in question order
+	for i, item := range items {
+		got, report, err := ImportQuiz(QuizFormatQTI, item)
+		if err != nil {
+			t.Fatalf("item %d: %v", i+1, err)
+		}
+		if report.Imported != 1 {
+			t.Fatalf("item %d: report = %+v", i+1, report)
+		}
+		assertSameQuestions(t, got.Questions, want.Questions[i:i+1])
+	}
+}
+
+func TestImportQuizReportsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		data     string
+		imported int
+		skipped  int
+	}{
+		{
+			name:   "gift answer missing and unsupported type",
+			format: QuizFormatGIFT,
+			data: "::ok::Pick two {~1 =2 ~3}\n\n" +
+				"::none::No right answer {~a ~b}\n\n" +
+				"::essay::Write about x {}\n",
+			imported: 1,
+			skipped:  2,
+		},
+		{
+			name:   "csv bad answer letter and empty question",
+			format: QuizFormatCSV,
+			data: "question,answer,option_a,option_b\n" +
+				"Pick b,B,a,b\n" +
+				"Pick z,Z,a,b\n" +
+				",A,a,b\n",
+			imported: 1,
+			skipped:  2,
+		},
+		{
+			name:   "json answer not among the options and one option",
+			format: QuizFormatJSON,
+			data: `[{"question":"Pick b","options":["a","b"],"answer":"b"},
+				{"question":"Pick c","options":["a","b"],"answer":"c"},
+				{"question":"Only one","options":["a","a"],"answer":"A"}]`,
+			imported: 1,
+			skipped:  2,
+		},
+		{
+			name:     "qti item without a correct response",
+			format:   QuizFormatQTI,
+			data:     `<assessmentItem xmlns="http://www.imsglobal.org/xsd/imsqti_v2p1" identifier="q1" title="x"><itemBody><choiceInteraction responseIdentifier="RESPONSE" maxChoices="1"><prompt>Pick</prompt><simpleChoice identifier="A">a</simpleChoice><simpleChoice identifier="B">b</simpleChoice></choiceInteraction></itemBody></assessmentItem>`,
+			imported: 0,
+			skipped:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, report, err := ImportQuiz(tt.format, []byte(tt.data))
+			if err != nil {
+				t.Fatalf("import: %v", err)
+			}
+			if report.Imported != tt.imported || report.Skipped != tt.skipped || len(doc.Questions) != tt.imported {
+				t.Errorf("imported %d, skipped %d (%d questions); want %d, %d", report.Imported, report.Skipped, len(doc.Questions), tt.imported, tt.skipped)
+			}
+			errors := 0
+			for _, issue := range report.Issues {
+				if issue.Severity == "error" {
+					errors++
+					if issue.Message == "" || issue.Item == 0 {
+						t.Errorf("error issue without item or message: %+v", issue)
+					}
+				}
+			}
+			if errors != tt.skipped {
+				t.Errorf("got %d error issues, want one per skipped question: %+v", errors, report.Issues)
+			}
+		})
+	}
+}
+
+func TestImportQuizRejectsUnreadableDocuments(t *testing.T) {
+	tests := []struct{ format, data string }{
+		{QuizFormatJSON, `{"questions": [`},
+		{QuizFormatCSV, "prompt,solution\nx,y\n"},
+		{QuizFormatQTI, "PK\x03\x04 not really a zip"},
+		{"docx", "anything"},
+	}
+	for _, tt := range tests {
+		if _, _, err := ImportQuiz(tt.format, []byte(tt.data)); err == nil {
+			t.Errorf("ImportQuiz(%s, %q) succeeded, want an error", tt.format, tt.data)
+		}
+	}
+}
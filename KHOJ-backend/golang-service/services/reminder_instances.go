@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Reminder instance states
+const (
+	ReminderSent         = "sent"
+	ReminderAcknowledged = "acknowledged"
+	ReminderSnoozed      = "snoozed"
+	ReminderSkipped      = "skipped"
+	ReminderCompleted    = "completed"
+)
+
+const (
+	// MaxSnoozeMinutes caps a single snooze at a day
+	MaxSnoozeMinutes = 24 * 60
+	// reminderCompletionWindow is how long after delivery finishing a quiz on
+	// the topic still completes the reminder
+	reminderCompletionWindow = 36 * time.Hour
+)
+
+var (
+	// ErrReminderClosed is returned when acting on a skipped or completed reminder
+	ErrReminderClosed = errors.New("reminder is already skipped or completed")
+	// ErrInvalidSnooze is returned for a snooze outside 1..MaxSnoozeMinutes
+	ErrInvalidSnooze = fmt.Errorf("minutes must be between 1 and %d", MaxSnoozeMinutes)
+)
+
+// openReminderStates are the states a learner can still act on
+var openReminderStates = []string{ReminderSent, ReminderAcknowledged, ReminderSnoozed}
+
+// recordReminderInstance creates the instance for a delivered occurrence in
+// the delivery's transaction
+func recordReminderInstance(tx *sqlx.Tx, s models.Schedule, occurrence time.Time, messageID string) error {
+	_, err := tx.Exec(`
+		INSERT INTO reminder_instances (schedule_id, user_id, chat_id, topic, occurrence_time, state, message_id)
+		VALUES ($1, $2, $3, $4, $5, 'sent', $6)
+		ON CONFLICT (schedule_id, occurrence_time) DO NOTHING
+	`, s.ID, s.UserID, s.ChatID, s.Topic, occurrence, messageID)
+	return err
+}
+
+// UpdateReminderState applies a learner action to an instance they own.
+// Snoozing needs minutes; the other states ignore it.
+func UpdateReminderState(instanceID int, userID int, state string, minutes int) (models.ReminderInstance, error) {
+	var inst models.ReminderInstance
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return inst, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.Get(&inst, "SELECT * FROM reminder_instances WHERE id=$1 AND user_id=$2 FOR UPDATE", instanceID, userID); err != nil {
+		return inst, err
+	}
+	if !containsString(openReminderStates, inst.State) {
+		return inst, ErrReminderClosed
+	}
+
+	switch state {
+	case ReminderSnoozed:
+		if minutes < 1 || minutes > MaxSnoozeMinutes {
+			return inst, ErrInvalidSnooze
+		}
+		err = tx.Get(&inst, `
+			UPDATE reminder_instances
+			SET state='snoozed', snoozed_until=$2, snooze_count=snooze_count+1, updated_at=NOW()
+			WHERE id=$1 RETURNING *
+		`, inst.ID, time.Now().Add(time.Duration(minutes)*time.Minute))
+	case ReminderAcknowledged, ReminderSkipped, ReminderCompleted:
+		err = tx.Get(&inst, `
+			UPDATE reminder_instances SET state=$2, snoozed_until=NULL, updated_at=NOW()
+			WHERE id=$1 RETURNING *
+		`, inst.ID, state)
+	default:
+		return inst, fmt.Errorf("unknown reminder state %q", state)
+	}
+	if err != nil {
+		return inst, err
+	}
+	return inst, tx.Commit()
+}
+
+// LatestOpenReminder returns the user's most recent reminder in a chat that
+// they haven't skipped or completed
+func LatestOpenReminder(userID int, chatID string) (models.ReminderInstance, error) {
+	var inst models.ReminderInstance
+	err := config.DB.Get(&inst, `
+		SELECT * FROM reminder_instances
+		WHERE user_id=$1 AND chat_id=$2 AND state IN ('sent', 'acknowledged', 'snoozed')
+		ORDER BY occurrence_time DESC LIMIT 1
+	`, userID, chatID)
+	return inst, err
+}
+
+// SkipNextOccurrence skips a schedule's pending occurrence before it is sent:
+// recurring schedules move to the following occurrence and one-time schedules
+// are deactivated. The skipped occurrence is recorded as a skipped instance.
+func SkipNextOccurrence(scheduleID int, userID int) (models.Schedule, time.Time, error) {
+	var s models.Schedule
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return s, time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.Get(&s, "SELECT * FROM schedules WHERE id=$1 AND user_id=$2 AND active=true FOR UPDATE", scheduleID, userID); err != nil {
+		return s, time.Time{}, err
+	}
+	skipped := ScheduleOccurrence(s)
+	_, err = tx.Exec(`
+		INSERT INTO reminder_instances (schedule_id, user_id, chat_id, topic, occurrence_time, state)
+		VALUES ($1, $2, $3, $4, $5, 'skipped')
+		ON CONFLICT (schedule_id, occurrence_time) DO UPDATE SET state='skipped', updated_at=NOW()
+	`, s.ID, s.UserID, s.ChatID, s.Topic, skipped)
+	if err != nil {
+		return s, skipped, err
+	}
+	// Advance past the skipped occurrence, not past now, so skipping an
+	// occurrence that is hours away doesn't also drop the ones before it
+	after := skipped
+	if now := time.Now(); now.After(after) {
+		after = now
+	}
+	next, fireAt, ok := PlanOccurrence(s, after, UserQuietHours(s.UserID))
+	if ok {
+		_, err = tx.Exec(`
+			UPDATE schedules SET scheduled_time=$2, occurrence_time=$3, attempts=0, next_attempt_at=NULL, last_error='' WHERE id=$1
+		`, s.ID, fireAt, next)
+	} else {
+		_, err = tx.Exec("UPDATE schedules SET active=false, attempts=0, next_attempt_at=NULL WHERE id=$1", s.ID)
+	}
+	if err != nil {
+		return s, skipped, err
+	}
+	if err := tx.Get(&s, "SELECT * FROM schedules WHERE id=$1", s.ID); err != nil {
+		return s, skipped, err
+	}
+	return s, skipped, tx.Commit()
+}
+
+// RunDueSnoozes re-delivers snoozed reminders whose snooze has run out and
+// returns how many it handled
+func RunDueSnoozes(ctx context.Context) (int, error) {
+	n := 0
+	for n < reminderBatchSize {
+		if ctx.Err() != nil {
+			return n, nil
+		}
+		handled, err := processDueSnooze(ctx)
+		if err != nil {
+			return n, err
+		}
+		if !handled {
+			return n, nil
+		}
+		n++
+	}
+	return n, nil
+}
+
+func processDueSnooze(ctx context.Context) (bool, error) {
+	tx, err := config.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var inst models.ReminderInstance
+	err = tx.Get(&inst, `
+		SELECT * FROM reminder_instances
+		WHERE state='snoozed' AND snoozed_until <= $1
+		ORDER BY snoozed_until ASC
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, time.Now())
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	msg, err := AppendMessage(context.Background(), tx, inst.ChatID, "bot", ReminderMessage(inst.Topic), "")
+	if err != nil {
+		return false, fmt.Errorf("failed to post snoozed reminder: %w", err)
+	}
+	_, err = tx.Exec(`
+		UPDATE reminder_instances SET state='sent', snoozed_until=NULL, message_id=$2, updated_at=NOW() WHERE id=$1
+	`, inst.ID, msg.ID)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	NotifyAsync(Notification{
+		UserID:    inst.UserID,
+		ChatID:    inst.ChatID,
+		Kind:      NotifyQuizReminder,
+		Data:      map[string]interface{}{"topic": inst.Topic, "schedule_id": inst.ScheduleID},
+		DedupeKey: fmt.Sprintf("schedule-%d-%d-snooze-%d", inst.ScheduleID, inst.OccurrenceTime.Unix(), inst.SnoozeCount),
+		Skip:      []string{ChannelInApp},
+	})
+	return true, nil
+}
+
+// completeRemindersForQuiz marks the user's open reminders on the quiz's topic
+// as completed when they finish a quiz on it
+func completeRemindersForQuiz(e Event) {
+	var open []models.ReminderInstance
+	err := config.DB.Select(&open, `
+		SELECT * FROM reminder_instances
+		WHERE user_id=$1 AND state IN ('sent', 'acknowledged', 'snoozed') AND occurrence_time >= $2 AND occurrence_time <= $3
+	`, e.UserID, e.At.Add(-reminderCompletionWindow), e.At)
+	if err != nil {
+		fmt.Printf("Warning: failed to load reminders of user %d: %v\n", e.UserID, err)
+		return
+	}
+
+	var quizID *int
+	if id, err := strconv.Atoi(strings.TrimPrefix(e.SourceID, "quiz:")); err == nil {
+		quizID = &id
+	}
+	topic := CanonicalTopic(e.Topic)
+	for _, inst := range open {
+		if CanonicalTopic(inst.Topic) != topic {
+			continue
+		}
+		_, err := config.DB.Exec(`
+			UPDATE reminder_instances SET state='completed', snoozed_until=NULL, completed_quiz_id=$2, updated_at=NOW()
+			WHERE id=$1 AND state IN ('sent', 'acknowledged', 'snoozed')
+		`, inst.ID, quizID)
+		if err != nil {
+			fmt.Printf("Warning: failed to complete reminder %d: %v\n", inst.ID, err)
+		}
+	}
+}
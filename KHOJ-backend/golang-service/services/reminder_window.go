@@ -0,0 +1,442 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+)
+
+// Window strategies: where inside reminder_time..reminder_time_end an
+// occurrence fires
+const (
+	WindowRandom   = "random"   // A random moment, stable for a given occurrence
+	WindowActivity = "activity" // The slot the learner is usually active in, else random
+	WindowStart    = "start"    // Always at reminder_time
+)
+
+const (
+	// windowSlot is the granularity of activity buckets and of the search for
+	// a moment outside quiet hours
+	windowSlot = 15 * time.Minute
+	// activityLookbackDays is how much chat history the activity strategy uses
+	activityLookbackDays = 30
+	// maxCoalescedOccurrences bounds how many occurrences a deferral may skip
+	maxCoalescedOccurrences = 366
+)
+
+// ValidWindowStrategy reports whether s is a known window strategy
+func ValidWindowStrategy(s string) bool {
+	return s == WindowRandom || s == WindowActivity || s == WindowStart
+}
+
+// ParseClock parses "HH:MM" into minutes after midnight
+func ParseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// QuietHours is a user's do-not-disturb configuration
+type QuietHours struct {
+	Start, End int // Minutes after midnight; quiet when Start != End
+	Days       [7]bool
+	Location   *time.Location
+}
+
+// ParseDNDDays parses a comma-separated weekday list (0=Sun .. 6=Sat)
+func ParseDNDDays(s string) ([7]bool, error) {
+	var days [7]bool
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := strconv.Atoi(part)
+		if err != nil || d < 0 || d > 6 {
+			return days, fmt.Errorf("invalid do-not-disturb day %q, use 0 (Sun) to 6 (Sat)", part)
+		}
+		days[d] = true
+	}
+	all := true
+	for _, d := range days {
+		all = all && d
+	}
+	if all {
+		return days, fmt.Errorf("at least one day must allow reminders")
+	}
+	return days, nil
+}
+
+// NewQuietHours validates reminder preferences for the given zone
+func NewQuietHours(p models.ReminderPreferences, loc *time.Location) (QuietHours, error) {
+	q := QuietHours{Location: loc}
+	if (p.QuietHoursStart == "") != (p.QuietHoursEnd == "") {
+		return q, fmt.Errorf("quiet_hours_start and quiet_hours_end must be set together")
+	}
+	var err error
+	if p.QuietHoursStart != "" {
+		if q.Start, err = ParseClock(p.QuietHoursStart); err != nil {
+			return q, err
+		}
+		if q.End, err = ParseClock(p.QuietHoursEnd); err != nil {
+			return q, err
+		}
+	}
+	if q.Days, err = ParseDNDDays(p.DNDDays); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+// UserQuietHours loads the user's quiet hours in their time zone. Invalid
+// stored values are logged and ignored so reminders keep flowing.
+func UserQuietHours(userID int) QuietHours {
+	loc := UserLocation(userID)
+	var p models.ReminderPreferences
+	err := config.DB.Get(&p, `
+		SELECT quiet_hours_start, quiet_hours_end, dnd_days, preferred_hours_start, preferred_hours_end
+		FROM users WHERE id=$1
+	`, userID)
+	if err != nil {
+		return QuietHours{Location: loc}
+	}
+	q, err := NewQuietHours(p, loc)
+	if err != nil {
+		fmt.Printf("Warning: ignoring invalid quiet hours of user %d: %v\n", userID, err)
+		return QuietHours{Location: loc}
+	}
+	return q
+}
+
+// Allowed reports whether a reminder may be delivered at t
+func (q QuietHours) Allowed(t time.Time) bool {
+	l := t.In(q.Location)
+	if q.Days[l.Weekday()] {
+		return false
+	}
+	if q.Start == q.End {
+		return true
+	}
+	m := l.Hour()*60 + l.Minute()
+	if q.Start < q.End {
+		return m < q.Start || m >= q.End
+	}
+	// Overnight, e.g. 22:00-07:00
+	return m < q.Start && m >= q.End
+}
+
+// NextAllowed returns t if reminders are allowed then, otherwise the first
+// moment after it when quiet hours end on a day that isn't do-not-disturb
+func (q QuietHours) NextAllowed(t time.Time) time.Time {
+	for i := 0; i < 16 && !q.Allowed(t); i++ {
+		l := t.In(q.Location)
+		if q.Days[l.Weekday()] {
+			t = LocalTime(l.Year(), l.Month(), l.Day()+1, 0, 0, 0, q.Location)
+			continue
+		}
+		day := l.Day()
+		if m := l.Hour()*60 + l.Minute(); q.Start > q.End && m >= q.Start {
+			day++ // Overnight quiet hours end tomorrow
+		}
+		t = LocalTime(l.Year(), l.Month(), day, q.End/60, q.End%60, 0, q.Location)
+	}
+	return t
+}
+
+// reminderWindow returns how long after the nominal occurrence the reminder
+// may fire. A window ending before it starts wraps past midnight.
+func reminderWindow(s models.Schedule) time.Duration {
+	if s.ReminderTimeEnd == "" || s.ReminderTime == "" {
+		return 0
+	}
+	start, err1 := ParseClock(s.ReminderTime)
+	end, err2 := ParseClock(s.ReminderTimeEnd)
+	if err1 != nil || err2 != nil || start == end {
+		return 0
+	}
+	if end < start {
+		end += 24 * 60
+	}
+	return time.Duration(end-start) * time.Minute
+}
+
+// windowRand is seeded from the schedule and occurrence so that planning the
+// same occurrence twice (after a restart or a preference change) gives the
+// same moment
+func windowRand(s models.Schedule, occurrence time.Time) *rand.Rand {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d|%s|%s|%d", s.UserID, s.ChatID, s.Topic, occurrence.Unix())
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// activityOffset picks the window slot in which the learner sent the most
+// messages (by local time of day) over the last activityLookbackDays
+func activityOffset(s models.Schedule, occurrence time.Time, window time.Duration, loc *time.Location) (time.Duration, bool) {
+	var times []time.Time
+	err := config.DB.Select(&times, `
+		SELECT m.created_at FROM messages m
+		JOIN chats c ON c.id = m.chat_id
+		WHERE c.user_id=$1 AND m.role='user' AND m.created_at >= $2
+	`, s.UserID, occurrence.AddDate(0, 0, -activityLookbackDays))
+	if err != nil || len(times) == 0 {
+		return 0, false
+	}
+
+	local := occurrence.In(loc)
+	startMin := local.Hour()*60 + local.Minute()
+	slotMin := int(windowSlot / time.Minute)
+	slots := int(window / windowSlot)
+	if slots == 0 {
+		return 0, false
+	}
+	counts := make([]int, slots)
+	for _, t := range times {
+		l := t.In(loc)
+		offset := (l.Hour()*60 + l.Minute() - startMin + 24*60) % (24 * 60)
+		if slot := offset / slotMin; slot < slots {
+			counts[slot]++
+		}
+	}
+	best := -1
+	for i, n := range counts {
+		if n > 0 && (best < 0 || n > counts[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	return time.Duration(best) * windowSlot, true
+}
+
+// PlanFireTime picks when one nominal occurrence actually fires: somewhere in
+// its window according to the strategy, moved to an allowed moment of the
+// window if it lands in quiet hours, or deferred to the next allowed slot when
+// the whole window is quiet
+func PlanFireTime(s models.Schedule, occurrence time.Time, q QuietHours) time.Time {
+	window := reminderWindow(s)
+	candidate := occurrence
+	if window > 0 {
+		offset, ok := time.Duration(0), false
+		switch s.WindowStrategy {
+		case WindowStart:
+			ok = true
+		case WindowActivity:
+			offset, ok = activityOffset(s, occurrence, window, q.Location)
+		}
+		if !ok {
+			offset = time.Duration(windowRand(s, occurrence).Int63n(int64(window/time.Minute)+1)) * time.Minute
+		}
+		candidate = occurrence.Add(offset)
+	}
+	if q.Allowed(candidate) {
+		return candidate
+	}
+
+	// Prefer the first allowed slot after the candidate, then any earlier one
+	var slots []time.Time
+	for t := occurrence; !t.After(occurrence.Add(window)); t = t.Add(windowSlot) {
+		if q.Allowed(t) {
+			slots = append(slots, t)
+		}
+	}
+	if end := occurrence.Add(window); window > 0 && q.Allowed(end) {
+		slots = append(slots, end)
+	}
+	if len(slots) > 0 {
+		i := sort.Search(len(slots), func(i int) bool { return slots[i].After(candidate) })
+		if i < len(slots) {
+			return slots[i]
+		}
+		return slots[0]
+	}
+	return q.NextAllowed(candidate)
+}
+
+// PlanOccurrence returns the first nominal occurrence of a schedule after the
+// given time together with the moment it should fire. When an occurrence is
+// deferred past the next one, the two are coalesced into the later occurrence
+// so the learner never gets a burst of reminders after quiet hours.
+func PlanOccurrence(s models.Schedule, after time.Time, q QuietHours) (occurrence time.Time, fireAt time.Time, ok bool) {
+	rec, ok, err := ScheduleRecurrence(s)
+	if err != nil {
+		fmt.Printf("Warning: schedule %d has an invalid recurrence: %v\n", s.ID, err)
+		return time.Time{}, time.Time{}, false
+	}
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	if occurrence, ok = rec.Next(after); !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	for i := 0; i < maxCoalescedOccurrences; i++ {
+		fireAt = PlanFireTime(s, occurrence, q)
+		following, more := rec.Next(occurrence)
+		if !more || fireAt.Before(following) {
+			break
+		}
+		occurrence = following
+	}
+	return occurrence, fireAt, true
+}
+
+// ScheduleOccurrence returns the nominal occurrence a schedule is waiting to
+// deliver. Rows created before windows existed only have scheduled_time.
+func ScheduleOccurrence(s models.Schedule) time.Time {
+	if s.OccurrenceTime != nil {
+		return *s.OccurrenceTime
+	}
+	return s.ScheduledTime
+}
+
+// ReplanSchedules recomputes when the pending occurrence of each of the
+// user's active schedules fires, after their quiet hours change
+func ReplanSchedules(userID int) error {
+	var schedules []models.Schedule
+	if err := config.DB.Select(&schedules, "SELECT * FROM schedules WHERE user_id=$1 AND active=true", userID); err != nil {
+		return err
+	}
+	q := UserQuietHours(userID)
+	for _, s := range schedules {
+		fireAt := PlanFireTime(s, ScheduleOccurrence(s), q)
+		_, err := config.DB.Exec(`
+			UPDATE schedules SET scheduled_time=$2, occurrence_time=$3 WHERE id=$1 AND next_attempt_at IS NULL
+		`, s.ID, fireAt, ScheduleOccurrence(s))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	clockPattern = regexp.MustCompile(`(\d{1,2})(?:[:.](\d{2}))?\s*(am|pm|a\.m\.|p\.m\.)?`)
+	// durationPattern matches a length of time such as "2 hours" or "1-2
+	// hrs", which must not be read as clock times
+	durationPattern = regexp.MustCompile(`(\d+(?:\.\d+)?)(?:\s*(?:-|–|to)\s*(\d+(?:\.\d+)?))?\s*(hours?|hrs?|h|minutes?|mins?)\b`)
+	// startPattern matches a single time the window starts at, "after 7pm"
+	startPattern = regexp.MustCompile(`\b(?:after|from)\s+` + clockPattern.String())
+	// Part-of-day words map to conventional windows
+	partOfDayHours = map[string][2]string{
+		"early morning": {"05:00", "08:00"},
+		"morning":       {"07:00", "11:00"},
+		"noon":          {"12:00", "14:00"},
+		"afternoon":     {"13:00", "17:00"},
+		"evening":       {"17:00", "21:00"},
+		"night":         {"20:00", "23:00"},
+		"late night":    {"22:00", "01:00"},
+	}
+)
+
+// defaultPreferredLength is how long a window given only by its start, as in
+// "after 7pm", lasts
+const defaultPreferredLength = 2 * 60
+
+// ParsePreferredHours reads the free-text onboarding answer to "What are your
+// preferred hours and time?", e.g. "6-8 pm", "18:00 to 20:30", "after 7pm"
+// or "evening", into an "HH:MM" window. Durations such as "2-3 hours" are
+// not times of day: they set the length of a window given by its start and
+// are otherwise ignored. ok is false when nothing usable is found.
+func ParsePreferredHours(answer string) (start string, end string, ok bool) {
+	text := strings.ToLower(strings.TrimSpace(answer))
+	length := defaultPreferredLength
+	if m := durationPattern.FindStringSubmatch(text); m != nil {
+		if d := durationMinutes(m); d > 0 && d < 24*60 {
+			length = d
+		}
+	}
+	text = durationPattern.ReplaceAllString(text, " ")
+
+	matches := clockPattern.FindAllStringSubmatch(text, 2)
+	if len(matches) == 2 {
+		h1, m1, s1 := clockParts(matches[0])
+		h2, m2, s2 := clockParts(matches[1])
+		// "6-8 pm": the suffix of the second time applies to both
+		if s1 == "" {
+			s1 = s2
+		}
+		a, okA := to24h(h1, m1, s1)
+		b, okB := to24h(h2, m2, s2)
+		// "9 to 5" without am/pm means the working day, not overnight
+		if s2 == "" && b < a && h2 < 12 {
+			b += 12 * 60
+		}
+		if okA && okB && a != b {
+			return formatClock(a), formatClock(b), true
+		}
+	}
+	if m := startPattern.FindStringSubmatch(text); m != nil {
+		h, min, suffix := clockParts(m)
+		if a, ok := to24h(h, min, suffix); ok {
+			return formatClock(a), formatClock((a + length) % (24 * 60)), true
+		}
+	}
+
+	// Longest phrase first so "late night" wins over "night"
+	var phrases []string
+	for p := range partOfDayHours {
+		phrases = append(phrases, p)
+	}
+	sort.Slice(phrases, func(i, j int) bool { return len(phrases[i]) > len(phrases[j]) })
+	for _, p := range phrases {
+		if strings.Contains(text, p) {
+			w := partOfDayHours[p]
+			return w[0], w[1], true
+		}
+	}
+	return "", "", false
+}
+
+// durationMinutes reads a durationPattern match, taking the longer end of a
+// range such as "1-2 hours"
+func durationMinutes(m []string) int {
+	n, _ := strconv.ParseFloat(m[1], 64)
+	if m[2] != "" {
+		n, _ = strconv.ParseFloat(m[2], 64)
+	}
+	if strings.HasPrefix(m[3], "h") {
+		n *= 60
+	}
+	return int(n)
+}
+
+func clockParts(m []string) (hour int, min int, suffix string) {
+	hour, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		min, _ = strconv.Atoi(m[2])
+	}
+	return hour, min, strings.ReplaceAll(m[3], ".", "")
+}
+
+func to24h(hour int, min int, suffix string) (int, bool) {
+	switch suffix {
+	case "am":
+		if hour < 1 || hour > 12 {
+			return 0, false
+		}
+		hour %= 12
+	case "pm":
+		if hour < 1 || hour > 12 {
+			return 0, false
+		}
+		hour = hour%12 + 12
+	}
+	if hour > 23 || min > 59 {
+		return 0, false
+	}
+	return hour*60 + min, true
+}
+
+func formatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
@@ -0,0 +1,34 @@
+package services
+
+import "testing"
+
+func TestParsePreferredHours(t *testing.T) {
+	tests := []struct {
+		answer     string
+		start, end string
+		ok         bool
+	}{
+		{"6-8 pm", "18:00", "20:00", true},
+		{"18:00 to 20:30", "18:00", "20:30", true},
+		{"9 to 5", "09:00", "17:00", true},
+		{"7am - 9am", "07:00", "09:00", true},
+		{"evening", "17:00", "21:00", true},
+		{"late night", "22:00", "01:00", true},
+		// Durations are not clock times
+		{"2-3 hours in the evening", "17:00", "21:00", true},
+		{"1-2 hours daily", "", "", false},
+		{"30 mins", "", "", false},
+		{"about 2 hrs", "", "", false},
+		// "after X" starts the window; a duration sets its length
+		{"I have 2 hours after 7pm", "19:00", "21:00", true},
+		{"after 7pm", "19:00", "21:00", true},
+		{"from 22:00 for 90 minutes", "22:00", "23:30", true},
+		{"whenever", "", "", false},
+	}
+	for _, tt := range tests {
+		start, end, ok := ParsePreferredHours(tt.answer)
+		if start != tt.start || end != tt.end || ok != tt.ok {
+			t.Errorf("ParsePreferredHours(%q) = %q, %q, %v; want %q, %q, %v", tt.answer, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
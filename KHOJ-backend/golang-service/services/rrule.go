@@ -0,0 +1,738 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence rules follow RFC 5545 section 3.3.10, restricted to what a study
+// reminder needs: FREQ=DAILY, WEEKLY, MONTHLY or YEARLY with INTERVAL, COUNT,
+// UNTIL, BYDAY, BYMONTHDAY, BYMONTH, BYHOUR, BYMINUTE, BYSETPOS and WKST.
+// BYSECOND, BYYEARDAY, BYWEEKNO and sub-daily frequencies are rejected.
+
+// Supported frequencies
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+	FreqYearly  = "YEARLY"
+)
+
+// recurrenceHorizonYears bounds the search for the next occurrence so rules
+// that can never match (e.g. February 30th) terminate
+const recurrenceHorizonYears = 50
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+var rruleWeekdayNames = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// WeekdayNum is a BYDAY entry: a weekday, optionally the Nth (or, when
+// negative, Nth from last) one in the month or year. N is 0 for every one.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+func (w WeekdayNum) String() string {
+	if w.N == 0 {
+		return rruleWeekdayNames[w.Day]
+	}
+	return strconv.Itoa(w.N) + rruleWeekdayNames[w.Day]
+}
+
+// untilKind records how UNTIL was written, which decides how it is resolved
+// against the schedule's time zone
+type untilKind int
+
+const (
+	untilNone     untilKind = iota
+	untilUTC                // 20250101T090000Z: an absolute instant
+	untilFloating           // 20250101T090000: wall clock in the schedule's zone
+	untilDate               // 20250101: through the end of that local day
+)
+
+// RRule is a parsed recurrence rule
+type RRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []int
+	ByHour     []int
+	ByMinute   []int
+	BySetPos   []int
+	WeekStart  time.Weekday
+
+	until     time.Time // Wall clock fields only unless untilKind is untilUTC
+	untilKind untilKind
+	untilRaw  string
+}
+
+// ParseRRule parses an RRULE value, with or without the "RRULE:" prefix.
+// Unsupported or contradictory parts are reported as errors rather than ignored.
+func ParseRRule(s string) (RRule, error) {
+	r := RRule{Interval: 1, WeekStart: time.Monday}
+	s = strings.TrimSpace(s)
+	if len(s) >= 6 && strings.EqualFold(s[:6], "RRULE:") {
+		s = s[6:]
+	}
+	if s == "" {
+		return r, fmt.Errorf("rrule is empty")
+	}
+
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return r, fmt.Errorf("invalid rrule part %q", part)
+		}
+		key, value := strings.ToUpper(strings.TrimSpace(kv[0])), strings.ToUpper(strings.TrimSpace(kv[1]))
+		if seen[key] {
+			return r, fmt.Errorf("%s is given more than once", key)
+		}
+		seen[key] = true
+
+		var err error
+		switch key {
+		case "FREQ":
+			switch value {
+			case FreqDaily, FreqWeekly, FreqMonthly, FreqYearly:
+				r.Freq = value
+			case "SECONDLY", "MINUTELY", "HOURLY":
+				return r, fmt.Errorf("FREQ=%s is not supported for reminders", value)
+			default:
+				return r, fmt.Errorf("invalid FREQ %q", value)
+			}
+		case "INTERVAL":
+			if r.Interval, err = strconv.Atoi(value); err != nil || r.Interval < 1 {
+				return r, fmt.Errorf("INTERVAL must be a positive integer")
+			}
+		case "COUNT":
+			if r.Count, err = strconv.Atoi(value); err != nil || r.Count < 1 {
+				return r, fmt.Errorf("COUNT must be a positive integer")
+			}
+		case "UNTIL":
+			if err := r.parseUntil(value); err != nil {
+				return r, err
+			}
+		case "BYDAY":
+			for _, item := range strings.Split(value, ",") {
+				wd, err := parseWeekdayNum(item)
+				if err != nil {
+					return r, err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			if r.ByMonthDay, err = parseIntList(key, value, -31, 31, false); err != nil {
+				return r, err
+			}
+		case "BYMONTH":
+			if r.ByMonth, err = parseIntList(key, value, 1, 12, true); err != nil {
+				return r, err
+			}
+		case "BYHOUR":
+			if r.ByHour, err = parseIntList(key, value, 0, 23, true); err != nil {
+				return r, err
+			}
+		case "BYMINUTE":
+			if r.ByMinute, err = parseIntList(key, value, 0, 59, true); err != nil {
+				return r, err
+			}
+		case "BYSETPOS":
+			if r.BySetPos, err = parseIntList(key, value, -366, 366, false); err != nil {
+				return r, err
+			}
+		case "WKST":
+			wd, ok := rruleWeekdays[value]
+			if !ok {
+				return r, fmt.Errorf("invalid WKST %q", value)
+			}
+			r.WeekStart = wd
+		case "BYSECOND", "BYYEARDAY", "BYWEEKNO":
+			return r, fmt.Errorf("%s is not supported", key)
+		default:
+			return r, fmt.Errorf("unknown rrule part %s", key)
+		}
+	}
+
+	if r.Freq == "" {
+		return r, fmt.Errorf("FREQ is required")
+	}
+	if r.Count > 0 && r.untilKind != untilNone {
+		return r, fmt.Errorf("COUNT and UNTIL cannot both be set")
+	}
+	for _, wd := range r.ByDay {
+		if wd.N == 0 {
+			continue
+		}
+		switch r.Freq {
+		case FreqMonthly:
+			if wd.N < -5 || wd.N > 5 {
+				return r, fmt.Errorf("BYDAY %s is out of range for a monthly rule", wd)
+			}
+		case FreqYearly:
+			if wd.N < -53 || wd.N > 53 {
+				return r, fmt.Errorf("BYDAY %s is out of range for a yearly rule", wd)
+			}
+		default:
+			return r, fmt.Errorf("numbered BYDAY (%s) is only valid with FREQ=MONTHLY or YEARLY", wd)
+		}
+	}
+	if len(r.ByMonthDay) > 0 && r.Freq == FreqWeekly {
+		return r, fmt.Errorf("BYMONTHDAY cannot be used with FREQ=WEEKLY")
+	}
+	if len(r.BySetPos) > 0 && len(r.ByDay)+len(r.ByMonthDay)+len(r.ByMonth)+len(r.ByHour)+len(r.ByMinute) == 0 {
+		return r, fmt.Errorf("BYSETPOS requires another BYxxx part")
+	}
+	return r, nil
+}
+
+func (r *RRule) parseUntil(value string) error {
+	r.untilRaw = value
+	var err error
+	switch {
+	case len(value) == 8:
+		r.until, err = time.Parse("20060102", value)
+		r.untilKind = untilDate
+	case strings.HasSuffix(value, "Z"):
+		r.until, err = time.Parse("20060102T150405Z", value)
+		r.untilKind = untilUTC
+	default:
+		r.until, err = time.Parse("20060102T150405", value)
+		r.untilKind = untilFloating
+	}
+	if err != nil {
+		return fmt.Errorf("invalid UNTIL %q, use YYYYMMDD or YYYYMMDDTHHMMSS[Z]", value)
+	}
+	return nil
+}
+
+func parseWeekdayNum(s string) (WeekdayNum, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+	}
+	day, ok := rruleWeekdays[s[len(s)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+	}
+	wd := WeekdayNum{Day: day}
+	if prefix := s[:len(s)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 {
+			return WeekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+		}
+		wd.N = n
+	}
+	return wd, nil
+}
+
+func parseIntList(key string, value string, min int, max int, allowZero bool) ([]int, error) {
+	var out []int
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || n < min || n > max || (n == 0 && !allowZero) {
+			return nil, fmt.Errorf("invalid %s value %q", key, item)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// String renders the rule in canonical form, suitable for storage
+func (r RRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	if r.untilKind != untilNone {
+		parts = append(parts, "UNTIL="+r.untilRaw)
+	}
+	joinInts := func(key string, v []int) {
+		if len(v) == 0 {
+			return
+		}
+		s := make([]string, len(v))
+		for i, n := range v {
+			s[i] = strconv.Itoa(n)
+		}
+		parts = append(parts, key+"="+strings.Join(s, ","))
+	}
+	joinInts("BYMONTH", r.ByMonth)
+	joinInts("BYMONTHDAY", r.ByMonthDay)
+	if len(r.ByDay) > 0 {
+		s := make([]string, len(r.ByDay))
+		for i, wd := range r.ByDay {
+			s[i] = wd.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(s, ","))
+	}
+	joinInts("BYHOUR", r.ByHour)
+	joinInts("BYMINUTE", r.ByMinute)
+	joinInts("BYSETPOS", r.BySetPos)
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+rruleWeekdayNames[r.WeekStart])
+	}
+	return strings.Join(parts, ";")
+}
+
+// ExDate is an excluded occurrence: an exact instant, or every occurrence on
+// a local calendar day when AllDay is set
+type ExDate struct {
+	At     time.Time
+	AllDay bool
+}
+
+// ParseExDate accepts RFC 3339, iCalendar (20250101T090000[Z]) or local
+// date-time (2025-01-01T09:00:00) instants, or a date (2025-01-01 or
+// 20250101) to exclude a whole day. Values without an offset are read in loc.
+func ParseExDate(s string, loc *time.Location) (ExDate, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{"2006-01-02", "20060102"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return ExDate{At: t, AllDay: true}, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return ExDate{At: t}, nil
+	}
+	if t, err := time.Parse("20060102T150405Z", s); err == nil {
+		return ExDate{At: t}, nil
+	}
+	for _, layout := range []string{"20060102T150405", "2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return ExDate{At: t}, nil
+		}
+	}
+	return ExDate{}, fmt.Errorf("invalid exdate %q", s)
+}
+
+// String renders an exdate for storage: a date, or an instant in UTC
+func (e ExDate) String() string {
+	if e.AllDay {
+		return e.At.Format("2006-01-02")
+	}
+	return e.At.UTC().Format(time.RFC3339)
+}
+
+// ParseExDates parses a comma-separated list as stored in schedules.exdates
+func ParseExDates(list string, loc *time.Location) ([]ExDate, error) {
+	var out []ExDate
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		e, err := ParseExDate(item, loc)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// FormatExDates joins exdates for storage in schedules.exdates
+func FormatExDates(exdates []ExDate) string {
+	s := make([]string, len(exdates))
+	for i, e := range exdates {
+		s[i] = e.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// Recurrence is a rule anchored at a start time. Start's location is the
+// zone occurrences are computed in, and its time of day is used unless the
+// rule sets BYHOUR/BYMINUTE.
+type Recurrence struct {
+	Rule    RRule
+	Start   time.Time
+	ExDates []ExDate
+}
+
+// Next returns the first occurrence strictly after the given time
+func (rec Recurrence) Next(after time.Time) (time.Time, bool) {
+	next := rec.NextN(after, 1)
+	if len(next) == 0 {
+		return time.Time{}, false
+	}
+	return next[0], true
+}
+
+// NextN returns up to n occurrences strictly after the given time, skipping
+// excluded dates
+func (rec Recurrence) NextN(after time.Time, n int) []time.Time {
+	var out []time.Time
+	if n <= 0 {
+		return out
+	}
+	rec.each(func(t time.Time) bool {
+		if t.After(after) && !rec.excluded(t) {
+			out = append(out, t)
+		}
+		return len(out) < n
+	})
+	return out
+}
+
+func (rec Recurrence) excluded(t time.Time) bool {
+	loc := rec.Start.Location()
+	for _, e := range rec.ExDates {
+		if e.AllDay {
+			if t.In(loc).Format("2006-01-02") == e.At.Format("2006-01-02") {
+				return true
+			}
+		} else if t.Equal(e.At) {
+			return true
+		}
+	}
+	return false
+}
+
+// untilInstant resolves UNTIL against the recurrence's zone
+func (rec Recurrence) untilInstant() (time.Time, bool) {
+	r := rec.Rule
+	loc := rec.Start.Location()
+	switch r.untilKind {
+	case untilUTC:
+		return r.until, true
+	case untilFloating:
+		return LocalTime(r.until.Year(), r.until.Month(), r.until.Day(), r.until.Hour(), r.until.Minute(), r.until.Second(), loc), true
+	case untilDate:
+		return LocalTime(r.until.Year(), r.until.Month(), r.until.Day()+1, 0, 0, 0, loc).Add(-time.Nanosecond), true
+	}
+	return time.Time{}, false
+}
+
+// each calls fn with every occurrence in order, from Start, until fn returns
+// false or the rule ends. COUNT counts occurrences before exdates are removed,
+// as RFC 5545 specifies.
+func (rec Recurrence) each(fn func(time.Time) bool) {
+	r := rec.Rule
+	if r.Interval < 1 {
+		r.Interval = 1
+	}
+	start := rec.Start
+	until, hasUntil := rec.untilInstant()
+	horizon := start.AddDate(recurrenceHorizonYears, 0, 0)
+	count := 0
+
+	for p := 0; ; p++ {
+		periodStart, candidates := rec.expandPeriod(r, p)
+		if periodStart.After(horizon) || (hasUntil && periodStart.After(until)) {
+			return
+		}
+		for _, t := range candidates {
+			if t.Before(start) {
+				continue
+			}
+			if hasUntil && t.After(until) {
+				return
+			}
+			count++
+			if !fn(t) {
+				return
+			}
+			if r.Count > 0 && count >= r.Count {
+				return
+			}
+		}
+	}
+}
+
+// expandPeriod returns the start of the p-th period (day, week, month or
+// year, stepping by INTERVAL) and its occurrences in ascending order
+func (rec Recurrence) expandPeriod(r RRule, p int) (time.Time, []time.Time) {
+	// Dates are computed as UTC calendar days; only withTimes applies the zone
+	start := rec.Start
+	y, m, d := start.Date()
+
+	var periodStart time.Time
+	var dates []time.Time
+	switch r.Freq {
+	case FreqDaily:
+		periodStart = time.Date(y, m, d+p*r.Interval, 0, 0, 0, 0, time.UTC)
+		if rec.matchesFilters(periodStart, true) {
+			dates = append(dates, periodStart)
+		}
+	case FreqWeekly:
+		offset := (int(start.Weekday()) - int(r.WeekStart) + 7) % 7
+		periodStart = time.Date(y, m, d-offset+7*p*r.Interval, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < 7; i++ {
+			day := time.Date(periodStart.Year(), periodStart.Month(), periodStart.Day()+i, 0, 0, 0, 0, time.UTC)
+			if len(r.ByDay) == 0 && day.Weekday() != start.Weekday() {
+				continue
+			}
+			if rec.matchesFilters(day, true) {
+				dates = append(dates, day)
+			}
+		}
+	case FreqMonthly:
+		periodStart = time.Date(y, m+time.Month(p*r.Interval), 1, 0, 0, 0, 0, time.UTC)
+		if len(r.ByMonth) == 0 || containsInt(r.ByMonth, int(periodStart.Month())) {
+			dates = rec.monthDates(r, periodStart.Year(), periodStart.Month())
+		}
+	case FreqYearly:
+		periodStart = time.Date(y+p*r.Interval, 1, 1, 0, 0, 0, 0, time.UTC)
+		year := periodStart.Year()
+		switch {
+		case len(r.ByMonthDay) == 0 && len(r.ByDay) == 0:
+			months := r.ByMonth
+			if len(months) == 0 {
+				months = []int{int(m)}
+			}
+			for _, month := range months {
+				if d <= daysIn(year, time.Month(month)) {
+					dates = append(dates, time.Date(year, time.Month(month), d, 0, 0, 0, 0, time.UTC))
+				}
+			}
+		case len(r.ByMonth) == 0 && len(r.ByMonthDay) == 0:
+			dates = rec.yearWeekdays(r, year)
+		default:
+			months := r.ByMonth
+			if len(months) == 0 {
+				months = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+			}
+			for _, month := range months {
+				dates = append(dates, rec.monthDates(r, year, time.Month(month))...)
+			}
+		}
+	}
+
+	loc := start.Location()
+	periodStart = LocalTime(periodStart.Year(), periodStart.Month(), periodStart.Day(), 0, 0, 0, loc)
+	return periodStart, applySetPos(r.BySetPos, rec.withTimes(r, dates))
+}
+
+// matchesFilters applies the BYxxx parts that limit (rather than expand) a
+// daily or weekly rule
+func (rec Recurrence) matchesFilters(day time.Time, checkDay bool) bool {
+	r := rec.Rule
+	if len(r.ByMonth) > 0 && !containsInt(r.ByMonth, int(day.Month())) {
+		return false
+	}
+	if len(r.ByMonthDay) > 0 {
+		n := daysIn(day.Year(), day.Month())
+		ok := false
+		for _, md := range r.ByMonthDay {
+			if md == day.Day() || (md < 0 && n+1+md == day.Day()) {
+				ok = true
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if checkDay && len(r.ByDay) > 0 {
+		ok := false
+		for _, wd := range r.ByDay {
+			if wd.Day == day.Weekday() {
+				ok = true
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// monthDates expands BYMONTHDAY and BYDAY within one month; when both are set
+// a day must match both
+func (rec Recurrence) monthDates(r RRule, year int, month time.Month) []time.Time {
+	n := daysIn(year, month)
+	if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+		// Months without the start day (e.g. the 31st) are skipped, per RFC 5545
+		if d := rec.Start.Day(); d <= n {
+			return []time.Time{time.Date(year, month, d, 0, 0, 0, 0, time.UTC)}
+		}
+		return nil
+	}
+
+	var byMonthDay, byDay map[int]bool
+	if len(r.ByMonthDay) > 0 {
+		byMonthDay = map[int]bool{}
+		for _, md := range r.ByMonthDay {
+			if md < 0 {
+				md = n + 1 + md
+			}
+			if md >= 1 && md <= n {
+				byMonthDay[md] = true
+			}
+		}
+	}
+	if len(r.ByDay) > 0 {
+		byDay = map[int]bool{}
+		for _, wd := range r.ByDay {
+			var matches []int
+			for day := 1; day <= n; day++ {
+				if time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Weekday() == wd.Day {
+					matches = append(matches, day)
+				}
+			}
+			for _, day := range pickNth(matches, wd.N) {
+				byDay[day] = true
+			}
+		}
+	}
+
+	var out []time.Time
+	for day := 1; day <= n; day++ {
+		if byMonthDay != nil && !byMonthDay[day] {
+			continue
+		}
+		if byDay != nil && !byDay[day] {
+			continue
+		}
+		out = append(out, time.Date(year, month, day, 0, 0, 0, 0, time.UTC))
+	}
+	return out
+}
+
+// yearWeekdays expands BYDAY over a whole year (e.g. 20MO, the 20th Monday)
+func (rec Recurrence) yearWeekdays(r RRule, year int) []time.Time {
+	selected := map[int]bool{}
+	days := time.Date(year, 12, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	for _, wd := range r.ByDay {
+		var matches []int
+		for yd := 1; yd <= days; yd++ {
+			if time.Date(year, 1, yd, 0, 0, 0, 0, time.UTC).Weekday() == wd.Day {
+				matches = append(matches, yd)
+			}
+		}
+		for _, yd := range pickNth(matches, wd.N) {
+			selected[yd] = true
+		}
+	}
+	var out []time.Time
+	for yd := 1; yd <= days; yd++ {
+		if selected[yd] {
+			out = append(out, time.Date(year, 1, yd, 0, 0, 0, 0, time.UTC))
+		}
+	}
+	return out
+}
+
+// withTimes combines dates with BYHOUR/BYMINUTE (or the start's time of day)
+// in the recurrence's zone and returns the occurrences sorted and
+// de-duplicated. Times in a DST gap or overlap are resolved by LocalTime.
+func (rec Recurrence) withTimes(r RRule, dates []time.Time) []time.Time {
+	hours, minutes := r.ByHour, r.ByMinute
+	if len(hours) == 0 {
+		hours = []int{rec.Start.Hour()}
+	}
+	if len(minutes) == 0 {
+		minutes = []int{rec.Start.Minute()}
+	}
+	loc := rec.Start.Location()
+	var out []time.Time
+	for _, d := range dates {
+		for _, h := range hours {
+			for _, min := range minutes {
+				out = append(out, LocalTime(d.Year(), d.Month(), d.Day(), h, min, rec.Start.Second(), loc))
+			}
+		}
+	}
+	return sortUniqueTimes(out)
+}
+
+// applySetPos keeps the BYSETPOS-th occurrences of a period's set
+func applySetPos(positions []int, set []time.Time) []time.Time {
+	if len(positions) == 0 {
+		return set
+	}
+	var out []time.Time
+	for _, pos := range positions {
+		i := pos - 1
+		if pos < 0 {
+			i = len(set) + pos
+		}
+		if i >= 0 && i < len(set) {
+			out = append(out, set[i])
+		}
+	}
+	return sortUniqueTimes(out)
+}
+
+// pickNth returns all matches for n == 0, else the nth (or nth from last)
+func pickNth(matches []int, n int) []int {
+	switch {
+	case n == 0:
+		return matches
+	case n > 0 && n <= len(matches):
+		return []int{matches[n-1]}
+	case n < 0 && -n <= len(matches):
+		return []int{matches[len(matches)+n]}
+	}
+	return nil
+}
+
+func sortUniqueTimes(ts []time.Time) []time.Time {
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Before(ts[j]) })
+	out := ts[:0]
+	for i, t := range ts {
+		if i == 0 || !t.Equal(ts[i-1]) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func containsInt(list []int, v int) bool {
+	for _, n := range list {
+		if n == v {
+			return true
+		}
+	}
+	return false
+}
+
+// LegacyRRule converts the original recurrence_type/days_of_week fields to a
+// rule. "once" (or empty) has no rule.
+func LegacyRRule(recurrenceType string, daysOfWeek string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(recurrenceType)) {
+	case "", "once":
+		return "", nil
+	case "daily":
+		return "FREQ=DAILY", nil
+	case "weekly":
+		var days []string
+		seen := map[int]bool{}
+		for _, part := range strings.Split(daysOfWeek, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			d, err := strconv.Atoi(part)
+			if err != nil || d < 0 || d > 6 {
+				return "", fmt.Errorf("invalid day of week %q, use 0 (Sunday) to 6 (Saturday)", part)
+			}
+			if !seen[d] {
+				seen[d] = true
+				days = append(days, rruleWeekdayNames[d])
+			}
+		}
+		if len(days) == 0 {
+			return "", fmt.Errorf("days_of_week required for weekly reminders")
+		}
+		return "FREQ=WEEKLY;BYDAY=" + strings.Join(days, ","), nil
+	}
+	return "", fmt.Errorf("invalid recurrence_type %q, use 'daily', 'weekly', 'once' or an rrule", recurrenceType)
+}
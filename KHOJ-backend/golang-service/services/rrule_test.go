@@ -0,0 +1,182 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecurrenceNextN(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	const layout = "2006-01-02 15:04 MST"
+	tests := []struct {
+		name    string
+		rule    string
+		start   time.Time
+		exdates string
+		n       int
+		want    []string
+	}{
+		{
+			name:  "BYSETPOS last weekday of the month",
+			rule:  "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1",
+			start: LocalTime(2025, 1, 1, 9, 0, 0, ny),
+			n:     3,
+			want:  []string{"2025-01-31 09:00 EST", "2025-02-28 09:00 EST", "2025-03-31 09:00 EDT"},
+		},
+		{
+			name:  "BYSETPOS first and last day of the week",
+			rule:  "FREQ=WEEKLY;BYDAY=MO,WE,FR;BYSETPOS=1,-1",
+			start: LocalTime(2025, 1, 6, 9, 0, 0, ny),
+			n:     4,
+			want:  []string{"2025-01-06 09:00 EST", "2025-01-10 09:00 EST", "2025-01-13 09:00 EST", "2025-01-17 09:00 EST"},
+		},
+		{
+			name:  "BYDAY second Tuesday",
+			rule:  "FREQ=MONTHLY;BYDAY=2TU",
+			start: LocalTime(2025, 1, 1, 18, 0, 0, ny),
+			n:     3,
+			want:  []string{"2025-01-14 18:00 EST", "2025-02-11 18:00 EST", "2025-03-11 18:00 EDT"},
+		},
+		{
+			name:  "BYDAY last Friday",
+			rule:  "FREQ=MONTHLY;BYDAY=-1FR",
+			start: LocalTime(2025, 1, 1, 18, 0, 0, ny),
+			n:     2,
+			want:  []string{"2025-01-31 18:00 EST", "2025-02-28 18:00 EST"},
+		},
+		{
+			name:  "BYDAY fourth Thursday of November",
+			rule:  "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH",
+			start: LocalTime(2025, 1, 1, 12, 0, 0, ny),
+			n:     2,
+			want:  []string{"2025-11-27 12:00 EST", "2026-11-26 12:00 EST"},
+		},
+		{
+			name:  "COUNT ends the rule",
+			rule:  "FREQ=DAILY;COUNT=3",
+			start: LocalTime(2025, 1, 1, 9, 0, 0, ny),
+			n:     5,
+			want:  []string{"2025-01-01 09:00 EST", "2025-01-02 09:00 EST", "2025-01-03 09:00 EST"},
+		},
+		{
+			name:  "UNTIL date includes the whole day",
+			rule:  "FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20250115",
+			start: LocalTime(2025, 1, 1, 20, 0, 0, ny),
+			n:     10,
+			want: []string{"2025-01-01 20:00 EST", "2025-01-06 20:00 EST", "2025-01-08 20:00 EST",
+				"2025-01-13 20:00 EST", "2025-01-15 20:00 EST"},
+		},
+		{
+			name:  "UNTIL UTC instant is inclusive",
+			rule:  "FREQ=DAILY;UNTIL=20250103T140000Z",
+			start: LocalTime(2025, 1, 1, 9, 0, 0, ny),
+			n:     10,
+			want:  []string{"2025-01-01 09:00 EST", "2025-01-02 09:00 EST", "2025-01-03 09:00 EST"},
+		},
+		{
+			name:    "EXDATE removes occurrences counted by COUNT",
+			rule:    "FREQ=DAILY;COUNT=4",
+			start:   LocalTime(2025, 1, 1, 9, 0, 0, ny),
+			exdates: "2025-01-02,2025-01-03T09:00:00",
+			n:       10,
+			want:    []string{"2025-01-01 09:00 EST", "2025-01-04 09:00 EST"},
+		},
+		{
+			name:    "EXDATE instant at another time excludes nothing",
+			rule:    "FREQ=DAILY;COUNT=2",
+			start:   LocalTime(2025, 1, 1, 9, 0, 0, ny),
+			exdates: "2025-01-02T10:00:00",
+			n:       10,
+			want:    []string{"2025-01-01 09:00 EST", "2025-01-02 09:00 EST"},
+		},
+		{
+			name:  "DST spring forward keeps the wall time",
+			rule:  "FREQ=DAILY",
+			start: LocalTime(2025, 3, 8, 9, 0, 0, ny),
+			n:     3,
+			want:  []string{"2025-03-08 09:00 EST", "2025-03-09 09:00 EDT", "2025-03-10 09:00 EDT"},
+		},
+		{
+			name:  "DST fall back keeps the wall time",
+			rule:  "FREQ=WEEKLY",
+			start: LocalTime(2025, 10, 26, 9, 0, 0, ny),
+			n:     2,
+			want:  []string{"2025-10-26 09:00 EDT", "2025-11-02 09:00 EST"},
+		},
+		{
+			name:  "DST gap moves past the missing hour",
+			rule:  "FREQ=DAILY",
+			start: LocalTime(2025, 3, 8, 2, 30, 0, ny),
+			n:     3,
+			want:  []string{"2025-03-08 02:30 EST", "2025-03-09 03:30 EDT", "2025-03-10 02:30 EDT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRRule(tt.rule)
+			if err != nil {
+				t.Fatalf("ParseRRule(%q): %v", tt.rule, err)
+			}
+			exdates, err := ParseExDates(tt.exdates, ny)
+			if err != nil {
+				t.Fatalf("ParseExDates(%q): %v", tt.exdates, err)
+			}
+			rec := Recurrence{Rule: rule, Start: tt.start, ExDates: exdates}
+			got := rec.NextN(tt.start.Add(-time.Nanosecond), tt.n)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d occurrences %v, want %v", len(got), got, tt.want)
+			}
+			for i, occ := range got {
+				if s := occ.In(ny).Format(layout); s != tt.want[i] {
+					t.Errorf("occurrence %d = %s, want %s", i, s, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLocalTime(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	tests := []struct {
+		name string
+		got  time.Time
+		want string
+	}{
+		{"standard time", LocalTime(2025, 1, 15, 9, 0, 0, ny), "2025-01-15T14:00:00Z"},
+		{"daylight time", LocalTime(2025, 7, 15, 9, 0, 0, ny), "2025-07-15T13:00:00Z"},
+		// 02:30 does not exist on 2025-03-09; the pre-transition offset is used
+		{"spring forward gap", LocalTime(2025, 3, 9, 2, 30, 0, ny), "2025-03-09T07:30:00Z"},
+		// 01:30 happens twice on 2025-11-02; the first one is used
+		{"fall back overlap", LocalTime(2025, 11, 2, 1, 30, 0, ny), "2025-11-02T05:30:00Z"},
+		{"day overflow normalises", LocalTime(2025, 1, 32, 9, 0, 0, ny), "2025-02-01T14:00:00Z"},
+	}
+	for _, tt := range tests {
+		if s := tt.got.UTC().Format(time.RFC3339); s != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, s, tt.want)
+		}
+	}
+}
+
+func TestParseRRuleRejects(t *testing.T) {
+	for _, rule := range []string{
+		"",
+		"BYDAY=MO",
+		"FREQ=DAILY;COUNT=0",
+		"FREQ=DAILY;COUNT=3;UNTIL=20250101",
+		"FREQ=WEEKLY;BYDAY=2MO",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=MONTHLY;BYDAY=0MO",
+		"FREQ=MONTHLY;BYSETPOS=1",
+		"FREQ=DAILY;FREQ=WEEKLY",
+	} {
+		if _, err := ParseRRule(rule); err == nil {
+			t.Errorf("ParseRRule(%q) succeeded, want an error", rule)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Reminder delivery modes, selected with REMINDER_MODE
+const (
+	// ReminderModeInternal posts the reminder into the schedule's chat (default)
+	ReminderModeInternal = "internal"
+	// ReminderModeWebhook POSTs each occurrence to REMINDER_WEBHOOK_URL
+	ReminderModeWebhook = "webhook"
+	// ReminderModeExternal disables the worker; an outside poller such as n8n
+	// calls GET /api/schedule/due and POST /api/quiz/reminder instead
+	ReminderModeExternal = "external"
+)
+
+const (
+	reminderMaxAttempts  = 5
+	reminderBaseBackoff  = 30 * time.Second
+	reminderMaxBackoff   = 30 * time.Minute
+	reminderBatchSize    = 50
+	reminderPollInterval = 30 * time.Second
+	reminderWebhookWait  = 10 * time.Second
+)
+
+// ErrScheduleNotDue is returned when a schedule has no occurrence waiting to
+// be delivered (already delivered, inactive, locked by another worker or not
+// yet due)
+var ErrScheduleNotDue = errors.New("schedule is not due")
+
+// ReminderMode returns the configured delivery mode
+func ReminderMode() string {
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("REMINDER_MODE"))); mode {
+	case ReminderModeWebhook, ReminderModeExternal:
+		return mode
+	}
+	return ReminderModeInternal
+}
+
+// ReminderMessage is the chat text of a quiz reminder
+func ReminderMessage(topic string) string {
+	return fmt.Sprintf("📅 Time for your quiz! Take quiz on '%s' for today. Would you like to:\n1. Take quiz here (type 'quiz here')\n2. Go to dashboard (type 'dashboard')", topic)
+}
+
+// StartReminderScheduler runs the reminder worker until ctx is cancelled. In
+// external mode it only redelivers snoozed reminders. The poll interval can be
+// overridden with REMINDER_POLL_SECONDS.
+func StartReminderScheduler(ctx context.Context) {
+	// Finishing a quiz completes the reminders that asked for it
+	Subscribe(EventQuizCompleted, completeRemindersForQuiz)
+
+	mode := ReminderMode()
+	if mode == ReminderModeExternal {
+		// Snoozes are ours to redeliver even when n8n polls for schedules
+		fmt.Println("⏰ Reminder worker disabled (REMINDER_MODE=external); only snoozed reminders are redelivered")
+	}
+	if mode == ReminderModeWebhook && os.Getenv("REMINDER_WEBHOOK_URL") == "" {
+		fmt.Println("Warning: REMINDER_MODE=webhook but REMINDER_WEBHOOK_URL is not set; deliveries will fail and be retried")
+	}
+
+	interval := reminderPollInterval
+	if secs, err := strconv.Atoi(os.Getenv("REMINDER_POLL_SECONDS")); err == nil && secs > 0 {
+		interval = time.Duration(secs) * time.Second
+	}
+	fmt.Printf("⏰ Reminder worker started (mode=%s, every %s)\n", mode, interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if mode != ReminderModeExternal {
+				if n, err := RunDueReminders(ctx, mode); err != nil {
+					fmt.Printf("Warning: reminder worker: %v\n", err)
+				} else if n > 0 {
+					fmt.Printf("⏰ Processed %d reminder occurrence(s)\n", n)
+				}
+			}
+			if n, err := RunDueSnoozes(ctx); err != nil {
+				fmt.Printf("Warning: snoozed reminders: %v\n", err)
+			} else if n > 0 {
+				fmt.Printf("⏰ Redelivered %d snoozed reminder(s)\n", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunDueReminders processes due occurrences one at a time until none are
+// left or a batch is done, and returns how many it handled
+func RunDueReminders(ctx context.Context, mode string) (int, error) {
+	n := 0
+	for n < reminderBatchSize {
+		if ctx.Err() != nil {
+			return n, nil
+		}
+		handled, err := processDueSchedule(ctx, mode, 0)
+		if errors.Is(err, ErrScheduleNotDue) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if handled {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// FireSchedule delivers the due occurrence of one schedule. It backs the
+// external (n8n) path, so calling it twice for the same occurrence sends once.
+func FireSchedule(ctx context.Context, scheduleID int, mode string) error {
+	_, err := processDueSchedule(ctx, mode, scheduleID)
+	return err
+}
+
+// processDueSchedule claims the oldest due schedule (or the given one) with
+// FOR UPDATE SKIP LOCKED, so concurrent workers never pick the same row,
+// delivers it and records the outcome in the same transaction
+func processDueSchedule(ctx context.Context, mode string, scheduleID int) (bool, error) {
+	now := time.Now()
+	tx, err := config.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var s models.Schedule
+	query := `
+		SELECT * FROM schedules
+		WHERE active=true AND COALESCE(next_attempt_at, scheduled_time) <= $1
+		ORDER BY COALESCE(next_attempt_at, scheduled_time) ASC
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`
+	args := []interface{}{now}
+	if scheduleID != 0 {
+		query = `
+			SELECT * FROM schedules
+			WHERE id=$2 AND active=true AND COALESCE(next_attempt_at, scheduled_time) <= $1
+			FOR UPDATE SKIP LOCKED`
+		args = append(args, scheduleID)
+	}
+	if err := tx.Get(&s, query, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return false, ErrScheduleNotDue
+		}
+		return false, err
+	}
+
+	occurrence := ScheduleOccurrence(s)
+	attempt := s.Attempts + 1
+
+	// A savepoint lets a failed delivery roll back its own writes while the
+	// failure is still recorded in this transaction
+	if _, err := tx.Exec("SAVEPOINT deliver"); err != nil {
+		return false, err
+	}
+	messageID, deliverErr := deliverReminder(ctx, tx, mode, s)
+	if deliverErr != nil {
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT deliver"); err != nil {
+			return false, err
+		}
+	}
+
+	status, errText := "sent", ""
+	if deliverErr != nil {
+		status, errText = "failed", deliverErr.Error()
+	}
+	_, err = tx.Exec(`
+		INSERT INTO reminder_deliveries (schedule_id, user_id, occurrence_time, attempt, mode, status, error, message_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, s.ID, s.UserID, occurrence, attempt, mode, status, errText, messageID, now)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case deliverErr == nil:
+		if err = recordReminderInstance(tx, s, occurrence, messageID); err != nil {
+			return false, err
+		}
+		err = advanceSchedule(tx, s, now, "")
+	case attempt >= reminderMaxAttempts:
+		// Give up on this occurrence but keep recurring schedules alive
+		fmt.Printf("Warning: reminder %d gave up after %d attempts: %v\n", s.ID, attempt, deliverErr)
+		err = advanceSchedule(tx, s, now, errText)
+	default:
+		_, err = tx.Exec(`
+			UPDATE schedules SET attempts=$2, next_attempt_at=$3, last_error=$4 WHERE id=$1
+		`, s.ID, attempt, now.Add(reminderBackoff(attempt)), errText)
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	// The chat message went out with the transaction; the user's other
+	// channels (email, push, webhook) follow once it is committed
+	if deliverErr == nil && mode != ReminderModeWebhook {
+		NotifyAsync(Notification{
+			UserID:    s.UserID,
+			ChatID:    s.ChatID,
+			Kind:      NotifyQuizReminder,
+			Data:      map[string]interface{}{"topic": s.Topic, "schedule_id": s.ID},
+			DedupeKey: fmt.Sprintf("schedule-%d-%d", s.ID, occurrence.Unix()),
+			Skip:      []string{ChannelInApp},
+		})
+	}
+	if deliverErr != nil && scheduleID != 0 {
+		return true, deliverErr
+	}
+	return true, nil
+}
+
+// reminderBackoff doubles the wait after every failed attempt
+func reminderBackoff(attempt int) time.Duration {
+	d := reminderBaseBackoff << (attempt - 1)
+	if d <= 0 || d > reminderMaxBackoff {
+		return reminderMaxBackoff
+	}
+	return d
+}
+
+// advanceSchedule moves a recurring schedule to its next occurrence after now
+// (missed occurrences are skipped rather than sent in a burst), planned within
+// the reminder window and outside quiet hours, and deactivates one-time schedules
+func advanceSchedule(tx *sqlx.Tx, s models.Schedule, now time.Time, lastError string) error {
+	next, fireAt, ok := PlanOccurrence(s, now, UserQuietHours(s.UserID))
+	if !ok {
+		_, err := tx.Exec(`
+			UPDATE schedules SET active=false, attempts=0, next_attempt_at=NULL, last_fired_at=$2, last_error=$3 WHERE id=$1
+		`, s.ID, now, lastError)
+		return err
+	}
+	_, err := tx.Exec(`
+		UPDATE schedules SET scheduled_time=$2, occurrence_time=$3, attempts=0, next_attempt_at=NULL, last_fired_at=$4, last_error=$5 WHERE id=$1
+	`, s.ID, fireAt, next, now, lastError)
+	return err
+}
+
+// ScheduleRecurrence builds a schedule's recurrence in its owner's time zone,
+// so a 09:00 reminder stays at 09:00 local time across DST changes.
+// Schedules without an rrule use their legacy recurrence_type/days_of_week;
+// one-time schedules return ok=false.
+func ScheduleRecurrence(s models.Schedule) (Recurrence, bool, error) {
+	rule := s.RRule
+	if rule == "" {
+		var err error
+		if rule, err = LegacyRRule(s.RecurrenceType, s.DaysOfWeek); err != nil || rule == "" {
+			return Recurrence{}, false, err
+		}
+	}
+	r, err := ParseRRule(rule)
+	if err != nil {
+		return Recurrence{}, false, err
+	}
+	loc := UserLocation(s.UserID)
+	exdates, err := ParseExDates(s.ExDates, loc)
+	if err != nil {
+		return Recurrence{}, false, err
+	}
+	start := ScheduleOccurrence(s)
+	if s.DTStart != nil {
+		start = *s.DTStart
+	}
+	return Recurrence{Rule: r, Start: start.In(loc), ExDates: exdates}, true, nil
+}
+
+// NextOccurrence returns the first occurrence of a recurring schedule strictly
+// after the given time. One-time schedules, finished rules (COUNT/UNTIL) and
+// invalid rules have no next occurrence.
+func NextOccurrence(s models.Schedule, after time.Time) (time.Time, bool) {
+	rec, ok, err := ScheduleRecurrence(s)
+	if err != nil {
+		fmt.Printf("Warning: schedule %d has an invalid recurrence: %v\n", s.ID, err)
+		return time.Time{}, false
+	}
+	if !ok {
+		return time.Time{}, false
+	}
+	return rec.Next(after)
+}
+
+// RezoneSchedules keeps a user's recurring reminders at the same local time of
+// day after their time zone changes from one zone to another: each dtstart is
+// re-anchored to the same wall clock in the new zone and the pending
+// occurrence is recomputed. One-time reminders are fixed instants and stay put.
+func RezoneSchedules(userID int, from, to *time.Location) error {
+	if from.String() == to.String() {
+		return nil
+	}
+	var schedules []models.Schedule
+	err := config.DB.Select(&schedules, `
+		SELECT * FROM schedules WHERE user_id=$1 AND active=true AND recurrence_type <> 'once'
+	`, userID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	q := UserQuietHours(userID)
+	for _, s := range schedules {
+		start := ScheduleOccurrence(s)
+		if s.DTStart != nil {
+			start = *s.DTStart
+		}
+		w := start.In(from)
+		dtstart := LocalTime(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), w.Second(), to)
+		s.DTStart = &dtstart
+		next, fireAt, ok := PlanOccurrence(s, now, q)
+		if !ok {
+			continue
+		}
+		_, err := config.DB.Exec(`
+			UPDATE schedules SET dtstart=$2, scheduled_time=$3, occurrence_time=$4, attempts=0, next_attempt_at=NULL WHERE id=$1
+		`, s.ID, dtstart, fireAt, next)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliverReminder sends one occurrence and returns the chat message id for
+// internal deliveries
+func deliverReminder(ctx context.Context, tx *sqlx.Tx, mode string, s models.Schedule) (string, error) {
+	if mode == ReminderModeWebhook {
+		return "", postReminderWebhook(ctx, s)
+	}
+
+	msg, err := AppendMessage(ctx, tx, s.ChatID, "bot", scheduleReminderMessage(tx, s), "")
+	if err != nil {
+		return "", fmt.Errorf("failed to post reminder: %w", err)
+	}
+	return msg.ID, nil
+}
+
+// postReminderWebhook sends the occurrence to REMINDER_WEBHOOK_URL. The
+// Idempotency-Key header lets the receiver drop duplicates if our commit
+// fails after a successful POST.
+func postReminderWebhook(ctx context.Context, s models.Schedule) error {
+	url := os.Getenv("REMINDER_WEBHOOK_URL")
+	if url == "" {
+		return errors.New("REMINDER_WEBHOOK_URL is not set")
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"schedule_id":     s.ID,
+		"user_id":         s.UserID,
+		"chat_id":         s.ChatID,
+		"topic":           s.Topic,
+		"recurrence_type": s.RecurrenceType,
+		"occurrence_time": ScheduleOccurrence(s).UTC().Format(time.RFC3339),
+		"message":         ReminderMessage(s.Topic),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, reminderWebhookWait)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", fmt.Sprintf("schedule-%d-%d", s.ID, ScheduleOccurrence(s).Unix()))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+)
+
+// ScheduleRequest describes when a reminder fires. Either rrule (RFC 5545)
+// or the original recurrence_type fields can be used.
+type ScheduleRequest struct {
+	ScheduledTime   string   `json:"scheduled_time,omitempty"`    // ISO 8601 format for one-time reminders
+	RecurrenceType  string   `json:"recurrence_type,omitempty"`   // "daily", "weekly", "once"; ignored when rrule is set
+	ReminderTime    string   `json:"reminder_time,omitempty"`     // Time of day "HH:MM" for daily/weekly
+	ReminderTimeEnd string   `json:"reminder_time_end,omitempty"` // Optional end time for ranges
+	DaysOfWeek      string   `json:"days_of_week,omitempty"`      // Comma-separated: "1,3,5" for Mon,Wed,Fri
+	RRule           string   `json:"rrule,omitempty"`             // e.g. "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR" or "FREQ=MONTHLY;BYDAY=2TU;COUNT=6"
+	DTStart         string   `json:"dtstart,omitempty"`           // First possible occurrence; defaults to the first one at or after now
+	ExDates         []string `json:"exdates,omitempty"`           // Occurrences (RFC 3339) or whole dates (YYYY-MM-DD) to skip
+	WindowStrategy  string   `json:"window_strategy,omitempty"`   // "random" (default), "activity" or "start" within reminder_time..reminder_time_end
+}
+
+// ResolvedSchedule is a validated ScheduleRequest
+type ResolvedSchedule struct {
+	RecurrenceType string
+	WindowStrategy string
+	RRule          string // Canonical rule, empty for one-time reminders
+	DTStart        *time.Time
+	ExDates        []ExDate
+	Recurrence     Recurrence
+	Next           time.Time
+}
+
+// ResolveScheduleRequest validates the request and finds its first occurrence
+// after now. Times without an offset are read in loc, the user's zone.
+func ResolveScheduleRequest(req ScheduleRequest, now time.Time, loc *time.Location) (ResolvedSchedule, error) {
+	var out ResolvedSchedule
+	rule := strings.TrimSpace(req.RRule)
+	out.RecurrenceType = strings.ToLower(strings.TrimSpace(req.RecurrenceType))
+
+	out.WindowStrategy = strings.ToLower(strings.TrimSpace(req.WindowStrategy))
+	if out.WindowStrategy == "" {
+		out.WindowStrategy = WindowRandom
+	}
+	if !ValidWindowStrategy(out.WindowStrategy) {
+		return out, fmt.Errorf("window_strategy must be random, activity or start")
+	}
+	if req.ReminderTimeEnd != "" {
+		if _, err := ParseClock(req.ReminderTimeEnd); err != nil {
+			return out, fmt.Errorf("Invalid reminder_time_end format. Use HH:MM (e.g., 15:30)")
+		}
+	}
+
+	if rule == "" && (out.RecurrenceType == "once" || out.RecurrenceType == "") {
+		if req.ScheduledTime == "" {
+			return out, fmt.Errorf("scheduled_time is required for one-time reminders (or set recurrence_type/rrule)")
+		}
+		scheduledTime, err := time.Parse(time.RFC3339, req.ScheduledTime)
+		if err != nil {
+			// Try simpler format, as wall clock time in the user's zone
+			local, perr := time.ParseInLocation("2006-01-02T15:04:05", req.ScheduledTime, time.UTC)
+			if perr != nil {
+				return out, fmt.Errorf("Invalid time format. Use ISO 8601 (e.g., 2025-10-31T14:30:00Z)")
+			}
+			scheduledTime = LocalTime(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), loc)
+		}
+		if scheduledTime.Before(now) {
+			return out, fmt.Errorf("Scheduled time must be in the future")
+		}
+		out.RecurrenceType = "once"
+		out.Next = scheduledTime.In(loc)
+		return out, nil
+	}
+
+	if rule == "" {
+		var err error
+		if rule, err = LegacyRRule(out.RecurrenceType, req.DaysOfWeek); err != nil {
+			return out, err
+		}
+		if req.ReminderTime == "" {
+			return out, fmt.Errorf("reminder_time is required for %s reminders", out.RecurrenceType)
+		}
+	} else {
+		out.RecurrenceType = "rrule"
+	}
+	parsed, err := ParseRRule(rule)
+	if err != nil {
+		return out, fmt.Errorf("Invalid rrule: %v", err)
+	}
+	out.RRule = parsed.String()
+
+	// The anchor defaults to the first occurrence at or after now, counting
+	// from today at reminder_time (or now) in the user's zone, so an instant
+	// already past doesn't use up a COUNT slot
+	dtstart := now.In(loc).Truncate(time.Minute)
+	if req.DTStart != "" {
+		if dtstart, err = time.Parse(time.RFC3339, req.DTStart); err != nil {
+			var local time.Time
+			if local, err = time.Parse("2006-01-02T15:04:05", req.DTStart); err != nil {
+				if local, err = time.Parse("2006-01-02T15:04", req.DTStart); err != nil {
+					return out, fmt.Errorf("Invalid dtstart. Use ISO 8601 (e.g., 2025-10-31T14:30:00+05:30)")
+				}
+			}
+			dtstart = LocalTime(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), loc)
+		}
+	} else if req.ReminderTime != "" {
+		t, err := time.Parse("15:04", req.ReminderTime)
+		if err != nil {
+			return out, fmt.Errorf("Invalid reminder time format. Use HH:MM (e.g., 14:30)")
+		}
+		dtstart = LocalTime(dtstart.Year(), dtstart.Month(), dtstart.Day(), t.Hour(), t.Minute(), 0, loc)
+	}
+	if req.DTStart == "" {
+		uncounted := parsed
+		uncounted.Count = 0
+		probe := Recurrence{Rule: uncounted, Start: dtstart.In(loc)}
+		if first, ok := probe.Next(now.Add(-time.Nanosecond)); ok {
+			dtstart = first
+		}
+	}
+	dtstart = dtstart.In(loc)
+	out.DTStart = &dtstart
+
+	for _, raw := range req.ExDates {
+		e, err := ParseExDate(raw, loc)
+		if err != nil {
+			return out, err
+		}
+		out.ExDates = append(out.ExDates, e)
+	}
+
+	out.Recurrence = Recurrence{Rule: parsed, Start: dtstart.In(loc), ExDates: out.ExDates}
+	next, ok := out.Recurrence.Next(now.Add(-time.Nanosecond))
+	if !ok {
+		return out, fmt.Errorf("The recurrence has no future occurrences")
+	}
+	out.Next = next
+	return out, nil
+}
+
+// InvalidScheduleError is a schedule request that doesn't validate; its
+// message is meant for the learner
+type InvalidScheduleError struct {
+	Err error
+}
+
+func (e InvalidScheduleError) Error() string { return e.Err.Error() }
+
+func (e InvalidScheduleError) Unwrap() error { return e.Err }
+
+// CreatedSchedule is a new schedule with its first occurrence and the moment
+// its reminder is planned to fire
+type CreatedSchedule struct {
+	Schedule models.Schedule
+	Resolved ResolvedSchedule
+	FireAt   time.Time
+	Location *time.Location
+}
+
+// CreateSchedule adds a quiz reminder schedule to one of the user's chats,
+// on the chat's topic. Daily and weekly reminders without a time default to
+// the hours the learner gave during onboarding. It returns sql.ErrNoRows
+// when the chat isn't the user's and InvalidScheduleError for a request that
+// doesn't validate.
+func CreateSchedule(userID int, chatID string, req ScheduleRequest, now time.Time) (CreatedSchedule, error) {
+	var out CreatedSchedule
+	var chat models.Chat
+	if err := config.DB.Get(&chat, "SELECT * FROM chats WHERE id=$1 AND user_id=$2", chatID, userID); err != nil {
+		return out, err
+	}
+
+	if req.RRule == "" && req.ReminderTime == "" && req.ReminderTimeEnd == "" &&
+		(strings.EqualFold(req.RecurrenceType, "daily") || strings.EqualFold(req.RecurrenceType, "weekly")) {
+		var prefs models.ReminderPreferences
+		err := config.DB.Get(&prefs, "SELECT quiet_hours_start, quiet_hours_end, dnd_days, preferred_hours_start, preferred_hours_end FROM users WHERE id=$1", userID)
+		if err == nil && prefs.PreferredHoursStart != "" {
+			req.ReminderTime, req.ReminderTimeEnd = prefs.PreferredHoursStart, prefs.PreferredHoursEnd
+		}
+	}
+
+	out.Location = UserLocation(userID)
+	resolved, err := ResolveScheduleRequest(req, now, out.Location)
+	if err != nil {
+		return out, InvalidScheduleError{err}
+	}
+	out.Resolved = resolved
+	reminderTime := req.ReminderTime
+	if reminderTime == "" && resolved.DTStart != nil {
+		reminderTime = resolved.DTStart.Format("15:04")
+	}
+
+	schedule := models.Schedule{
+		UserID:          userID,
+		ChatID:          chatID,
+		Topic:           chat.Topic,
+		ReminderTime:    reminderTime,
+		ReminderTimeEnd: req.ReminderTimeEnd,
+		WindowStrategy:  resolved.WindowStrategy,
+	}
+	out.FireAt = PlanFireTime(schedule, resolved.Next, UserQuietHours(userID))
+
+	err = config.DB.Get(&out.Schedule, `
+		INSERT INTO schedules (user_id, chat_id, topic, scheduled_time, active, created_at, recurrence_type, reminder_time, reminder_time_end, days_of_week, rrule, exdates, dtstart, window_strategy, occurrence_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING *
+	`, userID, chatID, chat.Topic, out.FireAt, true, now, resolved.RecurrenceType, reminderTime, req.ReminderTimeEnd, req.DaysOfWeek,
+		resolved.RRule, FormatExDates(resolved.ExDates), resolved.DTStart, resolved.WindowStrategy, resolved.Next)
+	if err != nil {
+		return out, fmt.Errorf("failed to create schedule: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"golang-service/config"
+
+	"github.com/lib/pq"
+)
+
+// Search result types
+const (
+	SearchMessage      = "message"
+	SearchQuizQuestion = "quiz_question"
+)
+
+// Search modes
+const (
+	SearchModeText     = "text"     // Postgres full-text search
+	SearchModeSemantic = "semantic" // Embedding similarity
+)
+
+const (
+	// MaxSearchResults caps a page of search results
+	MaxSearchResults = 50
+
+	// semanticMinSimilarity is the cosine similarity a semantic hit needs
+	semanticMinSimilarity = 0.55
+	// semanticCandidates caps how many of the learner's most recent items a
+	// semantic search compares
+	semanticCandidates = 5000
+	// searchIndexMinLength skips texts too short to be worth embedding ("ok")
+	searchIndexMinLength = 12
+	// searchIndexBatch is how many items one indexer pass embeds
+	searchIndexBatch = 100
+	// searchTopUpBatch is how many of a learner's items a semantic search
+	// embeds first, so recent messages are found before the indexer runs
+	searchTopUpBatch    = 20
+	searchIndexInterval = 10 * time.Minute
+	searchSnippetLength = 200
+)
+
+// searchItemsSQL lists what a learner can search ($1 is the user id): their
+// messages and the questions of their quizzes, in one shape
+const searchItemsSQL = `
+	SELECT 'message' AS type, m.id, m.chat_id, NULL::int AS quiz_id, c.topic, c.title AS chat_title, m.role, m.content AS body, m.created_at
+	FROM messages m JOIN chats c ON c.id = m.chat_id
+	WHERE c.user_id=$1
+	UNION ALL
+	SELECT 'quiz_question', qq.id::text, qz.chat_id, qz.id, qz.topic, c.title, '', qq.question, qz.created_at
+	FROM quiz_questions qq JOIN quizzes qz ON qz.id = qq.quiz_id JOIN chats c ON c.id = qz.chat_id
+	WHERE qz.user_id=$1`
+
+// SearchQuery is a search over one learner's messages and quiz questions
+type SearchQuery struct {
+	UserID int
+	Text   string
+	Mode   string
+	Types  []string   // SearchMessage and/or SearchQuizQuestion; empty for both
+	Topic  string     // Exact topic, ignoring case
+	From   *time.Time // Inclusive
+	To     *time.Time // Exclusive
+	Limit  int
+	Offset int
+}
+
+// SearchResult is one hit. Snippet marks matched words with <mark> in text
+// mode; in semantic mode it is the start of the text.
+type SearchResult struct {
+	Type      string    `db:"type" json:"type"`
+	ID        string    `db:"id" json:"id"`
+	ChatID    string    `db:"chat_id" json:"chat_id"`
+	QuizID    *int      `db:"quiz_id" json:"quiz_id,omitempty"`
+	Topic     string    `db:"topic" json:"topic"`
+	ChatTitle string    `db:"chat_title" json:"chat_title"`
+	Role      string    `db:"role" json:"role,omitempty"`
+	Snippet   string    `db:"snippet" json:"snippet"`
+	Score     float64   `db:"score" json:"score"` // Text rank or cosine similarity
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// SearchPage is a page of results with the total number of hits
+type SearchPage struct {
+	Mode    string         `json:"mode"`
+	Total   int            `json:"total"`
+	Results []SearchResult `json:"results"`
+	// Truncated is set when a semantic search compared only the most recent
+	// semanticCandidates items; older ones need a narrower from/to or topic
+	Truncated bool `json:"truncated"`
+}
+
+func (q SearchQuery) types() []string {
+	if len(q.Types) == 0 {
+		return []string{SearchMessage, SearchQuizQuestion}
+	}
+	return q.Types
+}
+
+// SearchText ranks the learner's items by full-text match and highlights the
+// matched words
+func SearchText(q SearchQuery) (SearchPage, error) {
+	page := SearchPage{Mode: SearchModeText, Results: []SearchResult{}}
+	var rows []struct {
+		SearchResult
+		Total int `db:"total"`
+	}
+	err := config.DB.Select(&rows, `
+		WITH query AS (
+			SELECT websearch_to_tsquery('english', $2) AS tsq
+		), hits AS (
+			SELECT items.*, ts_rank_cd(to_tsvector('english', items.body), query.tsq) AS score
+			FROM (`+searchItemsSQL+`) items, query
+			WHERE to_tsvector('english', items.body) @@ query.tsq
+			AND items.type = ANY($3)
+			AND ($4 = '' OR LOWER(items.topic) = LOWER($4))
+			AND ($5::timestamptz IS NULL OR items.created_at >= $5)
+			AND ($6::timestamptz IS NULL OR items.created_at < $6)
+		), page AS (
+			SELECT hits.*, COUNT(*) OVER () AS total FROM hits
+			ORDER BY score DESC, created_at DESC, id
+			LIMIT $7 OFFSET $8
+		)
+		SELECT page.type, page.id, page.chat_id, page.quiz_id, page.topic, page.chat_title, page.role, page.score, page.created_at, page.total,
+			ts_headline('english', page.body, query.tsq,
+				'StartSel=<mark>, StopSel=</mark>, MinWords=15, MaxWords=35, MaxFragments=2, FragmentDelimiter=" … "') AS snippet
+		FROM page, query
+		ORDER BY page.score DESC, page.created_at DESC, page.id
+	`, q.UserID, q.Text, pq.Array(q.types()), q.Topic, q.From, q.To, q.Limit, q.Offset)
+	if err != nil {
+		return page, err
+	}
+	for _, r := range rows {
+		page.Total = r.Total
+		page.Results = append(page.Results, r.SearchResult)
+	}
+	if len(rows) == 0 && q.Offset > 0 {
+		// Past the last page; count the hits so the caller can tell
+		err = config.DB.Get(&page.Total, `
+			SELECT COUNT(*) FROM (`+searchItemsSQL+`) items
+			WHERE to_tsvector('english', items.body) @@ websearch_to_tsquery('english', $2)
+			AND items.type = ANY($3)
+			AND ($4 = '' OR LOWER(items.topic) = LOWER($4))
+			AND ($5::timestamptz IS NULL OR items.created_at >= $5)
+			AND ($6::timestamptz IS NULL OR items.created_at < $6)
+		`, q.UserID, q.Text, pq.Array(q.types()), q.Topic, q.From, q.To)
+	}
+	return page, err
+}
+
+// SearchSemantic ranks the learner's items by how close their embeddings are
+// to the query's, so "eigenvalues" also finds "characteristic polynomial".
+// Only items the indexer has embedded are found, and only the most recent
+// semanticCandidates that match the filters are compared; the page says when
+// that cut anything off.
+func SearchSemantic(ctx context.Context, apiKey string, q SearchQuery) (SearchPage, error) {
+	page := SearchPage{Mode: SearchModeSemantic, Results: []SearchResult{}}
+	vec, err := EmbedText(ctx, apiKey, q.Text)
+	if err != nil {
+		return page, err
+	}
+	if _, err := IndexSearchEmbeddings(ctx, apiKey, q.UserID, searchTopUpBatch); err != nil {
+		fmt.Printf("Warning: failed to embed recent items of user %d: %v\n", q.UserID, err)
+	}
+
+	var rows []struct {
+		SearchResult
+		Body      string `db:"body"`
+		Embedding string `db:"embedding"`
+	}
+	err = config.DB.Select(&rows, `
+		SELECT items.type, items.id, items.chat_id, items.quiz_id, items.topic, items.chat_title, items.role, items.body, items.created_at, e.embedding
+		FROM (`+searchItemsSQL+`) items
+		JOIN search_embeddings e ON e.source_type = items.type AND e.source_id = items.id AND e.model = $7
+		WHERE items.type = ANY($2)
+		AND ($3 = '' OR LOWER(items.topic) = LOWER($3))
+		AND ($4::timestamptz IS NULL OR items.created_at >= $4)
+		AND ($5::timestamptz IS NULL OR items.created_at < $5)
+		ORDER BY items.created_at DESC
+		LIMIT $6
+	`, q.UserID, pq.Array(q.types()), q.Topic, q.From, q.To, semanticCandidates+1, EmbeddingModel)
+	if err != nil {
+		return page, err
+	}
+	if len(rows) > semanticCandidates {
+		rows = rows[:semanticCandidates]
+		page.Truncated = true
+	}
+
+	var hits []SearchResult
+	for _, r := range rows {
+		sim := CosineSimilarity(vec, DecodeEmbedding(r.Embedding))
+		if sim < semanticMinSimilarity {
+			continue
+		}
+		r.Score = sim
+		r.Snippet = r.Body
+		if b := []rune(r.Body); len(b) > searchSnippetLength {
+			r.Snippet = string(b[:searchSnippetLength]) + "…"
+		}
+		hits = append(hits, r.SearchResult)
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	page.Total = len(hits)
+	if q.Offset < len(hits) {
+		page.Results = hits[q.Offset:min(q.Offset+q.Limit, len(hits))]
+	}
+	return page, nil
+}
+
+// IndexSearchEmbeddings embeds up to limit of the most recent items that
+// have no embedding yet, for one user (or everyone with userID 0), and
+// returns how many it stored. It stops at the first embedding error.
+func IndexSearchEmbeddings(ctx context.Context, apiKey string, userID int, limit int) (int, error) {
+	var items []struct {
+		Type   string `db:"type"`
+		ID     string `db:"id"`
+		UserID int    `db:"user_id"`
+		Body   string `db:"body"`
+	}
+	err := config.DB.Select(&items, `
+		SELECT type, id, user_id, body FROM (
+			SELECT 'message' AS type, m.id, c.user_id, m.content AS body, m.created_at
+			FROM messages m JOIN chats c ON c.id = m.chat_id
+			WHERE ($1 = 0 OR c.user_id=$1) AND LENGTH(m.content) >= $3
+			AND NOT EXISTS (SELECT 1 FROM search_embeddings e WHERE e.source_type='message' AND e.source_id = m.id AND e.model = $4)
+			UNION ALL
+			SELECT 'quiz_question', qq.id::text, qz.user_id, qq.question, qz.created_at
+			FROM quiz_questions qq JOIN quizzes qz ON qz.id = qq.quiz_id
+			WHERE ($1 = 0 OR qz.user_id=$1) AND LENGTH(qq.question) >= $3
+			AND NOT EXISTS (SELECT 1 FROM search_embeddings e WHERE e.source_type='quiz_question' AND e.source_id = qq.id::text AND e.model = $4)
+		) todo
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, userID, limit, searchIndexMinLength, EmbeddingModel)
+	if err != nil {
+		return 0, err
+	}
+	stored := 0
+	for _, item := range items {
+		vec, err := EmbedText(ctx, apiKey, item.Body)
+		if err != nil {
+			return stored, err
+		}
+		_, err = config.DB.Exec(`
+			INSERT INTO search_embeddings (source_type, source_id, user_id, embedding, model)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (source_type, source_id) DO UPDATE SET embedding=EXCLUDED.embedding, model=EXCLUDED.model, created_at=NOW()
+		`, item.Type, item.ID, item.UserID, EncodeEmbedding(vec), EmbeddingModel)
+		if err != nil {
+			return stored, err
+		}
+		stored++
+	}
+	return stored, nil
+}
+
+// pruneSearchEmbeddings drops embeddings of deleted messages and quizzes
+func pruneSearchEmbeddings() error {
+	_, err := config.DB.Exec(`
+		DELETE FROM search_embeddings e
+		WHERE (e.source_type = 'message' AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.id = e.source_id))
+		OR (e.source_type = 'quiz_question' AND NOT EXISTS (SELECT 1 FROM quiz_questions qq WHERE qq.id::text = e.source_id))
+	`)
+	return err
+}
+
+// StartSearchIndexer embeds new messages and quiz questions in the
+// background for semantic search. It needs a Gemini API key in the
+// environment; without one, semantic search only sees what searches embed.
+func StartSearchIndexer(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(searchIndexInterval)
+		defer ticker.Stop()
+		for {
+			if apiKey := ResolveGeminiAPIKeyFromEnv(); apiKey != "" {
+				if n, err := IndexSearchEmbeddings(ctx, apiKey, 0, searchIndexBatch); err != nil {
+					fmt.Printf("Warning: search indexer: %v\n", err)
+				} else if n > 0 {
+					fmt.Printf("🔎 Embedded %d item(s) for search\n", n)
+				}
+			}
+			if err := pruneSearchEmbeddings(); err != nil {
+				fmt.Printf("Warning: search indexer: %v\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
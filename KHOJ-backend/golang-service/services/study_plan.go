@@ -0,0 +1,761 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-service/config"
+	"golang-service/models"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Study plan item kinds
+const (
+	PlanItemSession    = "session"
+	PlanItemReview     = "review"
+	PlanItemCheckpoint = "quiz_checkpoint"
+)
+
+// Study plan item states
+const (
+	PlanItemPending = "pending"
+	PlanItemDone    = "done"
+	PlanItemMissed  = "missed"
+	PlanItemSkipped = "skipped"
+)
+
+// Study plan states
+const (
+	StudyPlanActive    = "active"
+	StudyPlanCompleted = "completed"
+	StudyPlanArchived  = "archived"
+)
+
+const (
+	DefaultStudyPlanWeeks = 4
+	MaxStudyPlanWeeks     = 12
+	MaxStudyPlanTopics    = 5
+
+	// planMissedGrace is how long after its slot an item counts as missed
+	planMissedGrace = 24 * time.Hour
+	// planEarlyWindow is how early an item can be done before its slot
+	planEarlyWindow = 12 * time.Hour
+	// planSessionMinMessages is how many messages in the topic's chat make a
+	// session or review count as done
+	planSessionMinMessages = 3
+	// planSweepInterval is how often overdue items are looked for
+	planSweepInterval = 30 * time.Minute
+)
+
+var (
+	// ErrNoPlanTopics is returned when a plan has no topics to study
+	ErrNoPlanTopics = errors.New("at least one topic is required")
+	// ErrPlanItemClosed is returned when completing an item that is no longer pending
+	ErrPlanItemClosed = errors.New("study plan item is no longer pending")
+)
+
+// PlanSettings is how a plan is laid out
+type PlanSettings struct {
+	StudyDays        []time.Weekday
+	SessionTime      int // Minutes after midnight, user's zone
+	SessionMinutes   int
+	LearningStyles   []string
+	RemindersEnabled bool
+	FactsEnabled     bool
+}
+
+// PlanSettingsFromProfile turns the learner profile into plan settings.
+// Saved preferred hours win over the profile's windows; without either,
+// sessions are at 18:00 for 30 minutes.
+func PlanSettingsFromProfile(p models.LearnerProfile, prefs models.ReminderPreferences) PlanSettings {
+	s := PlanSettings{
+		SessionTime:      18 * 60,
+		SessionMinutes:   30,
+		LearningStyles:   p.LearningStyles,
+		RemindersEnabled: p.ReminderFrequency != FrequencyNever,
+		FactsEnabled:     p.FactsNotifications,
+	}
+	if len(s.LearningStyles) == 0 {
+		s.LearningStyles = []string{StyleConversations}
+	}
+
+	switch p.ReminderFrequency {
+	case FrequencyDaily:
+		s.StudyDays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+	case FrequencyWeekly:
+		s.StudyDays = []time.Weekday{time.Saturday}
+	default:
+		// 3x/week, and the pace for learners who don't want reminders
+		s.StudyDays = []time.Weekday{time.Monday, time.Wednesday, time.Friday}
+	}
+
+	start, end := prefs.PreferredHoursStart, prefs.PreferredHoursEnd
+	if start == "" && len(p.PreferredWindows) > 0 {
+		start, end, _ = ValidatePreferredWindow(p.PreferredWindows[0])
+	}
+	if from, err := ParseClock(start); err == nil {
+		s.SessionTime = from
+		if to, err := ParseClock(end); err == nil {
+			length := to - from
+			if length < 0 {
+				length += 24 * 60
+			}
+			if length > 0 {
+				s.SessionMinutes = min(max(length, 20), 60)
+			}
+		}
+	}
+	return s
+}
+
+// planSlot is an item before it is stored
+type planSlot struct {
+	Topic    string
+	Kind     string
+	Activity string
+	Week     int
+	At       time.Time
+	Minutes  int
+}
+
+// studySlots returns the study-day slots in loc from the start date (a
+// local calendar date) for the given number of days, grouped by plan week
+func studySlots(settings PlanSettings, start time.Time, days int, loc *time.Location) [][]time.Time {
+	var weeks [][]time.Time
+	for d := 0; d < days; d++ {
+		day := start.AddDate(0, 0, d)
+		if !containsWeekday(settings.StudyDays, day.Weekday()) {
+			continue
+		}
+		w := d / 7
+		for len(weeks) <= w {
+			weeks = append(weeks, nil)
+		}
+		at := LocalTime(day.Year(), day.Month(), day.Day(), settings.SessionTime/60, settings.SessionTime%60, 0, loc)
+		weeks[w] = append(weeks[w], at)
+	}
+	return weeks
+}
+
+func containsWeekday(days []time.Weekday, d time.Weekday) bool {
+	for _, x := range days {
+		if x == d {
+			return true
+		}
+	}
+	return false
+}
+
+// layoutPlan assigns kinds and topics to the slots. The last study day of
+// each week is a quiz checkpoint on a topic studied since the previous one
+// (with one study day a week, every other week and the last week are
+// checkpoints); every third other slot reviews the oldest unreviewed session;
+// the rest are sessions going round the topics.
+func layoutPlan(topics []string, settings PlanSettings, weeks [][]time.Time) []planSlot {
+	var out []planSlot
+	sessions := 0
+	var unreviewed []string
+	lastCheckpoint := map[string]int{}
+	checkpoints := 0
+	studySlotsSeen := 0
+	studied := map[string]bool{}
+	var studiedOrder []string
+
+	for w, days := range weeks {
+		for j, at := range days {
+			slot := planSlot{Week: w + 1, At: at}
+			isCheckpoint := j == len(days)-1 && (len(days) >= 2 || w%2 == 1 || w == len(weeks)-1)
+			switch {
+			case isCheckpoint:
+				slot.Kind = PlanItemCheckpoint
+				candidates := studiedOrder
+				if len(candidates) == 0 {
+					candidates = topics
+				}
+				// The studied topic checkpointed longest ago
+				slot.Topic = candidates[0]
+				for _, t := range candidates {
+					if lastCheckpoint[t] < lastCheckpoint[slot.Topic] {
+						slot.Topic = t
+					}
+				}
+				checkpoints++
+				lastCheckpoint[slot.Topic] = checkpoints
+				studied, studiedOrder = map[string]bool{}, nil
+				slot.Minutes = min(max(settings.SessionMinutes, 10), 30)
+			case studySlotsSeen%3 == 2 && len(unreviewed) > 0:
+				slot.Kind = PlanItemReview
+				slot.Topic, unreviewed = unreviewed[0], unreviewed[1:]
+				slot.Minutes = max(settings.SessionMinutes/2, 10)
+			default:
+				slot.Kind = PlanItemSession
+				slot.Topic = topics[sessions%len(topics)]
+				slot.Minutes = settings.SessionMinutes
+				slot.Activity = sessionActivity(settings.LearningStyles, sessions, slot.Topic)
+				sessions++
+				unreviewed = append(unreviewed, slot.Topic)
+				if !studied[slot.Topic] {
+					studied[slot.Topic] = true
+					studiedOrder = append(studiedOrder, slot.Topic)
+				}
+			}
+			if slot.Kind != PlanItemCheckpoint {
+				studySlotsSeen++
+			}
+			if slot.Activity == "" {
+				slot.Activity = planActivity(slot.Kind, slot.Topic)
+			}
+			out = append(out, slot)
+		}
+	}
+	return out
+}
+
+// styleRotation weights sessions towards the learner's top preferences: the
+// first style every other session, the second every fourth, the third the rest
+var styleRotation = []int{0, 1, 0, 2}
+
+func sessionActivity(styles []string, n int, topic string) string {
+	i := styleRotation[n%len(styleRotation)]
+	if i >= len(styles) {
+		i = 0
+	}
+	style := strings.ToLower(styles[i])
+	switch {
+	case strings.Contains(style, "video"):
+		return fmt.Sprintf("Watch a short video lesson on %s, then note three key ideas", topic)
+	case strings.Contains(style, "document") || strings.Contains(style, "read"):
+		return fmt.Sprintf("Read an article or notes on %s and summarise it in your own words", topic)
+	case strings.Contains(style, "practi"):
+		return fmt.Sprintf("Solve a few practice problems on %s, asking the tutor where you get stuck", topic)
+	}
+	return fmt.Sprintf("Work through %s with the AI tutor in your chat", topic)
+}
+
+func planActivity(kind string, topic string) string {
+	switch kind {
+	case PlanItemReview:
+		return fmt.Sprintf("Review what you learned about %s: ask the tutor to go over the key points", topic)
+	case PlanItemCheckpoint:
+		return fmt.Sprintf("Take a quiz on %s to check your progress", topic)
+	}
+	return fmt.Sprintf("Study %s", topic)
+}
+
+// PlanReminderMessage is the chat text of a study plan item's reminder
+func PlanReminderMessage(item models.StudyPlanItem) string {
+	switch item.Kind {
+	case PlanItemCheckpoint:
+		return fmt.Sprintf("🧪 Study plan, week %d: checkpoint quiz on '%s' (%d min). Type 'quiz here' to start it.", item.Week, item.Topic, item.DurationMinutes)
+	case PlanItemReview:
+		return fmt.Sprintf("🔁 Study plan, week %d: review '%s' (%d min).\n%s", item.Week, item.Topic, item.DurationMinutes, item.Activity)
+	}
+	return fmt.Sprintf("📘 Study plan, week %d: session on '%s' (%d min).\n%s", item.Week, item.Topic, item.DurationMinutes, item.Activity)
+}
+
+// scheduleReminderMessage is the chat text posted when a schedule fires
+func scheduleReminderMessage(tx *sqlx.Tx, s models.Schedule) string {
+	if s.PlanItemID != nil {
+		var item models.StudyPlanItem
+		if err := tx.Get(&item, "SELECT * FROM study_plan_items WHERE id=$1", *s.PlanItemID); err == nil {
+			return PlanReminderMessage(item)
+		}
+	}
+	return ReminderMessage(s.Topic)
+}
+
+// CreateStudyPlan builds a plan for the topics from the user's learner
+// profile and reminder preferences, starting on the given local date. Any
+// active plan is archived and its pending reminders cancelled.
+func CreateStudyPlan(userID int, topics []string, weeks int, start time.Time) (models.StudyPlan, error) {
+	var plan models.StudyPlan
+	topics = uniqueTopics(topics)
+	if len(topics) == 0 {
+		return plan, ErrNoPlanTopics
+	}
+	if len(topics) > MaxStudyPlanTopics {
+		return plan, fmt.Errorf("a plan can cover at most %d topics", MaxStudyPlanTopics)
+	}
+	if weeks <= 0 {
+		weeks = DefaultStudyPlanWeeks
+	}
+	if weeks > MaxStudyPlanWeeks {
+		return plan, fmt.Errorf("a plan can last at most %d weeks", MaxStudyPlanWeeks)
+	}
+
+	// Learners who skipped onboarding get the defaults
+	profile, err := LoadLearnerProfile(userID)
+	if err != nil && err != sql.ErrNoRows {
+		return plan, err
+	}
+	var prefs models.ReminderPreferences
+	err = config.DB.Get(&prefs, "SELECT quiet_hours_start, quiet_hours_end, dnd_days, preferred_hours_start, preferred_hours_end FROM users WHERE id=$1", userID)
+	if err != nil {
+		return plan, err
+	}
+	settings := PlanSettingsFromProfile(profile, prefs)
+	loc := UserLocation(userID)
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+
+	now := time.Now()
+	var slots []planSlot
+	for _, s := range layoutPlan(topics, settings, studySlots(settings, start, weeks*7, loc)) {
+		if s.At.After(now) {
+			slots = append(slots, s)
+		}
+	}
+
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return plan, err
+	}
+	defer tx.Rollback()
+
+	if err := archiveActivePlans(tx, userID); err != nil {
+		return plan, err
+	}
+	days := make([]string, len(settings.StudyDays))
+	for i, d := range settings.StudyDays {
+		days[i] = strconv.Itoa(int(d))
+	}
+	err = tx.Get(&plan, `
+		INSERT INTO study_plans (user_id, topics, weeks, start_date, end_date, study_days, session_time, session_minutes, learning_styles, reminders_enabled, facts_enabled)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING *
+	`, userID, strings.Join(topics, ","), weeks, start.Format("2006-01-02"), start.AddDate(0, 0, weeks*7-1).Format("2006-01-02"),
+		strings.Join(days, ","), fmt.Sprintf("%02d:%02d", settings.SessionTime/60, settings.SessionTime%60), settings.SessionMinutes,
+		strings.Join(settings.LearningStyles, ", "), settings.RemindersEnabled, settings.FactsEnabled)
+	if err != nil {
+		return plan, err
+	}
+
+	chats := map[string]string{}
+	for _, t := range topics {
+		if chats[t], err = ensureTopicChat(tx, userID, t); err != nil {
+			return plan, err
+		}
+	}
+	quiet := UserQuietHours(userID)
+	for _, s := range slots {
+		item := models.StudyPlanItem{
+			PlanID: plan.ID, UserID: userID, ChatID: chats[s.Topic], Topic: s.Topic, Kind: s.Kind,
+			Activity: s.Activity, Week: s.Week, ScheduledFor: s.At, DurationMinutes: s.Minutes,
+		}
+		if _, err := insertPlanItem(tx, plan, item, quiet); err != nil {
+			return plan, err
+		}
+	}
+	return plan, tx.Commit()
+}
+
+func uniqueTopics(topics []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, t := range topics {
+		t = strings.TrimSpace(t)
+		key := CanonicalTopic(t)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, t)
+	}
+	return out
+}
+
+// ensureTopicChat returns the user's chat on the topic, creating it if needed
+func ensureTopicChat(tx *sqlx.Tx, userID int, topic string) (string, error) {
+	var chatID string
+	err := tx.Get(&chatID, "SELECT id FROM chats WHERE user_id=$1 AND topic=$2 ORDER BY updated_at DESC LIMIT 1", userID, topic)
+	if err == nil {
+		return chatID, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+	chatID = uuid.New().String()
+	_, err = tx.Exec(`
+		INSERT INTO chats (id, user_id, topic, created_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+	`, chatID, userID, topic)
+	return chatID, err
+}
+
+// insertPlanItem stores an item and, when the plan has reminders, the
+// one-time schedule that reminds of it
+func insertPlanItem(tx *sqlx.Tx, plan models.StudyPlan, item models.StudyPlanItem, quiet QuietHours) (models.StudyPlanItem, error) {
+	err := tx.Get(&item, `
+		INSERT INTO study_plan_items (plan_id, user_id, chat_id, topic, kind, activity, week, scheduled_for, duration_minutes, replanned_from)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING *
+	`, item.PlanID, item.UserID, item.ChatID, item.Topic, item.Kind, item.Activity, item.Week, item.ScheduledFor, item.DurationMinutes, item.ReplannedFrom)
+	if err != nil || !plan.RemindersEnabled {
+		return item, err
+	}
+
+	s := models.Schedule{UserID: item.UserID, ChatID: item.ChatID, Topic: item.Topic, WindowStrategy: WindowStart}
+	fireAt := PlanFireTime(s, item.ScheduledFor, quiet)
+	var scheduleID int
+	err = tx.QueryRow(`
+		INSERT INTO schedules (user_id, chat_id, topic, scheduled_time, active, created_at, recurrence_type, reminder_time, window_strategy, occurrence_time, plan_item_id)
+		VALUES ($1, $2, $3, $4, true, NOW(), 'once', $5, $6, $7, $8)
+		RETURNING id
+	`, item.UserID, item.ChatID, item.Topic, fireAt, item.ScheduledFor.Format("15:04"), WindowStart, item.ScheduledFor, item.ID).Scan(&scheduleID)
+	if err != nil {
+		return item, err
+	}
+	item.ScheduleID = &scheduleID
+	_, err = tx.Exec("UPDATE study_plan_items SET schedule_id=$2 WHERE id=$1", item.ID, scheduleID)
+	return item, err
+}
+
+// archiveActivePlans archives the user's active plans, skipping their pending
+// items and cancelling the reminders for them
+func archiveActivePlans(tx *sqlx.Tx, userID int) error {
+	_, err := tx.Exec(`
+		UPDATE schedules SET active=false
+		WHERE plan_item_id IN (
+			SELECT i.id FROM study_plan_items i JOIN study_plans p ON p.id = i.plan_id
+			WHERE p.user_id=$1 AND p.status='active' AND i.status='pending'
+		)
+	`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE study_plan_items SET status='skipped'
+		WHERE status='pending' AND plan_id IN (SELECT id FROM study_plans WHERE user_id=$1 AND status='active')
+	`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE study_plans SET status='archived', updated_at=NOW() WHERE user_id=$1 AND status='active'", userID)
+	return err
+}
+
+// ArchiveStudyPlan archives one of the user's plans
+func ArchiveStudyPlan(planID int, userID int) error {
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var status string
+	if err := tx.Get(&status, "SELECT status FROM study_plans WHERE id=$1 AND user_id=$2 FOR UPDATE", planID, userID); err != nil {
+		return err
+	}
+	if status == StudyPlanActive {
+		if err := archiveActivePlans(tx, userID); err != nil {
+			return err
+		}
+	} else if _, err := tx.Exec("UPDATE study_plans SET status='archived', updated_at=NOW() WHERE id=$1", planID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplanStudyPlan catches a plan up after the learner fell behind. Items more
+// than a day past their slot are marked missed; missed sessions and
+// checkpoints are re-added at the next study slots, ahead of the remaining
+// pending items, which move back accordingly. Missed reviews are dropped,
+// since the next review covers them. The plan's end date moves out as
+// needed. It returns how many missed items were carried over.
+func ReplanStudyPlan(planID int, now time.Time) (int, error) {
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var plan models.StudyPlan
+	if err := tx.Get(&plan, "SELECT * FROM study_plans WHERE id=$1 AND status='active' FOR UPDATE", planID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`
+		UPDATE study_plan_items SET status='missed'
+		WHERE plan_id=$1 AND status='pending' AND scheduled_for < $2
+	`, plan.ID, now.Add(-planMissedGrace)); err != nil {
+		return 0, err
+	}
+
+	// Missed items that haven't been carried over yet
+	var missed []models.StudyPlanItem
+	err = tx.Select(&missed, `
+		SELECT * FROM study_plan_items i
+		WHERE plan_id=$1 AND status='missed' AND kind != 'review'
+		AND NOT EXISTS (SELECT 1 FROM study_plan_items r WHERE r.replanned_from = i.id)
+		ORDER BY scheduled_for
+	`, plan.ID)
+	if err != nil || len(missed) == 0 {
+		return 0, err
+	}
+	var pending []models.StudyPlanItem
+	err = tx.Select(&pending, "SELECT * FROM study_plan_items WHERE plan_id=$1 AND status='pending' ORDER BY scheduled_for", plan.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Enough study slots from now on for everything still to do
+	settings := PlanSettings{SessionMinutes: plan.SessionMinutes}
+	for _, d := range strings.Split(plan.StudyDays, ",") {
+		if n, err := strconv.Atoi(d); err == nil {
+			settings.StudyDays = append(settings.StudyDays, time.Weekday(n))
+		}
+	}
+	if settings.SessionTime, err = ParseClock(plan.SessionTime); err != nil || len(settings.StudyDays) == 0 {
+		return 0, fmt.Errorf("study plan %d has invalid settings", plan.ID)
+	}
+	loc := UserLocation(plan.UserID)
+	today := now.In(loc)
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, loc)
+	need := len(missed) + len(pending)
+	var slots []time.Time
+	for days := 7; len(slots) < need; days += 7 {
+		slots = slots[:0]
+		for _, week := range studySlots(settings, today, days, loc) {
+			for _, at := range week {
+				if at.After(now) {
+					slots = append(slots, at)
+				}
+			}
+		}
+	}
+
+	planStart := time.Date(plan.StartDate.Year(), plan.StartDate.Month(), plan.StartDate.Day(), 0, 0, 0, 0, loc)
+	weekOf := func(at time.Time) int {
+		local := at.In(loc)
+		day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+		return int(day.Sub(planStart).Hours()/24)/7 + 1
+	}
+	quiet := UserQuietHours(plan.UserID)
+	i := 0
+	for _, m := range missed {
+		from := m.ID
+		item := m
+		item.ReplannedFrom = &from
+		item.ScheduledFor = slots[i]
+		item.Week = weekOf(slots[i])
+		if _, err := insertPlanItem(tx, plan, item, quiet); err != nil {
+			return 0, err
+		}
+		i++
+	}
+	for _, p := range pending {
+		at := slots[i]
+		i++
+		if _, err := tx.Exec("UPDATE study_plan_items SET scheduled_for=$2, week=$3 WHERE id=$1", p.ID, at, weekOf(at)); err != nil {
+			return 0, err
+		}
+		if p.ScheduleID != nil {
+			s := models.Schedule{UserID: p.UserID, ChatID: p.ChatID, Topic: p.Topic, WindowStrategy: WindowStart}
+			_, err := tx.Exec(`
+				UPDATE schedules SET scheduled_time=$2, occurrence_time=$3, reminder_time=$4, active=true, attempts=0, next_attempt_at=NULL, last_error=''
+				WHERE id=$1
+			`, *p.ScheduleID, PlanFireTime(s, at, quiet), at, at.In(loc).Format("15:04"))
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	end := slots[need-1].In(loc)
+	if endDate := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC); endDate.After(plan.EndDate) {
+		plan.EndDate = endDate
+	}
+	_, err = tx.Exec(`
+		UPDATE study_plans SET end_date=$2, replan_count=replan_count+1, replanned_at=$3, updated_at=NOW() WHERE id=$1
+	`, plan.ID, plan.EndDate.Format("2006-01-02"), now)
+	if err != nil {
+		return 0, err
+	}
+	return len(missed), tx.Commit()
+}
+
+// CompletePlanItem marks an item done and cancels its reminder if it hasn't
+// fired yet. quizID links a checkpoint to the quiz that completed it.
+func CompletePlanItem(itemID int, userID int, quizID *int) (models.StudyPlanItem, error) {
+	var item models.StudyPlanItem
+	tx, err := config.DB.Beginx()
+	if err != nil {
+		return item, err
+	}
+	defer tx.Rollback()
+	if err := tx.Get(&item, "SELECT * FROM study_plan_items WHERE id=$1 AND user_id=$2 FOR UPDATE", itemID, userID); err != nil {
+		return item, err
+	}
+	// A missed item can still be done late, as long as it wasn't carried over
+	if item.Status != PlanItemPending && item.Status != PlanItemMissed {
+		return item, ErrPlanItemClosed
+	}
+	if item.Status == PlanItemMissed {
+		var carried bool
+		if err := tx.Get(&carried, "SELECT EXISTS(SELECT 1 FROM study_plan_items WHERE replanned_from=$1)", item.ID); err != nil {
+			return item, err
+		}
+		if carried {
+			return item, ErrPlanItemClosed
+		}
+	}
+	err = tx.Get(&item, `
+		UPDATE study_plan_items SET status='done', completed_at=NOW(), quiz_id=COALESCE($2, quiz_id)
+		WHERE id=$1 RETURNING *
+	`, item.ID, quizID)
+	if err != nil {
+		return item, err
+	}
+	if item.ScheduleID != nil {
+		if _, err := tx.Exec("UPDATE schedules SET active=false WHERE id=$1 AND last_fired_at IS NULL", *item.ScheduleID); err != nil {
+			return item, err
+		}
+	}
+	if err := completePlanIfFinished(tx, item.PlanID); err != nil {
+		return item, err
+	}
+	return item, tx.Commit()
+}
+
+// completePlanIfFinished marks the plan completed once nothing is pending
+func completePlanIfFinished(tx *sqlx.Tx, planID int) error {
+	_, err := tx.Exec(`
+		UPDATE study_plans SET status='completed', updated_at=NOW()
+		WHERE id=$1 AND status='active'
+		AND NOT EXISTS (SELECT 1 FROM study_plan_items WHERE plan_id=$1 AND status='pending')
+	`, planID)
+	return err
+}
+
+// matchingPlanItems finds the user's pending (or missed, not carried over)
+// items of the given kinds on a topic whose slot is near at
+func matchingPlanItems(userID int, topic string, kinds []string, at time.Time) ([]models.StudyPlanItem, error) {
+	var items []models.StudyPlanItem
+	err := config.DB.Select(&items, `
+		SELECT i.* FROM study_plan_items i JOIN study_plans p ON p.id = i.plan_id
+		WHERE i.user_id=$1 AND p.status='active' AND i.kind = ANY(string_to_array($2, ','))
+		AND i.scheduled_for BETWEEN $3 AND $4
+		AND (i.status='pending' OR (i.status='missed' AND NOT EXISTS (SELECT 1 FROM study_plan_items r WHERE r.replanned_from = i.id)))
+		ORDER BY i.scheduled_for
+	`, userID, strings.Join(kinds, ","), at.Add(-planMissedGrace), at.Add(planEarlyWindow))
+	if err != nil {
+		return nil, err
+	}
+	canonical := CanonicalTopic(topic)
+	var out []models.StudyPlanItem
+	for _, item := range items {
+		if CanonicalTopic(item.Topic) == canonical {
+			out = append(out, item)
+		}
+	}
+	return out, nil
+}
+
+// completePlanCheckpoint completes the checkpoint a finished quiz satisfies
+func completePlanCheckpoint(e Event) {
+	items, err := matchingPlanItems(e.UserID, e.Topic, []string{PlanItemCheckpoint}, e.At)
+	if err != nil {
+		fmt.Printf("Warning: failed to match quiz to study plan of user %d: %v\n", e.UserID, err)
+		return
+	}
+	if len(items) == 0 {
+		return
+	}
+	var quizID *int
+	if id, err := strconv.Atoi(strings.TrimPrefix(e.SourceID, "quiz:")); err == nil {
+		quizID = &id
+	}
+	if _, err := CompletePlanItem(items[0].ID, e.UserID, quizID); err != nil && err != ErrPlanItemClosed {
+		fmt.Printf("Warning: failed to complete study plan item %d: %v\n", items[0].ID, err)
+	}
+}
+
+// completePlanStudy completes a session or review once the learner has
+// studied the topic in its chat around the slot. Review events (quiz reviews)
+// complete review items directly.
+func completePlanStudy(e Event) {
+	kinds := []string{PlanItemSession, PlanItemReview}
+	if e.Type == EventReviewCompleted {
+		kinds = []string{PlanItemReview}
+	}
+	items, err := matchingPlanItems(e.UserID, e.Topic, kinds, e.At)
+	if err != nil {
+		fmt.Printf("Warning: failed to match activity to study plan of user %d: %v\n", e.UserID, err)
+		return
+	}
+	for _, item := range items {
+		if e.Type == EventMessageSent {
+			var sent int
+			err := config.DB.Get(&sent, `
+				SELECT COUNT(*) FROM messages WHERE chat_id=$1 AND role='user' AND created_at >= $2
+			`, item.ChatID, item.ScheduledFor.Add(-planEarlyWindow))
+			if err != nil || sent < planSessionMinMessages {
+				return
+			}
+		}
+		if _, err := CompletePlanItem(item.ID, e.UserID, nil); err != nil && err != ErrPlanItemClosed {
+			fmt.Printf("Warning: failed to complete study plan item %d: %v\n", item.ID, err)
+		}
+		return // One item per activity
+	}
+}
+
+// RunStudyPlanSweep re-plans active plans that have fallen behind and
+// completes plans with nothing left to do. It returns how many plans were
+// re-planned.
+func RunStudyPlanSweep(ctx context.Context, now time.Time) (int, error) {
+	var behind []int
+	err := config.DB.Select(&behind, `
+		SELECT DISTINCT p.id FROM study_plans p JOIN study_plan_items i ON i.plan_id = p.id
+		WHERE p.status='active' AND i.status='pending' AND i.scheduled_for < $1
+	`, now.Add(-planMissedGrace))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range behind {
+		if ctx.Err() != nil {
+			break
+		}
+		if _, err := ReplanStudyPlan(id, now); err != nil {
+			fmt.Printf("Warning: failed to re-plan study plan %d: %v\n", id, err)
+			continue
+		}
+		n++
+	}
+	_, err = config.DB.Exec(`
+		UPDATE study_plans p SET status='completed', updated_at=NOW()
+		WHERE status='active' AND end_date < $1::date
+		AND NOT EXISTS (SELECT 1 FROM study_plan_items WHERE plan_id=p.id AND status='pending')
+	`, now.UTC().Format("2006-01-02"))
+	return n, err
+}
+
+// StartStudyPlans tracks plan progress from learning events and periodically
+// re-plans plans the learner has fallen behind on
+func StartStudyPlans(ctx context.Context) {
+	Subscribe(EventQuizCompleted, completePlanCheckpoint)
+	Subscribe(EventMessageSent, completePlanStudy)
+	Subscribe(EventReviewCompleted, completePlanStudy)
+
+	go func() {
+		ticker := time.NewTicker(planSweepInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := RunStudyPlanSweep(ctx, time.Now()); err != nil {
+				fmt.Printf("Warning: study plan sweep: %v\n", err)
+			} else if n > 0 {
+				fmt.Printf("📅 Re-planned %d study plan(s)\n", n)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang-service/config"
+)
+
+// ParseTimezone loads an IANA zone name such as "Asia/Kolkata". "Local" is
+// rejected because it would silently mean the server's zone.
+func ParseTimezone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.EqualFold(name, "Local") {
+		return nil, fmt.Errorf("invalid timezone %q, use an IANA name such as Asia/Kolkata", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q, use an IANA name such as Asia/Kolkata", name)
+	}
+	return loc, nil
+}
+
+// UserLocation returns the user's configured time zone, or UTC when it is
+// unset or invalid
+func UserLocation(userID int) *time.Location {
+	var tz string
+	if err := config.DB.Get(&tz, "SELECT COALESCE(timezone, 'UTC') FROM users WHERE id=$1", userID); err != nil {
+		return time.UTC
+	}
+	loc, err := ParseTimezone(tz)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// LocalTime returns the instant of a wall-clock time in loc, resolving DST
+// transitions the way RFC 5545 does:
+//   - in a gap (the clock jumps from 02:00 to 03:00) the time is read with the
+//     offset before the gap, so 02:30 becomes 03:30
+//   - in an overlap (01:00-02:00 happens twice) the earlier instant is used
+//
+// time.Date leaves both cases unspecified.
+func LocalTime(year int, month time.Month, day, hour, min, sec int, loc *time.Location) time.Time {
+	wall := time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+	// Transitions are far apart, so the offsets a day either side cover both sides of any change
+	_, before := wall.Add(-24 * time.Hour).In(loc).Zone()
+	_, after := wall.Add(24 * time.Hour).In(loc).Zone()
+
+	matches := func(t time.Time) bool {
+		l := t.In(loc)
+		return l.Year() == wall.Year() && l.YearDay() == wall.YearDay() &&
+			l.Hour() == wall.Hour() && l.Minute() == wall.Minute() && l.Second() == wall.Second()
+	}
+	earlier := wall.Add(-time.Duration(before) * time.Second)
+	later := wall.Add(-time.Duration(after) * time.Second)
+	if later.Before(earlier) {
+		earlier, later = later, earlier
+	}
+	switch {
+	case matches(earlier):
+		return earlier.In(loc)
+	case matches(later):
+		return later.In(loc)
+	}
+	// Gap: use the offset in effect before the transition
+	return wall.Add(-time.Duration(before) * time.Second).In(loc)
+}
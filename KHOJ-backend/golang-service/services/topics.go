@@ -0,0 +1,51 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+)
+
+// topicAliases maps common spellings onto a single canonical topic key
+var topicAliases = map[string]string{
+	"math":        "mathematics",
+	"maths":       "mathematics",
+	"bio":         "biology",
+	"chem":        "chemistry",
+	"cs":          "computer science",
+	"comp sci":    "computer science",
+	"programming": "computer science",
+	"phys":        "physics",
+	"stats":       "statistics",
+	"econ":        "economics",
+	"eco":         "economics",
+}
+
+// CanonicalTopic turns a user-typed topic ("  Linear-Algebra!") into the key
+// used to share content across users ("linear algebra").
+func CanonicalTopic(topic string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(topic) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	canonical := strings.Join(strings.Fields(b.String()), " ")
+	if alias, ok := topicAliases[canonical]; ok {
+		return alias
+	}
+	return canonical
+}
+
+// NormalizeQuestionText reduces a question to a comparison key so trivially
+// different phrasings ("What is X?" vs "what is x") dedupe to the same row.
+func NormalizeQuestionText(question string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(question) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			b.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang-service/config"
+	"golang-service/models"
+)
+
+// Learner levels
+const (
+	LevelBeginner     = "beginner"
+	LevelIntermediate = "intermediate"
+	LevelAdvanced     = "advanced"
+
+	DefaultTutorStyle = "balanced"
+
+	// levelQuizSample is how many recent quizzes on a topic set the level
+	levelQuizSample = 5
+	// levelMinQuizzes is how many completed quizzes it takes to infer a level
+	levelMinQuizzes = 2
+)
+
+var (
+	tutorStyleName = regexp.MustCompile(`^[a-z][a-z0-9_]{1,30}$`)
+
+	languageNames = map[string]string{
+		"en": "English", "hi": "Hindi", "es": "Spanish", "fr": "French", "de": "German",
+		"bn": "Bengali", "ta": "Tamil", "te": "Telugu", "mr": "Marathi", "kn": "Kannada",
+		"pt": "Portuguese", "it": "Italian", "ja": "Japanese", "zh": "Chinese", "ar": "Arabic",
+	}
+)
+
+// TutorPromptData fills the tutor.system and tutor.style.* templates
+type TutorPromptData struct {
+	Topic          string
+	Level          string // "beginner", "intermediate" or "advanced"
+	Language       string // BCP 47 tag
+	LanguageName   string // e.g. "Hindi"
+	LearningStyles []string
+	PracticeFirst  bool // Learning styles rank practice first
+	Visual         bool // ... videos first
+	Reading        bool // ... documents first
+	TutorStyle     string
+	StyleGuide     string // The rendered tutor.style.<TutorStyle> template
+}
+
+// LanguageName returns the English name of a language tag, or the tag itself
+func LanguageName(tag string) string {
+	base := strings.SplitN(tag, "-", 2)[0]
+	if name, ok := languageNames[base]; ok {
+		return name
+	}
+	return tag
+}
+
+// NormalizeLevel maps a level answer to a canonical level
+func NormalizeLevel(answer string) (string, bool) {
+	a := strings.ToLower(strings.TrimSpace(answer))
+	switch {
+	case strings.Contains(a, "begin") || strings.Contains(a, "new") || strings.Contains(a, "novice"):
+		return LevelBeginner, true
+	case strings.Contains(a, "intermediate") || strings.Contains(a, "some"):
+		return LevelIntermediate, true
+	case strings.Contains(a, "advanced") || strings.Contains(a, "expert"):
+		return LevelAdvanced, true
+	}
+	return "", false
+}
+
+// ValidTutorStyle reports whether a tutor style has an active template
+func ValidTutorStyle(style string) (bool, error) {
+	if !tutorStyleName.MatchString(style) {
+		return false, nil
+	}
+	var ok bool
+	err := config.DB.Get(&ok, "SELECT EXISTS(SELECT 1 FROM prompt_templates WHERE name=$1 AND active)", PromptTutorStylePrefix+style)
+	return ok, err
+}
+
+// TopicLevel infers the learner's level on a topic from their recent quiz
+// scores, falling back to the profile's level (or beginner) with too few
+// quizzes to go on
+func TopicLevel(userID int, topic string, fallback string) string {
+	var scores []struct {
+		Score int `db:"score"`
+		Total int `db:"total_questions"`
+	}
+	err := config.DB.Select(&scores, `
+		SELECT score, total_questions FROM quizzes
+		WHERE user_id=$1 AND status='completed' AND total_questions > 0 AND LOWER(topic) = LOWER($2)
+		ORDER BY completed_at DESC LIMIT $3
+	`, userID, topic, levelQuizSample)
+	if err != nil || len(scores) < levelMinQuizzes {
+		if fallback == "" {
+			return LevelBeginner
+		}
+		return fallback
+	}
+	var sum float64
+	for _, s := range scores {
+		sum += float64(s.Score) / float64(s.Total)
+	}
+	switch avg := sum / float64(len(scores)); {
+	case avg >= 0.8:
+		return LevelAdvanced
+	case avg >= 0.5:
+		return LevelIntermediate
+	}
+	return LevelBeginner
+}
+
+// TutorPersona builds the prompt data for a learner's chat on a topic. A
+// level set in the profile wins over the one inferred from quizzes.
+func TutorPersona(userID int, topic string) TutorPromptData {
+	profile, err := LoadLearnerProfile(userID)
+	if err != nil && err != sql.ErrNoRows {
+		fmt.Printf("Warning: failed to load learner profile of user %d: %v\n", userID, err)
+	}
+	d := TutorPromptData{
+		Topic:          topic,
+		Language:       profile.Language,
+		LearningStyles: profile.LearningStyles,
+		TutorStyle:     profile.TutorStyle,
+	}
+	if d.Language == "" {
+		d.Language = DefaultLanguage
+	}
+	d.LanguageName = LanguageName(d.Language)
+	if d.TutorStyle == "" {
+		d.TutorStyle = DefaultTutorStyle
+	}
+	d.Level = profile.Level
+	if d.Level == "" {
+		d.Level = TopicLevel(userID, topic, "")
+	}
+	if len(d.LearningStyles) > 0 {
+		switch d.LearningStyles[0] {
+		case StylePractice:
+			d.PracticeFirst = true
+		case StyleVideos:
+			d.Visual = true
+		case StyleDocuments:
+			d.Reading = true
+		}
+	}
+	return d
+}
+
+// TutorSystemPrompt renders the system prompt for a learner's chat on a
+// topic from the active tutor templates, or those of the learner's
+// experiment variants
+func TutorSystemPrompt(userID int, topic string) (RenderedPrompt, error) {
+	d := TutorPersona(userID, topic)
+	guide, err := RenderPrompt(PromptTutorStylePrefix+d.TutorStyle, userID, d)
+	if err != nil {
+		// The chosen style may have been retired; fall back to the default
+		fmt.Printf("Warning: tutor style %q of user %d: %v\n", d.TutorStyle, userID, err)
+		d.TutorStyle = DefaultTutorStyle
+		if guide, err = RenderPrompt(PromptTutorStylePrefix+DefaultTutorStyle, userID, d); err != nil {
+			return RenderedPrompt{}, err
+		}
+	}
+	d.StyleGuide = guide.Text
+	p, err := RenderPrompt(PromptTutorSystem, userID, d)
+	p.Includes = append(p.Includes, guide)
+	return p, err
+}
+
+// defaultLearnerProfile is the profile of a learner who skipped onboarding
+func defaultLearnerProfile(userID int) models.LearnerProfile {
+	return models.LearnerProfile{UserID: userID, QuestionnaireVersion: CurrentQuestionnaireVersion, ReminderFrequency: DefaultFrequency,
+		Language: DefaultLanguage, TutorStyle: DefaultTutorStyle, LearningStyles: []string{}, PreferredWindows: []string{}}
+}
@@ -86,6 +86,8 @@ export default function ChatPage() {
   const [chatId, setChatId] = useState(null);
   const [topic, setTopic] = useState("");
   const [messages, setMessages] = useState([]);
+  const [olderCursor, setOlderCursor] = useState(null); // next_cursor of the oldest loaded page
+  const [loadingOlder, setLoadingOlder] = useState(false);
   const [inputMessage, setInputMessage] = useState("");
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState("");
@@ -116,6 +118,7 @@ export default function ChatPage() {
     return () => window.removeEventListener("resize", checkScreenSize);
   }, []);
   const messagesEndRef = useRef(null);
+  const prependedRef = useRef(false); // set while older messages are being prepended
   const apiBaseUrl = process.env.NEXT_PUBLIC_API_BASE_URL || "http://127.0.0.1:8080";
 
   useEffect(() => {
@@ -150,13 +153,22 @@ export default function ChatPage() {
     }
     setLoadingChats(true);
     try {
-      const url = `${apiBaseUrl}/api/chat/user/${uid}`;
+      const url = `${apiBaseUrl}/api/chat/user/${uid}?limit=200`;
       console.log("Loading chats from:", url);
-      const res = await fetch(url);
+      let res = await fetch(url);
       console.log("Chat list response status:", res.status);
       
       if (res.ok) {
-        const chats = await res.json();
+        // The list comes in pages; follow next_cursor until it runs out
+        let { items: chats, next_cursor: cursor } = await res.json();
+        chats = chats || [];
+        while (cursor) {
+          res = await fetch(`${url}&cursor=${encodeURIComponent(cursor)}`);
+          if (!res.ok) break;
+          const page = await res.json();
+          chats = chats.concat(page.items || []);
+          cursor = page.next_cursor;
+        }
         console.log("Chats received:", chats);
         console.log("Number of chats:", chats?.length || 0);
         setChatList(chats || []);
@@ -179,7 +191,12 @@ export default function ChatPage() {
   };
 
   useEffect(() => {
-    // Auto-scroll to bottom when messages change
+    // Auto-scroll to bottom when messages change, but not when older
+    // messages were added above
+    if (prependedRef.current) {
+      prependedRef.current = false;
+      return;
+    }
     messagesEndRef.current?.scrollIntoView({ behavior: "smooth" });
   }, [messages]);
 
@@ -187,8 +204,9 @@ export default function ChatPage() {
     try {
       const res = await fetch(`${apiBaseUrl}/api/chat/${chatIdToLoad}`);
       if (res.ok) {
-        const history = await res.json();
+        const { items: history, next_cursor } = await res.json();
         setMessages(history || []);
+        setOlderCursor(next_cursor || null);
         setNewBotMessages(new Set()); // Clear new messages when loading history
       }
     } catch (err) {
@@ -196,6 +214,25 @@ export default function ChatPage() {
     }
   };
 
+  // Loads the page of messages before the oldest one shown
+  const loadOlderMessages = async () => {
+    if (!chatId || !olderCursor || loadingOlder) return;
+    setLoadingOlder(true);
+    try {
+      const res = await fetch(`${apiBaseUrl}/api/chat/${chatId}?cursor=${encodeURIComponent(olderCursor)}`);
+      if (res.ok) {
+        const { items: older, next_cursor } = await res.json();
+        prependedRef.current = true;
+        setMessages((prev) => [...(older || []), ...prev]);
+        setOlderCursor(next_cursor || null);
+      }
+    } catch (err) {
+      console.error("Failed to load older messages:", err);
+    } finally {
+      setLoadingOlder(false);
+    }
+  };
+
   const startChat = async () => {
     if (!topicInput.trim() || !userId) {
       setError("Please enter a topic");
@@ -212,6 +249,7 @@ export default function ChatPage() {
         body: JSON.stringify({
           user_id: userId,
           topic: topicInput.trim(),
+          new: true,
         }),
       });
 
@@ -233,6 +271,7 @@ export default function ChatPage() {
         await loadChatHistory(data.chat_id);
       } else {
         setMessages([]);
+        setOlderCursor(null);
         setNewBotMessages(new Set());
       }
       
@@ -352,6 +391,7 @@ export default function ChatPage() {
     setTopic("");
     setTopicInput("");
     setMessages([]);
+    setOlderCursor(null);
     setNewBotMessages(new Set());
     setShowNewChatForm(true); // Show the form when clicking New Chat
     setError("");
@@ -444,6 +484,7 @@ export default function ChatPage() {
         setChatId(null);
         setTopic("");
         setMessages([]);
+        setOlderCursor(null);
         setNewBotMessages(new Set());
         sessionStorage.removeItem("chatId");
         sessionStorage.removeItem("chatTopic");
@@ -1121,9 +1162,10 @@ export default function ChatPage() {
                             chatId === chat.id ? "text-white" : "text-gray-300"
                           }`}
                         >
-                          {chat.topic}
+                          {chat.title || chat.topic}
                         </p>
-                        <p className="text-xs text-gray-500 mt-1">
+                        <p className="text-xs text-gray-500 mt-1 truncate">
+                          {chat.title ? `${chat.topic} · ` : ""}
                           {formatDate(chat.updated_at || chat.created_at)}
                         </p>
                       </div>
@@ -1189,6 +1231,17 @@ export default function ChatPage() {
         {/* Messages Area */}
         <div className="flex-1 overflow-y-auto px-4 py-6">
           <div className="max-w-4xl mx-auto space-y-4">
+          {olderCursor && (
+            <div className="text-center">
+              <button
+                onClick={loadOlderMessages}
+                disabled={loadingOlder}
+                className="px-4 py-2 rounded-lg bg-white/10 hover:bg-white/20 border border-white/10 text-sm text-gray-300 transition disabled:opacity-50"
+              >
+                {loadingOlder ? "Loading..." : "Load older messages"}
+              </button>
+            </div>
+          )}
           <AnimatePresence>
             {messages.length === 0 ? (
               <motion.div
@@ -48,6 +48,8 @@ export default function OnboardingPage() {
     const payload = {
       id: parseInt(userId, 10),
       answers: questions,
+      // IANA zone (e.g. "Asia/Kolkata") so reminders fire at the learner's local time
+      timezone: Intl.DateTimeFormat().resolvedOptions().timeZone,
     };
 
     try {
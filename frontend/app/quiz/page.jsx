@@ -39,11 +39,18 @@ export default function QuizPage() {
     
     setLoading(true);
     try {
-      const res = await fetch(`${apiBaseUrl}/api/chat/user/${uid}`);
-      if (res.ok) {
-        const chatList = await res.json();
-        setChats(chatList || []);
-      }
+      // The list comes in pages; follow next_cursor until it runs out
+      const url = `${apiBaseUrl}/api/chat/user/${uid}?limit=200`;
+      let chatList = [];
+      let cursor = null;
+      do {
+        const res = await fetch(cursor ? `${url}&cursor=${encodeURIComponent(cursor)}` : url);
+        if (!res.ok) break;
+        const page = await res.json();
+        chatList = chatList.concat(page.items || []);
+        cursor = page.next_cursor;
+      } while (cursor);
+      setChats(chatList);
     } catch (err) {
       console.error("Failed to load chats:", err);
     } finally {